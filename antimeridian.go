@@ -0,0 +1,125 @@
+package philifence
+
+import (
+	"math"
+)
+
+// splitAntimeridian cuts every part of the feature that crosses ±180° into parts on
+// either side of it, as geojson is expected to come
+//
+// https://tools.ietf.org/html/rfc7946#section-3.1.9
+func (f *Feature) splitAntimeridian() {
+	if f.isPoint() {
+		return
+	}
+	areal := f.isAreal()
+	var geometry []*Polygon
+	for _, poly := range f.Geometry {
+		if !poly.Exterior.crossesAntimeridian() {
+			geometry = append(geometry, poly)
+		} else if areal {
+			geometry = append(geometry, splitPolygon(poly)...)
+		} else {
+			geometry = append(geometry, splitLine(poly.Exterior.Coordinates)...)
+		}
+	}
+	f.Geometry = geometry
+}
+
+// any two consecutive vertices more than 180° of longitude apart are assumed to take
+// the short way round, across the antimeridian
+func (pr *PolyRing) crossesAntimeridian() bool {
+	for i := 1; i < len(pr.Coordinates); i++ {
+		if math.Abs(pr.Coordinates[i].lon-pr.Coordinates[i-1].lon) > 180 {
+			return true
+		}
+	}
+	return false
+}
+
+func splitLine(coords []Coordinate) (parts []*Polygon) {
+	part := []Coordinate{coords[0]}
+	for i := 1; i < len(coords); i++ {
+		a, b := coords[i-1], coords[i]
+		if math.Abs(b.lon-a.lon) > 180 {
+			edge := math.Copysign(180, a.lon)
+			t := (edge - a.lon) / (b.lon + 2*edge - a.lon)
+			lat := a.lat + (b.lat-a.lat)*t
+			part = append(part, Coordinate{lat: lat, lon: edge})
+			parts = append(parts, NewPoly(part...))
+			part = []Coordinate{{lat: lat, lon: -edge}}
+		}
+		part = append(part, b)
+	}
+	return append(parts, NewPoly(part...))
+}
+
+// unwraps the rings so they are continuous past ±180°, then clips them on both sides
+// of the antimeridian, moving the far side back into range
+func splitPolygon(poly *Polygon) (parts []*Polygon) {
+	exterior := unwrapLon(poly.Exterior.Coordinates, poly.Exterior.Coordinates[0].lon)
+	edge := 180.0
+	for _, c := range exterior {
+		if c.lon < -180 {
+			edge = -180
+			break
+		}
+	}
+	for _, near := range []bool{true, false} {
+		ring := clipLon(exterior, edge, near)
+		if ring == nil {
+			continue
+		}
+		part := NewPoly(ring...)
+		for _, hole := range poly.Holes {
+			clipped := clipLon(unwrapLon(hole.Coordinates, exterior[0].lon), edge, near)
+			if clipped != nil {
+				part.Holes = append(part.Holes, NewPolyRing(clipped...))
+			}
+		}
+		if !near {
+			for _, ring := range part.rings() {
+				for i := range ring.Coordinates {
+					ring.Coordinates[i].lon -= 2 * edge
+				}
+			}
+		}
+		parts = append(parts, part)
+	}
+	return
+}
+
+// shifts longitudes by multiples of 360° so consecutive vertices are never more than 180° apart
+func unwrapLon(coords []Coordinate, ref float64) []Coordinate {
+	unwrapped := make([]Coordinate, len(coords))
+	prev := ref
+	for i, c := range coords {
+		c.lon = prev + wrapLon(c.lon-prev)
+		unwrapped[i] = c
+		prev = c.lon
+	}
+	return unwrapped
+}
+
+// Sutherland–Hodgman clipping of a closed ring against the meridian at edge, keeping
+// the side towards the prime meridian when near is set, or the side beyond otherwise
+func clipLon(coords []Coordinate, edge float64, near bool) (clipped []Coordinate) {
+	inside := func(c Coordinate) bool {
+		return (math.Abs(c.lon) <= math.Abs(edge)) == near || c.lon == edge
+	}
+	for i := 1; i < len(coords); i++ {
+		a, b := coords[i-1], coords[i]
+		ain, bin := inside(a), inside(b)
+		if ain {
+			clipped = append(clipped, a)
+		}
+		if ain != bin && a.lon != edge && b.lon != edge {
+			t := (edge - a.lon) / (b.lon - a.lon)
+			clipped = append(clipped, Coordinate{lat: a.lat + (b.lat-a.lat)*t, lon: edge})
+		}
+	}
+	if len(clipped) < 3 {
+		return nil
+	}
+	return append(clipped, clipped[0])
+}
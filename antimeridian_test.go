@@ -0,0 +1,86 @@
+package philifence
+
+import (
+	"testing"
+)
+
+func TestSplitAntimeridianPolygon(t *testing.T) {
+	// 170°E to 170°W, the short way round
+	feature := NewPolygonFeature(NewPoly(cd(-10, 170), cd(-10, -170), cd(10, -170), cd(10, 170), cd(-10, 170)))
+	feature.splitAntimeridian()
+	if len(feature.Geometry) != 2 {
+		t.Fatalf("Expected 2 parts, got %v", feature.Geometry)
+	}
+	boxes := []Box{
+		{min: cd(-10, 170), max: cd(10, 180)},
+		{min: cd(-10, -180), max: cd(10, -170)},
+	}
+	for i, poly := range feature.Geometry {
+		if poly.computeBox() != boxes[i] {
+			t.Errorf("Wrong box for part %d, %v", i, poly.computeBox())
+		}
+	}
+	for _, c := range []Coordinate{cd(0, 175), cd(0, -175), cd(5, 179.9)} {
+		if !feature.Contains(c) {
+			t.Errorf("Split polygon !contains %v", c)
+		}
+	}
+	if feature.Contains(cd(0, 0)) {
+		t.Errorf("Split polygon contains the prime meridian")
+	}
+}
+
+func TestSplitAntimeridianLine(t *testing.T) {
+	feature := NewLineFeature(NewPoly(cd(0, 170), cd(10, -170), cd(10, -160)))
+	feature.splitAntimeridian()
+	if len(feature.Geometry) != 2 {
+		t.Fatalf("Expected 2 parts, got %v", feature.Geometry)
+	}
+	west, east := feature.Geometry[0].Exterior.Coordinates, feature.Geometry[1].Exterior.Coordinates
+	if len(west) != 2 || west[1] != cd(5, 180) {
+		t.Errorf("Wrong western part %v", west)
+	}
+	if len(east) != 3 || east[0] != cd(5, -180) {
+		t.Errorf("Wrong eastern part %v", east)
+	}
+}
+
+func TestRectsFromCenter(t *testing.T) {
+	if rects := rectsFromCenter(cd(10, 123), 1000); len(rects) != 1 {
+		t.Errorf("Expected 1 box, got %d", len(rects))
+	}
+	rects := rectsFromCenter(cd(0, 179.999), 1000)
+	if len(rects) != 2 {
+		t.Fatalf("Expected 2 boxes across the antimeridian, got %d", len(rects))
+	}
+	if rects[0].box.max.lon != 180 || rects[1].box.min.lon != -180 {
+		t.Errorf("Wrong boxes across the antimeridian %v %v", rects[0].box, rects[1].box)
+	}
+	rects = rectsFromCenter(cd(89.999, 0), 1000)
+	if len(rects) != 1 || rects[0].box.min.lon != -180 || rects[0].box.max.lon != 180 || rects[0].box.max.lat != 90 {
+		t.Errorf("Expected a box around the pole, got %v", rects[0].box)
+	}
+}
+
+func TestFenceAcrossAntimeridian(t *testing.T) {
+	fence, _ := NewFence()
+	feature := NewPolygonFeature(NewPoly(cd(-10, 170), cd(-10, -170), cd(10, -170), cd(10, 170), cd(-10, 170)))
+	feature.splitAntimeridian()
+	fence.Add(feature)
+	for _, c := range []Coordinate{cd(0, 179.9999), cd(0, -179.9999)} {
+		if matchs := fence.Get(c, 100); len(matchs) != 1 {
+			t.Errorf("Expected %v in fence, got %v", c, matchs)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, c := range []Coordinate{cd(90.1, 0), cd(-91, 0), cd(0, 180.5), cd(0, -181)} {
+		if c.validate() == nil {
+			t.Errorf("Expected %v to be invalid", c)
+		}
+	}
+	if err := cd(-90, 180).validate(); err != nil {
+		t.Errorf("Expected valid coordinate, got %v", err)
+	}
+}
@@ -0,0 +1,347 @@
+package philifence
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	AccessRead  = "read"  // search, list, join, tiles, events and /metrics
+	AccessWrite = "write" // add and delete indices, post locations
+	AccessAdmin = "admin" // /admin/reload and /debug/pprof
+)
+
+// resources of the routes that aren't about an index
+const (
+	ResourceAdmin   = "admin"
+	ResourceDebug   = "debug"
+	ResourceMetrics = "metrics"
+	ResourceAll     = "*" // every index, e.g. events without an index filter
+)
+
+// how far the time of an HMAC signed request may be from the server's
+var HMACMaxSkew = 5 * time.Minute
+
+// Grant gives access to the indices matching a {layer}/{index} pattern, as in path.Match, or
+// a route's resource. "*" matches everything of the principal's own tenant, other tenants'
+// only being reached by patterns naming them, such as t/*/fence/*.
+type Grant struct {
+	Index  string   `json:"index"`
+	Access []string `json:"access"` // AccessRead, AccessWrite or AccessAdmin
+}
+
+func (g Grant) allows(access, resource string) bool {
+	ok := g.Index == ResourceAll && !strings.HasPrefix(resource, "t/")
+	if !ok {
+		ok, _ = path.Match(g.Index, resource)
+	}
+	if !ok {
+		return false
+	}
+	for _, a := range g.Access {
+		if a == access {
+			return true
+		}
+	}
+	return false
+}
+
+// Principal is who a request authenticated as, and what it may do in its tenant. Grants of
+// the default tenant's principals reach into the others as t/{tenant}/{layer}/{index}.
+type Principal struct {
+	Name   string
+	Tenant string // DefaultTenant, or the only one the principal has access to
+	Grants []Grant
+}
+
+func (p *Principal) Allowed(access, resource string) bool {
+	if p == nil {
+		return false
+	}
+	for _, g := range p.Grants {
+		if g.allows(access, resource) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedIn is whether the principal may access the resource of the tenant
+func (p *Principal) allowedIn(tenant, access, resource string) bool {
+	switch {
+	case p == nil:
+		return false
+	case p.Tenant == tenant:
+		return p.Allowed(access, resource)
+	case p.Tenant == DefaultTenant:
+		return p.Allowed(access, "t/"+tenant+"/"+resource)
+	}
+	return false
+}
+
+// Authenticator finds the principal of a request, or nil when the request doesn't carry its
+// kind of credentials. Credentials it can't verify are an error.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// Auth authenticates requests with the first authenticator that recognises their credentials,
+// those without any are the anonymous principal, which may be nil
+type Auth struct {
+	Authenticators []Authenticator
+	Anonymous      *Principal
+}
+
+func (a *Auth) authenticate(r *http.Request) (*Principal, error) {
+	for _, authn := range a.Authenticators {
+		p, err := authn.Authenticate(r)
+		if p != nil || err != nil {
+			return p, err
+		}
+	}
+	return a.Anonymous, nil
+}
+
+// SetAuth enforces authentication and authorisation on every route but /healthz and /readyz,
+// a server without is open to anyone
+func (s *Server) SetAuth(auth *Auth) {
+	s.auth = auth
+}
+
+type principalKey struct{}
+
+// PrincipalFrom is the principal a request to a server with Auth authenticated as
+func PrincipalFrom(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+// allowed is whether the request may access the resource of the tenant, always true
+// without Auth
+func (s *Server) allowed(r *http.Request, tenant, access, resource string) bool {
+	if s.auth == nil {
+		return true
+	}
+	p, _ := PrincipalFrom(r.Context())
+	return p.allowedIn(tenant, access, resource)
+}
+
+// guard authenticates the request and checks it has the access to every resource it names
+// before handing it on with the principal in its context
+func (s *Server) guard(access string, resources func(*http.Request, httprouter.Params) []string, handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		if s.auth == nil {
+			handle(w, r, params)
+			return
+		}
+		p, err := s.auth.authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="philifence"`)
+			respondError(w, http.StatusUnauthorized, ErrorUnauthorized, err.Error())
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), principalKey{}, p))
+		tenant := params.ByName("tenant")
+		for _, resource := range resources(r, params) {
+			if p.allowedIn(tenant, access, resource) {
+				continue
+			}
+			if p == nil || p == s.auth.Anonymous {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="philifence"`)
+				respondError(w, http.StatusUnauthorized, ErrorUnauthorized, "Credentials required")
+				return
+			}
+			respondError(w, http.StatusForbidden, ErrorForbidden, sprintf("%q has no %s access to %s", p.Name, access, resource))
+			return
+		}
+		handle(w, r, params)
+	}
+}
+
+// the resources of the routes
+func resource(name string) func(*http.Request, httprouter.Params) []string {
+	return func(*http.Request, httprouter.Params) []string {
+		return []string{name}
+	}
+}
+
+func layerIndex(layer string) func(*http.Request, httprouter.Params) []string {
+	return func(r *http.Request, params httprouter.Params) []string {
+		return []string{layer + "/" + params.ByName("name")}
+	}
+}
+
+// anyone authenticated, the handler filtering what it responds with
+func noResource(*http.Request, httprouter.Params) []string {
+	return nil
+}
+
+func joinIndices(r *http.Request, params httprouter.Params) []string {
+	query := r.URL.Query()
+	return []string{query.Get("left"), query.Get("right")}
+}
+
+// every index when the stream isn't filtered to some
+func eventIndices(r *http.Request, params httprouter.Params) []string {
+	if indices := eventFilter(r.URL.Query()).Indices; len(indices) > 0 {
+		return indices
+	}
+	return []string{ResourceAll}
+}
+
+// the indices rendered, those of the index param or all of the tenant's layer's
+func (s *Server) tileIndices(r *http.Request, params httprouter.Params) (indices []string) {
+	layers, _, ok := s.tenantScope(params.ByName("tenant"))
+	if !ok {
+		return nil
+	}
+	layer, ok := layers[params.ByName("layer")]
+	if !ok {
+		return nil
+	}
+	names := splitParam(r.URL.Query(), "index")
+	if len(names) == 0 {
+		names = layer.Index.Keys()
+	}
+	for _, name := range names {
+		indices = append(indices, layer.Name+"/"+name)
+	}
+	return
+}
+
+// APIKeys authenticates the X-API-Key header
+type APIKeys struct {
+	keys map[[sha256.Size]byte]*Principal // by hashed key, so lookups don't leak the keys' bytes
+}
+
+func NewAPIKeys() *APIKeys {
+	return &APIKeys{keys: make(map[[sha256.Size]byte]*Principal)}
+}
+
+func (a *APIKeys) Add(key string, p *Principal) {
+	a.keys[sha256.Sum256([]byte(key))] = p
+}
+
+func (a *APIKeys) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		return nil, nil
+	}
+	p, ok := a.keys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return nil, errorf("Unknown API key")
+	}
+	return p, nil
+}
+
+// HMACKeys authenticates requests signed with a shared secret, as
+//
+//	Authorization: HMAC id={key id},ts={unix seconds},nonce={optional},sig={hex HMAC-SHA256}
+//
+// over the method, request uri, ts, the nonce when given and the hex SHA-256 of the body, each
+// on a line of its own. A signature is only taken once, so resending a request within the
+// same second, when it would sign the same, takes a nonce.
+type HMACKeys struct {
+	keys  map[string]hmacKey
+	seen  map[string]time.Time // signatures taken, until their ts is past HMACMaxSkew
+	swept time.Time
+	mu    sync.Mutex
+}
+
+type hmacKey struct {
+	secret    []byte
+	principal *Principal
+}
+
+func NewHMACKeys() *HMACKeys {
+	return &HMACKeys{keys: make(map[string]hmacKey), seen: make(map[string]time.Time)}
+}
+
+func (h *HMACKeys) Add(id, secret string, p *Principal) {
+	h.keys[id] = hmacKey{[]byte(secret), p}
+}
+
+func (h *HMACKeys) Authenticate(r *http.Request) (*Principal, error) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "HMAC ") {
+		return nil, nil
+	}
+	fields := make(map[string]string, 4)
+	for _, field := range strings.Split(strings.TrimPrefix(auth, "HMAC "), ",") {
+		if kv := strings.SplitN(strings.TrimSpace(field), "=", 2); len(kv) == 2 {
+			fields[kv[0]] = kv[1]
+		}
+	}
+	key, ok := h.keys[fields["id"]]
+	if !ok {
+		return nil, errorf("Unknown HMAC key %q", fields["id"])
+	}
+	ts, err := strconv.ParseInt(fields["ts"], 10, 64)
+	if err != nil {
+		return nil, errorf("Invalid HMAC timestamp %q", fields["ts"])
+	}
+	if skew := time.Since(time.Unix(ts, 0)); skew > HMACMaxSkew || skew < -HMACMaxSkew {
+		return nil, errorf("HMAC timestamp more than %v off", HMACMaxSkew)
+	}
+	sig, err := hex.DecodeString(fields["sig"])
+	if err != nil {
+		return nil, errorf("Invalid HMAC signature")
+	}
+	body, err := ioutil.ReadAll(limitBody(r.Body))
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if !hmac.Equal(sig, SignHMAC(key.secret, r.Method, r.URL.RequestURI(), ts, fields["nonce"], body)) {
+		return nil, errorf("Invalid HMAC signature")
+	}
+	if !h.take(hex.EncodeToString(sig), ts) {
+		return nil, errorf("HMAC signature already used")
+	}
+	return key.principal, nil
+}
+
+// takes a signature unless it was already, forgetting those whose ts would be refused anyway
+func (h *HMACKeys) take(sig string, ts int64) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	if now.Sub(h.swept) > HMACMaxSkew {
+		for s, until := range h.seen {
+			if now.After(until) {
+				delete(h.seen, s)
+			}
+		}
+		h.swept = now
+	}
+	if _, ok := h.seen[sig]; ok {
+		return false
+	}
+	h.seen[sig] = time.Unix(ts, 0).Add(HMACMaxSkew)
+	return true
+}
+
+// SignHMAC is the signature HMACKeys expects of a request, the nonce being left out when empty
+func SignHMAC(secret []byte, method, uri string, ts int64, nonce string, body []byte) []byte {
+	sum := sha256.Sum256(body)
+	signed := method + "\n" + uri + "\n" + strconv.FormatInt(ts, 10) + "\n"
+	if nonce != "" {
+		signed += nonce + "\n"
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed + hex.EncodeToString(sum[:])))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,178 @@
+package philifence
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func authServer(t *testing.T) *Server {
+	a := NewPolygonFeature(square(0, 0, 2))
+	a.Properties = map[string]interface{}{"id": "a"}
+	s := serverWith(t, KindFence, KindFence, a)
+	s.layers[KindFence].Index.Set("towns", s.layers[KindFence].Index.Get("cities"))
+	keys := NewAPIKeys()
+	keys.Add("reader-key", &Principal{Name: "reader", Grants: []Grant{{Index: "fence/*", Access: []string{AccessRead}}}})
+	keys.Add("writer-key", &Principal{Name: "writer", Grants: []Grant{{Index: "fence/cities", Access: []string{AccessRead, AccessWrite}}}})
+	keys.Add("admin-key", &Principal{Name: "admin", Grants: []Grant{{Index: "*", Access: []string{AccessRead, AccessWrite, AccessAdmin}}}})
+	hmacs := NewHMACKeys()
+	hmacs.Add("partner", "secret", &Principal{Name: "partner", Grants: []Grant{{Index: "fence/towns", Access: []string{AccessRead}}}})
+	s.SetAuth(&Auth{Authenticators: []Authenticator{keys, hmacs}})
+	s.Profile()
+	return s
+}
+
+func serveAs(s *Server, method, path, body string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+func TestAPIKeys(t *testing.T) {
+	const feature = `{"type": "Feature", "id": "b", "properties": {}, "geometry": {"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [1, 1], [0, 0]]]}}`
+	cases := []struct {
+		key, method, path string
+		status            int
+	}{
+		{"", "GET", "/fence/cities/search?lat=1&lon=1", 401},
+		{"wrong-key", "GET", "/fence/cities/search?lat=1&lon=1", 401},
+		{"reader-key", "GET", "/fence/cities/search?lat=1&lon=1", 200},
+		{"reader-key", "POST", "/fence/cities/add", 403},
+		{"writer-key", "POST", "/fence/cities/add", 200},
+		{"writer-key", "POST", "/fence/towns/add", 403},
+		{"writer-key", "GET", "/join?left=fence/cities&right=fence/towns", 403},
+		{"reader-key", "GET", "/join?left=fence/cities&right=fence/towns", 200},
+		{"reader-key", "GET", "/tiles/fence/0/0/0.mvt", 200},
+		{"writer-key", "GET", "/tiles/fence/0/0/0.mvt", 403},
+		{"reader-key", "GET", "/admin/reload", 403},
+		{"admin-key", "GET", "/admin/reload", 200},
+		{"reader-key", "GET", "/debug/pprof/cmdline", 403},
+		{"admin-key", "GET", "/debug/pprof/cmdline", 200},
+		{"reader-key", "GET", "/metrics", 403},
+		{"", "GET", "/healthz", 200},
+	}
+	s := authServer(t)
+	for _, c := range cases {
+		r := serveAs(s, c.method, c.path, feature, "X-API-Key", c.key)
+		if r.Code != c.status {
+			t.Errorf("%s %s as %q: expected %d, got %d %s", c.method, c.path, c.key, c.status, r.Code, r.Body)
+		}
+	}
+
+	var indices []string
+	json.Unmarshal(serveAs(s, "GET", "/fence", "", "X-API-Key", "writer-key").Body.Bytes(), &indices)
+	if len(indices) != 1 || indices[0] != "cities" {
+		t.Errorf("Expected the list to only have readable indices, got %v", indices)
+	}
+
+	s.auth.Anonymous = &Principal{Name: "anonymous", Grants: []Grant{{Index: "fence/towns", Access: []string{AccessRead}}}}
+	if r := serveAs(s, "GET", "/fence/towns/search?lat=1&lon=1", ""); r.Code != 200 {
+		t.Errorf("Expected anonymous reads, got %d %s", r.Code, r.Body)
+	}
+	if r := serveAs(s, "GET", "/fence/cities/search?lat=1&lon=1", ""); r.Code != 401 {
+		t.Errorf("Expected anonymous reads to be limited to their grants, got %d", r.Code)
+	}
+}
+
+func TestHMACKeys(t *testing.T) {
+	s := authServer(t)
+	body := `[{"id": "x", "lat": 1, "lon": 1}]`
+	nonce := ""
+	sign := func(uri string, ts int64, secret, body string) string {
+		sig := SignHMAC([]byte(secret), "POST", uri, ts, nonce, []byte(body))
+		return "HMAC id=partner,ts=" + strconv.FormatInt(ts, 10) + ",nonce=" + nonce + ",sig=" + hex.EncodeToString(sig)
+	}
+	now := time.Now().Unix()
+	uri := "/fence/towns/search/batch"
+	cases := []struct {
+		auth   string
+		status int
+	}{
+		{sign(uri, now, "secret", body), 200},
+		{sign(uri, now, "guess", body), 401},
+		{sign(uri, now, "secret", `[]`), 401},
+		{sign(uri, now-3600, "secret", body), 401},
+		{sign("/fence/cities/search/batch", now, "secret", body), 401},
+	}
+	for i, c := range cases {
+		if r := serveAs(s, "POST", uri, body, "Authorization", c.auth); r.Code != c.status {
+			t.Errorf("Case %d: expected %d, got %d %s", i, c.status, r.Code, r.Body)
+		}
+	}
+	// a signature is only taken once, the same request takes a nonce to be sent again
+	if r := serveAs(s, "POST", uri, body, "Authorization", cases[0].auth); r.Code != 401 {
+		t.Errorf("Expected a replayed signature refused, got %d", r.Code)
+	}
+	nonce = "2"
+	if r := serveAs(s, "POST", uri, body, "Authorization", sign(uri, now, "secret", body)); r.Code != 200 {
+		t.Errorf("Expected the request with a nonce taken, got %d %s", r.Code, r.Body)
+	}
+	uri = "/fence/cities/search/batch"
+	if r := serveAs(s, "POST", uri, body, "Authorization", sign(uri, now, "secret", body)); r.Code != 403 {
+		t.Errorf("Expected no access to other indices, got %d", r.Code)
+	}
+}
+
+func TestJWTVerifier(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	enc := base64.RawURLEncoding
+	jwks := `{"keys": [{"kty": "EC", "kid": "k1", "use": "sig", "crv": "P-256", "x": "` + enc.EncodeToString(key.X.FillBytes(make([]byte, 32))) +
+		`", "y": "` + enc.EncodeToString(key.Y.FillBytes(make([]byte, 32))) + `"}]}`
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	ioutil.WriteFile(path, []byte(jwks), 0644)
+	verifier, err := LoadJWKS(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier.Audience = "philifence"
+	sign := func(claims map[string]interface{}) string {
+		header, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": "k1"})
+		payload, _ := json.Marshal(claims)
+		signed := enc.EncodeToString(header) + "." + enc.EncodeToString(payload)
+		digest := sha256.Sum256([]byte(signed))
+		r, s, _ := ecdsa.Sign(rand.Reader, key, digest[:])
+		return signed + "." + enc.EncodeToString(append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...))
+	}
+	s := authServer(t)
+	s.auth.Authenticators = []Authenticator{verifier}
+	exp := time.Now().Add(time.Hour).Unix()
+	cases := []struct {
+		token  string
+		status int
+	}{
+		{sign(map[string]interface{}{"sub": "app", "aud": "philifence", "exp": exp, "scope": "read:fence/cities"}), 200},
+		{sign(map[string]interface{}{"sub": "app", "aud": []string{"other", "philifence"}, "exp": exp, "scope": "read:fence/*"}), 200},
+		{sign(map[string]interface{}{"sub": "app", "aud": "philifence", "exp": exp, "scope": "read:fence/towns"}), 403},
+		{sign(map[string]interface{}{"sub": "app", "aud": "philifence", "exp": time.Now().Add(-time.Hour).Unix(), "scope": "read:fence/cities"}), 401},
+		{sign(map[string]interface{}{"sub": "app", "aud": "other", "exp": exp, "scope": "read:fence/cities"}), 401},
+		{sign(map[string]interface{}{"sub": "app", "aud": "philifence", "scope": "read:fence/cities"}), 401},
+		{sign(map[string]interface{}{"sub": "app", "aud": "philifence", "iat": time.Now().Unix(), "scope": "read:fence/cities"}), 200},
+		{sign(map[string]interface{}{"sub": "app", "aud": "philifence", "iat": time.Now().Add(-2 * time.Hour).Unix(), "scope": "read:fence/cities"}), 401},
+		{sign(map[string]interface{}{"sub": "app", "aud": "philifence", "exp": exp, "scope": "read:fence/cities"})[:20] + "x", 401},
+	}
+	for i, c := range cases {
+		r := serveAs(s, "GET", "/fence/cities/search?lat=1&lon=1", "", "Authorization", "Bearer "+c.token)
+		if r.Code != c.status {
+			t.Errorf("Case %d: expected %d, got %d %s", i, c.status, r.Code, r.Body)
+		}
+		if r.Code == http.StatusUnauthorized && r.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("Case %d: expected a WWW-Authenticate challenge", i)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package philifence
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// maximum number of goroutines used to answer a single batch request
+var BatchConcurrency = runtime.NumCPU()
+
+// BatchQuery is a single point lookup within a batch request
+type BatchQuery struct {
+	Id        BatchId `json:"id"`
+	Lat       float64 `json:"lat"`
+	Lon       float64 `json:"lon"`
+	Tolerance float64 `json:"tolerance"`
+}
+
+func (q BatchQuery) Coordinate() Coordinate {
+	return Coordinate{lat: q.Lat, lon: q.Lon}
+}
+
+// BatchId accepts both string and numeric ids, e.g. {"id": "ping-1"} or {"id": 1}
+type BatchId string
+
+func (id *BatchId) UnmarshalJSON(b []byte) (err error) {
+	var s string
+	if err = json.Unmarshal(b, &s); err == nil {
+		*id = BatchId(s)
+		return
+	}
+	var n json.Number
+	if err = json.Unmarshal(b, &n); err != nil {
+		return errorf("Batch id must be a string or number, got %s", b)
+	}
+	*id = BatchId(n.String())
+	return
+}
+
+// Reads a batch of queries, whose ids have to be unique as they key the results
+func decodeBatch(r io.Reader) (queries []BatchQuery, err error) {
+	if queries, err = decodeQueries(r); err != nil {
+		return
+	}
+	seen := make(map[BatchId]bool, len(queries))
+	for _, q := range queries {
+		if seen[q.Id] {
+			return nil, errorf("Duplicate query id %q", q.Id)
+		}
+		seen[q.Id] = true
+	}
+	return
+}
+
+// Reads either a json array of queries or a newline delimited stream of query objects
+func decodeQueries(r io.Reader) (queries []BatchQuery, err error) {
+	buf := bufio.NewReader(r)
+	first, err := peekNonSpace(buf)
+	if err != nil {
+		if err == io.EOF {
+			err = errorf("Empty batch")
+		}
+		return
+	}
+	dec := json.NewDecoder(buf)
+	if first == '[' {
+		err = dec.Decode(&queries)
+	} else {
+		for {
+			var q BatchQuery
+			if err = dec.Decode(&q); err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				break
+			}
+			queries = append(queries, q)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range queries {
+		if queries[i].Id == "" {
+			queries[i].Id = BatchId(strconv.Itoa(i))
+		}
+		if queries[i].Tolerance == 0 {
+			queries[i].Tolerance = 1 // ~1m
+		}
+	}
+	return
+}
+
+func peekNonSpace(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b, r.UnreadByte()
+	}
+}
+
+// GetBatch answers every query against the fence using at most BatchConcurrency goroutines.
+// The fence must not be modified while this runs.
+func (r *Fence) GetBatch(queries []BatchQuery) map[string][]*Feature {
+	return getBatch(queries, r.Get)
+}
+
+// EachBatch answers the queries like GetBatch, calling fn from a single goroutine with the
+// matches of each query as it is answered, in no particular order. It stops at the first
+// error fn returns or once ctx is done, returning that error.
+func (r *Fence) EachBatch(ctx context.Context, queries []BatchQuery, fn func(id string, matchs []*Feature) error) error {
+	return eachBatch(ctx, queries, r.Get, fn)
+}
+
+func getBatch(queries []BatchQuery, get func(c Coordinate, tol float64) []*Feature) map[string][]*Feature {
+	results := make(map[string][]*Feature, len(queries))
+	eachBatch(context.Background(), queries, get, func(id string, matchs []*Feature) error {
+		results[id] = matchs
+		return nil
+	})
+	return results
+}
+
+type batchResult struct {
+	query  int
+	matchs []*Feature
+}
+
+func eachBatch(ctx context.Context, queries []BatchQuery, get func(c Coordinate, tol float64) []*Feature, fn func(id string, matchs []*Feature) error) (err error) {
+	workers := BatchConcurrency
+	if workers > len(queries) {
+		workers = len(queries)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan int)
+	results := make(chan batchResult, workers)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				q := queries[i]
+				results <- batchResult{i, get(q.Coordinate(), q.Tolerance)}
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for i := range queries {
+			select {
+			case jobs <- i:
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	n := 0
+	for res := range results {
+		if err != nil {
+			continue // drain the workers
+		}
+		if err = ctx.Err(); err == nil {
+			err = fn(string(queries[res.query].Id), res.matchs)
+		}
+		if err != nil {
+			close(stop)
+		}
+		n++
+	}
+	if err == nil && n < len(queries) {
+		err = ctx.Err()
+	}
+	return
+}
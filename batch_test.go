@@ -0,0 +1,75 @@
+package philifence
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDecodeBatch(t *testing.T) {
+	bodies := []string{
+		`[{"id": "a", "lat": 10.2925, "lon": 123.9056, "tolerance": 5}, {"id": 2, "lat": 14.6503, "lon": 121.0520}]`,
+		"{\"id\": \"a\", \"lat\": 10.2925, \"lon\": 123.9056, \"tolerance\": 5}\n{\"id\": 2, \"lat\": 14.6503, \"lon\": 121.0520}\n",
+	}
+	for _, body := range bodies {
+		queries, err := decodeBatch(strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Unable to decode batch %v", err)
+		}
+		if len(queries) != 2 {
+			t.Fatalf("Expected 2 queries, got %d", len(queries))
+		}
+		if queries[0].Id != "a" || queries[1].Id != "2" {
+			t.Errorf("Wrong batch ids %v", queries)
+		}
+		if queries[0].Tolerance != 5 || queries[1].Tolerance != 1 {
+			t.Errorf("Wrong batch tolerance %v", queries)
+		}
+	}
+	if _, err := decodeBatch(strings.NewReader(`[{"id": "a", "lat": 1, "lon": 1}, {"id": "a", "lat": 2, "lon": 2}]`)); err == nil {
+		t.Errorf("Expected duplicate ids to be rejected")
+	}
+	if _, err := decodeBatch(strings.NewReader(`[{"id": "1", "lat": 1, "lon": 1}, {"lat": 2, "lon": 2}]`)); err == nil {
+		t.Errorf("Expected an id clashing with a query's position to be rejected")
+	}
+}
+
+func TestFenceGetBatch(t *testing.T) {
+	fence, err := NewFence()
+	if err != nil {
+		t.Fatal(err)
+	}
+	square := NewPolygonFeature(NewPoly(cd(0, 0), cd(0, 10), cd(10, 10), cd(10, 0), cd(0, 0)))
+	fence.Add(square)
+	queries := []BatchQuery{
+		{Id: "in", Lat: 5, Lon: 5, Tolerance: 1},
+		{Id: "out", Lat: 20, Lon: 20, Tolerance: 1},
+	}
+	matchs := fence.GetBatch(queries)
+	if len(matchs["in"]) != 1 {
+		t.Errorf("Expected a match for %v", queries[0])
+	}
+	if len(matchs["out"]) != 0 {
+		t.Errorf("Expected no match for %v", queries[1])
+	}
+}
+
+func TestFenceEachBatchCancel(t *testing.T) {
+	fence, _ := newFenceOf([]*Feature{NewPolygonFeature(square(0, 0, 10))})
+	queries := make([]BatchQuery, 1000)
+	for i := range queries {
+		queries[i] = BatchQuery{Id: BatchId(strconv.Itoa(i)), Lat: 5, Lon: 5, Tolerance: 1}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	n := 0
+	err := fence.EachBatch(ctx, queries, func(id string, matchs []*Feature) error {
+		if n++; n == 10 {
+			cancel()
+		}
+		return nil
+	})
+	if err != context.Canceled || n != 10 {
+		t.Errorf("Expected the batch to stop once cancelled, got %v after %d", err, n)
+	}
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/codegangsta/cli"
 	"github.com/jtejido/philifence"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 var version = "0.0.1"
@@ -16,11 +20,19 @@ func client(args []string) {
 	app.Usage = "Putting up the White Picket-Fences and laying Yellow-Bricked roads around you."
 	app.Version = version
 	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "config, c",
+			Usage: "Json config file for the server and datasets, replaces the path flags",
+		},
 		cli.StringFlag{
 			Name:  "port, p",
 			Value: "8080",
 			Usage: "Port to bind to",
 		},
+		cli.StringFlag{
+			Name:  "grpc-port",
+			Usage: "Port to serve the gRPC api on, off when left out",
+		},
 		cli.StringFlag{
 			Name:  "road-path, road",
 			Value: "../osm_philippine_roads_wgs84_2012/",
@@ -35,27 +47,278 @@ func client(args []string) {
 			Name:  "with-profiler",
 			Usage: "Profiling endpoints",
 		},
+		cli.DurationFlag{
+			Name:  "watch-interval",
+			Value: 30 * time.Second,
+			Usage: "How often to look for changed geojson files to reload, 0 to only reload through /admin/reload",
+		},
+		cli.DurationFlag{
+			Name:  "purge-interval",
+			Value: time.Minute,
+			Usage: "How often to purge features past their valid_until, 0 to never",
+		},
+		cli.DurationFlag{
+			Name:  "purge-retention",
+			Value: 24 * time.Hour,
+			Usage: "How long past their valid_until features are kept for searches at an earlier time",
+		},
+		cli.StringFlag{
+			Name:  "data-dir",
+			Usage: "Directory to save the indices' history to, and restore it from on start",
+		},
+		cli.BoolFlag{
+			Name:  "snapshot-reads",
+			Usage: "Serve searches from lock-free snapshots, so they never wait on writes",
+		},
+		cli.StringFlag{
+			Name:  "tls-cert",
+			Usage: "Certificate file to serve https and gRPC over TLS with, along with --tls-key",
+		},
+		cli.StringFlag{
+			Name:  "tls-key",
+			Usage: "Private key file of the certificate",
+		},
+		cli.StringFlag{
+			Name:  "tls-client-ca",
+			Usage: "CA file client certificates are verified against",
+		},
+		cli.DurationFlag{
+			Name:  "shutdown-timeout",
+			Value: 30 * time.Second,
+			Usage: "How long requests in flight get to finish on SIGTERM",
+		},
+	}
+	app.Commands = []cli.Command{
+		{
+			Name:  "join",
+			Usage: "Lists every pair of intersecting features between two geojson files",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "left, l",
+					Usage: "Geojson file for the left side, e.g. cities",
+				},
+				cli.StringFlag{
+					Name:  "right, r",
+					Usage: "Geojson file for the right side, e.g. roads",
+				},
+				cli.StringFlag{
+					Name:  "format, f",
+					Value: "csv",
+					Usage: "Output format, csv or geojson",
+				},
+				cli.StringFlag{
+					Name:  "output, o",
+					Usage: "Output file (default: stdout)",
+				},
+				cli.BoolFlag{
+					Name:  "measure",
+					Usage: "Include intersection length (meters) and area (square meters)",
+				},
+			},
+			Action: join,
+		},
+		{
+			Name:  "match",
+			Usage: "Matches a gps trace onto the roads of a geojson file, writing the snapped trace as geojson",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "roads",
+					Usage: "Geojson file of the roads",
+				},
+				cli.StringFlag{
+					Name:  "trace, t",
+					Usage: "GPX or geojson file of the trace",
+				},
+				cli.StringFlag{
+					Name:  "output, o",
+					Usage: "Output file (default: stdout)",
+				},
+				cli.Float64Flag{
+					Name:  "radius",
+					Value: philifence.MatchRadius,
+					Usage: "Meters around a ping within which roads are candidates, at most 500",
+				},
+				cli.Float64Flag{
+					Name:  "sigma",
+					Value: philifence.MatchSigma,
+					Usage: "Meters of gps noise",
+				},
+				cli.Float64Flag{
+					Name:  "beta",
+					Value: philifence.MatchBeta,
+					Usage: "Meters routes between pings tend to differ from straight lines",
+				},
+			},
+			Action: match,
+		},
+		{
+			Name:  "config",
+			Usage: "Works with config files",
+			Subcommands: []cli.Command{
+				{
+					Name:   "check",
+					Usage:  "Validates a config file and lists the indices it resolves to, e.g. config check philifence.json",
+					Action: checkConfig,
+				},
+			},
+		},
 	}
 	app.Action = func(c *cli.Context) {
 		log.Println("Starting PhiliFence")
-		fencePath := fmt.Sprintf("%s", c.String("fence-path"))
-		fences, err := philifence.LoadIndex(fencePath)
+		config, err := loadConfig(c)
 		if err != nil {
 			die(c, err.Error())
 		}
-		roadPath := fmt.Sprintf("%s", c.String("road-path"))
-		roads, err := philifence.LoadIndex(roadPath)
+		if err = config.Check(); err != nil {
+			die(c, err.Error())
+		}
+		indices := config.Indices()
+		watchers := config.Watchers(indices)
+		server, err := config.NewServer(indices, watchers)
 		if err != nil {
 			die(c, err.Error())
 		}
-		prof := c.Bool("with-profiler")
-		port := fmt.Sprintf(":%s", c.String("port"))
-		err = philifence.ListenAndServe(port, fences, roads, prof)
-		die(c, err.Error())
+		// served meanwhile, /readyz tells when the indices are all there
+		server.SetReady(false)
+		go func() {
+			if err := config.LoadInto(indices); err != nil {
+				die(c, err.Error())
+			}
+			if dir := config.Server.DataDir; dir != "" {
+				if err := server.RestoreHistory(dir); err != nil {
+					die(c, err.Error())
+				}
+				if err := server.ReplayJournals(); err != nil {
+					die(c, err.Error())
+				}
+			}
+			for _, w := range watchers {
+				w.Start()
+			}
+			server.PurgeEvery(config.Server.PurgeInterval.Duration, config.Server.PurgeRetention.Duration)
+			server.SetReady(true)
+			log.Println("Loaded every index")
+		}()
+		server.OnShutdown(func() error {
+			for _, w := range watchers {
+				w.Stop()
+			}
+			return nil
+		})
+		server.KeepHistory(config.Server.DataDir, config.Server.HistoryInterval.Duration, config.Server.FlushInterval.Duration)
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+		defer stop()
+		if err = server.Serve(ctx, config.Server); err != nil {
+			die(c, err.Error())
+		}
 	}
 	app.Run(args)
 }
 
+// the config file if given, otherwise one built from the flags
+func loadConfig(c *cli.Context) (*philifence.Config, error) {
+	if path := c.GlobalString("config"); path != "" {
+		return philifence.LoadConfig(path)
+	}
+	config := philifence.NewConfig(c.GlobalString("port"), c.GlobalString("fence-path"), c.GlobalString("road-path"))
+	config.Server.GRPCPort = c.GlobalString("grpc-port")
+	config.Server.Profiler = c.GlobalBool("with-profiler")
+	config.Server.SnapshotReads = c.GlobalBool("snapshot-reads")
+	config.Server.WatchInterval.Duration = c.GlobalDuration("watch-interval")
+	config.Server.PurgeInterval.Duration = c.GlobalDuration("purge-interval")
+	config.Server.PurgeRetention.Duration = c.GlobalDuration("purge-retention")
+	config.Server.DataDir = c.GlobalString("data-dir")
+	config.Server.ShutdownTimeout.Duration = c.GlobalDuration("shutdown-timeout")
+	config.Server.TLS = philifence.TLSConfig{
+		Cert:     c.GlobalString("tls-cert"),
+		Key:      c.GlobalString("tls-key"),
+		ClientCA: c.GlobalString("tls-client-ca"),
+	}
+	return config, nil
+}
+
+func checkConfig(c *cli.Context) {
+	path := c.Args().First()
+	if path == "" {
+		path = c.GlobalString("config")
+	}
+	if path == "" {
+		die(c, "Missing config file")
+	}
+	config, err := philifence.LoadConfig(path)
+	if err != nil {
+		die(c, err.Error())
+	}
+	config.Describe(os.Stdout)
+	if err = config.Check(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("OK")
+}
+
+func join(c *cli.Context) {
+	left, _, err := philifence.LoadFence(c.String("left"))
+	if err != nil {
+		die(c, err.Error())
+	}
+	right, _, err := philifence.LoadFence(c.String("right"))
+	if err != nil {
+		die(c, err.Error())
+	}
+	out := os.Stdout
+	if c.String("output") != "" {
+		out, err = os.Create(c.String("output"))
+		if err != nil {
+			die(c, err.Error())
+		}
+		defer out.Close()
+	}
+	switch c.String("format") {
+	case "csv":
+		err = philifence.WriteJoinCSV(out, left.Tree(), right.Tree(), c.Bool("measure"))
+	case "geojson":
+		err = philifence.WriteJoinGeoJson(out, left.Tree(), right.Tree(), c.Bool("measure"))
+	default:
+		err = fmt.Errorf("Unknown format %q", c.String("format"))
+	}
+	if err != nil {
+		die(c, err.Error())
+	}
+}
+
+func match(c *cli.Context) {
+	roads, _, err := philifence.LoadFence(c.String("roads"))
+	if err != nil {
+		die(c, err.Error())
+	}
+	in, err := os.Open(c.String("trace"))
+	if err != nil {
+		die(c, err.Error())
+	}
+	defer in.Close()
+	trace, err := philifence.ReadTrace(in)
+	if err != nil {
+		die(c, err.Error())
+	}
+	out := os.Stdout
+	if c.String("output") != "" {
+		out, err = os.Create(c.String("output"))
+		if err != nil {
+			die(c, err.Error())
+		}
+		defer out.Close()
+	}
+	opts := philifence.MatchOptions{Radius: c.Float64("radius"), Sigma: c.Float64("sigma"), Beta: c.Float64("beta")}
+	if opts.Radius > philifence.MatchMaxRadius {
+		die(c, fmt.Sprintf("Radius %v out of range [0, %v]", opts.Radius, philifence.MatchMaxRadius))
+	}
+	result := philifence.MatchTrace(roads.Tree(), trace, opts)
+	if err = philifence.WriteMatchGeoJson(out, result); err != nil {
+		die(c, err.Error())
+	}
+}
+
 func main() {
 	client(os.Args)
 }
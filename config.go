@@ -0,0 +1,621 @@
+package philifence
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config describes the datasets to index and how to serve them. It is read from a json file,
+// any setting of which can be overridden by the environment:
+//
+//	PHILIFENCE_PORT, PHILIFENCE_GRPC_PORT, PHILIFENCE_PROFILER, PHILIFENCE_SNAPSHOT_READS, PHILIFENCE_WATCH_INTERVAL
+//	PHILIFENCE_PURGE_INTERVAL, PHILIFENCE_PURGE_RETENTION, PHILIFENCE_DATA_DIR
+//	PHILIFENCE_TLS_CERT, PHILIFENCE_TLS_KEY, PHILIFENCE_TLS_CLIENT_CA, PHILIFENCE_SHUTDOWN_TIMEOUT
+//	PHILIFENCE_{GROUP}_PATH replaces the sources of a group with a single path
+type Config struct {
+	Server  ServerConfig   `json:"server"`
+	Groups  []GroupConfig  `json:"groups"`
+	Auth    *AuthConfig    `json:"auth"`   // every route is open when left out
+	Limits  *LimitConfig   `json:"limits"` // no limits when left out
+	Tenants []TenantConfig `json:"tenants"`
+	Objects []ObjectConfig `json:"objects"`
+}
+
+// ObjectConfig adds a layer of moving objects, served under /{name}
+type ObjectConfig struct {
+	Name     string   `json:"name"`
+	TTL      Duration `json:"ttl"`       // ObjectTTL when left out
+	CellSize float64  `json:"cell_size"` // degrees, ObjectCellSize when left out
+}
+
+// TenantConfig adds a tenant, served under /t/{name} with empty indices of its own
+type TenantConfig struct {
+	Name string `json:"name"`
+	TenantLimits
+}
+
+// AuthConfig lists who may do what. Requests without credentials get the anonymous grants.
+type AuthConfig struct {
+	Keys      []KeyConfig `json:"keys"` // sent as X-API-Key
+	HMAC      []KeyConfig `json:"hmac"` // the name being the key id, see HMACKeys
+	JWT       *JWTConfig  `json:"jwt"`
+	Anonymous []Grant     `json:"anonymous"`
+}
+
+type KeyConfig struct {
+	Name   string  `json:"name"`
+	Key    string  `json:"key"`    // the API key or the HMAC secret
+	Tenant string  `json:"tenant"` // the only tenant the key has access to, the default one when left out
+	Grants []Grant `json:"grants"`
+}
+
+type JWTConfig struct {
+	JWKS     string `json:"jwks"` // path of a JWKS file
+	Issuer   string `json:"issuer"`
+	Audience string `json:"audience"`
+}
+
+type ServerConfig struct {
+	Port            string   `json:"port"`
+	GRPCPort        string   `json:"grpc_port"` // gRPC is off when left out
+	Profiler        bool     `json:"profiler"`
+	SnapshotReads   bool     `json:"snapshot_reads"`   // for every group, see IndexOptions
+	WatchInterval   Duration `json:"watch_interval"`   // 0 only reloads through /admin/reload
+	PurgeInterval   Duration `json:"purge_interval"`   // how often expired features are purged, 0 for never
+	PurgeRetention  Duration `json:"purge_retention"`  // how long expired features are kept for searches at= before
+	AllowedOrigins  []string `json:"allowed_origins"`  // of pages that may open event websockets besides the server's own
+	DataDir         string   `json:"data_dir"`         // where the indices' history is saved, nowhere when left out
+	HistoryInterval Duration `json:"history_interval"` // how often it is saved, besides on shutdown
+	FlushInterval   Duration `json:"flush_interval"`   // how often the journal of writes since is flushed
+
+	// 0 for no timeout, event streams and joins outlive the write timeout
+	ReadTimeout       Duration  `json:"read_timeout"`
+	ReadHeaderTimeout Duration  `json:"read_header_timeout"`
+	WriteTimeout      Duration  `json:"write_timeout"`
+	IdleTimeout       Duration  `json:"idle_timeout"`
+	ShutdownTimeout   Duration  `json:"shutdown_timeout"` // how long requests in flight get to finish
+	MaxHeaderBytes    int       `json:"max_header_bytes"`
+	TLS               TLSConfig `json:"tls"`
+}
+
+// NewServerConfig is the server config with the default timeouts
+func NewServerConfig(port string) ServerConfig {
+	return ServerConfig{
+		Port:              port,
+		WatchInterval:     Duration{30 * time.Second},
+		PurgeInterval:     Duration{time.Minute},
+		PurgeRetention:    Duration{24 * time.Hour},
+		HistoryInterval:   Duration{HistorySaveInterval},
+		FlushInterval:     Duration{JournalFlushInterval},
+		ReadTimeout:       Duration{time.Minute},
+		ReadHeaderTimeout: Duration{10 * time.Second},
+		WriteTimeout:      Duration{time.Minute},
+		IdleTimeout:       Duration{2 * time.Minute},
+		ShutdownTimeout:   Duration{30 * time.Second},
+		MaxHeaderBytes:    1 << 16, // 64 KB
+	}
+}
+
+// GroupConfig is a named group of indices of one kind, built from any number of sources and
+// served as the layer of its name
+type GroupConfig struct {
+	Name    string         `json:"name"`
+	Kind    string         `json:"kind"` // KindFence, KindRoad or a registered kind
+	Sources []SourceConfig `json:"sources"`
+	Options IndexOptions   `json:"options"`
+}
+
+// SourceConfig is a geojson file, a directory of them or a glob, every file being an index
+type SourceConfig struct {
+	Path   string `json:"path"`
+	Format string `json:"format"` // FormatGeoJson, the default, or FormatGeoJsonSeq
+	Index  string `json:"index"`  // index name for a single file, defaults to the slug of its name
+}
+
+// Duration reads durations such as "30s" from json
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) (err error) {
+	var s string
+	if err = json.Unmarshal(b, &s); err != nil {
+		return
+	}
+	d.Duration, err = time.ParseDuration(s)
+	return
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// NewConfig is the configuration the cli runs with by default, a fence and a road group
+// each from a directory, served as /fence and /road
+func NewConfig(port, fencePath, roadPath string) *Config {
+	return &Config{
+		Server: NewServerConfig(port),
+		Groups: []GroupConfig{
+			{Name: KindFence, Kind: KindFence, Sources: []SourceConfig{{Path: fencePath}}},
+			{Name: KindRoad, Kind: KindRoad, Sources: []SourceConfig{{Path: roadPath}}},
+		},
+	}
+}
+
+// LoadConfig reads a json config file and applies the environment overrides
+func LoadConfig(path string) (config *Config, err error) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	config = &Config{Server: NewServerConfig("8080")}
+	if err = json.Unmarshal(file, config); err != nil {
+		return nil, fmt.Errorf("Invalid config %s: %v", path, err)
+	}
+	if err = config.applyEnv(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (c *Config) applyEnv() (err error) {
+	if v, ok := os.LookupEnv("PHILIFENCE_PORT"); ok {
+		c.Server.Port = v
+	}
+	if v, ok := os.LookupEnv("PHILIFENCE_GRPC_PORT"); ok {
+		c.Server.GRPCPort = v
+	}
+	if v, ok := os.LookupEnv("PHILIFENCE_PROFILER"); ok {
+		if c.Server.Profiler, err = strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("Invalid PHILIFENCE_PROFILER: %v", err)
+		}
+	}
+	if v, ok := os.LookupEnv("PHILIFENCE_SNAPSHOT_READS"); ok {
+		if c.Server.SnapshotReads, err = strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("Invalid PHILIFENCE_SNAPSHOT_READS: %v", err)
+		}
+	}
+	if v, ok := os.LookupEnv("PHILIFENCE_WATCH_INTERVAL"); ok {
+		if c.Server.WatchInterval.Duration, err = time.ParseDuration(v); err != nil {
+			return fmt.Errorf("Invalid PHILIFENCE_WATCH_INTERVAL: %v", err)
+		}
+	}
+	if v, ok := os.LookupEnv("PHILIFENCE_PURGE_INTERVAL"); ok {
+		if c.Server.PurgeInterval.Duration, err = time.ParseDuration(v); err != nil {
+			return fmt.Errorf("Invalid PHILIFENCE_PURGE_INTERVAL: %v", err)
+		}
+	}
+	if v, ok := os.LookupEnv("PHILIFENCE_PURGE_RETENTION"); ok {
+		if c.Server.PurgeRetention.Duration, err = time.ParseDuration(v); err != nil {
+			return fmt.Errorf("Invalid PHILIFENCE_PURGE_RETENTION: %v", err)
+		}
+	}
+	if v, ok := os.LookupEnv("PHILIFENCE_DATA_DIR"); ok {
+		c.Server.DataDir = v
+	}
+	if v, ok := os.LookupEnv("PHILIFENCE_TLS_CERT"); ok {
+		c.Server.TLS.Cert = v
+	}
+	if v, ok := os.LookupEnv("PHILIFENCE_TLS_KEY"); ok {
+		c.Server.TLS.Key = v
+	}
+	if v, ok := os.LookupEnv("PHILIFENCE_TLS_CLIENT_CA"); ok {
+		c.Server.TLS.ClientCA = v
+	}
+	if v, ok := os.LookupEnv("PHILIFENCE_SHUTDOWN_TIMEOUT"); ok {
+		if c.Server.ShutdownTimeout.Duration, err = time.ParseDuration(v); err != nil {
+			return fmt.Errorf("Invalid PHILIFENCE_SHUTDOWN_TIMEOUT: %v", err)
+		}
+	}
+	for i := range c.Groups {
+		g := &c.Groups[i]
+		if v, ok := os.LookupEnv(g.envName("PATH")); ok {
+			format := ""
+			if len(g.Sources) > 0 {
+				format = g.Sources[0].Format
+			}
+			g.Sources = []SourceConfig{{Path: v, Format: format}}
+		}
+	}
+	return
+}
+
+func (g *GroupConfig) envName(setting string) string {
+	name := strings.Trim(slugger.ReplaceAllString(strings.ToLower(g.Name), "_"), "_")
+	return "PHILIFENCE_" + strings.ToUpper(name) + "_" + setting
+}
+
+// Check reports every problem with the config at once
+func (c *Config) Check() error {
+	var problems []string
+	problem := func(format string, vals ...interface{}) {
+		problems = append(problems, sprintf(format, vals...))
+	}
+	if _, err := strconv.ParseUint(c.Server.Port, 10, 16); err != nil {
+		problem("server: invalid port %q", c.Server.Port)
+	}
+	if _, err := strconv.ParseUint(c.Server.GRPCPort, 10, 16); c.Server.GRPCPort != "" && err != nil {
+		problem("server: invalid grpc port %q", c.Server.GRPCPort)
+	}
+	if c.Server.WatchInterval.Duration < 0 {
+		problem("server: negative watch interval %v", c.Server.WatchInterval)
+	}
+	if c.Server.PurgeInterval.Duration < 0 {
+		problem("server: negative purge interval %v", c.Server.PurgeInterval)
+	}
+	if c.Server.PurgeRetention.Duration < 0 {
+		problem("server: negative purge retention %v", c.Server.PurgeRetention)
+	}
+	timeouts := []Duration{c.Server.ReadTimeout, c.Server.ReadHeaderTimeout, c.Server.WriteTimeout, c.Server.IdleTimeout, c.Server.ShutdownTimeout}
+	for i, name := range []string{"read", "read header", "write", "idle", "shutdown"} {
+		if timeouts[i].Duration < 0 {
+			problem("server: negative %s timeout %v", name, timeouts[i])
+		}
+	}
+	if c.Server.MaxHeaderBytes < 0 {
+		problem("server: negative max header bytes %d", c.Server.MaxHeaderBytes)
+	}
+	if tls := c.Server.TLS; tls.enabled() {
+		if _, err := tls.config(); err != nil {
+			problem("server: %v", err)
+		}
+	} else if tls.ClientCA != "" || tls.RequireClientCert {
+		problem("server: client certificates need a tls cert and key")
+	}
+	groups := make(map[string]bool)
+	for _, g := range c.Groups {
+		switch {
+		case g.Name == "" || reservedLayers[g.Name] || strings.Contains(g.Name, "/"):
+			problem("group %q: invalid name", g.Name)
+		case groups[g.Name]:
+			problem("group %q: more than one group with this name", g.Name)
+		}
+		groups[g.Name] = true
+		if _, ok := LookupKind(g.Kind); !ok {
+			problem("group %q: unknown kind %q", g.Name, g.Kind)
+		}
+		if len(g.Sources) == 0 {
+			problem("group %q: no sources", g.Name)
+		}
+		opts := g.Options
+		if opts.Validation != "" && opts.Validation != ValidationSkip && opts.Validation != ValidationStrict {
+			problem("group %q: validation must be %q or %q", g.Name, ValidationSkip, ValidationStrict)
+		}
+		if opts.Simplify < 0 {
+			problem("group %q: negative simplification", g.Name)
+		}
+		if opts.MinChildren < 0 || opts.MaxChildren < 0 || (opts.MaxChildren > 0 && opts.MinChildren > opts.MaxChildren) {
+			problem("group %q: invalid fan-out %d..%d", g.Name, opts.MinChildren, opts.MaxChildren)
+		}
+		indices := make(map[string]string) // by name, to the source
+		for _, s := range g.Sources {
+			if s.Format != "" && s.Format != FormatGeoJson && s.Format != FormatGeoJsonSeq {
+				problem("group %q: source %s format must be %q or %q", g.Name, s.Path, FormatGeoJson, FormatGeoJsonSeq)
+			}
+			paths, err := s.paths()
+			switch {
+			case err != nil:
+				problem("group %q: source %s: %v", g.Name, s.Path, err)
+			case len(paths) == 0:
+				problem("group %q: source %s matches no files", g.Name, s.Path)
+			case s.Index != "" && len(paths) > 1:
+				problem("group %q: source %s is named %q but matches %d files", g.Name, s.Path, s.Index, len(paths))
+				continue
+			}
+			for _, path := range paths {
+				key := s.key(path)
+				if other, ok := indices[key]; ok {
+					problem("group %q: index %s from %s is also read from %s", g.Name, key, path, other)
+				}
+				indices[key] = path
+			}
+		}
+	}
+	tenants := make(map[string]bool, len(c.Tenants))
+	for _, t := range c.Tenants {
+		switch {
+		case !tenantName.MatchString(t.Name):
+			problem("tenant %q: invalid name", t.Name)
+		case tenants[t.Name]:
+			problem("tenant %q: defined twice", t.Name)
+		case t.MaxFeatures < 0 || t.MaxMemory < 0 || t.MaxIndices < 0:
+			problem("tenant %q: negative limits", t.Name)
+		}
+		tenants[t.Name] = true
+	}
+	objects := make(map[string]bool, len(c.Objects))
+	for _, g := range c.Groups {
+		objects[g.Name] = true
+	}
+	for _, o := range c.Objects {
+		switch {
+		case o.Name == "" || reservedLayers[o.Name] || strings.Contains(o.Name, "/"):
+			problem("objects %q: invalid name", o.Name)
+		case objects[o.Name]:
+			problem("objects %q: name already taken", o.Name)
+		case o.TTL.Duration < 0 || o.CellSize < 0 || o.CellSize > 10:
+			problem("objects %q: ttl must not be negative and cell_size be up to 10 degrees", o.Name)
+		}
+		objects[o.Name] = true
+	}
+	if a := c.Auth; a != nil {
+		checkGrants := func(who string, grants []Grant) {
+			for _, g := range grants {
+				if _, err := path.Match(g.Index, ""); err != nil || g.Index == "" {
+					problem("auth: %s has an invalid index pattern %q", who, g.Index)
+				}
+				for _, access := range g.Access {
+					if access != AccessRead && access != AccessWrite && access != AccessAdmin {
+						problem("auth: %s has unknown access %q", who, access)
+					}
+				}
+			}
+		}
+		for _, keys := range [][]KeyConfig{a.Keys, a.HMAC} {
+			for _, k := range keys {
+				if k.Name == "" || k.Key == "" {
+					problem("auth: keys need a name and a key")
+				}
+				if k.Tenant != DefaultTenant && !tenants[k.Tenant] {
+					problem("auth: key %q is of unknown tenant %q", k.Name, k.Tenant)
+				}
+				checkGrants(sprintf("key %q", k.Name), k.Grants)
+			}
+		}
+		checkGrants("anonymous", a.Anonymous)
+		if a.JWT != nil {
+			if _, err := LoadJWKS(a.JWT.JWKS); err != nil {
+				problem("auth: %v", err)
+			}
+		}
+	}
+	if l := c.Limits; l != nil {
+		classes := make([]string, 0, len(l.Rates))
+		for class := range l.Rates {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+		for _, class := range classes {
+			rate := l.Rates[class]
+			if class != LimitSearch && class != LimitMutation && class != LimitBatch {
+				problem("limits: unknown class %q", class)
+			}
+			if rate.PerSecond < 0 || rate.Burst < 1 {
+				problem("limits: %s needs a positive rate and a burst of at least 1", class)
+			}
+		}
+		if l.DailyQuota < 0 {
+			problem("limits: negative daily quota %d", l.DailyQuota)
+		}
+		if l.TrustedProxies < 0 {
+			problem("limits: negative trusted proxies %d", l.TrustedProxies)
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// NewAuth is the Auth of the config, nil when it has none
+func (c *Config) NewAuth() (*Auth, error) {
+	a := c.Auth
+	if a == nil {
+		return nil, nil
+	}
+	auth := &Auth{}
+	if len(a.Keys) > 0 {
+		keys := NewAPIKeys()
+		for _, k := range a.Keys {
+			keys.Add(k.Key, &Principal{Name: k.Name, Tenant: k.Tenant, Grants: k.Grants})
+		}
+		auth.Authenticators = append(auth.Authenticators, keys)
+	}
+	if len(a.HMAC) > 0 {
+		keys := NewHMACKeys()
+		for _, k := range a.HMAC {
+			keys.Add(k.Name, k.Key, &Principal{Name: k.Name, Tenant: k.Tenant, Grants: k.Grants})
+		}
+		auth.Authenticators = append(auth.Authenticators, keys)
+	}
+	if a.JWT != nil {
+		verifier, err := LoadJWKS(a.JWT.JWKS)
+		if err != nil {
+			return nil, err
+		}
+		verifier.Issuer, verifier.Audience = a.JWT.Issuer, a.JWT.Audience
+		auth.Authenticators = append(auth.Authenticators, verifier)
+	}
+	if len(a.Anonymous) > 0 {
+		auth.Anonymous = &Principal{Name: "anonymous", Grants: a.Anonymous}
+	}
+	return auth, nil
+}
+
+// Describe writes the indices every group resolves to
+func (c *Config) Describe(w io.Writer) {
+	fmt.Fprintf(w, "server: port %s, grpc port %q, profiler %v, snapshot reads %v, watch interval %v, purge interval %v, purge retention %v\n",
+		c.Server.Port, c.Server.GRPCPort, c.Server.Profiler, c.Server.SnapshotReads, c.Server.WatchInterval, c.Server.PurgeInterval, c.Server.PurgeRetention)
+	if c.Server.DataDir != "" {
+		fmt.Fprintf(w, "\thistory: saved to %s every %v, journal flushed every %v\n", c.Server.DataDir, c.Server.HistoryInterval, c.Server.FlushInterval)
+	}
+	fmt.Fprintf(w, "\ttimeouts: read %v, read header %v, write %v, idle %v, shutdown %v, max header bytes %d, tls %v\n",
+		c.Server.ReadTimeout, c.Server.ReadHeaderTimeout, c.Server.WriteTimeout, c.Server.IdleTimeout, c.Server.ShutdownTimeout,
+		c.Server.MaxHeaderBytes, c.Server.TLS.enabled())
+	if a := c.Auth; a != nil {
+		fmt.Fprintf(w, "auth: %d api keys, %d hmac keys, jwt %v, %d anonymous grants\n", len(a.Keys), len(a.HMAC), a.JWT != nil, len(a.Anonymous))
+	}
+	for _, t := range c.Tenants {
+		fmt.Fprintf(w, "tenant %q: %+v\n", t.Name, t.TenantLimits)
+	}
+	for _, o := range c.Objects {
+		fmt.Fprintf(w, "objects %q: ttl %v, cell size %v\n", o.Name, o.TTL, o.CellSize)
+	}
+	for _, g := range c.Groups {
+		fmt.Fprintf(w, "%s %q: %+v\n", g.Kind, g.Name, g.Options)
+		for _, s := range g.Sources {
+			paths, _ := s.paths()
+			for _, path := range paths {
+				fmt.Fprintf(w, "\t%s/%s <- %s (%s)\n", g.Name, s.key(path), path, s.format())
+			}
+		}
+	}
+}
+
+// Load builds an index for every group, by its name, holding the indices of its sources
+func (c *Config) Load() (indices map[string]FenceIndex, err error) {
+	indices = c.Indices()
+	if err = c.LoadInto(indices); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Indices are the empty indices of every group, for LoadInto, journaled to the data dir if
+// there is one
+func (c *Config) Indices() map[string]FenceIndex {
+	indices := make(map[string]FenceIndex, len(c.Groups))
+	for _, g := range c.Groups {
+		opts := g.Options
+		opts.SnapshotReads = opts.SnapshotReads || c.Server.SnapshotReads
+		indices[g.Name] = NewFenceIndexWith(opts)
+		if c.Server.DataDir != "" {
+			indices[g.Name] = NewJournal(indices[g.Name], filepath.Join(c.Server.DataDir, g.Name))
+		}
+	}
+	return indices
+}
+
+// LoadInto loads the sources of every group into the group's index, which may be served
+// meanwhile, along with the history saved for them in the data dir
+func (c *Config) LoadInto(indices map[string]FenceIndex) error {
+	for _, g := range c.Groups {
+		idx := indices[g.Name]
+		for _, s := range g.Sources {
+			paths, err := s.paths()
+			if err != nil {
+				return err
+			}
+			for _, path := range paths {
+				key := s.key(path)
+				info("Indexing %q from %s\n", key, path)
+				load := func(path string) (*Fence, int, error) {
+					return s.load(path, g.Options)
+				}
+				var fence *Fence
+				var n int
+				if c.Server.DataDir != "" {
+					fence, n, err = LoadWithHistory(filepath.Join(c.Server.DataDir, g.Name), key, path, load)
+				} else {
+					fence, n, err = load(path)
+				}
+				if err != nil {
+					return err
+				}
+				info("Loaded %d features for %q\n", n, key)
+				idx.Set(key, fence)
+			}
+		}
+	}
+	return nil
+}
+
+// Watchers reloads the sources of every group into the indices from Load
+func (c *Config) Watchers(indices map[string]FenceIndex) (watchers []*Watcher) {
+	for _, g := range c.Groups {
+		for _, s := range g.Sources {
+			s, opts := s, g.Options
+			load := func(path string) (*Fence, int, error) {
+				return s.load(path, opts)
+			}
+			watchers = append(watchers, newWatcher(s.pattern(), indices[g.Name], c.Server.WatchInterval.Duration, load, s.key))
+		}
+	}
+	return
+}
+
+// NewServer serves the index of every group from Load as a layer named after the group,
+// e.g. /cities
+func (c *Config) NewServer(indices map[string]FenceIndex, watchers []*Watcher) (*Server, error) {
+	s := NewServer()
+	for _, g := range c.Groups {
+		if err := s.AddLayer(g.Name, g.Kind, indices[g.Name]); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range c.Tenants {
+		if _, err := s.AddTenant(t.Name, t.TenantLimits); err != nil {
+			return nil, err
+		}
+	}
+	for _, o := range c.Objects {
+		idx := NewObjectIndex(o.TTL.Duration, o.CellSize)
+		if err := s.AddObjects(o.Name, idx); err != nil {
+			return nil, err
+		}
+		idx.Start()
+		s.OnShutdown(idx.Stop)
+	}
+	s.AddWatchers(watchers...)
+	s.SetOrigins(c.Server.AllowedOrigins...)
+	auth, err := c.NewAuth()
+	if err != nil {
+		return nil, err
+	}
+	if auth != nil {
+		s.SetAuth(auth)
+	}
+	if c.Limits != nil {
+		limiter := NewLimiter(*c.Limits)
+		if err := limiter.LoadQuotas(); err != nil {
+			return nil, err
+		}
+		s.SetLimiter(limiter)
+		interval := c.Limits.SaveInterval.Duration
+		if interval == 0 {
+			interval = QuotaSaveInterval
+		}
+		s.SaveQuotasEvery(interval)
+		s.OnShutdown(limiter.SaveQuotas)
+	}
+	return s, nil
+}
+
+// a directory stands for every json file in it
+func (s SourceConfig) pattern() string {
+	if fi, err := os.Stat(s.Path); err == nil && fi.IsDir() {
+		return filepath.Join(s.Path, "*json")
+	}
+	return s.Path
+}
+
+func (s SourceConfig) paths() ([]string, error) {
+	return filepath.Glob(s.pattern())
+}
+
+func (s SourceConfig) key(path string) string {
+	if s.Index != "" {
+		return s.Index
+	}
+	return sluggify(path)
+}
+
+func (s SourceConfig) format() string {
+	if s.Format == "" {
+		return FormatGeoJson
+	}
+	return s.Format
+}
+
+func (s SourceConfig) load(path string, opts IndexOptions) (*Fence, int, error) {
+	return LoadFenceWith(NewSourceFormat(path, s.format()), opts)
+}
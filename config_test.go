@@ -0,0 +1,65 @@
+package philifence
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "philifence")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "cities.json"), []byte(sprintf(squareGeoJson, "a")), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "towns.json"), []byte(sprintf(squareGeoJson, "b")), 0644)
+	path := filepath.Join(dir, "config")
+	ioutil.WriteFile(path, []byte(`{
+		"server": {"port": "9090", "watch_interval": "1m"},
+		"groups": [
+			{"name": "cities", "kind": "fence", "sources": [{"path": "`+filepath.Join(dir, "cities.json")+`", "index": "metro"}],
+			 "options": {"simplify": 0.001, "validation": "strict", "id_property": "code"}},
+			{"name": "towns", "kind": "fence", "sources": [{"path": "`+dir+`"}]}
+		]}`), 0644)
+
+	os.Setenv("PHILIFENCE_PORT", "9191")
+	defer os.Unsetenv("PHILIFENCE_PORT")
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Server.Port != "9191" || config.Server.WatchInterval.Minutes() != 1 {
+		t.Errorf("Wrong server config %+v", config.Server)
+	}
+	if err := config.Check(); err != nil {
+		t.Fatal(err)
+	}
+	indices, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// features without the id property keep their own id
+	if _, err := indices["cities"].Feature("metro", "a"); err != nil {
+		t.Errorf("Named index was not loaded, %v", err)
+	}
+	if keys := indices["cities"].Keys(); len(keys) != 1 {
+		t.Errorf("Expected groups of a kind to keep their indices apart, got %v", keys)
+	}
+	if keys := indices["towns"].Keys(); len(keys) != 2 {
+		t.Errorf("Expected every file of the directory, got %v", keys)
+	}
+
+	config.Groups[0].Kind = "river"
+	config.Groups[1].Sources[0].Index = "towns"
+	config.Groups[1].Options.Validation = "lenient"
+	err = config.Check()
+	if err == nil {
+		t.Fatal("Expected config problems")
+	}
+	if problems := strings.Split(err.Error(), "\n"); len(problems) != 3 {
+		t.Errorf("Expected 3 problems, got %q", problems)
+	}
+}
@@ -1,13 +1,22 @@
 package philifence
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
 )
 
 type Coordinate struct {
 	lat, lon float64
 }
 
+// NewCoordinate rejects latitudes outside of -90..90° and longitudes outside of -180..180°
+func NewCoordinate(lat, lon float64) (c Coordinate, err error) {
+	c = Coordinate{lat: lat, lon: lon}
+	err = c.validate()
+	return
+}
+
 func (c Coordinate) Lon() float64 {
 	return c.lon
 }
@@ -19,3 +28,46 @@ func (c Coordinate) Lat() float64 {
 func (c Coordinate) String() string {
 	return fmt.Sprintf("[%.5f, %.5f]", c.lat, c.lon)
 }
+
+// rejects coordinates outside of -90..90° latitude and -180..180° longitude
+func (c Coordinate) validate() error {
+	if math.IsNaN(c.lat) || c.lat < -90 || c.lat > 90 {
+		return errorf("Latitude %v out of range [-90, 90]", c.lat)
+	}
+	if math.IsNaN(c.lon) || c.lon < -180 || c.lon > 180 {
+		return errorf("Longitude %v out of range [-180, 180]", c.lon)
+	}
+	return nil
+}
+
+// widest tolerance a search takes, in meters
+var MaxTolerance = 100000.0
+
+func validateTolerance(tol float64) error {
+	if math.IsNaN(tol) || tol < 0 || tol > MaxTolerance {
+		return errorf("Tolerance %v out of range [0, %v]", tol, MaxTolerance)
+	}
+	return nil
+}
+
+// MarshalJSON writes the coordinate in geojson order, [lon, lat]
+func (c Coordinate) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]float64{c.lon, c.lat})
+}
+
+// UnmarshalJSON reads a validated coordinate in geojson order, [lon, lat]
+func (c *Coordinate) UnmarshalJSON(b []byte) error {
+	var pair []float64
+	if err := json.Unmarshal(b, &pair); err != nil {
+		return err
+	}
+	if len(pair) < 2 {
+		return errorf("Coordinate needs a longitude and latitude, got %s", b)
+	}
+	coord, err := NewCoordinate(pair[1], pair[0])
+	if err != nil {
+		return err
+	}
+	*c = coord
+	return nil
+}
@@ -0,0 +1,114 @@
+package philifence
+
+import (
+	"io"
+	"net/http"
+)
+
+// machine readable codes of the errors the http routes respond with
+const (
+	ErrorBadRequest        = "bad_request"        // 400, a malformed param or body
+	ErrorInvalidFeature    = "invalid_feature"    // 422, geojson that isn't a valid feature
+	ErrorInvalidCoordinate = "invalid_coordinate" // 422, lat or lon out of range
+	ErrorInvalidTolerance  = "invalid_tolerance"  // 422, tolerance out of [0, MaxTolerance]
+	ErrorInvalidTile       = "invalid_tile"       // 400
+	ErrorUnauthorized      = "unauthorized"       // 401, missing or invalid credentials
+	ErrorForbidden         = "forbidden"          // 403, no access to the index
+	ErrorUnknownLayer      = "unknown_layer"      // 404
+	ErrorUnknownIndex      = "unknown_index"      // 404
+	ErrorUnknownFeature    = "unknown_feature"    // 404
+	ErrorUnknownTenant     = "unknown_tenant"     // 404
+	ErrorUnknownObject     = "unknown_object"     // 404
+	ErrorNotFound          = "not_found"          // 404, no such route
+	ErrorMethodNotAllowed  = "method_not_allowed" // 405
+	ErrorBodyTooLarge      = "body_too_large"     // 413, past MaxBodySize
+	ErrorRateLimited       = "rate_limited"       // 429, see Retry-After
+	ErrorQuotaExceeded     = "quota_exceeded"     // 429, until the next UTC day
+	ErrorNotReady          = "not_ready"          // 503, indices still loading
+	ErrorBusy              = "busy"               // 503, too many reloads queued
+	ErrorTenantLimit       = "tenant_limit"       // 507, past the tenant's features or memory
+	ErrorInternal          = "internal"           // 500
+)
+
+// largest request body the routes read
+var MaxBodySize int64 = 1 << 26 // 64 MB
+
+// ErrorMessage is the body of every error response, e.g.
+// {"error": {"status": 404, "code": "unknown_index", "message": "No index \"cities\""}}
+type ErrorMessage struct {
+	Error ErrorBody `json:"error"`
+}
+
+type ErrorBody struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func respondError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Server", "philifence")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	writeJson(w, ErrorMessage{ErrorBody{status, code, message}})
+}
+
+// the responses for errors reading a body, as it is either too large or malformed
+func respondBodyError(w http.ResponseWriter, err error, what string) {
+	if err == errBodyTooLarge {
+		respondError(w, http.StatusRequestEntityTooLarge, ErrorBodyTooLarge, err.Error())
+		return
+	}
+	respondError(w, http.StatusBadRequest, ErrorBadRequest, "Unable to read "+what+": "+err.Error())
+}
+
+func respondUnknownIndex(w http.ResponseWriter, layer *Layer, name string) {
+	respondError(w, http.StatusNotFound, ErrorUnknownIndex, sprintf("Layer %q does not contain index %q", layer.Name, name))
+}
+
+var errBodyTooLarge = errorf("Body too large")
+
+// limitBody fails reads past MaxBodySize with errBodyTooLarge, where a LimitReader would
+// quietly cut the body short
+func limitBody(r io.Reader) io.Reader {
+	return &bodyLimit{r, MaxBodySize}
+}
+
+type bodyLimit struct {
+	r io.Reader
+	n int64 // bytes left
+}
+
+func (b *bodyLimit) Read(p []byte) (int, error) {
+	if int64(len(p)) > b.n+1 {
+		p = p[:b.n+1]
+	}
+	n, err := b.r.Read(p)
+	if b.n -= int64(n); b.n < 0 {
+		return n, errBodyTooLarge
+	}
+	return n, err
+}
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+	respondError(w, http.StatusNotFound, ErrorNotFound, "No route "+r.URL.Path)
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	respondError(w, http.StatusMethodNotAllowed, ErrorMethodNotAllowed, "Method "+r.Method+" not allowed on "+r.URL.Path)
+}
+
+// validQueries responds to the first query out of range, if any
+func validQueries(w http.ResponseWriter, queries []BatchQuery, what string) bool {
+	for _, q := range queries {
+		if err := q.Coordinate().validate(); err != nil {
+			respondError(w, http.StatusUnprocessableEntity, ErrorInvalidCoordinate, "Invalid "+what+" "+string(q.Id)+": "+err.Error())
+			return false
+		}
+		if err := validateTolerance(q.Tolerance); err != nil {
+			respondError(w, http.StatusUnprocessableEntity, ErrorInvalidTolerance, "Invalid "+what+" "+string(q.Id)+": "+err.Error())
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,266 @@
+package philifence
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	EventEnter   = "enter"
+	EventExit    = "exit"
+	EventDropped = "dropped"
+)
+
+// how many events a subscriber may fall behind before the oldest are dropped
+var SubscriberBuffer = 256
+
+// Event is a device entering or leaving a feature of an index
+type Event struct {
+	Type    string     `json:"type"`
+	Layer   string     `json:"layer"`
+	Index   string     `json:"index"`
+	Feature string     `json:"feature"`
+	Device  string     `json:"device"`
+	Point   Coordinate `json:"point"`
+	Time    time.Time  `json:"time"`
+}
+
+// EventFilter picks the events a subscriber gets, an empty field matching any
+type EventFilter struct {
+	Indices  []string // as {layer}/{index}
+	Features []string
+	Devices  []string
+	Types    []string
+}
+
+func (f EventFilter) match(e Event) bool {
+	return matchAny(f.Indices, e.Layer+"/"+e.Index) && matchAny(f.Features, e.Feature) &&
+		matchAny(f.Devices, e.Device) && matchAny(f.Types, e.Type)
+}
+
+func matchAny(values []string, v string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+type deviceKey struct {
+	layer, index, device string
+}
+
+// how long a device that stopped moving is remembered in its features, after which its next
+// move enters them anew
+var DeviceTTL = 24 * time.Hour
+
+// EventHub turns location updates into enter and exit events, by remembering the features
+// each device was last in, and publishes them to its subscribers
+type EventHub struct {
+	devices map[deviceKey]*deviceState
+	moving  map[deviceKey]*deviceLock
+	subs    map[*Subscription]bool
+	swept   time.Time // when devices past DeviceTTL were last dropped
+	mu      sync.Mutex
+}
+
+type deviceState struct {
+	in   map[string]bool // ids of the features the device is in
+	seen time.Time       // of its last move
+}
+
+// deviceLock serialises the moves of a device, dropped once no move holds or waits for it.
+// Moves are numbered as they arrive, as the lock may not let them through in that order.
+type deviceLock struct {
+	sync.Mutex
+	moves   int
+	arrived uint64 // number of the last move to arrive
+	applied uint64 // of the last move applied
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{
+		devices: make(map[deviceKey]*deviceState),
+		moving:  make(map[deviceKey]*deviceLock),
+		subs:    make(map[*Subscription]bool),
+	}
+}
+
+// locks the device for a move, returning the number the move arrived as
+func (h *EventHub) lockDevice(key deviceKey) (*deviceLock, uint64) {
+	h.mu.Lock()
+	l, ok := h.moving[key]
+	if !ok {
+		l = &deviceLock{}
+		h.moving[key] = l
+	}
+	l.moves++
+	l.arrived++
+	seq := l.arrived
+	h.mu.Unlock()
+	l.Lock()
+	return l, seq
+}
+
+func (h *EventHub) unlockDevice(key deviceKey, l *deviceLock) {
+	l.Unlock()
+	h.mu.Lock()
+	if l.moves--; l.moves == 0 {
+		delete(h.moving, key)
+	}
+	h.mu.Unlock()
+}
+
+// Move updates the location of a device, publishing and returning an event for every feature
+// of the index it entered or left. A device is in the features the layer's Search returns for
+// its location, features without an id are left out. Moves of the same device are applied
+// one at a time, and a move that gets through after a later one is dropped without events,
+// so a slow search can't apply an older location over a newer one.
+func (h *EventHub) Move(ctx context.Context, layer *Layer, index, device string, c Coordinate, tol float64) ([]Event, error) {
+	_, events, err := h.move(ctx, layer, index, device, c, tol)
+	return events, err
+}
+
+// moves the device, also returning what the search found
+func (h *EventHub) move(ctx context.Context, layer *Layer, index, device string, c Coordinate, tol float64) (found []Properties, events []Event, err error) {
+	key := deviceKey{layer.Name, index, device}
+	l, seq := h.lockDevice(key)
+	defer h.unlockDevice(key, l)
+	if found, err = layer.Search(ctx, index, c, tol, nil); err != nil {
+		return
+	}
+	if seq < l.applied {
+		return
+	}
+	l.applied = seq
+	in := make(map[string]bool)
+	for _, props := range found {
+		if id, ok := props["id"]; ok && id != nil {
+			in[fmt.Sprint(id)] = true
+		}
+	}
+	now := time.Now().UTC()
+	event := func(typ, feature string) {
+		events = append(events, Event{typ, layer.Name, index, feature, device, c, now})
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sweep(now)
+	var was map[string]bool
+	if d := h.devices[key]; d != nil && now.Sub(d.seen) <= DeviceTTL {
+		was = d.in
+	}
+	for id := range was {
+		if !in[id] {
+			event(EventExit, id)
+		}
+	}
+	for id := range in {
+		if !was[id] {
+			event(EventEnter, id)
+		}
+	}
+	if len(in) > 0 {
+		h.devices[key] = &deviceState{in: in, seen: now}
+	} else {
+		delete(h.devices, key)
+	}
+	for _, e := range events {
+		for sub := range h.subs {
+			if sub.filter.match(e) {
+				sub.push(e)
+			}
+		}
+	}
+	return
+}
+
+// forgets the devices that haven't moved within DeviceTTL, at most every tenth of it
+func (h *EventHub) sweep(now time.Time) {
+	if now.Sub(h.swept) < DeviceTTL/10 {
+		return
+	}
+	h.swept = now
+	for key, d := range h.devices {
+		if now.Sub(d.seen) > DeviceTTL {
+			delete(h.devices, key)
+		}
+	}
+}
+
+// Subscribe starts collecting the events matching the filter, until the subscription is closed
+func (h *EventHub) Subscribe(filter EventFilter) *Subscription {
+	sub := &Subscription{filter: filter, hub: h, ready: make(chan struct{}, 1)}
+	h.mu.Lock()
+	h.subs[sub] = true
+	h.mu.Unlock()
+	return sub
+}
+
+// Subscription holds the events published since the subscriber last took them. Events it
+// can't keep up with are coalesced, an exit cancelling a pending enter of the same device
+// and feature and the other way around, and past SubscriberBuffer the oldest are dropped.
+type Subscription struct {
+	filter  EventFilter
+	hub     *EventHub
+	pending []Event
+	dropped int
+	ready   chan struct{} // signalled once events are pending
+	mu      sync.Mutex
+}
+
+func (s *Subscription) push(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.pending) - 1; i >= 0; i-- {
+		p := s.pending[i]
+		if p.Device == e.Device && p.Feature == e.Feature && p.Index == e.Index && p.Layer == e.Layer {
+			if p.Type != e.Type {
+				s.pending = append(s.pending[:i], s.pending[i+1:]...)
+				return
+			}
+			break
+		}
+	}
+	if n := len(s.pending) - SubscriberBuffer + 1; n > 0 {
+		s.pending = s.pending[n:]
+		s.dropped += n
+	}
+	s.pending = append(s.pending, e)
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Next waits for pending events and takes them, along with how many were dropped since the
+// last call
+func (s *Subscription) Next(ctx context.Context) (events []Event, dropped int, err error) {
+	for {
+		s.mu.Lock()
+		events, dropped = s.pending, s.dropped
+		s.pending, s.dropped = nil, 0
+		s.mu.Unlock()
+		if len(events) > 0 || dropped > 0 {
+			return
+		}
+		select {
+		case <-s.ready:
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		}
+	}
+}
+
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	delete(s.hub.subs, s)
+	s.hub.mu.Unlock()
+}
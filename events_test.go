@@ -0,0 +1,228 @@
+package philifence
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestEventHub(t *testing.T) {
+	a := NewPolygonFeature(square(0, 0, 2))
+	a.Properties = map[string]interface{}{"id": "a"}
+	idx := NewFenceIndex()
+	fence, _ := newFenceOf([]*Feature{a})
+	idx.Set("cities", fence)
+	layer, _ := NewLayer(KindFence, KindFence, idx)
+	hub := NewEventHub()
+	all := hub.Subscribe(EventFilter{})
+	exits := hub.Subscribe(EventFilter{Types: []string{EventExit}})
+	ctx := context.Background()
+
+	move := func(lat, lon float64) []Event {
+		events, err := hub.Move(ctx, layer, "cities", "car", Coordinate{lat: lat, lon: lon}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return events
+	}
+	if events := move(1, 1); len(events) != 1 || events[0].Type != EventEnter || events[0].Feature != "a" {
+		t.Errorf("Expected entering a, got %v", events)
+	}
+	if events := move(1.5, 1.5); len(events) != 0 {
+		t.Errorf("Expected no events staying inside, got %v", events)
+	}
+	if events := move(5, 5); len(events) != 1 || events[0].Type != EventExit {
+		t.Errorf("Expected leaving a, got %v", events)
+	}
+	// a subscriber that fell behind gets the enter and exit coalesced away
+	short, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if events, _, err := all.Next(short); len(events) != 0 || err != context.DeadlineExceeded {
+		t.Errorf("Expected the pending enter and exit to cancel out, got %v", events)
+	}
+	if events, _, _ := exits.Next(ctx); len(events) != 1 || events[0].Type != EventExit {
+		t.Errorf("Expected only the exit, got %v", events)
+	}
+
+	SubscriberBuffer = 2
+	defer func() { SubscriberBuffer = 256 }()
+	for _, device := range []string{"a", "b", "c"} {
+		hub.Move(ctx, layer, "cities", device, Coordinate{lat: 1, lon: 1}, 1)
+	}
+	if events, dropped, _ := all.Next(ctx); len(events) != 2 || dropped != 1 || events[0].Device != "b" {
+		t.Errorf("Expected the oldest event dropped, got %v, %d dropped", events, dropped)
+	}
+}
+
+// an index whose searches inside the fence wait to be let through
+type slowIndex struct {
+	FenceIndex
+	searching, through chan bool
+}
+
+func (idx slowIndex) SearchAt(name string, c Coordinate, tol float64, at time.Time) ([]*Feature, error) {
+	if c.lat < 2 {
+		idx.searching <- true
+		<-idx.through
+	}
+	return idx.FenceIndex.SearchAt(name, c, tol, at)
+}
+
+func TestEventHubOrder(t *testing.T) {
+	a := NewPolygonFeature(square(0, 0, 2))
+	a.Properties = map[string]interface{}{"id": "a"}
+	fences := NewFenceIndex()
+	fence, _ := newFenceOf([]*Feature{a})
+	fences.Set("cities", fence)
+	idx := slowIndex{fences, make(chan bool), make(chan bool)}
+	layer, _ := NewLayer(KindFence, KindFence, idx)
+	hub := NewEventHub()
+	ctx := context.Background()
+
+	// the car enters a but its search is slow, the later move out must wait for it
+	entered := make(chan []Event)
+	go func() {
+		events, _ := hub.Move(ctx, layer, "cities", "car", Coordinate{lat: 1, lon: 1}, 1)
+		entered <- events
+	}()
+	<-idx.searching
+	exited := make(chan []Event)
+	go func() {
+		events, _ := hub.Move(ctx, layer, "cities", "car", Coordinate{lat: 5, lon: 5}, 1)
+		exited <- events
+	}()
+	select {
+	case events := <-exited:
+		t.Fatalf("Expected the move out to wait for the move in, got %v", events)
+	case <-time.After(20 * time.Millisecond):
+	}
+	idx.through <- true
+	if events := <-entered; len(events) != 1 || events[0].Type != EventEnter {
+		t.Errorf("Expected entering a, got %v", events)
+	}
+	if events := <-exited; len(events) != 1 || events[0].Type != EventExit {
+		t.Errorf("Expected leaving a after entering it, got %v", events)
+	}
+	if len(hub.moving) != 0 {
+		t.Errorf("Expected the device lock dropped, got %d", len(hub.moving))
+	}
+}
+
+func TestEventHubStaleMoves(t *testing.T) {
+	a := NewPolygonFeature(square(0, 0, 2))
+	a.Properties = map[string]interface{}{"id": "a"}
+	idx := NewFenceIndex()
+	fence, _ := newFenceOf([]*Feature{a})
+	idx.Set("cities", fence)
+	layer, _ := NewLayer(KindFence, KindFence, idx)
+	hub := NewEventHub()
+	ctx := context.Background()
+
+	// the lock may let the moves waiting on it through in any order, the later move out wins
+	key := deviceKey{layer.Name, "cities", "car"}
+	held, _ := hub.lockDevice(key)
+	waiting := func(n int) {
+		for {
+			hub.mu.Lock()
+			moves := hub.moving[key].moves
+			hub.mu.Unlock()
+			if moves == n {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	moved := make(chan []Event, 2)
+	for i, c := range []Coordinate{{lat: 1, lon: 1}, {lat: 5, lon: 5}} {
+		go func(c Coordinate) {
+			events, _ := hub.Move(ctx, layer, "cities", "car", c, 1)
+			moved <- events
+		}(c)
+		waiting(i + 2)
+	}
+	hub.unlockDevice(key, held)
+	entered, exited := 0, 0
+	for i := 0; i < 2; i++ {
+		for _, e := range <-moved {
+			if e.Type == EventEnter {
+				entered++
+			} else {
+				exited++
+			}
+		}
+	}
+	if entered != exited || len(hub.devices) != 0 {
+		t.Errorf("Expected the car out of a, got %d enters, %d exits and %v", entered, exited, hub.devices)
+	}
+
+	// devices that stopped moving are forgotten, entering their features anew
+	defer func(ttl time.Duration) { DeviceTTL = ttl }(DeviceTTL)
+	DeviceTTL = 10 * time.Millisecond
+	hub.Move(ctx, layer, "cities", "car", Coordinate{lat: 1, lon: 1}, 1)
+	time.Sleep(20 * time.Millisecond)
+	hub.Move(ctx, layer, "cities", "bike", Coordinate{lat: 1, lon: 1}, 1)
+	if _, ok := hub.devices[deviceKey{layer.Name, "cities", "car"}]; ok || len(hub.devices) != 1 {
+		t.Errorf("Expected the car forgotten, got %v", hub.devices)
+	}
+	if events, _ := hub.Move(ctx, layer, "cities", "car", Coordinate{lat: 1, lon: 1}, 1); len(events) != 1 || events[0].Type != EventEnter {
+		t.Errorf("Expected the forgotten car to enter a again, got %v", events)
+	}
+}
+
+func TestEventStreams(t *testing.T) {
+	a := NewPolygonFeature(square(0, 0, 2))
+	a.Properties = map[string]interface{}{"id": "a"}
+	s := serverWith(t, KindFence, KindFence, a)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/events?index=fence/cities&device=car")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/events?type=enter", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	body := `[{"id": "car", "lat": 1, "lon": 1}, {"id": "bike", "lat": 1, "lon": 1}]`
+	moved, err := http.Post(ts.URL+"/fence/cities/locations", "application/json", strings.NewReader(body))
+	if err != nil || moved.StatusCode != 200 {
+		t.Fatalf("Unable to post locations %v %v", moved, err)
+	}
+	moved.Body.Close()
+
+	lines := bufio.NewReader(res.Body)
+	if line, _ := lines.ReadString('\n'); line != "event: enter\n" {
+		t.Errorf("Expected an enter event, got %q", line)
+	}
+	if line, _ := lines.ReadString('\n'); !strings.Contains(line, `"device":"car"`) {
+		t.Errorf("Expected the car's event, got %q", line)
+	}
+	var event Event
+	for _, device := range []string{"car", "bike"} {
+		if err := ws.ReadJSON(&event); err != nil || event.Device != device {
+			t.Errorf("Expected the %s entering over the websocket, got %v %v", device, event, err)
+		}
+	}
+
+	// pages of other sites can't open websockets unless their origin is allowed
+	url, origin := "ws"+strings.TrimPrefix(ts.URL, "http")+"/events", http.Header{"Origin": {"https://elsewhere.example"}}
+	if _, r, err := websocket.DefaultDialer.Dial(url, origin); err == nil || r == nil || r.StatusCode != 403 {
+		t.Errorf("Expected a websocket of another origin refused, got %v", err)
+	}
+	s.SetOrigins("https://elsewhere.example")
+	if ws, _, err := websocket.DefaultDialer.Dial(url, origin); err != nil {
+		t.Errorf("Expected a websocket of an allowed origin, got %v", err)
+	} else {
+		ws.Close()
+	}
+}
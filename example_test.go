@@ -0,0 +1,35 @@
+package philifence_test
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jtejido/philifence"
+)
+
+// Searching a fence in process, without the http service
+func Example() {
+	a, _ := philifence.NewCoordinate(0, 0)
+	b, _ := philifence.NewCoordinate(0, 1)
+	c, _ := philifence.NewCoordinate(1, 1)
+	poly, err := philifence.NewPolygon([]philifence.Coordinate{a, b, c})
+	if err != nil {
+		panic(err)
+	}
+	feature, err := philifence.NewFeatureFrom("Polygon", map[string]interface{}{"id": "triangle"}, poly)
+	if err != nil {
+		panic(err)
+	}
+	fence, _ := philifence.NewFence()
+	fence.Add(feature)
+	idx := philifence.NewFenceIndex()
+	idx.Set("shapes", fence)
+
+	layer, _ := philifence.NewLayer("fence", philifence.KindFence, idx)
+	point, _ := philifence.NewCoordinate(0.25, 0.5)
+	layer.Each(context.Background(), "shapes", point, 1, func(f *philifence.Feature) error {
+		fmt.Println(f.Id())
+		return nil
+	})
+	// Output: triangle
+}
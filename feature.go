@@ -1,8 +1,11 @@
 package philifence
 
 import (
+	"fmt"
 	"github.com/kpawlik/geojson"
+	"math"
 	"strings"
+	"time"
 )
 
 type Feature struct {
@@ -10,6 +13,13 @@ type Feature struct {
 	Type       string
 	Crs        *geojson.CRS
 	Properties map[string]interface{}
+	metrics    *Metrics
+	validity   *Validity
+	// versions of the feature's id in its fence, see version.go
+	replaces int       // versions before this one
+	previous *Feature  // the version this one replaced
+	since    time.Time // when it was added, zero for features loaded from datasets
+	until    int64     // unix nanos it was replaced or deleted at, 0 while current, atomic
 }
 
 func NewFeature(geometryType string, geometry ...*Polygon) *Feature {
@@ -34,6 +44,38 @@ func NewPointFeature(cs ...Coordinate) *Feature {
 	return feature
 }
 
+// NewFeatureFrom builds a feature of a geojson geometry type, e.g. "Polygon" or "MultiLineString",
+// checking its geometry the way features read from geojson files are. The type is lowercased
+// like NewFeature's. Geometry that crosses the antimeridian is split.
+func NewFeatureFrom(geometryType string, properties map[string]interface{}, geometry ...*Polygon) (*Feature, error) {
+	f := NewFeature(geometryType, geometry...)
+	f.Properties = properties
+	if len(geometry) == 0 {
+		return nil, errorf("Feature has no geometry")
+	}
+	min := 0
+	switch f.Type {
+	case "point", "multipoint":
+		min = 1
+	case "linestring", "multilinestring", "line":
+		min = 2
+	case "polygon", "multipolygon":
+		min = 4
+	default:
+		return nil, errorf("Unknown geometry type %q", geometryType)
+	}
+	for _, poly := range geometry {
+		if poly == nil || poly.Exterior == nil || poly.Len() < min {
+			return nil, errorf("%s needs at least %d coordinates per part", geometryType, min)
+		}
+	}
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	f.splitAntimeridian()
+	return f, nil
+}
+
 func MakeFeature(length int) *Feature {
 	return &Feature{Geometry: make([]*Polygon, length)}
 }
@@ -50,3 +92,95 @@ func (f *Feature) Contains(c Coordinate) bool {
 	}
 	return false
 }
+
+// Distance in meters from c to the nearest boundary of the feature, including the boundary
+// of holes. It is positive when c is inside the feature and negative when outside.
+func (f *Feature) Distance(c Coordinate) float64 {
+	d := math.Inf(1)
+	for _, poly := range f.Geometry {
+		poly.eachSegment(func(a, b Coordinate) bool {
+			d = math.Min(d, segmentDistance(c, a, b))
+			return true
+		})
+	}
+	if !f.Contains(c) {
+		d = -d
+	}
+	return d
+}
+
+// Validity of the feature as read from its properties, nil when it is always valid
+func (f *Feature) Validity() *Validity {
+	return f.validity
+}
+
+// Active is whether the feature is matched at t, being the version of its id then and valid
+func (f *Feature) Active(t time.Time) bool {
+	return f.recorded(t) && f.validity.Active(t)
+}
+
+// computes what a fence caches on the feature, once
+func (f *Feature) prepare() {
+	if f.validity == nil {
+		// checked by validate for features from geojson, only set when there is one as
+		// features are prepared again while searched when fences are rebuilt
+		if v, _ := parseValidity(f.Properties); v != nil {
+			f.validity = v
+		}
+	}
+	if CacheMetrics && f.metrics == nil {
+		f.metrics = f.computeMetrics()
+	}
+	if !f.isAreal() {
+		return
+	}
+	for _, poly := range f.Geometry {
+		if poly.Len() >= PrepareThreshold && poly.prepared == nil {
+			poly.prepare()
+		}
+	}
+}
+
+// Id of the feature as given by the geojson source, empty if it has none
+func (f *Feature) Id() string {
+	if id, ok := f.Properties["id"]; ok && id != nil {
+		return fmt.Sprint(id)
+	}
+	return ""
+}
+
+func (f *Feature) validate() (err error) {
+	if f.validity, err = parseValidity(f.Properties); err != nil {
+		return err
+	}
+	for _, poly := range f.Geometry {
+		for _, ring := range poly.rings() {
+			for _, c := range ring.Coordinates {
+				if err := c.validate(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// footprint estimates the bytes the feature takes in an index
+func (f *Feature) footprint() int64 {
+	n := int64(256) // the feature, its properties and tree entries
+	for _, poly := range f.Geometry {
+		for _, ring := range poly.rings() {
+			n += 64 + 16*int64(len(ring.Coordinates))
+		}
+	}
+	return n
+}
+
+func (f *Feature) isPoint() bool {
+	return strings.Contains(strings.ToLower(f.Type), "point")
+}
+
+// polygons are areal, everything else (lines and points) is not
+func (f *Feature) isAreal() bool {
+	return strings.Contains(strings.ToLower(f.Type), "polygon")
+}
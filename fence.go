@@ -1,31 +1,157 @@
 package philifence
 
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
 type Fence struct {
-	rtree *Rtree
+	rtree    *Rtree     // every part of every version, those of a single coordinate included
+	features featureIds // latest version of every id
+	shared   *Rtree     // the tree as of the last write, see Tree
+	count    int        // features added
+	expires  time.Time  // when the first of the features expires, zero if none do
+	readonly bool       // a fork handed out to read, see FenceIndex.Get
+	mu       sync.Mutex // guards shared, as readers may share the tree concurrently, and forks
+
+	// a fence built while readers are on the one it replaces retires the versions it
+	// replaced once it is published, so readers never miss both of them
+	unpublished bool
+	retiring    []retirement
+}
+
+type retirement struct {
+	feature *Feature
+	at      time.Time
 }
 
 func NewFence() (*Fence, error) {
-	rt, err := NewRtree()
+	return NewFenceWith(MinimumNodeChildren, MaximumNodeChildren)
+}
 
-	return &Fence{
-		rtree: rt,
-	}, err
+// NewFenceWith builds a fence whose tree has the given fan-out
+func NewFenceWith(min, max int) (*Fence, error) {
+	rt, err := NewRtreeWith(min, max)
+	if err != nil {
+		return nil, err
+	}
+	return &Fence{rtree: rt}, nil
 }
 
+// Add adds the feature as the latest version of its id, replacing the current one
 func (r *Fence) Add(f *Feature) {
+	r.addAt(f, time.Now())
+}
+
+// addAt adds the feature as added at t, e.g. when replaying a journal
+func (r *Fence) addAt(f *Feature, t time.Time) {
+	r.writable()
+	latest, _ := r.latest(f.Id())
+	f.supersede(latest, t)
+	r.insert(f)
+	if latest != f {
+		r.retire(latest, t)
+	}
+}
+
+// insert adds the feature as it is, versioned or not, e.g. when rebuilding a fence
+func (r *Fence) insert(f *Feature) {
+	r.writable()
+	f.prepare()
+	if id := f.Id(); id != "" {
+		if latest, ok := r.features.get(id); !ok || latest.replaces <= f.replaces {
+			r.features.set(f)
+		}
+	}
 	for _, poly := range f.Geometry {
-		if poly.Len() > 1 {
-			r.rtree.Insert(poly, f)
+		if poly.Len() > 0 {
+			r.rtree.insert(&customRect{poly, poly.computeBox(), f, r.count})
+		}
+	}
+	r.count++
+	r.expires = earliestExpiry(r.expires, f)
+	r.changed()
+}
+
+// writable panics on a fence handed out to read, which the index it is from shares
+func (r *Fence) writable() {
+	if r.readonly {
+		panic("philifence: write to a read-only fence, Set a fork of it instead")
+	}
+}
+
+// fork returns a copy of the fence which shares its tree and ids until either is written to,
+// the writes copying the nodes they touch, so neither sees the other's
+func (r *Fence) fork() *Fence {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &Fence{
+		rtree:    r.rtree.share(),
+		features: r.features.share(),
+		shared:   r.shared,
+		count:    r.count,
+		expires:  r.expires,
+	}
+}
+
+// readable returns a read-only fork of the fence
+func (r *Fence) readable() *Fence {
+	fork := r.fork()
+	fork.readonly = true
+	return fork
+}
+
+// retire retires the feature at t, or once the fence is published if it is unpublished
+func (r *Fence) retire(f *Feature, t time.Time) {
+	switch {
+	case f == nil || !f.Current():
+	case r.unpublished:
+		r.retiring = append(r.retiring, retirement{f, t})
+	default:
+		f.retire(t)
+	}
+}
+
+// published retires the versions the fence replaced, once it has replaced the fence readers
+// were on
+func (r *Fence) published() {
+	if !r.unpublished {
+		return
+	}
+	for _, v := range r.retiring {
+		if v.feature.Current() {
+			v.feature.retire(v.at)
 		}
 	}
+	if len(r.retiring) > 0 {
+		r.changed()
+	}
+	r.unpublished, r.retiring = false, nil
 }
 
+// Get returns the features containing c that are active now
 func (r *Fence) Get(c Coordinate, tol float64) (matchs []*Feature) {
-	nodes := r.rtree.Contains(c, tol)
-	
+	return r.GetAt(c, tol, time.Now())
+}
+
+// GetAt returns the features containing c that are active at t
+func (r *Fence) GetAt(c Coordinate, tol float64, t time.Time) (matchs []*Feature) {
+	return containing(r.rtree.Contains(c, tol), c, t)
+}
+
+func containing(nodes []*customRect, c Coordinate, t time.Time) (matchs []*Feature) {
+	defer func() { searchCandidates.add(len(nodes)); searchMatches.add(len(matchs)) }()
+	seen := make(map[*Feature]bool, len(nodes))
+
 	for _, n := range nodes {
 		feature := n.Feature()
-		if feature.Contains(c) {
+		if seen[feature] {
+			continue
+		}
+		seen[feature] = true
+		if feature.Active(t) && feature.Contains(c) {
 			matchs = append(matchs, feature)
 		}
 	}
@@ -33,6 +159,202 @@ func (r *Fence) Get(c Coordinate, tol float64) (matchs []*Feature) {
 	return
 }
 
+// Each calls fn with every feature Get returns, stopping at the first error fn returns or
+// once ctx is done
+func (r *Fence) Each(ctx context.Context, c Coordinate, tol float64, fn func(*Feature) error) error {
+	return eachFeature(ctx, r.Get(c, tol), fn)
+}
+
+// EachNear calls fn with every match Near returns, stopping at the first error fn returns or
+// once ctx is done
+func (r *Fence) EachNear(ctx context.Context, c Coordinate, tol float64, fn func(Match) error) error {
+	return eachMatch(ctx, r.Near(c, tol), fn)
+}
+
+func eachFeature(ctx context.Context, features []*Feature, fn func(*Feature) error) error {
+	for _, f := range features {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func eachMatch(ctx context.Context, matchs []Match, fn func(Match) error) error {
+	for _, m := range matchs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const (
+	StatusInside = "inside"
+	StatusNear   = "near"
+)
+
+// Match is a feature found around a query point
+type Match struct {
+	Feature  *Feature
+	Distance float64 // meters to the nearest boundary, positive inside and negative outside
+	Status   string  // StatusInside or StatusNear
+}
+
+// Near returns the features that contain c, along with those whose boundary is
+// within tol meters of c even though c is outside of them
+func (r *Fence) Near(c Coordinate, tol float64) (matchs []Match) {
+	return r.NearAt(c, tol, time.Now())
+}
+
+// NearAt is Near among the features active at t
+func (r *Fence) NearAt(c Coordinate, tol float64, t time.Time) (matchs []Match) {
+	return near(r.rtree.Contains(c, tol), c, tol, t)
+}
+
+func near(nodes []*customRect, c Coordinate, tol float64, t time.Time) (matchs []Match) {
+	defer func() { searchCandidates.add(len(nodes)); searchMatches.add(len(matchs)) }()
+	seen := make(map[*Feature]bool, len(nodes))
+
+	for _, n := range nodes {
+		feature := n.Feature()
+		if seen[feature] {
+			continue
+		}
+		seen[feature] = true
+		if !feature.Active(t) {
+			continue
+		}
+		d := feature.Distance(c)
+		switch {
+		case d >= 0:
+			matchs = append(matchs, Match{feature, d, StatusInside})
+		case -d <= tol:
+			matchs = append(matchs, Match{feature, d, StatusNear})
+		}
+	}
+
+	return
+}
+
+// Feature by its geojson id, unless it has been deleted
+func (r *Fence) Feature(id string) (f *Feature, ok bool) {
+	f, ok = r.latest(id)
+	if ok && !f.Current() {
+		return nil, false
+	}
+	return
+}
+
+// latest version of the id, deleted or not
+func (r *Fence) latest(id string) (f *Feature, ok bool) {
+	if id == "" {
+		return nil, false
+	}
+	return r.features.get(id)
+}
+
+// Delete retires the current feature of the id at t, keeping it for searches as of before t
+func (r *Fence) Delete(id string, t time.Time) bool {
+	f, ok := r.Feature(id)
+	if !ok {
+		return false
+	}
+	r.writable()
+	f.retire(t)
+	r.changed()
+	return true
+}
+
+// Features in the order they were added
+func (r *Fence) Features() (features []*Feature) {
+	leaves := r.leaves()
+	sort.SliceStable(leaves, func(i, j int) bool { return leaves[i].seq < leaves[j].seq })
+	seen := make(map[*Feature]bool, len(leaves))
+	for _, n := range leaves {
+		feature := n.Feature()
+		if !seen[feature] {
+			seen[feature] = true
+			features = append(features, feature)
+		}
+	}
+	return
+}
+
+// purge returns a copy of the fence without the features expired at t, along with them, or
+// the fence itself when none are
+func (r *Fence) purge(t time.Time) (*Fence, []*Feature, error) {
+	if r.expires.IsZero() || t.Before(r.expires) {
+		return r, nil, nil
+	}
+	fence, err := NewFenceWith(r.rtree.min, r.rtree.max)
+	if err != nil {
+		return nil, nil, err
+	}
+	var expired []*Feature
+	for _, feature := range r.Features() {
+		if feature.validity.Expired(t) {
+			expired = append(expired, feature)
+		} else {
+			fence.insert(feature)
+		}
+	}
+	purgedFeatures.add(len(expired))
+	return fence, expired, nil
+}
+
+// when the first of the fence's features expires, given when the fence's did
+func earliestExpiry(expires time.Time, f *Feature) time.Time {
+	if v := f.validity; v != nil && !v.Until.IsZero() && (expires.IsZero() || v.Until.Before(expires)) {
+		return v.Until
+	}
+	return expires
+}
+
+// Len is the number of features added, Size the number of polygons indexed for them
+func (r *Fence) Len() int {
+	return r.count
+}
+
 func (r *Fence) Size() int {
-	return r.rtree.rtree.Size()
+	return r.rtree.Size()
+}
+
+func (r *Fence) stats() IndexStats {
+	return IndexStats{Features: r.Len(), Leaves: r.Size(), Height: r.rtree.Height()}
+}
+
+// Tree returns the fence's tree as it is, which later writes to the fence leave alone, so it
+// can be traversed without holding any lock. The same tree is returned until the fence is
+// written to. It holds every version of the features, Feature.Current tells the current
+// ones, and their parts of a single coordinate, whose leaf box is the point.
+func (r *Fence) Tree() *Rtree {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.shared == nil {
+		r.shared = r.rtree.share()
+	}
+	return r.shared
+}
+
+// changed drops what was built from the fence as it was
+func (r *Fence) changed() {
+	r.mu.Lock()
+	r.shared = nil
+	r.mu.Unlock()
+}
+
+// the leaves of the tree in hilbert order
+func (r *Fence) leaves() (leaves []*customRect) {
+	leaves = make([]*customRect, 0, r.rtree.Size())
+	r.rtree.each(func(n *customRect) {
+		leaves = append(leaves, n)
+	})
+	return
 }
@@ -0,0 +1,58 @@
+package philifence
+
+import (
+	"math"
+)
+
+// great-circle distance in meters
+func haversine(a, b Coordinate) float64 {
+	φ1, φ2 := a.lat*radians, b.lat*radians
+	Δφ := φ2 - φ1
+	Δλ := (b.lon - a.lon) * radians
+	h := math.Sin(Δφ/2)*math.Sin(Δφ/2) + math.Cos(φ1)*math.Cos(φ2)*math.Sin(Δλ/2)*math.Sin(Δλ/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// spherical area of a closed ring in square meters, positive when counter-clockwise
+//
+// Chamberlain & Duquette, Some Algorithms for Polygons on a Sphere, JPL Publication 07-03
+func ringArea(coords []Coordinate) (area float64) {
+	for i := 1; i < len(coords); i++ {
+		p1, p2 := coords[i-1], coords[i]
+		area += (p2.lon - p1.lon) * radians * (2 + math.Sin(p1.lat*radians) + math.Sin(p2.lat*radians))
+	}
+	return area * earthRadius * earthRadius / 2
+}
+
+// great-circle length of a path in meters
+func pathLength(coords []Coordinate) (length float64) {
+	for i := 1; i < len(coords); i++ {
+		length += haversine(coords[i-1], coords[i])
+	}
+	return
+}
+
+// meters from c to the segment a-b, on a local equirectangular projection around c
+// which is accurate enough for the distances a tolerance covers
+func segmentDistance(c, a, b Coordinate) float64 {
+	_, d := projectSegment(c, a, b)
+	return d
+}
+
+// the fraction of the way from a to b of the point of the segment nearest to c, and
+// the meters to it, as segmentDistance measures them
+func projectSegment(c, a, b Coordinate) (t, meters float64) {
+	k := math.Cos(c.lat * radians)
+	ax, ay := wrapLon(a.lon-c.lon)*k, a.lat-c.lat
+	bx, by := wrapLon(b.lon-c.lon)*k, b.lat-c.lat
+	dx, dy := bx-ax, by-ay
+	if l := dx*dx + dy*dy; l > 0 {
+		t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/l))
+	}
+	return t, math.Hypot(ax+t*dx, ay+t*dy) * radians * earthRadius
+}
+
+// normalise a longitude difference to -180..+180°
+func wrapLon(Δ float64) float64 {
+	return math.Mod(Δ+540, 360) - 180
+}
@@ -1,25 +1,50 @@
 package philifence
 
 import (
+	"bufio"
 	"encoding/json"
 	"github.com/kpawlik/geojson"
 	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	FormatGeoJson    = "geojson"    // a FeatureCollection
+	FormatGeoJsonSeq = "geojsonseq" // a Feature per line, https://tools.ietf.org/html/rfc8142
 )
 
 type Source struct {
-	path string
+	path   string
+	format string
 }
 
 func NewSource(path string) *Source {
-	return &Source{path}
+	return &Source{path, FormatGeoJson}
+}
+
+func NewSourceFormat(path, format string) *Source {
+	return &Source{path, format}
 }
 
+// Publish sends every feature of the source, or nil for those that are invalid
 func (gj *Source) Publish() (features chan *Feature, err error) {
-	collection, err := readGeoJson(gj.path)
-	if err != nil {
-		return
+	switch gj.format {
+	case FormatGeoJson:
+		collection, err := readGeoJson(gj.path)
+		if err != nil {
+			return nil, err
+		}
+		features = publishFeatureCollection(collection)
+	case FormatGeoJsonSeq:
+		collection, err := readGeoJsonSeq(gj.path)
+		if err != nil {
+			return nil, err
+		}
+		features = publishFeatureCollection(collection)
+	default:
+		err = errorf("Unknown source format %q", gj.format)
 	}
-	features = publishFeatureCollection(collection)
 	return
 }
 
@@ -28,7 +53,7 @@ func featureAdapter(gj *geojson.Feature) (feature *Feature, err error) {
 	// TO-DO. Named or Linked Crs
 	igeom, err := gj.GetGeometry()
 	if igeom == nil || err != nil {
-		err = errorf("Invalid geojson feature %q", gj)
+		err = errorf("Invalid geojson feature %v", gj)
 		return
 	}
 	feature = NewFeature(igeom.GetType())
@@ -67,11 +92,26 @@ func featureAdapter(gj *geojson.Feature) (feature *Feature, err error) {
 		}
 	default:
 		feature = nil
-		err = errorf("Invalid Coordinate Type in GeoJson %q", geom)
+		err = errorf("Invalid Coordinate Type in GeoJson %v", geom)
+		return
+	}
+	if err = feature.validate(); err != nil {
+		feature = nil
+		return
 	}
+	feature.splitAntimeridian()
 	return
 }
 
+// ParseFeature reads a single geojson feature
+func ParseFeature(raw []byte) (*Feature, error) {
+	g, err := unmarshalFeature(string(raw))
+	if err != nil {
+		return nil, err
+	}
+	return featureAdapter(g)
+}
+
 func unmarshalFeature(raw string) (feature *geojson.Feature, err error) {
 	err = json.Unmarshal([]byte(raw), &feature)
 	return
@@ -138,11 +178,38 @@ func readGeoJson(path string) (features *geojson.FeatureCollection, err error) {
 	return
 }
 
+// reads a feature per line, lines which aren't a feature are kept as nil
+func readGeoJsonSeq(path string) (features *geojson.FeatureCollection, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	features = &geojson.FeatureCollection{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<26) // 64 MB max per feature
+	for scanner.Scan() {
+		line := strings.Trim(scanner.Text(), "\x1e \t\r")
+		if line == "" {
+			continue
+		}
+		feature, err := unmarshalFeature(line)
+		warn(err, "reading "+path)
+		features.Features = append(features.Features, feature)
+	}
+	err = scanner.Err()
+	return
+}
+
 func publishFeatureCollection(collection *geojson.FeatureCollection) (features chan *Feature) {
 	features = make(chan *Feature, 10)
 	go func() {
 		defer close(features)
 		for _, feature := range collection.Features {
+			if feature == nil {
+				features <- nil
+				continue
+			}
 			f, err := featureAdapter(feature)
 			warn(err, "feature publishing")
 			features <- f
@@ -0,0 +1,309 @@
+package philifence
+
+import (
+	"container/heap"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// the most points a group nearest-neighbour query takes
+var MaxGroup = 100
+
+// Aggregate combines the distances from a group's points into the one a meeting place is ranked by
+type Aggregate string
+
+const (
+	AggregateSum Aggregate = "sum" // least total distance travelled
+	AggregateMax Aggregate = "max" // the furthest has the least to travel
+	AggregateMin Aggregate = "min" // nearest to any one of the group
+)
+
+func (a Aggregate) validate() error {
+	switch a {
+	case AggregateSum, AggregateMax, AggregateMin:
+		return nil
+	}
+	return errorf("Aggregate must be sum, max or min, got %q", string(a))
+}
+
+func (a Aggregate) of(distances []float64) (agg float64) {
+	switch a {
+	case AggregateMax:
+		agg = math.Inf(-1)
+	case AggregateMin:
+		agg = math.Inf(1)
+	}
+	for _, d := range distances {
+		switch a {
+		case AggregateSum:
+			agg += d
+		case AggregateMax:
+			agg = math.Max(agg, d)
+		case AggregateMin:
+			agg = math.Min(agg, d)
+		}
+	}
+	return
+}
+
+// GroupQuery asks for the K features of a tree nearest to a group of points as a whole
+type GroupQuery struct {
+	Points    []Coordinate
+	K         int
+	Aggregate Aggregate
+	Within    *Feature  // only features intersecting it, when set
+	At        time.Time // only features active then
+}
+
+// GroupMatch is a meeting place, with its distance in meters from every point of the group
+// and their aggregate
+type GroupMatch struct {
+	Feature   *Feature
+	Distance  float64
+	Distances []float64
+}
+
+// GroupNearest finds the current features minimising the aggregate of their distances to the
+// group, the best first. The tree is searched best-first with the aggregate of each node's minimum
+// distances to the points as the bound, which no feature under the node can beat since the
+// aggregates are monotonic, so nodes are only opened while they may hold a better match.
+//
+// Papadias et al., Group Nearest Neighbor Queries, ICDE 2004 (MBM)
+//
+// A feature of several parts is ranked by its best part, the group meeting at one place.
+// Distances are on a local equirectangular projection around each point, as for tolerances.
+func (t *Rtree) GroupNearest(q GroupQuery) (matchs []GroupMatch) {
+	if t.root == nil || len(q.Points) == 0 || q.K < 1 {
+		return nil
+	}
+	var within Box
+	if q.Within != nil {
+		for i, poly := range q.Within.Geometry {
+			if i == 0 {
+				within = poly.computeBox()
+			} else {
+				within = within.extend(poly.computeBox())
+			}
+		}
+	}
+	queue := &groupQueue{}
+	push := func(n *rtreeNode) {
+		if q.Within != nil && !n.box.intersects(within) {
+			return
+		}
+		distances := make([]float64, len(q.Points))
+		for i, c := range q.Points {
+			distances[i] = boxDistance(c, n.box)
+		}
+		heap.Push(queue, groupEntry{node: n, bound: q.Aggregate.of(distances)})
+	}
+	push(t.root)
+	seen := make(map[*Feature]bool)
+	for queue.Len() > 0 && len(matchs) < q.K {
+		e := heap.Pop(queue).(groupEntry)
+		switch {
+		case e.match != nil:
+			if !seen[e.match.Feature] {
+				seen[e.match.Feature] = true
+				matchs = append(matchs, *e.match)
+			}
+		case e.node.leaf != nil:
+			f := e.node.leaf.Feature()
+			if seen[f] || f == q.Within || !f.Current() || !f.Active(q.At) || !intersectsFeature(e.node.leaf.polygon, f.isAreal(), q.Within) {
+				continue
+			}
+			distances := make([]float64, len(q.Points))
+			for i, c := range q.Points {
+				distances[i] = polygonDistance(c, e.node.leaf.polygon, f.isAreal())
+			}
+			m := &GroupMatch{Feature: f, Distance: q.Aggregate.of(distances), Distances: distances}
+			heap.Push(queue, groupEntry{match: m, bound: m.Distance})
+		default:
+			for _, child := range e.node.children {
+				push(child)
+			}
+		}
+	}
+	return
+}
+
+// a node to open, or a part measured exactly, by the least aggregate distance it may have
+type groupEntry struct {
+	node  *rtreeNode
+	match *GroupMatch
+	bound float64
+}
+
+type groupQueue []groupEntry
+
+func (q groupQueue) Len() int { return len(q) }
+func (q groupQueue) Less(i, j int) bool {
+	// measured parts go first on ties, they can't get any nearer
+	return q[i].bound < q[j].bound || q[i].bound == q[j].bound && q[i].match != nil && q[j].match == nil
+}
+func (q groupQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *groupQueue) Push(x interface{}) { *q = append(*q, x.(groupEntry)) }
+func (q *groupQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// meters from c to the nearest point of the box, on the projection segmentDistance uses
+func boxDistance(c Coordinate, b Box) float64 {
+	nearest := Coordinate{
+		lat: math.Max(b.min.lat, math.Min(b.max.lat, c.lat)),
+		lon: math.Max(b.min.lon, math.Min(b.max.lon, c.lon)),
+	}
+	return segmentDistance(c, nearest, nearest)
+}
+
+// meters from c to the part, nothing when inside an areal one
+func polygonDistance(c Coordinate, poly *Polygon, areal bool) float64 {
+	if poly.Len() == 1 {
+		point := poly.Exterior.Coordinates[0]
+		return segmentDistance(c, point, point)
+	}
+	if areal && poly.Contains(c) {
+		return 0
+	}
+	d := math.Inf(1)
+	poly.eachSegment(func(a, b Coordinate) bool {
+		d = math.Min(d, segmentDistance(c, a, b))
+		return true
+	})
+	return d
+}
+
+// whether the part shares any point with the fence, always without one
+func intersectsFeature(poly *Polygon, areal bool, fence *Feature) bool {
+	if fence == nil {
+		return true
+	}
+	for _, fp := range fence.Geometry {
+		if polygonsIntersect(poly, fp, areal, fence.isAreal()) {
+			return true
+		}
+	}
+	return false
+}
+
+// GroupRequest is the body of a group nearest-neighbour query, e.g.
+// {"points": [{"lat": 14.6, "lon": 121.0}, ...], "aggregate": "max", "k": 3, "fence": "fence/cities/manila"}
+type GroupRequest struct {
+	Points    []BatchQuery `json:"points"`
+	Aggregate Aggregate    `json:"aggregate"` // sum when left out
+	K         int          `json:"k"`         // 1 when left out
+	Fence     string       `json:"fence"`     // {layer}/{index}/{feature id} of the request's tenant
+	At        string       `json:"at"`        // now when left out
+}
+
+func decodeGroupRequest(r io.Reader) (req GroupRequest, err error) {
+	err = json.NewDecoder(r).Decode(&req)
+	if err == io.EOF {
+		err = errorf("No query")
+	}
+	return
+}
+
+// the features of the index nearest to a group of points, e.g. the best place for them to meet
+func (s *Server) postGroupNearest(layer *Layer) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		defer r.Body.Close()
+		req, err := decodeGroupRequest(limitBody(r.Body))
+		if err != nil {
+			respondBodyError(w, err, "query")
+			return
+		}
+		if len(req.Points) == 0 || len(req.Points) > MaxGroup {
+			respondError(w, http.StatusBadRequest, ErrorBadRequest, sprintf("A group needs 1 to %d points", MaxGroup))
+			return
+		}
+		if !validQueries(w, req.Points, "point") {
+			return
+		}
+		q := GroupQuery{K: req.K, Aggregate: req.Aggregate, At: time.Now()}
+		if q.K == 0 {
+			q.K = 1
+		}
+		if q.K < 0 || q.K > MaxNearest {
+			respondError(w, http.StatusBadRequest, ErrorBadRequest, sprintf("k must be 1 to %d", MaxNearest))
+			return
+		}
+		if q.Aggregate == "" {
+			q.Aggregate = AggregateSum
+		}
+		if err := q.Aggregate.validate(); err != nil {
+			respondError(w, http.StatusBadRequest, ErrorBadRequest, err.Error())
+			return
+		}
+		if req.At != "" {
+			if q.At, err = ParseTime(req.At); err != nil {
+				respondError(w, http.StatusBadRequest, ErrorBadRequest, err.Error())
+				return
+			}
+		}
+		for _, p := range req.Points {
+			q.Points = append(q.Points, p.Coordinate())
+		}
+		if req.Fence != "" {
+			var ok bool
+			if q.Within, ok = s.fenceFeature(w, r, params, req.Fence); !ok {
+				return
+			}
+		}
+		name := params.ByName("name")
+		tree, err := layer.Index.Tree(name)
+		if err != nil {
+			respondUnknownIndex(w, layer, name)
+			return
+		}
+
+		matchs := tree.GroupNearest(q)
+		result := make([]Properties, len(matchs))
+		for i, m := range matchs {
+			result[i] = resultProperties(m.Feature, Properties{"distance": m.Distance, "distances": m.Distances})
+		}
+		respond(w, result)
+	}
+}
+
+// resolves "{layer}/{index}/{feature id}" among the layers of the request's tenant, which
+// the request needs read access to, or responds why not
+func (s *Server) fenceFeature(w http.ResponseWriter, r *http.Request, params httprouter.Params, fence string) (*Feature, bool) {
+	parts := strings.SplitN(fence, "/", 3)
+	if len(parts) != 3 {
+		respondError(w, http.StatusBadRequest, ErrorBadRequest, "Fence must be {layer}/{index}/{feature id}")
+		return nil, false
+	}
+	layers, _, ok := s.scope(w, params)
+	if !ok {
+		return nil, false
+	}
+	layer, ok := layers[parts[0]]
+	if !ok {
+		respondError(w, http.StatusNotFound, ErrorUnknownLayer, "No layer "+parts[0])
+		return nil, false
+	}
+	if !s.allowed(r, tenantOf(layer), AccessRead, parts[0]+"/"+parts[1]) {
+		respondError(w, http.StatusForbidden, ErrorForbidden, "No read access to "+parts[0]+"/"+parts[1])
+		return nil, false
+	}
+	if !hasIndex(layer.Index, parts[1]) {
+		respondUnknownIndex(w, layer, parts[1])
+		return nil, false
+	}
+	feature, err := layer.Index.Feature(parts[1], parts[2])
+	if err != nil {
+		respondError(w, http.StatusNotFound, ErrorUnknownFeature, err.Error())
+		return nil, false
+	}
+	return feature, true
+}
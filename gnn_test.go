@@ -0,0 +1,117 @@
+package philifence
+
+import (
+	"encoding/json"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestGroupNearest(t *testing.T) {
+	random := rand.New(rand.NewSource(7))
+	var features []*Feature
+	for i := 0; i < 500; i++ {
+		f := NewPolygonFeature(square(14+random.Float64(), 120+random.Float64(), 0.001))
+		f.Properties = map[string]interface{}{"id": sprintf("poi-%d", i)}
+		features = append(features, f)
+	}
+	zone := NewPolygonFeature(square(14.2, 120.2, 0.3))
+	zone.Properties = map[string]interface{}{"id": "zone"}
+	fence, _ := newFenceOf(features)
+	tree := fence.Tree()
+	group := []Coordinate{cd(14.1, 120.1), cd(14.9, 120.3), cd(14.5, 120.9)}
+
+	for _, agg := range []Aggregate{AggregateSum, AggregateMax, AggregateMin} {
+		expected := make([]GroupMatch, len(features))
+		for i, f := range features {
+			distances := make([]float64, len(group))
+			for j, c := range group {
+				distances[j] = polygonDistance(c, f.Geometry[0], true)
+			}
+			expected[i] = GroupMatch{f, agg.of(distances), distances}
+		}
+		sort.Slice(expected, func(i, j int) bool { return expected[i].Distance < expected[j].Distance })
+
+		matchs := tree.GroupNearest(GroupQuery{Points: group, K: 5, Aggregate: agg, At: time.Now()})
+		if len(matchs) != 5 {
+			t.Fatalf("%s: expected 5 matchs, got %d", agg, len(matchs))
+		}
+		for i, m := range matchs {
+			if m.Feature != expected[i].Feature || m.Distance != expected[i].Distance {
+				t.Errorf("%s: expected %s at %d, got %s", agg, expected[i].Feature.Id(), i, m.Feature.Id())
+			}
+		}
+	}
+
+	zoned, _ := newFenceOf(append(features, zone))
+	matchs := zoned.Tree().GroupNearest(GroupQuery{Points: group, K: 50, Aggregate: AggregateMax, Within: zone, At: time.Now()})
+	if len(matchs) == 0 {
+		t.Fatal("expected matchs in the zone")
+	}
+	for i, m := range matchs {
+		if m.Feature == zone || !polygonsIntersect(m.Feature.Geometry[0], zone.Geometry[0], true, true) {
+			t.Errorf("expected only features in the zone, got %s", m.Feature.Id())
+		}
+		if i > 0 && m.Distance < matchs[i-1].Distance {
+			t.Errorf("expected matchs nearest first, got %v after %v", m.Distance, matchs[i-1].Distance)
+		}
+	}
+
+	// point POIs are in the tree with their point as the leaf box, added or loaded alike
+	var pois []*Feature
+	for i := 0; i < 300; i++ {
+		f := NewPointFeature(cd(14+random.Float64(), 120+random.Float64()))
+		f.Properties = map[string]interface{}{"id": sprintf("poi-%d", i)}
+		pois = append(pois, f)
+	}
+	loaded, _ := newFenceOf(pois[:200])
+	snapshots := NewSnapshotFenceIndex()
+	snapshots.Set("pois", loaded)
+	for _, f := range pois[200:] {
+		snapshots.Add("pois", f)
+	}
+	expected := make([]GroupMatch, len(pois))
+	for i, f := range pois {
+		distances := make([]float64, len(group))
+		for j, c := range group {
+			distances[j] = segmentDistance(c, f.Geometry[0].Exterior.Coordinates[0], f.Geometry[0].Exterior.Coordinates[0])
+		}
+		expected[i] = GroupMatch{f, AggregateSum.of(distances), distances}
+	}
+	sort.Slice(expected, func(i, j int) bool { return expected[i].Distance < expected[j].Distance })
+	tree, _ = snapshots.Tree("pois")
+	matchs = tree.GroupNearest(GroupQuery{Points: group, K: 5, Aggregate: AggregateSum, At: time.Now()})
+	if len(matchs) != 5 {
+		t.Fatalf("expected 5 point matchs, got %d", len(matchs))
+	}
+	for i, m := range matchs {
+		if m.Feature != expected[i].Feature || m.Distance != expected[i].Distance {
+			t.Errorf("expected point %s at %d, got %s", expected[i].Feature.Id(), i, m.Feature.Id())
+		}
+	}
+
+	s := serverWith(t, KindFence, KindFence, append(features, zone)...)
+	cases := []struct {
+		body   string
+		status int
+	}{
+		{`{"points": [{"lat": 14.1, "lon": 120.1}, {"lat": 14.9, "lon": 120.3}], "aggregate": "max", "k": 3, "fence": "fence/cities/zone"}`, 200},
+		{`{"points": []}`, 400},
+		{`{"points": [{"lat": 14.1, "lon": 120.1}], "aggregate": "avg"}`, 400},
+		{`{"points": [{"lat": 14.1, "lon": 120.1}], "k": 1000}`, 400},
+		{`{"points": [{"lat": 91, "lon": 120.1}]}`, 422},
+		{`{"points": [{"lat": 14.1, "lon": 120.1}], "fence": "fence/cities/nope"}`, 404},
+		{`{"points": [{"lat": 14.1, "lon": 120.1}], "fence": "fence/cities"}`, 400},
+	}
+	for _, c := range cases {
+		if r := serveAs(s, "POST", "/fence/cities/gnn", c.body); r.Code != c.status {
+			t.Errorf("%s: expected %d, got %d %s", c.body, c.status, r.Code, r.Body)
+		}
+	}
+	var result []Properties
+	json.Unmarshal(serveAs(s, "POST", "/fence/cities/gnn", `{"points": [{"lat": 14.1, "lon": 120.1}, {"lat": 14.9, "lon": 120.3}, {"lat": 14.5, "lon": 120.9}]}`).Body.Bytes(), &result)
+	if len(result) != 1 || result[0]["distances"] == nil {
+		t.Errorf("expected the one nearest by sum, got %v", result)
+	}
+}
@@ -0,0 +1,525 @@
+package philifence
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	pb "github.com/jtejido/philifence/philifencepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// RegisterGRPC serves the layers of s through the PhiliFence service of philifence.proto.
+// With Auth, g must be built with GRPCServerOptions, every call is refused otherwise.
+func (s *Server) RegisterGRPC(g *grpc.Server) {
+	pb.RegisterPhiliFenceServer(g, &grpcService{s: s})
+}
+
+// GRPCServerOptions are the interceptors authenticating, authorising and rate limiting the
+// calls of a gRPC server as the http routes are, from the x-api-key and authorization metadata
+func (s *Server) GRPCServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(s.unaryInterceptor),
+		grpc.ChainStreamInterceptor(s.streamInterceptor),
+	}
+}
+
+// the access the calls need to the {layer}/{index} of their requests, reads when left out
+var grpcAccess = map[string]string{
+	pb.PhiliFence_Add_FullMethodName:    AccessWrite,
+	pb.PhiliFence_Delete_FullMethodName: AccessWrite,
+	pb.PhiliFence_Track_FullMethodName:  AccessWrite,
+}
+
+// the class each call is limited as, searches when left out. Track counts every update.
+var grpcLimits = map[string]string{
+	pb.PhiliFence_Add_FullMethodName:         LimitMutation,
+	pb.PhiliFence_Delete_FullMethodName:      LimitMutation,
+	pb.PhiliFence_Track_FullMethodName:       LimitMutation,
+	pb.PhiliFence_SearchBatch_FullMethodName: LimitBatch,
+}
+
+// the requests about an index
+type indexRequest interface {
+	GetLayer() string
+	GetIndex() string
+}
+
+func (s *Server) unaryInterceptor(ctx context.Context, req interface{}, call *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	r, err := s.grpcAuthenticate(ctx, call.FullMethod, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.grpcAuthorize(r.Context(), call.FullMethod, req); err != nil {
+		return nil, err
+	}
+	if err := s.grpcLimit(r, call.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(r.Context(), req)
+}
+
+func (s *Server) streamInterceptor(srv interface{}, ss grpc.ServerStream, call *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &guardedStream{ServerStream: ss, s: s, method: call.FullMethod, limited: call.IsClientStream})
+}
+
+// guardedStream authenticates a stream by its first message, then authorises every message
+// the client sends, and limits it when the client streams them, ending the call at the first
+// it has no access to or is past its limit. A stream of results is limited by the call.
+type guardedStream struct {
+	grpc.ServerStream
+	r       *http.Request // nil until the first message
+	s       *Server
+	method  string
+	limited bool
+}
+
+func (g *guardedStream) Context() context.Context {
+	if g.r == nil {
+		return g.ServerStream.Context()
+	}
+	return g.r.Context()
+}
+
+func (g *guardedStream) RecvMsg(m interface{}) error {
+	if err := g.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	first := g.r == nil
+	if first {
+		r, err := g.s.grpcAuthenticate(g.ServerStream.Context(), g.method, m)
+		if err != nil {
+			return err
+		}
+		g.r = r
+	}
+	if err := g.s.grpcAuthorize(g.r.Context(), g.method, m); err != nil {
+		return err
+	}
+	if g.limited || first {
+		return g.s.grpcLimit(g.r, g.method)
+	}
+	return nil
+}
+
+// grpcAuthenticate reads the call's metadata as the headers of a POST to the method, of the
+// request message marshalled deterministically, which is what HMAC signatures sign, with the
+// principal it authenticates as in its context as guard puts it
+func (s *Server) grpcAuthenticate(ctx context.Context, method string, req interface{}) (*http.Request, error) {
+	var body []byte
+	if msg, ok := req.(proto.Message); ok && s.auth != nil {
+		var err error
+		if body, err = (proto.MarshalOptions{Deterministic: true}).Marshal(msg); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	r, err := http.NewRequestWithContext(ctx, "POST", method, bytes.NewReader(body))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, key := range []string{"x-api-key", "authorization", "x-forwarded-for"} {
+		for _, v := range md.Get(key) {
+			r.Header.Add(key, v)
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+	}
+	if s.auth == nil {
+		return r, nil
+	}
+	p, err := s.auth.authenticate(r)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return r.WithContext(context.WithValue(ctx, principalKey{}, p)), nil
+}
+
+// grpcLimit is limit for a call, ResourceExhausted past the client's rate or quota
+func (s *Server) grpcLimit(r *http.Request, method string) error {
+	if s.limiter == nil {
+		return nil
+	}
+	class, ok := grpcLimits[method]
+	if !ok {
+		class = LimitSearch
+	}
+	code, retry := s.limiter.allow(class, s.client(r))
+	switch code {
+	case "":
+		return nil
+	case ErrorQuotaExceeded:
+		return status.Errorf(codes.ResourceExhausted, "Daily quota of %d requests used up, retry in %v", s.limiter.config.DailyQuota, retry.Round(time.Second))
+	}
+	return status.Errorf(codes.ResourceExhausted, "Too many %s requests, retry in %v", class, retry.Round(time.Second))
+}
+
+// grpcTenant is the tenant whose layers a call is about, given as x-tenant metadata, the
+// default tenant's without
+func grpcTenant(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if tenant := md.Get("x-tenant"); len(tenant) > 0 {
+		return tenant[0]
+	}
+	return DefaultTenant
+}
+
+// grpcAuthorize checks the principal may access the index of the request in its tenant
+func (s *Server) grpcAuthorize(ctx context.Context, method string, req interface{}) error {
+	r, ok := req.(indexRequest)
+	if s.auth == nil || !ok {
+		return nil
+	}
+	access, ok := grpcAccess[method]
+	if !ok {
+		access = AccessRead
+	}
+	p, _ := PrincipalFrom(ctx)
+	resource := r.GetLayer() + "/" + r.GetIndex()
+	switch {
+	case p.allowedIn(grpcTenant(ctx), access, resource):
+		return nil
+	case p == nil || p == s.auth.Anonymous:
+		return status.Error(codes.Unauthenticated, "Credentials required")
+	}
+	return status.Errorf(codes.PermissionDenied, "%q has no %s access to %s", p.Name, access, resource)
+}
+
+// ListenAndServeGRPC serves the gRPC api on its own address, alongside ListenAndServe, over
+// TLS when the config has a certificate
+func (s *Server) ListenAndServeGRPC(addr string, config TLSConfig) error {
+	var tlsConfig *tls.Config
+	if config.enabled() {
+		var err error
+		if tlsConfig, err = config.config(); err != nil {
+			return err
+		}
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	info("Serving gRPC on %s, tls %v\n", addr, tlsConfig != nil)
+	return s.grpcServer(tlsConfig).Serve(lis)
+}
+
+type grpcService struct {
+	pb.UnimplementedPhiliFenceServer
+	s *Server
+}
+
+// the indices that may be read, as the http lists
+func (g *grpcService) ListLayers(ctx context.Context, req *pb.ListLayersRequest) (*pb.ListLayersResponse, error) {
+	if err := g.authenticated(ctx); err != nil {
+		return nil, err
+	}
+	tenant := grpcTenant(ctx)
+	layers, _, ok := g.s.tenantScope(tenant)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "Unknown tenant %q", tenant)
+	}
+	p, _ := PrincipalFrom(ctx)
+	res := &pb.ListLayersResponse{}
+	for _, name := range g.s.names {
+		layer := layers[name]
+		var indices []string
+		for _, key := range layer.Index.Keys() {
+			if g.s.auth == nil || p.allowedIn(tenant, AccessRead, name+"/"+key) {
+				indices = append(indices, key)
+			}
+		}
+		res.Layers = append(res.Layers, &pb.Layer{Name: name, Kind: layer.Kind.Name, Indices: indices})
+	}
+	return res, nil
+}
+
+func (g *grpcService) Search(ctx context.Context, req *pb.SearchRequest) (*pb.SearchResponse, error) {
+	layer, err := g.index(ctx, req.Layer, req.Index)
+	if err != nil {
+		return nil, err
+	}
+	c, err := coordinateFromPb(req.Point)
+	if err != nil {
+		return nil, err
+	}
+	params := make(url.Values, len(req.Params))
+	for k, v := range req.Params {
+		params.Set(k, v)
+	}
+	result, err := layer.Search(ctx, req.Index, c, toleranceOrDefault(req.Tolerance), params)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &pb.SearchResponse{Query: coordinateToPb(c), Result: structsToPb(result)}, nil
+}
+
+func (g *grpcService) Near(ctx context.Context, req *pb.SearchRequest) (*pb.NearResponse, error) {
+	layer, err := g.index(ctx, req.Layer, req.Index)
+	if err != nil {
+		return nil, err
+	}
+	c, err := coordinateFromPb(req.Point)
+	if err != nil {
+		return nil, err
+	}
+	res := &pb.NearResponse{Query: coordinateToPb(c)}
+	err = layer.EachNear(ctx, req.Index, c, toleranceOrDefault(req.Tolerance), func(m Match) error {
+		res.Matches = append(res.Matches, &pb.Match{Feature: featureToPb(m.Feature), Distance: m.Distance, Status: m.Status})
+		return nil
+	})
+	if err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+	return res, nil
+}
+
+func (g *grpcService) Add(ctx context.Context, req *pb.AddRequest) (*pb.AddResponse, error) {
+	layer, err := g.index(ctx, req.Layer, req.Index)
+	if err != nil {
+		return nil, err
+	}
+	feature, err := featureFromPb(req.Feature)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := layer.reserve(req.Index, feature); err != nil {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if err := layer.Index.Add(req.Index, feature); err != nil {
+		layer.releaseFeatures(req.Index, []*Feature{feature})
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return &pb.AddResponse{}, nil
+}
+
+func (g *grpcService) Delete(ctx context.Context, req *pb.DeleteRequest) (*pb.DeleteResponse, error) {
+	layer, err := g.index(ctx, req.Layer, req.Index)
+	if err != nil {
+		return nil, err
+	}
+	layer.Index.Remove(req.Index)
+	layer.release(req.Index)
+	return &pb.DeleteResponse{}, nil
+}
+
+func (g *grpcService) SearchBatch(req *pb.BatchRequest, stream pb.PhiliFence_SearchBatchServer) error {
+	layer, err := g.index(stream.Context(), req.Layer, req.Index)
+	if err != nil {
+		return err
+	}
+	queries := make([]BatchQuery, len(req.Queries))
+	for i, q := range req.Queries {
+		c, err := coordinateFromPb(q.Point)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "Invalid query %s: %v", q.Id, err)
+		}
+		queries[i] = BatchQuery{Id: BatchId(q.Id), Lat: c.lat, Lon: c.lon, Tolerance: toleranceOrDefault(q.Tolerance)}
+	}
+	err = layer.EachBatch(stream.Context(), req.Index, queries, func(id string, matchs []*Feature) error {
+		result := make([]*structpb.Struct, len(matchs))
+		for i, f := range matchs {
+			result[i] = structToPb(f.Properties)
+		}
+		return stream.Send(&pb.BatchResult{Id: id, Result: result})
+	})
+	if err != nil {
+		return status.FromContextError(err).Err()
+	}
+	return nil
+}
+
+// answers updates one at a time, in order, an update that can't be searched getting an error
+// result rather than ending the stream
+func (g *grpcService) Track(stream pb.PhiliFence_TrackServer) error {
+	for {
+		update, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		res := &pb.LocationResult{Id: update.Id, Point: update.Point}
+		layer, err := g.index(stream.Context(), update.Layer, update.Index)
+		var c Coordinate
+		if err == nil {
+			c, err = coordinateFromPb(update.Point)
+		}
+		var result []Properties
+		var events []Event
+		if err == nil {
+			// the search that moves the device is also the result
+			result, events, err = g.s.hub(layer).move(stream.Context(), layer, update.Index, update.Id, c, toleranceOrDefault(update.Tolerance))
+		}
+		if err != nil {
+			res.Error = status.Convert(err).Message()
+		} else {
+			res.Result = structsToPb(result)
+			for _, e := range events {
+				res.Events = append(res.Events, eventToPb(e))
+			}
+		}
+		if err := stream.Send(res); err != nil {
+			return err
+		}
+	}
+}
+
+// refuses calls that didn't go through the interceptors of GRPCServerOptions when the
+// server has Auth
+func (g *grpcService) authenticated(ctx context.Context) error {
+	if _, ok := PrincipalFrom(ctx); g.s.auth != nil && !ok {
+		return status.Error(codes.Unauthenticated, "gRPC served without the interceptors of GRPCServerOptions")
+	}
+	return nil
+}
+
+// the layer of the call's tenant, once the index is known to be in it
+func (g *grpcService) index(ctx context.Context, name, index string) (*Layer, error) {
+	if err := g.authenticated(ctx); err != nil {
+		return nil, err
+	}
+	tenant := grpcTenant(ctx)
+	layers, _, ok := g.s.tenantScope(tenant)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "Unknown tenant %q", tenant)
+	}
+	layer, ok := layers[name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "Unknown layer %q", name)
+	}
+	if hasIndex(layer.Index, index) {
+		return layer, nil
+	}
+	return nil, status.Errorf(codes.NotFound, "Layer %q does not contain index %q", name, index)
+}
+
+func toleranceOrDefault(tol float64) float64 {
+	if tol <= 0 {
+		return 1 // ~1m
+	}
+	return tol
+}
+
+func coordinateFromPb(c *pb.Coordinate) (Coordinate, error) {
+	if c == nil {
+		return Coordinate{}, status.Error(codes.InvalidArgument, "Missing point")
+	}
+	coord, err := NewCoordinate(c.Lat, c.Lon)
+	if err != nil {
+		return coord, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return coord, nil
+}
+
+func coordinateToPb(c Coordinate) *pb.Coordinate {
+	return &pb.Coordinate{Lat: c.lat, Lon: c.lon}
+}
+
+func ringToPb(ring *PolyRing) *pb.Ring {
+	coords := make([]*pb.Coordinate, len(ring.Coordinates))
+	for i, c := range ring.Coordinates {
+		coords[i] = coordinateToPb(c)
+	}
+	return &pb.Ring{Coordinates: coords}
+}
+
+func ringFromPb(ring *pb.Ring) (coords []Coordinate) {
+	if ring == nil {
+		return
+	}
+	coords = make([]Coordinate, len(ring.Coordinates))
+	for i, c := range ring.Coordinates {
+		coords[i] = Coordinate{lat: c.GetLat(), lon: c.GetLon()}
+	}
+	return
+}
+
+func featureToPb(f *Feature) *pb.Feature {
+	geometry := make([]*pb.Geometry, len(f.Geometry))
+	for i, poly := range f.Geometry {
+		geometry[i] = &pb.Geometry{Exterior: ringToPb(poly.Exterior)}
+		for _, hole := range poly.Holes {
+			geometry[i].Holes = append(geometry[i].Holes, ringToPb(hole))
+		}
+	}
+	return &pb.Feature{Id: f.Id(), Type: f.Type, Geometry: geometry, Properties: structToPb(f.Properties)}
+}
+
+// checks the feature as NewFeatureFrom does, polygon rings being closed if left open
+func featureFromPb(f *pb.Feature) (*Feature, error) {
+	if f == nil {
+		return nil, errorf("Missing feature")
+	}
+	areal := strings.Contains(strings.ToLower(f.Type), "polygon")
+	geometry := make([]*Polygon, len(f.Geometry))
+	for i, g := range f.Geometry {
+		exterior := ringFromPb(g.Exterior)
+		if !areal {
+			geometry[i] = NewPoly(exterior...)
+			continue
+		}
+		holes := make([][]Coordinate, len(g.Holes))
+		for j, hole := range g.Holes {
+			holes[j] = ringFromPb(hole)
+		}
+		poly, err := NewPolygon(exterior, holes...)
+		if err != nil {
+			return nil, err
+		}
+		geometry[i] = poly
+	}
+	props := f.Properties.AsMap()
+	if f.Id != "" {
+		props["id"] = f.Id
+	}
+	return NewFeatureFrom(f.Type, props, geometry...)
+}
+
+func eventToPb(e Event) *pb.Event {
+	return &pb.Event{Type: e.Type, Layer: e.Layer, Index: e.Index, Feature: e.Feature, Device: e.Device,
+		Point: coordinateToPb(e.Point), Time: timestamppb.New(e.Time)}
+}
+
+func structsToPb(props []Properties) []*structpb.Struct {
+	structs := make([]*structpb.Struct, len(props))
+	for i, p := range props {
+		structs[i] = structToPb(p)
+	}
+	return structs
+}
+
+// properties read from geojson convert as they are, anything else, such as metrics, goes
+// through its json encoding
+func structToPb(props map[string]interface{}) *structpb.Struct {
+	s := &structpb.Struct{Fields: make(map[string]*structpb.Value, len(props))}
+	for k, v := range props {
+		value, err := structpb.NewValue(v)
+		if err != nil {
+			var generic interface{}
+			if buf, err := json.Marshal(v); err == nil && json.Unmarshal(buf, &generic) == nil {
+				value, err = structpb.NewValue(generic)
+			}
+			if value == nil {
+				value = structpb.NewNullValue()
+			}
+		}
+		s.Fields[k] = value
+	}
+	return s
+}
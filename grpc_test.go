@@ -0,0 +1,183 @@
+package philifence
+
+import (
+	"context"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	pb "github.com/jtejido/philifence/philifencepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+// a client of g served in memory, until stop
+func grpcClient(t *testing.T, g *grpc.Server) (client pb.PhiliFenceClient, stop func()) {
+	lis := bufconn.Listen(1 << 20)
+	go g.Serve(lis)
+	conn, err := grpc.NewClient("passthrough:///bufnet", grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pb.NewPhiliFenceClient(conn), func() {
+		conn.Close()
+		g.Stop()
+	}
+}
+
+func TestGRPC(t *testing.T) {
+	s := serverWith(t, KindFence, KindFence)
+	g := grpc.NewServer()
+	s.RegisterGRPC(g)
+	client, stop := grpcClient(t, g)
+	defer stop()
+	ctx := context.Background()
+
+	square := &pb.Geometry{Exterior: &pb.Ring{Coordinates: []*pb.Coordinate{{Lat: 0, Lon: 0}, {Lat: 0, Lon: 2}, {Lat: 2, Lon: 2}, {Lat: 2, Lon: 0}}}}
+	_, err := client.Add(ctx, &pb.AddRequest{Layer: KindFence, Index: "cities", Feature: &pb.Feature{Id: "a", Type: "Polygon", Geometry: []*pb.Geometry{square}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Search(ctx, &pb.SearchRequest{Layer: KindFence, Index: "cities", Point: &pb.Coordinate{Lat: 1, Lon: 1}, Params: map[string]string{"distance": "true"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Result) != 1 || res.Result[0].Fields["id"].GetStringValue() != "a" || res.Result[0].Fields["status"].GetStringValue() != StatusInside {
+		t.Errorf("Wrong search result %v", res)
+	}
+	_, err = client.Search(ctx, &pb.SearchRequest{Layer: KindFence, Index: "towns", Point: &pb.Coordinate{Lat: 1, Lon: 1}})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected an unknown index to be not found, got %v", err)
+	}
+	// tenants have indices of their own
+	s.AddTenant("acme", TenantLimits{})
+	acme := metadata.AppendToOutgoingContext(ctx, "x-tenant", "acme")
+	if _, err = client.Search(acme, &pb.SearchRequest{Layer: KindFence, Index: "cities", Point: &pb.Coordinate{Lat: 1, Lon: 1}}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected the default tenant's index to be out of reach, got %v", err)
+	}
+	nope := metadata.AppendToOutgoingContext(ctx, "x-tenant", "nope")
+	if _, err = client.ListLayers(nope, &pb.ListLayersRequest{}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected an unknown tenant to be not found, got %v", err)
+	}
+
+	track, err := client.Track(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updates := []*pb.LocationUpdate{
+		{Id: "car", Layer: KindFence, Index: "cities", Point: &pb.Coordinate{Lat: 1, Lon: 1}},
+		{Id: "car", Layer: KindFence, Index: "cities", Point: &pb.Coordinate{Lat: 100, Lon: 1}},
+		{Id: "car", Layer: KindFence, Index: "cities", Point: &pb.Coordinate{Lat: 5, Lon: 5}},
+	}
+	for _, u := range updates {
+		track.Send(u)
+	}
+	track.CloseSend()
+	for i, want := range []int{1, -1, 0} {
+		res, err := track.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(res.Result); (want < 0 && res.Error == "") || (want >= 0 && got != want) {
+			t.Errorf("Update %d: wrong result %v", i, res)
+		}
+	}
+}
+
+func TestGRPCAuth(t *testing.T) {
+	s := authServer(t)
+	client, stop := grpcClient(t, s.grpcServer(nil))
+	defer stop()
+	as := func(key string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "x-api-key", key)
+	}
+	square := &pb.Geometry{Exterior: &pb.Ring{Coordinates: []*pb.Coordinate{{Lat: 0, Lon: 0}, {Lat: 0, Lon: 2}, {Lat: 2, Lon: 2}, {Lat: 2, Lon: 0}}}}
+	add := &pb.AddRequest{Layer: KindFence, Index: "cities", Feature: &pb.Feature{Id: "b", Type: "Polygon", Geometry: []*pb.Geometry{square}}}
+	cases := []struct {
+		ctx  context.Context
+		code codes.Code
+	}{
+		{context.Background(), codes.Unauthenticated},
+		{as("nope"), codes.Unauthenticated},
+		{as("reader-key"), codes.PermissionDenied},
+		{as("writer-key"), codes.OK},
+	}
+	for i, c := range cases {
+		if _, err := client.Add(c.ctx, add); status.Code(err) != c.code {
+			t.Errorf("Add %d: expected %v, got %v", i, c.code, err)
+		}
+	}
+
+	search := &pb.SearchRequest{Layer: KindFence, Index: "towns", Point: &pb.Coordinate{Lat: 1, Lon: 1}}
+	if _, err := client.Search(as("writer-key"), search); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected searching towns without access denied, got %v", err)
+	}
+	if _, err := client.Search(as("reader-key"), search); err != nil {
+		t.Errorf("Expected the reader to search towns, got %v", err)
+	}
+	// HMAC signs the request message, which can't be swapped for another under the signature
+	signed := func(req proto.Message) context.Context {
+		body, _ := (proto.MarshalOptions{Deterministic: true}).Marshal(req)
+		ts := time.Now().Unix()
+		sig := SignHMAC([]byte("secret"), "POST", pb.PhiliFence_Search_FullMethodName, ts, "", body)
+		auth := "HMAC id=partner,ts=" + strconv.FormatInt(ts, 10) + ",sig=" + hex.EncodeToString(sig)
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", auth)
+	}
+	if _, err := client.Search(signed(search), search); err != nil {
+		t.Errorf("Expected the signed search through, got %v", err)
+	}
+	other := &pb.SearchRequest{Layer: KindFence, Index: "towns", Point: &pb.Coordinate{Lat: 2, Lon: 2}}
+	if _, err := client.Search(signed(search), other); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected another request under the signature refused, got %v", err)
+	}
+	track, _ := client.Track(as("reader-key"))
+	track.Send(&pb.LocationUpdate{Id: "car", Layer: KindFence, Index: "cities", Point: &pb.Coordinate{Lat: 1, Lon: 1}})
+	if _, err := track.Recv(); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected tracking without write access denied, got %v", err)
+	}
+	res, err := client.ListLayers(as("writer-key"), &pb.ListLayersRequest{})
+	if err != nil || len(res.Layers) != 1 || len(res.Layers[0].Indices) != 1 || res.Layers[0].Indices[0] != "cities" {
+		t.Errorf("Expected only the readable indices listed, got %v %v", res, err)
+	}
+
+	// registered on a server without the interceptors, nothing gets through
+	bare := grpc.NewServer()
+	s.RegisterGRPC(bare)
+	unguarded, stopBare := grpcClient(t, bare)
+	defer stopBare()
+	if _, err := unguarded.Add(as("writer-key"), add); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected calls without the interceptors refused, got %v", err)
+	}
+}
+
+func TestGRPCLimits(t *testing.T) {
+	s := serverWith(t, KindFence, KindFence)
+	s.SetLimiter(NewLimiter(LimitConfig{Rates: map[string]Rate{LimitBatch: {PerSecond: 0.1, Burst: 1}, LimitMutation: {PerSecond: 0.1, Burst: 2}}}))
+	client, stop := grpcClient(t, s.grpcServer(nil))
+	defer stop()
+	ctx := context.Background()
+	batch := &pb.BatchRequest{Layer: KindFence, Index: "cities", Queries: []*pb.BatchQuery{{Id: "a", Point: &pb.Coordinate{Lat: 1, Lon: 1}}}}
+	for i, code := range []codes.Code{codes.OK, codes.ResourceExhausted} {
+		stream, _ := client.SearchBatch(ctx, batch)
+		if _, err := stream.Recv(); status.Code(err) != code {
+			t.Errorf("Batch %d: expected %v, got %v", i, code, err)
+		}
+	}
+
+	track, _ := client.Track(ctx)
+	update := &pb.LocationUpdate{Id: "car", Layer: KindFence, Index: "cities", Point: &pb.Coordinate{Lat: 1, Lon: 1}}
+	for i, code := range []codes.Code{codes.OK, codes.OK, codes.ResourceExhausted} {
+		track.Send(update)
+		if _, err := track.Recv(); status.Code(err) != code {
+			t.Errorf("Update %d: expected %v, got %v", i, code, err)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package philifence
+
+// position of the coordinate along a hilbert curve over a 2^16 x 2^16 grid
+func hilbertKey(c Coordinate) uint64 {
+	const order = 16
+	x := lonToUint32(c.lon) >> (uint(Resolution) - 1 - order)
+	y := latToUint32(c.lat) >> (uint(Resolution) - 1 - order)
+	return hilbertXYToD(order, x, y)
+}
+
+// https://en.wikipedia.org/wiki/Hilbert_curve#Applications_and_mapping_algorithms
+func hilbertXYToD(order uint, x, y uint64) (d uint64) {
+	n := uint64(1) << order
+	if x >= n {
+		x = n - 1
+	}
+	if y >= n {
+		y = n - 1
+	}
+	for s := n / 2; s > 0; s /= 2 {
+		var rx, ry uint64
+		if x&s > 0 {
+			rx = 1
+		}
+		if y&s > 0 {
+			ry = 1
+		}
+		d += s * s * ((3 * rx) ^ ry)
+		// rotate
+		if ry == 0 {
+			if rx == 1 {
+				x = n - 1 - x
+				y = n - 1 - y
+			}
+			x, y = y, x
+		}
+	}
+	return
+}
@@ -0,0 +1,368 @@
+package philifence
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// how often Server.KeepHistory saves the indices
+var HistorySaveInterval = 5 * time.Minute
+
+const (
+	historyFormat = 1
+	historyExt    = ".history"
+)
+
+// A history snapshot is every version of a fence's features, gzipped json lines after a
+// header, each version after the one it replaced and linked to it by its line. A version
+// leaves out its geometry, type or properties when they are those of the version it replaced,
+// so a feature whose properties were edited a hundred times is stored with one geometry.
+type historyHeader struct {
+	Format      int `json:"history"`
+	MinChildren int `json:"min_children"`
+	MaxChildren int `json:"max_children"`
+}
+
+type historyRecord struct {
+	Type       string                 `json:"type,omitempty"`
+	Parts      [][][]Coordinate       `json:"parts,omitempty"` // rings of every polygon, the exterior first
+	Properties map[string]interface{} `json:"properties,omitempty"`
+	Version    int                    `json:"version,omitempty"`  // versions before this one
+	Previous   int                    `json:"previous,omitempty"` // line of the version it replaced, from 1
+	Since      int64                  `json:"since,omitempty"`    // unix nanos, 0 for loaded from a dataset
+	Until      int64                  `json:"until,omitempty"`    // unix nanos, 0 while current
+}
+
+// WriteHistory writes a history snapshot of the fence
+func WriteHistory(w io.Writer, fence *Fence) error {
+	gz := gzip.NewWriter(w)
+	enc := json.NewEncoder(gz)
+	if err := enc.Encode(historyHeader{historyFormat, fence.rtree.min, fence.rtree.max}); err != nil {
+		return err
+	}
+	features := everyFeature(fence)
+	lines := make(map[*Feature]int, len(features))
+	for _, f := range features {
+		lines[f] = 0
+	}
+	n := 0
+	var write func(f *Feature) error
+	write = func(f *Feature) error {
+		if lines[f] != 0 {
+			return nil
+		}
+		prev := f.previous
+		if _, ok := lines[prev]; !ok {
+			prev = nil // purged
+		} else if err := write(prev); err != nil {
+			return err
+		}
+		rec := historyRecord{Version: f.replaces, Since: unixNanos(f.since), Until: atomic.LoadInt64(&f.until)}
+		if prev != nil {
+			rec.Previous = lines[prev]
+		}
+		if prev == nil || prev.Type != f.Type {
+			rec.Type = f.Type
+		}
+		if prev == nil || !sameGeometry(prev, f) {
+			rec.Parts = historyParts(f.Geometry)
+		}
+		if prev == nil || !reflect.DeepEqual(prev.Properties, f.Properties) {
+			rec.Properties = f.Properties
+		}
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+		n++
+		lines[f] = n
+		return nil
+	}
+	for _, f := range features {
+		if err := write(f); err != nil {
+			return err
+		}
+	}
+	return gz.Close()
+}
+
+// ReadHistory reads back the fence of a history snapshot
+func ReadHistory(r io.Reader) (*Fence, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bufio.NewReader(gz))
+	var header historyHeader
+	if err = dec.Decode(&header); err != nil {
+		return nil, err
+	}
+	if header.Format != historyFormat {
+		return nil, errorf("Unknown history format %d", header.Format)
+	}
+	fence, err := NewFenceWith(header.MinChildren, header.MaxChildren)
+	if err != nil {
+		return nil, err
+	}
+	var features []*Feature
+	for {
+		var rec historyRecord
+		if err = dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		f := &Feature{Type: rec.Type, Properties: rec.Properties, replaces: rec.Version, until: rec.Until}
+		if rec.Since != 0 {
+			f.since = time.Unix(0, rec.Since).UTC()
+		}
+		if rec.Previous != 0 {
+			if rec.Previous > len(features) {
+				return nil, errorf("History version %d replaces the later %d", len(features)+1, rec.Previous)
+			}
+			prev := features[rec.Previous-1]
+			f.previous = prev
+			if rec.Type == "" {
+				f.Type = prev.Type
+			}
+			if rec.Parts == nil {
+				f.Geometry = copyGeometry(prev.Geometry)
+			}
+			if rec.Properties == nil {
+				f.Properties = prev.Properties
+			}
+		}
+		if f.Geometry == nil {
+			f.Geometry = historyGeometry(rec.Parts)
+		}
+		fence.insert(f)
+		features = append(features, f)
+	}
+	return fence, nil
+}
+
+func historyParts(geometry []*Polygon) [][][]Coordinate {
+	parts := make([][][]Coordinate, len(geometry))
+	for i, poly := range geometry {
+		for _, ring := range poly.rings() {
+			parts[i] = append(parts[i], ring.Coordinates)
+		}
+	}
+	return parts
+}
+
+func historyGeometry(parts [][][]Coordinate) []*Polygon {
+	geometry := make([]*Polygon, len(parts))
+	for i, rings := range parts {
+		poly := &Polygon{Exterior: NewPolyRing()}
+		for j, ring := range rings {
+			if j == 0 {
+				poly.Exterior.Coordinates = ring
+			} else {
+				poly.Holes = append(poly.Holes, NewPolyRing(ring...))
+			}
+		}
+		geometry[i] = poly
+	}
+	return geometry
+}
+
+// polygons of their own over the same coordinates, which are never written to
+func copyGeometry(geometry []*Polygon) []*Polygon {
+	polys := make([]*Polygon, len(geometry))
+	for i, poly := range geometry {
+		polys[i] = &Polygon{Exterior: NewPolyRing(poly.Exterior.Coordinates...)}
+		for _, hole := range poly.Holes {
+			polys[i].Holes = append(polys[i].Holes, NewPolyRing(hole.Coordinates...))
+		}
+	}
+	return polys
+}
+
+func unixNanos(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// SaveHistory writes a history snapshot of the fence to path, replacing it whole
+func SaveHistory(path string, fence *Fence) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err = WriteHistory(tmp, fence); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadHistory reads back the fence SaveHistory saved to path
+func LoadHistory(path string) (*Fence, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	fence, err := ReadHistory(file)
+	if err != nil {
+		return nil, errorf("Error reading history %s: %v", path, err)
+	}
+	return fence, nil
+}
+
+// historyDir is where the history of a layer's indices is kept under dir, {layer} for the
+// server's own and t/{tenant}/{layer} for tenants'
+func historyDir(dir string, layer *Layer) string {
+	if layer.tenant != nil {
+		return filepath.Join(dir, "t", layer.tenant.Name, layer.Name)
+	}
+	return filepath.Join(dir, layer.Name)
+}
+
+func historyFile(dir, name string) string {
+	return filepath.Join(dir, url.PathEscape(name)+historyExt)
+}
+
+// LoadWithHistory loads the dataset at path as the latest state of the history saved for it
+// in dir, if any: a history saved since the dataset last changed is all there is to load,
+// otherwise the dataset is loaded as a reload of it
+func LoadWithHistory(dir, name, path string, load func(path string) (*Fence, int, error)) (*Fence, int, error) {
+	file := historyFile(dir, name)
+	saved, err := os.Stat(file)
+	if err != nil {
+		return load(path)
+	}
+	history, err := LoadHistory(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	if dataset, err := os.Stat(path); err == nil && !dataset.ModTime().After(saved.ModTime()) {
+		return history, history.Len(), nil
+	}
+	fence, n, err := load(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	fence, err = reloaded(history, fence, time.Now())
+	if err == nil {
+		fence.published()
+	}
+	return fence, n, err
+}
+
+// SaveHistory saves a history snapshot of every index of every layer, tenants' included,
+// under dir, and removes those of the indices since removed
+func (s *Server) SaveHistory(dir string) (err error) {
+	for _, layer := range s.everyLayer() {
+		ldir := historyDir(dir, layer)
+		j, journaled := layer.Index.(*Journal)
+		if journaled {
+			ldir = j.dir
+		}
+		keep := make(map[string]bool)
+		for _, name := range layer.Index.Keys() {
+			var serr error
+			if journaled {
+				serr = j.SaveHistory(name)
+			} else if fence := layer.Index.Get(name); fence != nil {
+				serr = SaveHistory(historyFile(ldir, name), fence)
+			}
+			keep[historyFile(ldir, name)] = true
+			if serr != nil {
+				warn(serr, "saving history of "+layer.Name+"/"+name)
+				err = serr
+			}
+		}
+		files, _ := filepath.Glob(filepath.Join(ldir, "*"+historyExt))
+		for _, file := range files {
+			if !keep[file] {
+				warn(os.Remove(file), "removing history "+file)
+			}
+		}
+	}
+	return
+}
+
+// RestoreHistory sets every index saved under dir that isn't held yet, e.g. those added
+// through the api, in the layer of its directory
+func (s *Server) RestoreHistory(dir string) error {
+	for _, layer := range s.everyLayer() {
+		files, _ := filepath.Glob(filepath.Join(historyDir(dir, layer), "*"+historyExt))
+		for _, file := range files {
+			name, err := url.PathUnescape(strings.TrimSuffix(filepath.Base(file), historyExt))
+			if err != nil || layer.Index.Get(name) != nil {
+				continue
+			}
+			fence, err := LoadHistory(file)
+			if err != nil {
+				return err
+			}
+			layer.Index.Set(name, fence)
+			layer.recount(name)
+			info("Restored %d features for %q from %s\n", fence.Len(), layer.Name+"/"+name, file)
+		}
+	}
+	return nil
+}
+
+// KeepHistory saves the history of every index under dir every interval in the background
+// and on shutdown, once the server is ready: the history saved before has to be restored by
+// then, e.g. by loading the indices with it, RestoreHistory and ReplayJournals. The journals
+// are flushed every flush interval meanwhile.
+func (s *Server) KeepHistory(dir string, interval, flush time.Duration) {
+	if dir == "" {
+		return
+	}
+	save := func() error {
+		if !s.Ready() {
+			return nil
+		}
+		return s.SaveHistory(dir)
+	}
+	s.OnShutdown(func() error {
+		if err := s.FlushJournals(); err != nil {
+			return err
+		}
+		return save()
+	})
+	every := func(interval time.Duration, fn func() error) {
+		if interval <= 0 {
+			return
+		}
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-s.stopping.Done():
+					return
+				case <-ticker.C:
+					fn()
+				}
+			}
+		}()
+	}
+	every(interval, save)
+	every(flush, s.FlushJournals)
+}
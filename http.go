@@ -1,34 +1,216 @@
 package philifence
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
 	"github.com/julienschmidt/httprouter"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/pprof"
+	"net/url"
+	"path"
+	"sort"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
-var fences, roads FenceIndex
+// reserved for the routes that are not per layer
+var reservedLayers = map[string]bool{"layers": true, "t": true, "join": true, "admin": true, "debug": true, "events": true, "tiles": true,
+	"metrics": true, "healthz": true, "readyz": true}
 
-func ListenAndServe(addr string, fidx, ridx FenceIndex, profile bool) error {
-	info("Listening on %s\n", addr)
-	defer info("Done Fencing\n")
-	fences = fidx
-	roads = ridx
-	router := httprouter.New()
-	router.GET("/fence", getFenceList)
-	router.POST("/fence/:name/add", postFenceAdd)
-	router.GET("/fence/:name/search", getFenceSearch)
-	router.GET("/road", getRoadList)
-	router.POST("/road/:name/add", postRoadAdd)
-	router.GET("/road/:name/search", getRoadSearch)
-	if profile {
-		profiler(router)
-		info("Profiling available at /debug/pprof/")
+// Server serves any number of layers, each with its own list, add, search, batch search and
+// metrics routes. Layers and watchers are added before serving.
+type Server struct {
+	layers   map[string]*Layer
+	names    []string // in order added
+	tenants  map[string]*Tenant
+	objects  map[string]*ObjectIndex
+	watchers []*Watcher
+	events   *EventHub
+	origins  []string // allowed to open event websockets besides the server's own
+	tiles    *tileCache
+	metrics  *serverMetrics
+	auth     *Auth
+	limiter  *Limiter
+	ready    int32 // atomic, set while every layer is loaded
+	shutdown []func() error
+	stopping context.Context // done once shutting down
+	stop     context.CancelFunc
+	router   *httprouter.Router
+}
+
+func NewServer() *Server {
+	s := &Server{
+		layers:  make(map[string]*Layer),
+		tenants: make(map[string]*Tenant),
+		objects: make(map[string]*ObjectIndex),
+		events:  NewEventHub(),
+		tiles:   newTileCache(),
+		metrics: newServerMetrics(),
+		ready:   1,
+		router:  httprouter.New(),
+	}
+	s.stopping, s.stop = context.WithCancel(context.Background())
+	s.handle("GET", "/layers", s.guard(AccessRead, noResource, s.limit(LimitSearch, s.getLayers)))
+	s.handle("GET", "/t/:tenant/layers", s.guard(AccessRead, noResource, s.limit(LimitSearch, s.getLayers)))
+	s.handle("GET", "/join", s.guard(AccessRead, joinIndices, s.limit(LimitBatch, s.getJoin)))
+	s.handle("GET", "/t/:tenant/join", s.guard(AccessRead, joinIndices, s.limit(LimitBatch, s.getJoin)))
+	s.handle("GET", "/events", s.guard(AccessRead, eventIndices, s.limit(LimitSearch, s.getEvents)))
+	s.handle("GET", "/t/:tenant/events", s.guard(AccessRead, eventIndices, s.limit(LimitSearch, s.getEvents)))
+	s.handle("GET", "/tiles/:layer/:z/:x/:y", s.guard(AccessRead, s.tileIndices, s.limit(LimitSearch, s.getTile)))
+	s.handle("GET", "/t/:tenant/tiles/:layer/:z/:x/:y", s.guard(AccessRead, s.tileIndices, s.limit(LimitSearch, s.getTile)))
+	s.handle("GET", "/admin/reload", s.guard(AccessAdmin, resource(ResourceAdmin), s.getReloadStatus))
+	s.handle("POST", "/admin/reload", s.guard(AccessAdmin, resource(ResourceAdmin), s.postReload))
+	s.router.GET("/metrics", s.guard(AccessRead, resource(ResourceMetrics), s.getServerMetrics))
+	s.router.GET("/healthz", s.getHealth)
+	s.router.GET("/readyz", s.getReady)
+	s.router.NotFound = http.HandlerFunc(notFound)
+	s.router.MethodNotAllowed = http.HandlerFunc(methodNotAllowed)
+	return s
+}
+
+// handle registers the route, timing its requests by method and path pattern for /metrics
+func (s *Server) handle(method, route string, handle httprouter.Handle) {
+	s.router.Handle(method, route, func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		handle(sw, r, params)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		s.metrics.observe(method, route, sw.status, time.Since(start))
+	})
+}
+
+// ListenAndServe serves a fence and a road layer, as /fence and /road, with the timeouts of
+// NewServerConfig
+func ListenAndServe(addr string, fidx, ridx FenceIndex, profile bool, ws ...*Watcher) error {
+	s := NewServer()
+	s.AddLayer(KindFence, KindFence, fidx)
+	s.AddLayer(KindRoad, KindRoad, ridx)
+	s.AddWatchers(ws...)
+	return s.ListenAndServe(addr, profile)
+}
+
+// layerRoute is a route of every layer, under /{layer} and /t/{tenant}/{layer}
+type layerRoute struct {
+	method, path  string
+	access, class string
+	index         bool // whether the route is about the :name index, or the whole layer
+	handle        func(*Layer) httprouter.Handle
+}
+
+func (s *Server) layerRoutes() []layerRoute {
+	return []layerRoute{
+		{"GET", "", AccessRead, LimitSearch, false, s.getList},
+		{"PUT", "/:name", AccessWrite, LimitMutation, true, s.putIndex},
+		{"POST", "/:name/add", AccessWrite, LimitMutation, true, s.postAdd},
+		{"DELETE", "/:name", AccessWrite, LimitMutation, true, s.deleteIndex},
+		{"GET", "/:name/search", AccessRead, LimitSearch, true, s.getSearch},
+		{"POST", "/:name/search/batch", AccessRead, LimitBatch, true, s.postSearchBatch},
+		{"POST", "/:name/gnn", AccessRead, LimitSearch, true, s.postGroupNearest},
+		{"POST", "/:name/match", AccessRead, LimitBatch, true, s.postMatch},
+		{"POST", "/:name/locations", AccessWrite, LimitMutation, true, s.postLocations},
+		{"GET", "/:name/features/:id/metrics", AccessRead, LimitSearch, true, s.getMetrics},
+		{"GET", "/:name/features/:id/history", AccessRead, LimitSearch, true, s.getHistory},
+		{"DELETE", "/:name/features/:id", AccessWrite, LimitMutation, true, s.deleteFeature},
+	}
+}
+
+// AddLayer serves the index as the named layer of a registered kind, every tenant getting a
+// layer of its own alike
+func (s *Server) AddLayer(name, kind string, idx FenceIndex) error {
+	if reservedLayers[name] || strings.Contains(name, "/") {
+		return errorf("Invalid layer name %q", name)
+	}
+	if _, ok := s.layers[name]; ok {
+		return errorf("Layer %q already exists", name)
+	}
+	if _, ok := s.objects[name]; ok {
+		return errorf("Layer %q already exists", name)
+	}
+	layer, err := NewLayer(name, kind, idx)
+	if err != nil {
+		return err
+	}
+	s.layers[name] = layer
+	s.names = append(s.names, name)
+	for _, t := range s.tenants {
+		t.addLayer(layer)
+	}
+	for _, route := range s.layerRoutes() {
+		resources := noResource
+		if route.index {
+			resources = layerIndex(name)
+		}
+		s.handle(route.method, "/"+name+route.path, s.guard(route.access, resources, s.limit(route.class, route.handle(layer))))
+		s.handle(route.method, "/t/:tenant/"+name+route.path, s.guard(route.access, resources, s.limit(route.class, s.inTenant(name, route.handle))))
+	}
+	return nil
+}
+
+func (s *Server) Layer(name string) (layer *Layer, ok bool) {
+	layer, ok = s.layers[name]
+	return
+}
+
+// Events turns the location updates the server gets into enter and exit events
+func (s *Server) Events() *EventHub {
+	return s.events
+}
+
+// AddWatchers reports and triggers reloads of the watchers through /admin/reload
+func (s *Server) AddWatchers(ws ...*Watcher) {
+	s.watchers = append(s.watchers, ws...)
+}
+
+// SetReady sets what /readyz reports. A server is ready from the start, as the layers added
+// to it are taken to be loaded, so unset it while loading them in the background.
+func (s *Server) SetReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&s.ready, v)
+}
+
+func (s *Server) Ready() bool {
+	return atomic.LoadInt32(&s.ready) == 1
+}
+
+// Profile adds the pprof endpoints under /debug/pprof/, for admins when there is Auth
+func (s *Server) Profile() {
+	profiler(func(method, path string, h http.Handler) {
+		s.router.Handle(method, path, s.guard(AccessAdmin, resource(ResourceDebug), func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+			h.ServeHTTP(w, r)
+		}))
+	})
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// ListenAndServe serves http on addr with the timeouts of NewServerConfig until it fails.
+//
+// Deprecated: use Serve, which also serves gRPC and TLS and shuts down gracefully.
+func (s *Server) ListenAndServe(addr string, profile bool) error {
+	config := NewServerConfig("")
+	config.Profiler = profile
+	hs, err := s.httpServer(config)
+	if err != nil {
+		return err
 	}
-	return http.ListenAndServe(addr, router)
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.serve(context.Background(), hs, lis, config)
 }
 
 func respond(w http.ResponseWriter, res interface{}) {
@@ -39,144 +221,447 @@ func respond(w http.ResponseWriter, res interface{}) {
 	writeJson(w, res)
 }
 
-func getFenceList(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-	writeJson(w, fences.Keys())
+type layerMessage struct {
+	Name    string   `json:"name"`
+	Kind    string   `json:"kind"`
+	Indices []string `json:"indices"`
 }
 
-func getRoadList(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-	writeJson(w, roads.Keys())
+func (s *Server) getLayers(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	scoped, _, ok := s.scope(w, params)
+	if !ok {
+		return
+	}
+	layers := make([]layerMessage, len(s.names))
+	for i, name := range s.names {
+		layer := scoped[name]
+		layers[i] = layerMessage{Name: name, Kind: layer.Kind.Name, Indices: s.readable(r, layer)}
+	}
+	respond(w, layers)
 }
 
-func postFenceAdd(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1<<26)) // 64 MB max
-	if err != nil {
-		http.Error(w, "Body 64 MB max", http.StatusRequestEntityTooLarge)
-		return
+func (s *Server) getList(layer *Layer) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		writeJson(w, s.readable(r, layer))
 	}
-	if err := r.Body.Close(); err != nil {
-		http.Error(w, "Error closing body", http.StatusInternalServerError)
-		return
+}
+
+// the indices of the layer the request may read
+func (s *Server) readable(r *http.Request, layer *Layer) []string {
+	keys := []string{}
+	for _, key := range layer.Index.Keys() {
+		if s.allowed(r, tenantOf(layer), AccessRead, layer.Name+"/"+key) {
+			keys = append(keys, key)
+		}
 	}
-	name := params.ByName("name")
-	g, err := unmarshalFeature(string(body))
-	if err != nil {
-		http.Error(w, "Unable to read geojson feature", http.StatusBadRequest)
-		return
+	return keys
+}
+
+func (s *Server) postAdd(layer *Layer) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		defer r.Body.Close()
+		body, err := ioutil.ReadAll(limitBody(r.Body))
+		if err != nil {
+			respondBodyError(w, err, "body")
+			return
+		}
+		name := params.ByName("name")
+		if !hasIndex(layer.Index, name) {
+			respondUnknownIndex(w, layer, name)
+			return
+		}
+		g, err := unmarshalFeature(string(body))
+		if err != nil {
+			respondError(w, http.StatusBadRequest, ErrorBadRequest, "Unable to read geojson feature: "+err.Error())
+			return
+		}
+		feature, err := featureAdapter(g)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, ErrorInvalidFeature, err.Error())
+			return
+		}
+		if err := layer.reserve(name, feature); err != nil {
+			respondError(w, http.StatusInsufficientStorage, ErrorTenantLimit, err.Error())
+			return
+		}
+		if err := layer.Index.Add(name, feature); err != nil {
+			layer.releaseFeatures(name, []*Feature{feature})
+			respondError(w, http.StatusInternalServerError, ErrorInternal, "Error adding feature: "+err.Error())
+			return
+		}
+		respond(w, "success")
 	}
-	feature, err := featureAdapter(g)
-	if err != nil {
-		http.Error(w, "Unable to read geojson feature", http.StatusBadRequest)
+}
+
+// creates an empty index, unless there is one already
+func (s *Server) putIndex(layer *Layer) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		name := params.ByName("name")
+		if hasIndex(layer.Index, name) {
+			respond(w, "exists")
+			return
+		}
+		fence, err := NewFence()
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, ErrorInternal, err.Error())
+			return
+		}
+		if err := layer.addIndex(name, fence); err != nil {
+			respondError(w, http.StatusInsufficientStorage, ErrorTenantLimit, err.Error())
+			return
+		}
+		respond(w, "success")
+	}
+}
+
+func (s *Server) deleteIndex(layer *Layer) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		name := params.ByName("name")
+		if !hasIndex(layer.Index, name) {
+			respondUnknownIndex(w, layer, name)
+			return
+		}
+		layer.Index.Remove(name)
+		layer.release(name)
+		respond(w, "success")
+	}
+}
+
+// matchs lat, lon within tolerance meters by the layer's kind, echoing back the other params
+func (s *Server) getSearch(layer *Layer) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		query := r.URL.Query()
+		lat, err := strconv.ParseFloat(query.Get("lat"), 64)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, ErrorBadRequest, "Query param 'lat' required as float")
+			return
+		}
+		lon, err := strconv.ParseFloat(query.Get("lon"), 64)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, ErrorBadRequest, "Query param 'lon' required as float")
+			return
+		}
+		tol := 1.0 // ~1m
+		if v := query.Get("tolerance"); v != "" {
+			if tol, err = strconv.ParseFloat(v, 64); err != nil {
+				respondError(w, http.StatusBadRequest, ErrorBadRequest, "Query param 'tolerance' must be a float")
+				return
+			}
+		}
+		opts := make(url.Values, len(layer.Kind.Params))
+		for _, k := range layer.Kind.Params {
+			if v, ok := query[k]; ok {
+				opts[k] = v
+			}
+			query.Del(k)
+		}
+		query.Del("lat")
+		query.Del("lon")
+		query.Del("tolerance")
+		c := Coordinate{lat: lat, lon: lon}
+		if err := c.validate(); err != nil {
+			respondError(w, http.StatusUnprocessableEntity, ErrorInvalidCoordinate, err.Error())
+			return
+		}
+		if err := validateTolerance(tol); err != nil {
+			respondError(w, http.StatusUnprocessableEntity, ErrorInvalidTolerance, err.Error())
+			return
+		}
+		name := params.ByName("name")
+		if !hasIndex(layer.Index, name) {
+			respondUnknownIndex(w, layer, name)
+			return
+		}
+		result, err := layer.Search(r.Context(), name, c, tol, opts)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, ErrorBadRequest, "Error searching "+layer.Name+" "+name+": "+err.Error())
+			return
+		}
+		props := make(map[string]interface{}, len(query))
+		for k := range query {
+			props[k] = query.Get(k)
+		}
+
+		respond(w, *newResponseMessage(c, props, result))
+	}
+}
+
+func (s *Server) getMetrics(layer *Layer) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		name := params.ByName("name")
+		if !hasIndex(layer.Index, name) {
+			respondUnknownIndex(w, layer, name)
+			return
+		}
+		feature, err := layer.Index.Feature(name, params.ByName("id"))
+		if err != nil {
+			respondError(w, http.StatusNotFound, ErrorUnknownFeature, err.Error())
+			return
+		}
+		respond(w, feature.Metrics())
+	}
+}
+
+// every version of the feature, the latest first
+func (s *Server) getHistory(layer *Layer) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		name := params.ByName("name")
+		if !hasIndex(layer.Index, name) {
+			respondUnknownIndex(w, layer, name)
+			return
+		}
+		versions, err := layer.Index.History(name, params.ByName("id"))
+		if err != nil {
+			respondError(w, http.StatusNotFound, ErrorUnknownFeature, err.Error())
+			return
+		}
+		respond(w, newHistoryMessage(versions))
+	}
+}
+
+// retires the feature, it is still found by searches as of before now
+func (s *Server) deleteFeature(layer *Layer) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		name := params.ByName("name")
+		if !hasIndex(layer.Index, name) {
+			respondUnknownIndex(w, layer, name)
+			return
+		}
+		if err := layer.Index.DeleteFeature(name, params.ByName("id")); err != nil {
+			respondError(w, http.StatusNotFound, ErrorUnknownFeature, err.Error())
+			return
+		}
+		respond(w, "success")
+	}
+}
+
+// accepts a json array or newline delimited json of {id, lat, lon, tolerance}
+func (s *Server) postSearchBatch(layer *Layer) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		defer r.Body.Close()
+		queries, err := decodeBatch(limitBody(r.Body))
+		if err != nil {
+			respondBodyError(w, err, "batch")
+			return
+		}
+		if !validQueries(w, queries, "query") {
+			return
+		}
+		name := params.ByName("name")
+		if !hasIndex(layer.Index, name) {
+			respondUnknownIndex(w, layer, name)
+			return
+		}
+		matchs, err := layer.Index.SearchBatch(name, queries)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, ErrorInternal, "Error batch searching "+name+": "+err.Error())
+			return
+		}
+
+		respond(w, *newBatchResponseMessage(matchs))
+	}
+}
+
+// serves the prometheus text format
+func (s *Server) getServerMetrics(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	w.Header().Set("Server", "philifence")
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	s.writeMetrics(w)
+}
+
+// alive as long as it can answer
+func (s *Server) getHealth(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	respond(w, "ok")
+}
+
+func (s *Server) getReady(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	if !s.Ready() {
+		respondError(w, http.StatusServiceUnavailable, ErrorNotReady, "Loading indices")
 		return
 	}
-	if err := fences.Add(name, feature); err != nil {
-		http.Error(w, "Error adding feature "+err.Error(), http.StatusBadRequest)
+	respond(w, "ok")
+}
+
+func (s *Server) getReloadStatus(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	statuses := []ReloadStatus{}
+	for _, watcher := range s.watchers {
+		statuses = append(statuses, watcher.Statuses()...)
 	}
-	respond(w, "success")
+	respond(w, statuses)
 }
 
-func postRoadAdd(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1<<26)) // 64 MB max
-	if err != nil {
-		http.Error(w, "Body 64 MB max", http.StatusRequestEntityTooLarge)
+// reloads the index given by the 'index' query param, or every index
+func (s *Server) postReload(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	name := r.URL.Query().Get("index")
+	found, busy := false, false
+	for _, watcher := range s.watchers {
+		switch err := watcher.Reload(name); err {
+		case nil:
+			found = true
+		case ErrReloadBusy:
+			found, busy = true, true
+		}
+	}
+	if busy {
+		respondError(w, http.StatusServiceUnavailable, ErrorBusy, "Too many reloads queued, try again later")
 		return
 	}
-	if err := r.Body.Close(); err != nil {
-		http.Error(w, "Error closing body", http.StatusInternalServerError)
+	if !found {
+		respondError(w, http.StatusNotFound, ErrorUnknownIndex, "No reloadable index "+name)
 		return
 	}
-	name := params.ByName("name")
-	g, err := unmarshalFeature(string(body))
-	if err != nil {
-		http.Error(w, "Unable to read geojson feature", http.StatusBadRequest)
+	s.getReloadStatus(w, r, params)
+}
+
+// streams newline delimited pairs of intersecting feature ids between two indices,
+// e.g. /join?left=fence/philippine-cities&right=road/philippine-roads&measure=true
+func (s *Server) getJoin(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	layers, _, ok := s.scope(w, params)
+	if !ok {
 		return
 	}
-	feature, err := featureAdapter(g)
-	if err != nil {
-		http.Error(w, "Unable to read geojson feature", http.StatusBadRequest)
+	query := r.URL.Query()
+	left, ok := treeFromQuery(w, layers, "left", query.Get("left"))
+	if !ok {
 		return
 	}
-	if err := roads.Add(name, feature); err != nil {
-		http.Error(w, "Error adding feature "+err.Error(), http.StatusBadRequest)
+	right, ok := treeFromQuery(w, layers, "right", query.Get("right"))
+	if !ok {
+		return
 	}
-	respond(w, "success")
+	measure, _ := strconv.ParseBool(query.Get("measure"))
+
+	w.Header().Set("Server", "philifence")
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	extendWrite(w, 0) // a large join takes longer than the write timeout
+	flusher, _ := w.(http.Flusher)
+	n := 0
+	Join(left, right, measure, func(pair JoinPair) error {
+		if err := writeJson(w, newJoinMessage(pair, measure)); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, "\n"); err != nil {
+			return err
+		}
+		if n++; flusher != nil && n%100 == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
 }
 
-func getFenceSearch(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-	query := r.URL.Query()
-	lat, err := strconv.ParseFloat(query.Get("lat"), 64)
-	if err != nil {
-		http.Error(w, "Query param 'lat' required as float", http.StatusBadRequest)
+// renders /tiles/{layer}/{z}/{x}/{y}.mvt with a tile layer per index, every index of the layer
+// or those in the comma separated index param
+func (s *Server) getTile(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	layers, _, ok := s.scope(w, params)
+	if !ok {
 		return
 	}
-	lon, err := strconv.ParseFloat(query.Get("lon"), 64)
-	if err != nil {
-		http.Error(w, "Query param 'lon' required as float", http.StatusBadRequest)
+	layer, ok := layers[params.ByName("layer")]
+	file := params.ByName("y")
+	if !ok {
+		respondError(w, http.StatusNotFound, ErrorUnknownLayer, "No layer "+params.ByName("layer"))
 		return
 	}
-	tol, err := strconv.ParseFloat(query.Get("tolerance"), 64)
-	if err != nil {
-		tol = 1 // ~1m
+	if !strings.HasSuffix(file, ".mvt") {
+		notFound(w, r)
+		return
 	}
-
-	query.Del("lat")
-	query.Del("lon")
-	query.Del("tolerance")
-	c := Coordinate{lat: lat, lon: lon}
-	name := params.ByName("name")
-	matchs, err := fences.Search(name, c, tol)
-	if err != nil {
-		http.Error(w, "Error search fence "+name, http.StatusBadRequest)
+	z, errZ := strconv.Atoi(params.ByName("z"))
+	x, errX := strconv.Atoi(params.ByName("x"))
+	y, errY := strconv.Atoi(strings.TrimSuffix(file, ".mvt"))
+	if errZ != nil || errX != nil || errY != nil || z < 0 || z > 30 || x < 0 || y < 0 || x >= 1<<uint(z) || y >= 1<<uint(z) {
+		respondError(w, http.StatusBadRequest, ErrorInvalidTile, "Invalid tile "+params.ByName("z")+"/"+params.ByName("x")+"/"+file)
 		return
 	}
-	fences := make([]Properties, len(matchs))
-	for i, fence := range matchs {
-		fences[i] = fence.Properties
+	names := splitParam(r.URL.Query(), "index")
+	if len(names) == 0 {
+		names = layer.Index.Keys()
 	}
-	props := make(map[string]interface{}, len(query))
-	for k := range query {
-		props[k] = query.Get(k)
+	sort.Strings(names)
+	trees := make(map[string]*Rtree, len(names))
+	for _, name := range names {
+		tree, err := layer.Index.Tree(name)
+		if err != nil {
+			respondUnknownIndex(w, layer, name)
+			return
+		}
+		trees[name] = tree
 	}
-
-	respond(w, *newResponseMessage(c, props, fences))
+	key := sprintf("%s/%s/%d/%d/%d/%s", params.ByName("tenant"), layer.Name, z, x, y, strings.Join(names, ","))
+	tile, ok := s.tiles.get(key, trees)
+	if !ok {
+		tile = EncodeTile(z, x, y, trees)
+		s.tiles.put(key, trees, tile)
+	}
+	w.Header().Set("Server", "philifence")
+	w.Header().Set("Content-Type", "application/vnd.mapbox-vector-tile")
+	w.WriteHeader(http.StatusOK)
+	w.Write(tile)
 }
 
-func getRoadSearch(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-	query := r.URL.Query()
-	lat, err := strconv.ParseFloat(query.Get("lat"), 64)
-	if err != nil {
-		http.Error(w, "Query param 'lat' required as float", http.StatusBadRequest)
-		return
+// resolves the param's "{layer}/{name}", e.g. "fence/philippine-cities", among the layers or
+// responds why not
+func treeFromQuery(w http.ResponseWriter, layers map[string]*Layer, param, query string) (*Rtree, bool) {
+	dir, name := path.Split(query)
+	if dir == "" || name == "" {
+		respondError(w, http.StatusBadRequest, ErrorBadRequest, "Query param '"+param+"' must be {layer}/{name}")
+		return nil, false
 	}
-	lon, err := strconv.ParseFloat(query.Get("lon"), 64)
-	if err != nil {
-		http.Error(w, "Query param 'lon' required as float", http.StatusBadRequest)
-		return
+	layer, ok := layers[strings.TrimSuffix(dir, "/")]
+	if !ok {
+		respondError(w, http.StatusNotFound, ErrorUnknownLayer, "Query param '"+param+"' has no layer "+strings.TrimSuffix(dir, "/"))
+		return nil, false
 	}
-	tol, err := strconv.ParseFloat(query.Get("tolerance"), 64)
+	tree, err := layer.Index.Tree(name)
 	if err != nil {
-		tol = 1 // ~1m
+		respondUnknownIndex(w, layer, name)
+		return nil, false
 	}
+	return tree, true
+}
 
-	query.Del("lat")
-	query.Del("lon")
-	query.Del("tolerance")
-	c := Coordinate{lat: lat, lon: lon}
-	name := params.ByName("name")
-	matchs, err := roads.Search(name, c, tol)
-	if err != nil {
-		http.Error(w, "Error search road "+name, http.StatusBadRequest)
-		return
+// statusWriter keeps the status code for the metrics, passing on flushes and hijacks for
+// the streaming routes
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
 	}
-	roads := make([]Properties, len(matchs))
-	for i, road := range matchs {
-		roads[i] = road.Properties
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
 	}
-	props := make(map[string]interface{}, len(query))
-	for k := range query {
-		props[k] = query.Get(k)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
 	}
+}
+
+// Unwrap lets http.ResponseController reach the connection, for write deadlines
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
 
-	respond(w, *newResponseMessage(c, props, roads))
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errorf("Connection can't be hijacked")
+	}
+	w.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
 }
 
 func writeJson(w io.Writer, msg interface{}) (err error) {
@@ -189,17 +674,17 @@ func writeJson(w io.Writer, msg interface{}) (err error) {
 	return
 }
 
-func profiler(router *httprouter.Router) {
-	router.HandlerFunc("GET", "/debug/pprof/", pprof.Index)
-	router.HandlerFunc("POST", "/debug/pprof/", pprof.Index)
-	router.HandlerFunc("GET", "/debug/pprof/cmdline", pprof.Cmdline)
-	router.HandlerFunc("POST", "/debug/pprof/cmdline", pprof.Cmdline)
-	router.HandlerFunc("GET", "/debug/pprof/profile", pprof.Profile)
-	router.HandlerFunc("POST", "/debug/pprof/profile", pprof.Profile)
-	router.HandlerFunc("GET", "/debug/pprof/symbol", pprof.Symbol)
-	router.HandlerFunc("POST", "/debug/pprof/symbol", pprof.Symbol)
-	router.Handler("GET", "/debug/pprof/heap", pprof.Handler("heap"))
-	router.Handler("GET", "/debug/pprof/block", pprof.Handler("block"))
-	router.Handler("GET", "/debug/pprof/goroutine", pprof.Handler("goroutine"))
-	router.Handler("GET", "/debug/pprof/threadcreate", pprof.Handler("threadcreate"))
+func profiler(handle func(method, path string, h http.Handler)) {
+	handle("GET", "/debug/pprof/", http.HandlerFunc(pprof.Index))
+	handle("POST", "/debug/pprof/", http.HandlerFunc(pprof.Index))
+	handle("GET", "/debug/pprof/cmdline", http.HandlerFunc(pprof.Cmdline))
+	handle("POST", "/debug/pprof/cmdline", http.HandlerFunc(pprof.Cmdline))
+	handle("GET", "/debug/pprof/profile", http.HandlerFunc(pprof.Profile))
+	handle("POST", "/debug/pprof/profile", http.HandlerFunc(pprof.Profile))
+	handle("GET", "/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
+	handle("POST", "/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
+	handle("GET", "/debug/pprof/heap", pprof.Handler("heap"))
+	handle("GET", "/debug/pprof/block", pprof.Handler("block"))
+	handle("GET", "/debug/pprof/goroutine", pprof.Handler("goroutine"))
+	handle("GET", "/debug/pprof/threadcreate", pprof.Handler("threadcreate"))
 }
@@ -0,0 +1,184 @@
+package philifence
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func serverWith(t *testing.T, name, kind string, features ...*Feature) *Server {
+	idx := NewFenceIndex()
+	fence, _ := newFenceOf(features)
+	idx.Set("cities", fence)
+	s := NewServer()
+	if err := s.AddLayer(name, kind, idx); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func searchServer(t *testing.T, s *Server, path string) (msg ResponseMessage) {
+	r := httptest.NewRecorder()
+	s.ServeHTTP(r, httptest.NewRequest("GET", path, nil))
+	if r.Code != 200 {
+		t.Fatalf("GET %s: %d %s", path, r.Code, r.Body)
+	}
+	if err := json.Unmarshal(r.Body.Bytes(), &msg); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestServerLayers(t *testing.T) {
+	a := NewPolygonFeature(square(0, 0, 2))
+	a.Properties = map[string]interface{}{"id": "a"}
+	b := NewPolygonFeature(square(10, 10, 2))
+	b.Properties = map[string]interface{}{"id": "b"}
+	one := serverWith(t, KindFence, KindFence, a)
+	two := serverWith(t, KindFence, KindFence, b)
+
+	if msg := searchServer(t, one, "/fence/cities/search?lat=1&lon=1&user=x"); len(msg.Result) != 1 || msg.Query.Properties["user"] != "x" {
+		t.Errorf("Wrong search result %+v", msg)
+	}
+	if msg := searchServer(t, two, "/fence/cities/search?lat=1&lon=1"); len(msg.Result) != 0 {
+		t.Errorf("Servers share their layers, %+v", msg)
+	}
+	if msg := searchServer(t, one, "/fence/cities/search?lat=1&lon=1&distance=true"); len(msg.Result) != 1 || msg.Result[0]["distance"] == nil || msg.Result[0]["status"] != StatusInside {
+		t.Errorf("Expected the distance with distance=true, got %+v", msg.Result)
+	}
+
+	RegisterKind(&Kind{Name: "zone", Search: func(idx FenceIndex, name string, c Coordinate, tol float64, params url.Values) ([]Properties, error) {
+		features, err := idx.Search(name, c, tol)
+		props := make([]Properties, len(features))
+		for i, f := range features {
+			props[i] = Properties{"zone": f.Id()}
+		}
+		return props, err
+	}})
+	defer func() { kindsMu.Lock(); delete(kinds, "zone"); kindsMu.Unlock() }()
+	if err := two.AddLayer("zones", "zone", NewFenceIndex()); err != nil {
+		t.Fatal(err)
+	}
+	zones, _ := newFenceOf([]*Feature{b})
+	two.layers["zones"].Index.Set("cities", zones)
+	if msg := searchServer(t, two, "/zones/cities/search?lat=11&lon=11"); len(msg.Result) != 1 || msg.Result[0]["zone"] != "b" {
+		t.Errorf("Layer did not search by its kind, %+v", msg)
+	}
+	if err := two.AddLayer("join", KindFence, NewFenceIndex()); err == nil {
+		t.Errorf("Expected an error adding a reserved layer name")
+	}
+}
+
+func TestServerRoads(t *testing.T) {
+	// an L shaped road, the corner it leaves open far from either leg
+	s := serverWith(t, KindRoad, KindRoad, road("a", cd(0, 0), cd(0, 0.02), cd(0.02, 0.02)))
+	if msg := searchServer(t, s, "/road/cities/search?lat=0.0001&lon=0.01&tolerance=20"); len(msg.Result) != 1 {
+		t.Errorf("Expected the road 11m away, got %+v", msg.Result)
+	}
+	if msg := searchServer(t, s, "/road/cities/search?lat=0.01&lon=0.015&tolerance=20"); len(msg.Result) != 0 {
+		t.Errorf("Expected no road within 20m of the corner, got %+v", msg.Result)
+	}
+}
+
+func TestServerHandlers(t *testing.T) {
+	const feature = `{"type": "Feature", "id": "b", "properties": {}, "geometry": {"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [1, 1], [0, 0]]]}}`
+	const outside = `{"type": "Feature", "id": "b", "properties": {}, "geometry": {"type": "Polygon", "coordinates": [[[0, 0], [1, 95], [1, 1], [0, 0]]]}}`
+	cases := []struct {
+		method, path, body string
+		status             int
+		code               string
+	}{
+		{"GET", "/layers", "", 200, ""},
+		{"PUT", "/layers", "", 405, ErrorMethodNotAllowed},
+		{"GET", "/nowhere/at/all", "", 404, ErrorNotFound},
+		{"GET", "/fence", "", 200, ""},
+
+		{"POST", "/fence/cities/add", feature, 200, ""},
+		{"POST", "/fence/towns/add", feature, 404, ErrorUnknownIndex},
+		{"POST", "/fence/cities/add", "{", 400, ErrorBadRequest},
+		{"POST", "/fence/cities/add", outside, 422, ErrorInvalidFeature},
+		{"POST", "/fence/cities/add", strings.Repeat(" ", 2048), 413, ErrorBodyTooLarge},
+
+		{"DELETE", "/fence/cities", "", 200, ""},
+		{"DELETE", "/fence/towns", "", 404, ErrorUnknownIndex},
+
+		{"GET", "/fence/cities/search?lat=1&lon=1", "", 200, ""},
+		{"GET", "/fence/cities/search?lat=1&lon=1&tolerance=10&mode=near", "", 200, ""},
+		{"GET", "/fence/cities/search?lat=north&lon=1", "", 400, ErrorBadRequest},
+		{"GET", "/fence/cities/search?lat=1", "", 400, ErrorBadRequest},
+		{"GET", "/fence/cities/search?lat=91&lon=1", "", 422, ErrorInvalidCoordinate},
+		{"GET", "/fence/cities/search?lat=1&lon=-181", "", 422, ErrorInvalidCoordinate},
+		{"GET", "/fence/cities/search?lat=1&lon=1&tolerance=wide", "", 400, ErrorBadRequest},
+		{"GET", "/fence/cities/search?lat=1&lon=1&tolerance=-1", "", 422, ErrorInvalidTolerance},
+		{"GET", "/fence/cities/search?lat=1&lon=1&tolerance=1e9", "", 422, ErrorInvalidTolerance},
+		{"GET", "/fence/cities/search?lat=1&lon=1&mode=around", "", 400, ErrorBadRequest},
+		{"GET", "/fence/towns/search?lat=1&lon=1", "", 404, ErrorUnknownIndex},
+
+		{"GET", "/fence/cities/features/a/metrics", "", 200, ""},
+		{"GET", "/fence/cities/features/z/metrics", "", 404, ErrorUnknownFeature},
+		{"GET", "/fence/towns/features/a/metrics", "", 404, ErrorUnknownIndex},
+
+		{"POST", "/fence/cities/search/batch", `[{"id": "x", "lat": 1, "lon": 1}]`, 200, ""},
+		{"POST", "/fence/cities/search/batch", `[{"id": "x", "lat": 1`, 400, ErrorBadRequest},
+		{"POST", "/fence/cities/search/batch", ``, 400, ErrorBadRequest},
+		{"POST", "/fence/cities/search/batch", `[{"id": "x", "lat": 91, "lon": 1}]`, 422, ErrorInvalidCoordinate},
+		{"POST", "/fence/cities/search/batch", `[{"id": "x", "lat": 1, "lon": 1, "tolerance": -5}]`, 422, ErrorInvalidTolerance},
+		{"POST", "/fence/cities/search/batch", `[` + strings.Repeat(`{"lat": 1, "lon": 1},`, 100) + `]`, 413, ErrorBodyTooLarge},
+		{"POST", "/fence/towns/search/batch", `[{"id": "x", "lat": 1, "lon": 1}]`, 404, ErrorUnknownIndex},
+
+		{"POST", "/fence/cities/locations", `[{"id": "car", "lat": 1, "lon": 1}]`, 200, ""},
+		{"POST", "/fence/cities/locations", `[{"id": "car", "lat": 1, "lon": 200}]`, 422, ErrorInvalidCoordinate},
+		{"POST", "/fence/towns/locations", `[{"id": "car", "lat": 1, "lon": 1}]`, 404, ErrorUnknownIndex},
+
+		{"GET", "/join?left=fence/cities&right=fence/cities", "", 200, ""},
+		{"GET", "/join?left=cities&right=fence/cities", "", 400, ErrorBadRequest},
+		{"GET", "/join?left=fence/cities&right=road/cities", "", 404, ErrorUnknownLayer},
+		{"GET", "/join?left=fence/towns&right=fence/cities", "", 404, ErrorUnknownIndex},
+
+		{"GET", "/tiles/fence/0/0/0.mvt", "", 200, ""},
+		{"GET", "/tiles/road/0/0/0.mvt", "", 404, ErrorUnknownLayer},
+		{"GET", "/tiles/fence/0/0/0.png", "", 404, ErrorNotFound},
+		{"GET", "/tiles/fence/1/5/0.mvt", "", 400, ErrorInvalidTile},
+		{"GET", "/tiles/fence/0/0/0.mvt?index=towns", "", 404, ErrorUnknownIndex},
+
+		{"GET", "/admin/reload", "", 200, ""},
+		{"POST", "/admin/reload?index=towns", "", 404, ErrorUnknownIndex},
+
+		{"GET", "/metrics", "", 200, ""},
+		{"GET", "/healthz", "", 200, ""},
+		{"GET", "/readyz", "", 200, ""},
+	}
+	defer func(max int64) { MaxBodySize = max }(MaxBodySize)
+	MaxBodySize = 1024
+	a := NewPolygonFeature(square(0, 0, 2))
+	a.Properties = map[string]interface{}{"id": "a"}
+	for _, c := range cases {
+		s := serverWith(t, KindFence, KindFence, a)
+		r := httptest.NewRecorder()
+		s.ServeHTTP(r, httptest.NewRequest(c.method, c.path, strings.NewReader(c.body)))
+		if r.Code != c.status {
+			t.Errorf("%s %s: expected %d, got %d %s", c.method, c.path, c.status, r.Code, r.Body)
+			continue
+		}
+		if c.code == "" {
+			continue
+		}
+		var msg ErrorMessage
+		if err := json.Unmarshal(r.Body.Bytes(), &msg); err != nil || msg.Error.Code != c.code || msg.Error.Status != c.status {
+			t.Errorf("%s %s: expected a %s error, got %s", c.method, c.path, c.code, r.Body)
+		}
+	}
+
+	// an event stream ends with its request
+	s := serverWith(t, KindFence, KindFence, a)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := httptest.NewRecorder()
+	s.ServeHTTP(r, httptest.NewRequest("GET", "/events", nil).WithContext(ctx))
+	if r.Code != 200 || r.Header().Get("Content-Type") != "text/event-stream" {
+		t.Errorf("Expected an event stream, got %d %s", r.Code, r.Body)
+	}
+}
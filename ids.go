@@ -0,0 +1,107 @@
+package philifence
+
+import (
+	"hash/fnv"
+	"sync/atomic"
+)
+
+const (
+	idBits  = 4
+	idDepth = 5 // levels of nodes above the features, 2^20 buckets
+)
+
+// featureIds holds the latest feature of every id. It is a hash trie written like Rtree: in
+// place until shared, then by copying the nodes on the path to an id, so the forks of a
+// fence share all of it but what they changed.
+type featureIds struct {
+	root *idNode
+	size int
+	edit uint64
+}
+
+type idNode struct {
+	children [1 << idBits]*idNode
+	features []*Feature // at the bottom, those whose ids hash alike
+	edit     uint64
+}
+
+func idHash(id string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return h.Sum32()
+}
+
+func (m *featureIds) get(id string) (*Feature, bool) {
+	h, n := idHash(id), m.root
+	for level := 0; n != nil && level < idDepth; level++ {
+		n = n.children[h>>(uint(level)*idBits)&(1<<idBits-1)]
+	}
+	if n == nil {
+		return nil, false
+	}
+	for _, f := range n.features {
+		if f.Id() == id {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// set makes the feature the one of its id
+func (m *featureIds) set(f *Feature) {
+	if m.edit == 0 {
+		m.edit = atomic.AddUint64(&rtreeEdits, 1)
+	}
+	id := f.Id()
+	h := idHash(id)
+	m.root = m.writable(m.root)
+	n := m.root
+	for level := 0; level < idDepth; level++ {
+		i := h >> (uint(level) * idBits) & (1<<idBits - 1)
+		n.children[i] = m.writable(n.children[i])
+		n = n.children[i]
+	}
+	for i, old := range n.features {
+		if old.Id() == id {
+			n.features[i] = f
+			return
+		}
+	}
+	n.features = append(n.features, f)
+	m.size++
+}
+
+// writable is n when the map may write it, otherwise a copy it may, or a new node for none
+func (m *featureIds) writable(n *idNode) *idNode {
+	if n == nil {
+		return &idNode{edit: m.edit}
+	}
+	if n.edit == m.edit {
+		return n
+	}
+	return &idNode{children: n.children, features: append([]*Feature(nil), n.features...), edit: m.edit}
+}
+
+// share returns the map as it is, which neither it nor the map go on to write
+func (m *featureIds) share() featureIds {
+	if m.edit != 0 {
+		m.edit = 0
+	}
+	return *m
+}
+
+func (m *featureIds) each(fn func(*Feature)) {
+	var walk func(n *idNode)
+	walk = func(n *idNode) {
+		if n == nil {
+			return
+		}
+		for _, f := range n.features {
+			fn(f)
+		}
+		for _, child := range n.children {
+			walk(child)
+		}
+	}
+	walk(m.root)
+}
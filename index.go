@@ -4,22 +4,87 @@ import (
 	"fmt"
 	"path/filepath"
 	"sync"
+	"time"
 )
 
 //FenceIndex is a dictionary of multiple fences. Useful if you have multiple data sets that need to be searched
 type FenceIndex interface {
+	// Set replaces the named fence, or adds it
 	Set(name string, fence *Fence)
+	// Update sets the named fence to the one fn makes of it as it is, read-only or nil when
+	// there is none, with no write to the fence in between. The new fence is the index's.
+	Update(name string, fn func(old *Fence) (*Fence, error)) error
+	// Get returns a read-only copy of the fence as it is, which later writes leave alone.
+	// Writing to it panics, Set a fork of it instead.
 	Get(name string) *Fence
+	Remove(name string)
 	Add(name string, feature *Feature) error
 	Search(name string, c Coordinate, tol float64) ([]*Feature, error)
+	Near(name string, c Coordinate, tol float64) ([]Match, error)
+	SearchAt(name string, c Coordinate, tol float64, t time.Time) ([]*Feature, error)
+	NearAt(name string, c Coordinate, tol float64, t time.Time) ([]Match, error)
+	SearchBatch(name string, queries []BatchQuery) (map[string][]*Feature, error)
+	// Tree returns the fence's tree as it is, see Fence.Tree
+	Tree(name string) (*Rtree, error)
+	Feature(name, id string) (*Feature, error)
+	// History is every version of the feature's id, the latest first
+	History(name, id string) ([]*Feature, error)
+	// DeleteFeature retires the current feature of the id, keeping it in its history
+	DeleteFeature(name, id string) error
+	Stats(name string) (IndexStats, error)
 	Keys() []string
+	// Purge drops the features expired at t from the named fence, returning them
+	Purge(name string, t time.Time) ([]*Feature, error)
+}
+
+// IndexStats describes the size of a fence
+type IndexStats struct {
+	Features int `json:"features"`
+	Leaves   int `json:"leaves"` // polygons indexed for the features
+	Height   int `json:"height"` // levels of nodes of the tree above the leaves
+}
+
+// hasIndex checks for a fence without getting it, which can be costly for snapshots
+func hasIndex(idx FenceIndex, name string) bool {
+	for _, key := range idx.Keys() {
+		if key == name {
+			return true
+		}
+	}
+	return false
 }
 
 // Returns a thread-safe FenceIndex
 func NewFenceIndex() FenceIndex {
+	return NewFenceIndexWith(IndexOptions{})
+}
+
+// NewFenceIndexWith returns a SnapshotFenceIndex with opts.SnapshotReads, otherwise a
+// MutexFenceIndex
+func NewFenceIndexWith(opts IndexOptions) FenceIndex {
+	if opts.SnapshotReads {
+		return NewSnapshotFenceIndex()
+	}
 	return NewMutexFenceIndex()
 }
 
+// whether the index serves reads from snapshots, for indices to be alike
+func snapshotReads(idx FenceIndex) bool {
+	if j, ok := idx.(*Journal); ok {
+		idx = j.FenceIndex
+	}
+	_, ok := idx.(*SnapshotFenceIndex)
+	return ok
+}
+
+// settable is the fence an index can hold, a fork of it when it was handed out to read
+func settable(fence *Fence) *Fence {
+	if fence.readonly {
+		return fence.fork()
+	}
+	return fence
+}
+
 type UnsafeFenceIndex struct {
 	fences map[string]*Fence
 }
@@ -29,11 +94,30 @@ func NewUnsafeFenceIndex() *UnsafeFenceIndex {
 }
 
 func (idx *UnsafeFenceIndex) Set(name string, fence *Fence) {
+	fence = settable(fence)
 	idx.fences[name] = fence
+	fence.published()
+}
+
+func (idx *UnsafeFenceIndex) Update(name string, fn func(old *Fence) (*Fence, error)) error {
+	fence, err := fn(idx.Get(name))
+	if err != nil {
+		return err
+	}
+	idx.Set(name, fence)
+	return nil
 }
 
-func (idx *UnsafeFenceIndex) Get(name string) (fence *Fence) {
-	return idx.fences[name]
+func (idx *UnsafeFenceIndex) Get(name string) *Fence {
+	fence, ok := idx.fences[name]
+	if !ok {
+		return nil
+	}
+	return fence.readable()
+}
+
+func (idx *UnsafeFenceIndex) Remove(name string) {
+	delete(idx.fences, name)
 }
 
 func (idx *UnsafeFenceIndex) Add(name string, feature *Feature) (err error) {
@@ -42,17 +126,110 @@ func (idx *UnsafeFenceIndex) Add(name string, feature *Feature) (err error) {
 		return fmt.Errorf("FenceIndex does not contain fence %q", name)
 	}
 	fence.Add(feature)
+	inserts.inc()
 	return
 }
 
 func (idx *UnsafeFenceIndex) Search(name string, c Coordinate, tol float64) (matchs []*Feature, err error) {
+	return idx.SearchAt(name, c, tol, time.Now())
+}
+
+func (idx *UnsafeFenceIndex) SearchAt(name string, c Coordinate, tol float64, t time.Time) (matchs []*Feature, err error) {
 	fence, ok := idx.fences[name]
 	if !ok {
 		err = fmt.Errorf("FenceIndex does not contain fence %q", name)
 		return
 	}
 	info("Searching fence for latitude : %.5f, longitude : %.5f in %q", c.lat, c.lon, name)
-	matchs = fence.Get(c, tol)
+	matchs = fence.GetAt(c, tol, t)
+	return
+}
+
+func (idx *UnsafeFenceIndex) Near(name string, c Coordinate, tol float64) (matchs []Match, err error) {
+	return idx.NearAt(name, c, tol, time.Now())
+}
+
+func (idx *UnsafeFenceIndex) NearAt(name string, c Coordinate, tol float64, t time.Time) (matchs []Match, err error) {
+	fence, ok := idx.fences[name]
+	if !ok {
+		err = fmt.Errorf("FenceIndex does not contain fence %q", name)
+		return
+	}
+	info("Searching fence near latitude : %.5f, longitude : %.5f in %q", c.lat, c.lon, name)
+	matchs = fence.NearAt(c, tol, t)
+	return
+}
+
+func (idx *UnsafeFenceIndex) SearchBatch(name string, queries []BatchQuery) (matchs map[string][]*Feature, err error) {
+	fence, ok := idx.fences[name]
+	if !ok {
+		err = fmt.Errorf("FenceIndex does not contain fence %q", name)
+		return
+	}
+	info("Searching fence for %d points in %q", len(queries), name)
+	matchs = fence.GetBatch(queries)
+	return
+}
+
+func (idx *UnsafeFenceIndex) Tree(name string) (*Rtree, error) {
+	fence, ok := idx.fences[name]
+	if !ok {
+		return nil, fmt.Errorf("FenceIndex does not contain fence %q", name)
+	}
+	return fence.Tree(), nil
+}
+
+func (idx *UnsafeFenceIndex) Feature(name, id string) (feature *Feature, err error) {
+	fence, ok := idx.fences[name]
+	if !ok {
+		err = fmt.Errorf("FenceIndex does not contain fence %q", name)
+		return
+	}
+	if feature, ok = fence.Feature(id); !ok {
+		err = fmt.Errorf("Fence %q does not contain feature %q", name, id)
+	}
+	return
+}
+
+func (idx *UnsafeFenceIndex) History(name, id string) ([]*Feature, error) {
+	fence, ok := idx.fences[name]
+	if !ok {
+		return nil, fmt.Errorf("FenceIndex does not contain fence %q", name)
+	}
+	feature, ok := fence.latest(id)
+	if !ok {
+		return nil, fmt.Errorf("Fence %q does not contain feature %q", name, id)
+	}
+	return feature.History(), nil
+}
+
+func (idx *UnsafeFenceIndex) DeleteFeature(name, id string) error {
+	fence, ok := idx.fences[name]
+	if !ok {
+		return fmt.Errorf("FenceIndex does not contain fence %q", name)
+	}
+	if !fence.Delete(id, time.Now()) {
+		return fmt.Errorf("Fence %q does not contain feature %q", name, id)
+	}
+	return nil
+}
+
+func (idx *UnsafeFenceIndex) Stats(name string) (stats IndexStats, err error) {
+	fence, ok := idx.fences[name]
+	if !ok {
+		return stats, fmt.Errorf("FenceIndex does not contain fence %q", name)
+	}
+	return fence.stats(), nil
+}
+
+func (idx *UnsafeFenceIndex) Purge(name string, t time.Time) (expired []*Feature, err error) {
+	fence, ok := idx.fences[name]
+	if !ok {
+		return nil, fmt.Errorf("FenceIndex does not contain fence %q", name)
+	}
+	if fence, expired, err = fence.purge(t); err == nil {
+		idx.fences[name] = fence
+	}
 	return
 }
 
@@ -63,43 +240,233 @@ func (idx *UnsafeFenceIndex) Keys() (keys []string) {
 	return
 }
 
+// MutexFenceIndex locks every fence on its own, so writing to one fence never blocks
+// searches on the others. The index lock only guards the set of fences.
 type MutexFenceIndex struct {
-	fences *UnsafeFenceIndex
+	fences map[string]*mutexFence
+	sync.RWMutex
+}
+
+type mutexFence struct {
+	fence *Fence
 	sync.RWMutex
 }
 
 func NewMutexFenceIndex() *MutexFenceIndex {
-	return &MutexFenceIndex{fences: NewUnsafeFenceIndex()}
+	return &MutexFenceIndex{fences: make(map[string]*mutexFence)}
+}
+
+func (idx *MutexFenceIndex) fence(name string) (*mutexFence, error) {
+	idx.RLock()
+	defer idx.RUnlock()
+	fence, ok := idx.fences[name]
+	if !ok {
+		return nil, fmt.Errorf("FenceIndex does not contain fence %q", name)
+	}
+	return fence, nil
 }
 
+// Set swaps the fence of a name already held in place, so writes waiting on the fence it
+// replaces go to the new one
 func (idx *MutexFenceIndex) Set(name string, fence *Fence) {
+	fence = settable(fence)
 	idx.Lock()
 	defer idx.Unlock()
-	idx.fences.Set(name, fence)
+	held, ok := idx.fences[name]
+	if !ok {
+		idx.fences[name] = &mutexFence{fence: fence}
+		fence.published()
+		return
+	}
+	held.Lock()
+	defer held.Unlock()
+	held.fence = fence
+	fence.published()
+}
+
+// Update holds the fence's write lock from reading it to swapping in the new one, or the
+// index's when there is none yet
+func (idx *MutexFenceIndex) Update(name string, fn func(old *Fence) (*Fence, error)) error {
+	held, err := idx.fence(name)
+	if err != nil {
+		idx.Lock()
+		defer idx.Unlock()
+		if held = idx.fences[name]; held == nil {
+			fence, err := fn(nil)
+			if err != nil {
+				return err
+			}
+			fence = settable(fence)
+			idx.fences[name] = &mutexFence{fence: fence}
+			fence.published()
+			return nil
+		}
+	}
+	held.Lock()
+	defer held.Unlock()
+	fence, err := fn(held.fence.readable())
+	if err != nil {
+		return err
+	}
+	held.fence = settable(fence)
+	held.fence.published()
+	return nil
 }
 
 func (idx *MutexFenceIndex) Get(name string) *Fence {
-	idx.RLock()
-	defer idx.RUnlock()
-	return idx.fences.Get(name)
+	fence, err := idx.fence(name)
+	if err != nil {
+		return nil
+	}
+	fence.RLock()
+	defer fence.RUnlock()
+	return fence.fence.readable()
 }
 
-func (idx *MutexFenceIndex) Add(name string, feature *Feature) error {
+func (idx *MutexFenceIndex) Remove(name string) {
 	idx.Lock()
 	defer idx.Unlock()
-	return idx.fences.Add(name, feature)
+	delete(idx.fences, name)
+}
+
+func (idx *MutexFenceIndex) Add(name string, feature *Feature) error {
+	fence, err := idx.fence(name)
+	if err != nil {
+		return err
+	}
+	fence.Lock()
+	defer fence.Unlock()
+	fence.fence.Add(feature)
+	inserts.inc()
+	return nil
 }
 
 func (idx *MutexFenceIndex) Search(name string, c Coordinate, tol float64) ([]*Feature, error) {
-	idx.RLock()
-	defer idx.RUnlock()
-	return idx.fences.Search(name, c, tol)
+	return idx.SearchAt(name, c, tol, time.Now())
+}
+
+func (idx *MutexFenceIndex) SearchAt(name string, c Coordinate, tol float64, t time.Time) ([]*Feature, error) {
+	fence, err := idx.fence(name)
+	if err != nil {
+		return nil, err
+	}
+	fence.RLock()
+	defer fence.RUnlock()
+	info("Searching fence for latitude : %.5f, longitude : %.5f in %q", c.lat, c.lon, name)
+	return fence.fence.GetAt(c, tol, t), nil
+}
+
+func (idx *MutexFenceIndex) Near(name string, c Coordinate, tol float64) ([]Match, error) {
+	return idx.NearAt(name, c, tol, time.Now())
+}
+
+func (idx *MutexFenceIndex) NearAt(name string, c Coordinate, tol float64, t time.Time) ([]Match, error) {
+	fence, err := idx.fence(name)
+	if err != nil {
+		return nil, err
+	}
+	fence.RLock()
+	defer fence.RUnlock()
+	info("Searching fence near latitude : %.5f, longitude : %.5f in %q", c.lat, c.lon, name)
+	return fence.fence.NearAt(c, tol, t), nil
+}
+
+// SearchBatch holds a single read lock for the whole batch
+func (idx *MutexFenceIndex) SearchBatch(name string, queries []BatchQuery) (map[string][]*Feature, error) {
+	fence, err := idx.fence(name)
+	if err != nil {
+		return nil, err
+	}
+	fence.RLock()
+	defer fence.RUnlock()
+	info("Searching fence for %d points in %q", len(queries), name)
+	return fence.fence.GetBatch(queries), nil
+}
+
+func (idx *MutexFenceIndex) Tree(name string) (*Rtree, error) {
+	fence, err := idx.fence(name)
+	if err != nil {
+		return nil, err
+	}
+	fence.RLock()
+	defer fence.RUnlock()
+	return fence.fence.Tree(), nil
+}
+
+func (idx *MutexFenceIndex) Stats(name string) (IndexStats, error) {
+	fence, err := idx.fence(name)
+	if err != nil {
+		return IndexStats{}, err
+	}
+	fence.RLock()
+	defer fence.RUnlock()
+	return fence.fence.stats(), nil
+}
+
+func (idx *MutexFenceIndex) Feature(name, id string) (*Feature, error) {
+	fence, err := idx.fence(name)
+	if err != nil {
+		return nil, err
+	}
+	fence.RLock()
+	defer fence.RUnlock()
+	feature, ok := fence.fence.Feature(id)
+	if !ok {
+		return nil, fmt.Errorf("Fence %q does not contain feature %q", name, id)
+	}
+	return feature, nil
 }
 
-func (idx *MutexFenceIndex) Keys() []string {
+// Purge swaps in a rebuilt fence, blocking its searches meanwhile
+func (idx *MutexFenceIndex) Purge(name string, t time.Time) ([]*Feature, error) {
+	fence, err := idx.fence(name)
+	if err != nil {
+		return nil, err
+	}
+	fence.Lock()
+	defer fence.Unlock()
+	purged, expired, err := fence.fence.purge(t)
+	if err != nil {
+		return nil, err
+	}
+	fence.fence = purged
+	return expired, nil
+}
+
+func (idx *MutexFenceIndex) History(name, id string) ([]*Feature, error) {
+	fence, err := idx.fence(name)
+	if err != nil {
+		return nil, err
+	}
+	fence.RLock()
+	defer fence.RUnlock()
+	feature, ok := fence.fence.latest(id)
+	if !ok {
+		return nil, fmt.Errorf("Fence %q does not contain feature %q", name, id)
+	}
+	return feature.History(), nil
+}
+
+func (idx *MutexFenceIndex) DeleteFeature(name, id string) error {
+	fence, err := idx.fence(name)
+	if err != nil {
+		return err
+	}
+	fence.Lock()
+	defer fence.Unlock()
+	if !fence.fence.Delete(id, time.Now()) {
+		return fmt.Errorf("Fence %q does not contain feature %q", name, id)
+	}
+	return nil
+}
+
+func (idx *MutexFenceIndex) Keys() (keys []string) {
 	idx.RLock()
 	defer idx.RUnlock()
-	return idx.fences.Keys()
+	for k := range idx.fences {
+		keys = append(keys, k)
+	}
+	return
 }
 
 func LoadIndex(dir string) (fences FenceIndex, err error) {
@@ -111,25 +478,11 @@ func LoadIndex(dir string) (fences FenceIndex, err error) {
 	for _, path := range paths {
 		key := sluggify(path)
 		info("Indexing %q from %s\n", key, path)
-		fence, err := NewFence()
-		if err != nil {
-			fatal("Error building fence for %q. ERROR: %v", key, err)
-			continue
-		}
-		source := NewSource(path)
-		features, err := source.Publish()
+		fence, n, err := LoadFence(path)
 		if err != nil {
 			return nil, err
 		}
-		i := 0
-		for feature := range features {
-			if feature.Type == "Point" {
-				continue
-			}
-			fence.Add(feature)
-			i++
-		}
-		info("Loaded %d features for %q\n", i, key)
+		info("Loaded %d features for %q\n", n, key)
 		fences.Set(key, fence)
 	}
 	if len(fences.Keys()) < 1 {
@@ -138,3 +491,67 @@ func LoadIndex(dir string) (fences FenceIndex, err error) {
 	}
 	return
 }
+
+const (
+	ValidationSkip   = "skip"   // invalid features are logged and left out
+	ValidationStrict = "strict" // any invalid feature fails the whole source
+)
+
+// IndexOptions tune how a fence is built from its source
+type IndexOptions struct {
+	Simplify    float64 `json:"simplify"`     // Douglas-Peucker tolerance in meters, 0 keeps every vertex
+	Validation  string  `json:"validation"`   // ValidationSkip or ValidationStrict
+	IdProperty  string  `json:"id_property"`  // property used as the feature id instead of the geojson id
+	MinChildren int     `json:"min_children"` // R-tree fan-out, defaults to MinimumNodeChildren
+	MaxChildren int     `json:"max_children"` // defaults to MaximumNodeChildren
+	// serve searches from snapshots that writes replace rather than modify, which readers
+	// never wait for, see SnapshotFenceIndex
+	SnapshotReads bool `json:"snapshot_reads"`
+}
+
+// LoadFence indexes every non-point feature of a geojson file, returning how many were added
+func LoadFence(path string) (fence *Fence, n int, err error) {
+	return LoadFenceWith(NewSource(path), IndexOptions{})
+}
+
+// LoadFenceWith indexes every non-point feature of the source, returning how many were added
+func LoadFenceWith(source *Source, opts IndexOptions) (fence *Fence, n int, err error) {
+	defer loadDurations.since(time.Now())
+	min, max := opts.MinChildren, opts.MaxChildren
+	if min <= 0 {
+		min = MinimumNodeChildren
+	}
+	if max <= 0 {
+		max = MaximumNodeChildren
+	}
+	fence, err = NewFenceWith(min, max)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Error building fence for %q. ERROR: %v", source.path, err)
+	}
+	features, err := source.Publish()
+	if err != nil {
+		return nil, 0, err
+	}
+	invalid := 0
+	for feature := range features {
+		if feature == nil {
+			invalid++
+			continue
+		}
+		if feature.Type == "Point" {
+			continue
+		}
+		if id, ok := feature.Properties[opts.IdProperty]; ok && opts.IdProperty != "" {
+			feature.Properties["id"] = id
+		}
+		feature.simplify(opts.Simplify)
+		// as it is, datasets being recorded since always rather than since they were loaded
+		fence.insert(feature)
+		n++
+	}
+	if invalid > 0 && opts.Validation == ValidationStrict {
+		return nil, 0, fmt.Errorf("%d invalid features in %s", invalid, source.path)
+	}
+	loadedFeatures.add(n)
+	return
+}
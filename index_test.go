@@ -0,0 +1,129 @@
+package philifence
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// run with -race, readers and writers on two fences at once
+func testFenceIndexStress(t *testing.T, idx FenceIndex) {
+	for _, name := range []string{"busy", "quiet"} {
+		fence, _ := NewFence()
+		fence.Add(NewPolygonFeature(square(0, 0, 1)))
+		idx.Set(name, fence)
+	}
+	const writers, readers, adds = 4, 8, 300
+
+	var wg, rg sync.WaitGroup
+	wg.Add(writers)
+	rg.Add(readers)
+	done := make(chan struct{})
+	for w := 0; w < writers; w++ {
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < adds; i++ {
+				feature := NewPolygonFeature(square(float64(w), float64(i%50), 0.5))
+				feature.Properties = Properties{"id": fmt.Sprintf("%d-%d", w, i)}
+				if err := idx.Add("busy", feature); err != nil {
+					t.Error(err)
+				}
+			}
+		}(w)
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for r := 0; r < readers; r++ {
+		go func(r int) {
+			defer rg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				name := []string{"busy", "quiet"}[r%2]
+				if matchs, err := idx.Search(name, cd(0.1, 0.1), 1); err != nil || len(matchs) == 0 {
+					t.Errorf("Search %q got %v, %v", name, matchs, err)
+				}
+				idx.Near(name, cd(0.25, 0.25), 10)
+				idx.SearchBatch(name, []BatchQuery{{Id: "a", Lat: 0.5, Lon: 0.5, Tolerance: 1}})
+				idx.Feature(name, "0-0")
+				idx.Tree(name)
+				idx.Keys()
+			}
+		}(r)
+	}
+	rg.Wait()
+
+	for w := 0; w < writers; w++ {
+		id := fmt.Sprintf("%d-%d", w, adds-1)
+		if _, err := idx.Feature("busy", id); err != nil {
+			t.Errorf("Missing feature %q, %v", id, err)
+		}
+	}
+	if matchs, _ := idx.Search("busy", cd(0.25, 10.25), 1); len(matchs) != adds/50 {
+		t.Errorf("Expected %d matchs, got %d", adds/50, len(matchs))
+	}
+	if tree, _ := idx.Tree("busy"); tree.Size() != 1+writers*adds {
+		t.Errorf("Expected %d leaves, got %d", 1+writers*adds, tree.Size())
+	}
+}
+
+func TestMutexFenceIndexStress(t *testing.T) {
+	testFenceIndexStress(t, NewMutexFenceIndex())
+}
+
+func TestSnapshotFenceIndexStress(t *testing.T) {
+	testFenceIndexStress(t, NewSnapshotFenceIndex())
+}
+
+func TestFenceFork(t *testing.T) {
+	fence, err := NewFenceWith(2, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		fence.Add(NewPolygonFeature(square(float64(i%20), float64(i/20), 0.5)))
+	}
+	fork := fence.fork()
+	for i := 0; i < 200; i++ {
+		fork.Add(NewPolygonFeature(square(float64(i%20)+0.25, float64(i/20)+0.25, 0.5)))
+	}
+	if h := fork.rtree.Height(); h < 4 {
+		t.Errorf("Expected the tree to have split, got height %d", h)
+	}
+	if n := len(fence.Get(cd(5.3, 5.3), 1)); n != 1 {
+		t.Errorf("Expected the fork's adds to leave the fence alone, got %d matchs", n)
+	}
+	if n := len(fork.Get(cd(5.3, 5.3), 1)); n != 2 {
+		t.Errorf("Expected the fork to have both, got %d matchs", n)
+	}
+	if fence.Size() != 200 || fork.Size() != 400 || len(fork.Features()) != 400 {
+		t.Errorf("Unexpected sizes %d and %d", fence.Size(), fork.Size())
+	}
+
+	idx := NewMutexFenceIndex()
+	idx.Set("a", fence)
+	read := idx.Get("a")
+	idx.Add("a", NewPolygonFeature(square(50, 50, 1)))
+	if n := len(read.Get(cd(50.5, 50.5), 1)); n != 0 {
+		t.Errorf("Expected Get to return the fence as it was, got %d matchs", n)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected adding to a fence from Get to panic")
+		}
+	}()
+	read.Add(NewPolygonFeature(square(0, 0, 1)))
+}
+
+func TestNewRtreeWith(t *testing.T) {
+	for _, fanout := range [][2]int{{0, 4}, {3, 2}, {1, 1}} {
+		if _, err := NewRtreeWith(fanout[0], fanout[1]); err == nil {
+			t.Errorf("Expected fan-out %v to fail", fanout)
+		}
+	}
+}
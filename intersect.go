@@ -0,0 +1,206 @@
+package philifence
+
+import (
+	"math"
+	"sort"
+)
+
+// calls fn with every segment of the ring, stops early when fn returns false
+func (pr *PolyRing) eachSegment(fn func(a, b Coordinate) bool) bool {
+	for i := 1; i < len(pr.Coordinates); i++ {
+		if !fn(pr.Coordinates[i-1], pr.Coordinates[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (poly *Polygon) rings() []*PolyRing {
+	return append([]*PolyRing{poly.Exterior}, poly.Holes...)
+}
+
+func (poly *Polygon) eachSegment(fn func(a, b Coordinate) bool) bool {
+	for _, ring := range poly.rings() {
+		if !ring.eachSegment(fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// polygonsIntersect tests whether two geometries share any point. Linear geometries
+// (roads) only intersect through their segments, areal ones (fences) also through
+// containment of the other geometry.
+func polygonsIntersect(a, b *Polygon, aAreal, bAreal bool) bool {
+	boxA, boxB := a.computeBox(), b.computeBox()
+	if !boxA.intersects(boxB) {
+		return false
+	}
+	crossed := !a.eachSegment(func(p1, p2 Coordinate) bool {
+		if !segmentBox(p1, p2).intersects(boxB) {
+			return true
+		}
+		return b.eachSegment(func(q1, q2 Coordinate) bool {
+			return !segmentsIntersect(p1, p2, q1, q2)
+		})
+	})
+	if crossed {
+		return true
+	}
+	if bAreal && b.Contains(a.Exterior.Coordinates[0]) {
+		return true
+	}
+	return aAreal && a.Contains(b.Exterior.Coordinates[0])
+}
+
+func segmentBox(a, b Coordinate) Box {
+	return Box{
+		min: Coordinate{lat: math.Min(a.lat, b.lat), lon: math.Min(a.lon, b.lon)},
+		max: Coordinate{lat: math.Max(a.lat, b.lat), lon: math.Max(a.lon, b.lon)},
+	}
+}
+
+// http://geomalgorithms.com/a05-_intersect-1.html
+func segmentsIntersect(p1, p2, q1, q2 Coordinate) bool {
+	d1 := isLeft(q1, q2, p1)
+	d2 := isLeft(q1, q2, p2)
+	d3 := isLeft(p1, p2, q1)
+	d4 := isLeft(p1, p2, q2)
+	if ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0)) {
+		return true
+	}
+	return (d1 == 0 && onSegment(q1, q2, p1)) ||
+		(d2 == 0 && onSegment(q1, q2, p2)) ||
+		(d3 == 0 && onSegment(p1, p2, q1)) ||
+		(d4 == 0 && onSegment(p1, p2, q2))
+}
+
+// assumes c is collinear with a-b
+func onSegment(a, b, c Coordinate) bool {
+	return math.Min(a.lon, b.lon) <= c.lon && c.lon <= math.Max(a.lon, b.lon) &&
+		math.Min(a.lat, b.lat) <= c.lat && c.lat <= math.Max(a.lat, b.lat)
+}
+
+// parameter t along a-b where it crosses c-d, if it does
+func segmentCrossing(a, b, c, d Coordinate) (t float64, ok bool) {
+	rlon, rlat := b.lon-a.lon, b.lat-a.lat
+	slon, slat := d.lon-c.lon, d.lat-c.lat
+	denom := rlon*slat - rlat*slon
+	if denom == 0 {
+		return
+	}
+	qlon, qlat := c.lon-a.lon, c.lat-a.lat
+	t = (qlon*slat - qlat*slon) / denom
+	u := (qlon*rlat - qlat*rlon) / denom
+	ok = t >= 0 && t <= 1 && u >= 0 && u <= 1
+	return
+}
+
+func lerp(a, b Coordinate, t float64) Coordinate {
+	return Coordinate{lat: a.lat + (b.lat-a.lat)*t, lon: a.lon + (b.lon-a.lon)*t}
+}
+
+// lengthWithin returns the meters of the line that lie inside the polygon. Each segment
+// is split where it crosses the polygon's rings and the pieces are kept by their midpoint.
+func lengthWithin(line, poly *Polygon) (length float64) {
+	box := poly.computeBox()
+	line.Exterior.eachSegment(func(a, b Coordinate) bool {
+		if !segmentBox(a, b).intersects(box) {
+			return true
+		}
+		ts := []float64{0, 1}
+		poly.eachSegment(func(c, d Coordinate) bool {
+			if t, ok := segmentCrossing(a, b, c, d); ok {
+				ts = append(ts, t)
+			}
+			return true
+		})
+		sort.Float64s(ts)
+		for i := 1; i < len(ts); i++ {
+			if ts[i] == ts[i-1] {
+				continue
+			}
+			if poly.Contains(lerp(a, b, (ts[i-1]+ts[i])/2)) {
+				length += haversine(lerp(a, b, ts[i-1]), lerp(a, b, ts[i]))
+			}
+		}
+		return true
+	})
+	return
+}
+
+// intersectionArea returns the square meters shared by two polygons. The overlap is cut
+// into horizontal slabs at every vertex and edge crossing, within which the shared width
+// varies linearly, so the midpoint width of each slab gives its exact planar area.
+func intersectionArea(p, q *Polygon) (area float64) {
+	boxP, boxQ := p.computeBox(), q.computeBox()
+	if !boxP.intersects(boxQ) {
+		return
+	}
+	minLat := math.Max(boxP.min.lat, boxQ.min.lat)
+	maxLat := math.Min(boxP.max.lat, boxQ.max.lat)
+	lats := []float64{minLat, maxLat}
+	addLat := func(lat float64) {
+		if lat > minLat && lat < maxLat {
+			lats = append(lats, lat)
+		}
+	}
+	p.eachSegment(func(a, b Coordinate) bool {
+		addLat(a.lat)
+		if !segmentBox(a, b).intersects(boxQ) {
+			return true
+		}
+		q.eachSegment(func(c, d Coordinate) bool {
+			if t, ok := segmentCrossing(a, b, c, d); ok {
+				addLat(lerp(a, b, t).lat)
+			}
+			return true
+		})
+		return true
+	})
+	q.eachSegment(func(a, b Coordinate) bool {
+		addLat(a.lat)
+		return true
+	})
+	sort.Float64s(lats)
+
+	for i := 1; i < len(lats); i++ {
+		h := lats[i] - lats[i-1]
+		if h <= 0 {
+			continue
+		}
+		mid := (lats[i-1] + lats[i]) / 2
+		w := overlapWidth(p.scanline(mid), q.scanline(mid))
+		area += w * radians * earthRadius * math.Cos(mid*radians) * h * radians * earthRadius
+	}
+	return
+}
+
+// sorted longitudes where the polygon's rings cross the given latitude, every pair
+// of which bounds an interval inside the polygon
+func (poly *Polygon) scanline(lat float64) (lons []float64) {
+	poly.eachSegment(func(a, b Coordinate) bool {
+		if (a.lat > lat) != (b.lat > lat) {
+			lons = append(lons, a.lon+(lat-a.lat)*(b.lon-a.lon)/(b.lat-a.lat))
+		}
+		return true
+	})
+	sort.Float64s(lons)
+	return
+}
+
+func overlapWidth(a, b []float64) (w float64) {
+	for i, j := 0, 0; i+1 < len(a) && j+1 < len(b); {
+		lo := math.Max(a[i], b[j])
+		hi := math.Min(a[i+1], b[j+1])
+		if hi > lo {
+			w += hi - lo
+		}
+		if a[i+1] < b[j+1] {
+			i += 2
+		} else {
+			j += 2
+		}
+	}
+	return
+}
@@ -0,0 +1,174 @@
+package philifence
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+)
+
+// JoinPair is a pair of intersecting features from two fences
+type JoinPair struct {
+	Left, Right *Feature
+	Length      float64 // meters of the linear feature inside the areal one
+	Area        float64 // square meters shared by two areal features
+}
+
+// Join calls fn once for every pair of intersecting current features from the two trees, as
+// soon as it finds them. Both trees are descended at once from their roots: a pair of nodes is
+// only opened when their boxes overlap, and then only the children of each within the overlap
+// are paired, down to pairs of leaves which are confirmed with an exact intersection test,
+// and measured when asked to.
+//
+// Brinkhoff, Kriegel and Seeger, Efficient Processing of Spatial Joins Using R-trees,
+// SIGMOD 1993
+//
+// A pair is emitted from the first pair of their parts that intersects, so the pairs of
+// features of several parts are kept until the join is over to skip the others.
+func Join(left, right *Rtree, measure bool, fn func(JoinPair) error) error {
+	if left.root == nil || right.root == nil {
+		return nil
+	}
+	j := &joiner{measure: measure, fn: fn, multipart: make(map[[2]*Feature]bool)}
+	return j.join(left.root, right.root)
+}
+
+type joiner struct {
+	measure   bool
+	fn        func(JoinPair) error
+	multipart map[[2]*Feature]bool // pairs joined with a feature of several parts
+}
+
+// join pairs the leaves under the nodes, whose boxes intersect, until fn fails. Since each
+// pair of nodes is reached from a single pair of parents, so is each pair of leaves.
+func (j *joiner) join(l, r *rtreeNode) error {
+	switch {
+	case l.leaf != nil && r.leaf != nil:
+		return j.pair(l.leaf, r.leaf)
+	case l.leaf != nil:
+		for _, child := range r.children {
+			if child.box.intersects(l.box) {
+				if err := j.join(l, child); err != nil {
+					return err
+				}
+			}
+		}
+	case r.leaf != nil:
+		for _, child := range l.children {
+			if child.box.intersects(r.box) {
+				if err := j.join(child, r); err != nil {
+					return err
+				}
+			}
+		}
+	default:
+		var rights []*rtreeNode
+		for _, child := range r.children {
+			if child.box.intersects(l.box) {
+				rights = append(rights, child)
+			}
+		}
+		for _, lc := range l.children {
+			if !lc.box.intersects(r.box) {
+				continue
+			}
+			for _, rc := range rights {
+				if lc.box.intersects(rc.box) {
+					if err := j.join(lc, rc); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (j *joiner) pair(l, r *customRect) error {
+	lf, rf := l.Feature(), r.Feature()
+	if lf == rf || !lf.Current() || !rf.Current() {
+		return nil
+	}
+	key, multipart := [2]*Feature{lf, rf}, len(lf.Geometry) > 1 || len(rf.Geometry) > 1
+	if multipart && j.multipart[key] || !polygonsIntersect(l.polygon, r.polygon, lf.isAreal(), rf.isAreal()) {
+		return nil
+	}
+	if multipart {
+		j.multipart[key] = true
+	}
+	pair := JoinPair{Left: lf, Right: rf}
+	if j.measure {
+		pair.measure()
+	}
+	return j.fn(pair)
+}
+
+// sums the length or area shared by every pair of intersecting parts of the two features
+func (pair *JoinPair) measure() {
+	lAreal, rAreal := pair.Left.isAreal(), pair.Right.isAreal()
+	for _, l := range pair.Left.Geometry {
+		for _, r := range pair.Right.Geometry {
+			if !polygonsIntersect(l, r, lAreal, rAreal) {
+				continue
+			}
+			switch {
+			case lAreal && rAreal:
+				pair.Area += intersectionArea(l, r)
+			case rAreal:
+				pair.Length += lengthWithin(l, r)
+			case lAreal:
+				pair.Length += lengthWithin(r, l)
+			}
+		}
+	}
+}
+
+// WriteJoinCSV writes a left_id,right_id[,length,area] row for every joined pair
+func WriteJoinCSV(w io.Writer, left, right *Rtree, measure bool) error {
+	out := csv.NewWriter(w)
+	header := []string{"left_id", "right_id"}
+	if measure {
+		header = append(header, "length", "area")
+	}
+	out.Write(header)
+	err := Join(left, right, measure, func(pair JoinPair) error {
+		row := []string{pair.Left.Id(), pair.Right.Id()}
+		if measure {
+			row = append(row, strconv.FormatFloat(pair.Length, 'f', 3, 64), strconv.FormatFloat(pair.Area, 'f', 3, 64))
+		}
+		return out.Write(row)
+	})
+	out.Flush()
+	if err != nil {
+		return err
+	}
+	return out.Error()
+}
+
+// WriteJoinGeoJson writes a feature collection with a geometry-less feature for every
+// joined pair, holding the properties of both sides
+func WriteJoinGeoJson(w io.Writer, left, right *Rtree, measure bool) (err error) {
+	if _, err = io.WriteString(w, `{"type":"FeatureCollection","features":[`); err != nil {
+		return
+	}
+	sep := ""
+	err = Join(left, right, measure, func(pair JoinPair) error {
+		props := Properties{"left": pair.Left.Properties, "right": pair.Right.Properties}
+		if measure {
+			props["length"] = pair.Length
+			props["area"] = pair.Area
+		}
+		buf, err := json.Marshal(map[string]interface{}{"type": "Feature", "geometry": nil, "properties": props})
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, sep+string(buf)+"\n")
+		sep = ","
+		return err
+	})
+	if err != nil {
+		return
+	}
+	_, err = io.WriteString(w, "]}\n")
+	return
+}
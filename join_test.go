@@ -0,0 +1,117 @@
+package philifence
+
+import (
+	"math"
+	"testing"
+)
+
+func square(lat, lon, size float64) *Polygon {
+	return NewPoly(cd(lat, lon), cd(lat, lon+size), cd(lat+size, lon+size), cd(lat+size, lon), cd(lat, lon))
+}
+
+func TestJoin(t *testing.T) {
+	cities, _ := NewFence()
+	roads, _ := NewFence()
+	a := NewPolygonFeature(square(0, 0, 2))
+	b := NewPolygonFeature(square(1, 1, 2))
+	far := NewPolygonFeature(square(40, 40, 1))
+	road := NewLineFeature(NewPoly(cd(1.5, -1), cd(1.5, 3)))
+	for _, f := range []*Feature{a, b, far} {
+		cities.Add(f)
+	}
+	roads.Add(road)
+
+	pairs := make(map[[2]*Feature]JoinPair)
+	Join(cities.Tree(), cities.Tree(), true, func(pair JoinPair) error {
+		pairs[[2]*Feature{pair.Left, pair.Right}] = pair
+		return nil
+	})
+	if len(pairs) != 2 {
+		t.Fatalf("Expected a-b and b-a, got %d pairs", len(pairs))
+	}
+	want := math.Pow(radians*earthRadius, 2) * math.Cos(1.5*radians)
+	if got := pairs[[2]*Feature{a, b}].Area; math.Abs(got-want)/want > 1e-6 {
+		t.Errorf("Wrong intersection area %f, expected %f", got, want)
+	}
+
+	var lengths []float64
+	Join(roads.Tree(), cities.Tree(), true, func(pair JoinPair) error {
+		lengths = append(lengths, pair.Length)
+		return nil
+	})
+	if len(lengths) != 2 {
+		t.Fatalf("Expected road to cross 2 cities, got %d", len(lengths))
+	}
+	want = haversine(cd(1.5, 0), cd(1.5, 2))
+	for _, got := range lengths {
+		if math.Abs(got-want)/want > 1e-6 {
+			t.Errorf("Wrong intersection length %f, expected %f", got, want)
+		}
+	}
+
+	// a feature of two parts both crossing a is joined to it once, measured over both
+	twice := NewLineFeature(NewPoly(cd(0.5, -1), cd(0.5, 3)), NewPoly(cd(1.2, -1), cd(1.2, 3)))
+	roads.Add(twice)
+	joined := make(map[*Feature]int)
+	Join(roads.Tree(), cities.Tree(), true, func(pair JoinPair) error {
+		if pair.Left == twice {
+			joined[pair.Right]++
+			if pair.Right == a {
+				want = haversine(cd(0.5, 0), cd(0.5, 2)) + haversine(cd(1.2, 0), cd(1.2, 2))
+				if math.Abs(pair.Length-want)/want > 1e-6 {
+					t.Errorf("Wrong length over both parts %f, expected %f", pair.Length, want)
+				}
+			}
+		}
+		return nil
+	})
+	if len(joined) != 2 || joined[a] != 1 || joined[b] != 1 {
+		t.Errorf("Expected the two part road joined to a and b once each, got %v", joined)
+	}
+	stop := errorf("stop")
+	n := 0
+	err := Join(roads.Tree(), cities.Tree(), false, func(pair JoinPair) error {
+		n++
+		return stop
+	})
+	if err != stop || n != 1 {
+		t.Errorf("Expected the join to stop at the first error, got %v after %d", err, n)
+	}
+}
+
+func TestJoinTrees(t *testing.T) {
+	left, _ := NewFenceWith(2, 4)
+	right, _ := NewFenceWith(2, 3)
+	for i := 0; i < 60; i++ {
+		left.Add(NewPolygonFeature(square(float64(i%10), float64(i/10), 0.6)))
+	}
+	for i := 0; i < 45; i++ {
+		f := NewPolygonFeature(square(float64(i%9)+0.5, float64(i/9)+0.5, 0.3))
+		f.Properties = Properties{"id": sprintf("%d", i)}
+		right.Add(f)
+	}
+	// a replaced version isn't joined
+	replaced := NewPolygonFeature(square(0.5, 0.5, 0.3))
+	replaced.Properties = Properties{"id": "0"}
+	right.Add(replaced)
+
+	want := 0
+	for _, l := range left.Features() {
+		for _, r := range right.Features() {
+			if r.Current() && polygonsIntersect(l.Geometry[0], r.Geometry[0], true, true) {
+				want++
+			}
+		}
+	}
+	seen := make(map[[2]*Feature]bool)
+	Join(left.Tree(), right.Tree(), false, func(pair JoinPair) error {
+		if seen[[2]*Feature{pair.Left, pair.Right}] || !pair.Right.Current() {
+			t.Errorf("Unexpected pair %v", pair)
+		}
+		seen[[2]*Feature{pair.Left, pair.Right}] = true
+		return nil
+	})
+	if len(seen) != want || want == 0 {
+		t.Errorf("Expected %d pairs, got %d", want, len(seen))
+	}
+}
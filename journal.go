@@ -0,0 +1,340 @@
+package philifence
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// how often Server.KeepHistory flushes the journals
+var JournalFlushInterval = time.Second
+
+const journalExt = ".wal"
+
+// Journal is a FenceIndex whose adds and deletes are logged ahead to a journal per index in
+// dir, {index}.wal, so those made since the index's last history snapshot outlive a crash.
+// Writes are buffered until Flush, every flush interval and on shutdown. Saving a history
+// snapshot of an index cuts its journal, which Replay plays back onto the index as restored
+// from its snapshot.
+//
+// Until Replay, Set and Update only replace the index, so it can be loaded. From then on
+// they snapshot the index too, as the journal can't tell the new fence from the old one.
+type Journal struct {
+	FenceIndex
+	dir      string
+	journals map[string]*journal
+	replayed int32 // atomic
+	mu       sync.Mutex
+}
+
+type journal struct {
+	file *os.File
+	buf  *bufio.Writer
+	sync.Mutex
+}
+
+type journalRecord struct {
+	Op      string         `json:"op"` // "add" or "delete"
+	At      int64          `json:"at"` // unix nanos
+	Id      string         `json:"id,omitempty"`
+	Feature *historyRecord `json:"feature,omitempty"`
+}
+
+func NewJournal(idx FenceIndex, dir string) *Journal {
+	return &Journal{FenceIndex: idx, dir: dir, journals: make(map[string]*journal)}
+}
+
+func (j *Journal) path(name string) string {
+	return filepath.Join(j.dir, url.PathEscape(name)+journalExt)
+}
+
+// journal is the open journal of the index, held while logging to it
+func (j *Journal) journal(name string) (*journal, error) {
+	j.mu.Lock()
+	l, ok := j.journals[name]
+	if !ok {
+		if err := os.MkdirAll(j.dir, 0755); err != nil {
+			j.mu.Unlock()
+			return nil, err
+		}
+		file, err := os.OpenFile(j.path(name), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+		if err != nil {
+			j.mu.Unlock()
+			return nil, err
+		}
+		l = &journal{file: file, buf: bufio.NewWriter(file)}
+		j.journals[name] = l
+	}
+	j.mu.Unlock()
+	l.Lock()
+	return l, nil
+}
+
+func (l *journal) log(rec journalRecord) error {
+	buf, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = l.buf.Write(append(buf, '\n'))
+	return err
+}
+
+func (l *journal) flush() error {
+	if err := l.buf.Flush(); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+// cut empties the journal, its writes being in a snapshot
+func (l *journal) cut() error {
+	l.buf.Reset(l.file)
+	return l.file.Truncate(0)
+}
+
+func (j *Journal) Add(name string, feature *Feature) error {
+	l, err := j.journal(name)
+	if err != nil {
+		return err
+	}
+	defer l.Unlock()
+	if err = j.FenceIndex.Add(name, feature); err != nil {
+		return err
+	}
+	rec := &historyRecord{Type: feature.Type, Parts: historyParts(feature.Geometry), Properties: feature.Properties}
+	return l.log(journalRecord{Op: "add", At: feature.since.UnixNano(), Feature: rec})
+}
+
+func (j *Journal) DeleteFeature(name, id string) error {
+	l, err := j.journal(name)
+	if err != nil {
+		return err
+	}
+	defer l.Unlock()
+	if err = j.FenceIndex.DeleteFeature(name, id); err != nil {
+		return err
+	}
+	at := time.Now()
+	if history, err := j.FenceIndex.History(name, id); err == nil {
+		at = history[0].retired()
+	}
+	return l.log(journalRecord{Op: "delete", At: at.UnixNano(), Id: id})
+}
+
+func (j *Journal) Set(name string, fence *Fence) {
+	if atomic.LoadInt32(&j.replayed) == 0 {
+		j.FenceIndex.Set(name, fence)
+		return
+	}
+	l, err := j.journal(name)
+	if err != nil {
+		warn(err, "opening journal of "+name)
+		j.FenceIndex.Set(name, fence)
+		return
+	}
+	defer l.Unlock()
+	j.FenceIndex.Set(name, fence)
+	warn(j.save(name, l), "saving history of "+name)
+}
+
+func (j *Journal) Update(name string, fn func(old *Fence) (*Fence, error)) error {
+	if atomic.LoadInt32(&j.replayed) == 0 {
+		return j.FenceIndex.Update(name, fn)
+	}
+	l, err := j.journal(name)
+	if err != nil {
+		return err
+	}
+	defer l.Unlock()
+	if err = j.FenceIndex.Update(name, fn); err != nil {
+		return err
+	}
+	return j.save(name, l)
+}
+
+// Remove drops the index's journal and history along with it
+func (j *Journal) Remove(name string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.FenceIndex.Remove(name)
+	if l, ok := j.journals[name]; ok {
+		l.Lock()
+		l.file.Close()
+		l.Unlock()
+		delete(j.journals, name)
+	}
+	for _, path := range []string{j.path(name), historyFile(j.dir, name)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			warn(err, "removing "+path)
+		}
+	}
+}
+
+// save saves a history snapshot of the index and cuts its journal, which the caller holds
+func (j *Journal) save(name string, l *journal) error {
+	fence := j.FenceIndex.Get(name)
+	if fence == nil {
+		return nil
+	}
+	if err := SaveHistory(historyFile(j.dir, name), fence); err != nil {
+		return err
+	}
+	return l.cut()
+}
+
+// SaveHistory saves a history snapshot of the index, cutting its journal
+func (j *Journal) SaveHistory(name string) error {
+	l, err := j.journal(name)
+	if err != nil {
+		return err
+	}
+	defer l.Unlock()
+	return j.save(name, l)
+}
+
+// Flush writes what was logged to the journals through to disk
+func (j *Journal) Flush() (err error) {
+	j.mu.Lock()
+	journals := make(map[string]*journal, len(j.journals))
+	for name, l := range j.journals {
+		journals[name] = l
+	}
+	j.mu.Unlock()
+	for name, l := range journals {
+		l.Lock()
+		if ferr := l.flush(); ferr != nil {
+			warn(ferr, "flushing journal of "+name)
+			err = ferr
+		}
+		l.Unlock()
+	}
+	return
+}
+
+// Replay plays every journal in dir back onto its index, as loaded or restored from its
+// last history snapshot, adding indices that are only in a journal. Writes that the index
+// already has, as logged while it was loaded, are skipped.
+func (j *Journal) Replay() error {
+	paths, _ := filepath.Glob(filepath.Join(j.dir, "*"+journalExt))
+	for _, path := range paths {
+		name, err := url.PathUnescape(strings.TrimSuffix(filepath.Base(path), journalExt))
+		if err != nil {
+			continue
+		}
+		if err = j.replay(name); err != nil {
+			return err
+		}
+	}
+	atomic.StoreInt32(&j.replayed, 1)
+	return nil
+}
+
+func (j *Journal) replay(name string) error {
+	l, err := j.journal(name)
+	if err != nil {
+		return err
+	}
+	defer l.Unlock()
+	if err = l.buf.Flush(); err != nil {
+		return err
+	}
+	if _, err = l.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	var records []journalRecord
+	dec := json.NewDecoder(bufio.NewReader(l.file))
+	for {
+		var rec journalRecord
+		good := dec.InputOffset()
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			// the tail of a write cut short by a crash, dropped so later writes follow the
+			// last whole one
+			warn(err, "reading journal of "+name)
+			if err = l.file.Truncate(good); err != nil {
+				return err
+			}
+			break
+		}
+		records = append(records, rec)
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	n := 0
+	err = j.FenceIndex.Update(name, func(old *Fence) (fence *Fence, err error) {
+		if old != nil {
+			fence = old.fork()
+		} else if fence, err = NewFence(); err != nil {
+			return nil, err
+		}
+		for _, rec := range records {
+			if replayRecord(fence, rec) {
+				n++
+			}
+		}
+		return fence, nil
+	})
+	if n > 0 {
+		info("Replayed %d writes to %q\n", n, name)
+	}
+	return err
+}
+
+// replayRecord applies the write to the fence unless it already has it
+func replayRecord(fence *Fence, rec journalRecord) bool {
+	at := time.Unix(0, rec.At).UTC()
+	switch {
+	case rec.Op == "add" && rec.Feature != nil:
+		f := &Feature{Type: rec.Feature.Type, Geometry: historyGeometry(rec.Feature.Parts), Properties: rec.Feature.Properties}
+		if latest, ok := fence.latest(f.Id()); ok && latest.since.Equal(at) && sameFeature(latest, f) {
+			return false
+		}
+		fence.addAt(f, at)
+		return true
+	case rec.Op == "delete":
+		if _, ok := fence.Feature(rec.Id); !ok {
+			return false
+		}
+		return fence.Delete(rec.Id, at)
+	}
+	return false
+}
+
+// ReplayJournals replays the journals of every layer, once the indices are loaded and their
+// history restored
+func (s *Server) ReplayJournals() (err error) {
+	for _, layer := range s.everyLayer() {
+		j, ok := layer.Index.(*Journal)
+		if !ok {
+			continue
+		}
+		if err = j.Replay(); err != nil {
+			return err
+		}
+		for _, name := range j.Keys() {
+			layer.recount(name)
+		}
+	}
+	return nil
+}
+
+// FlushJournals writes what was logged to the journals of every layer through to disk
+func (s *Server) FlushJournals() (err error) {
+	for _, layer := range s.everyLayer() {
+		if j, ok := layer.Index.(*Journal); ok {
+			if ferr := j.Flush(); ferr != nil {
+				err = ferr
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,104 @@
+package philifence
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	city := func(id, name string) *Feature {
+		f := NewPolygonFeature(square(0, 0, 1))
+		f.Properties = map[string]interface{}{"id": id, "name": name}
+		return f
+	}
+	dir := t.TempDir()
+	j := NewJournal(NewMutexFenceIndex(), dir)
+	fence, _ := NewFence()
+	j.Set("cities", fence)
+	if err := j.Replay(); err != nil {
+		t.Fatal(err)
+	}
+	j.Add("cities", city("manila", "Manila"))
+	j.Add("cities", city("pasig", "Pasig"))
+	j.DeleteFeature("cities", "pasig")
+	if err := j.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	deleted, _ := j.History("cities", "pasig")
+
+	// as after a crash, with nothing saved but the journal
+	j = NewJournal(NewMutexFenceIndex(), dir)
+	if err := j.Replay(); err != nil {
+		t.Fatal(err)
+	}
+	if matchs, _ := j.Search("cities", cd(0.5, 0.5), 1); len(matchs) != 1 || matchs[0].Properties["name"] != "Manila" {
+		t.Errorf("expected manila replayed, got %v", matchs)
+	}
+	if history, _ := j.History("cities", "pasig"); len(history) != 1 || !history[0].Version().Until.Equal(deleted[0].Version().Until) {
+		t.Errorf("expected pasig deleted when it was, got %v", history)
+	}
+
+	wal := filepath.Join(dir, "cities"+journalExt)
+	if err := j.SaveHistory("cities"); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(wal); err != nil || fi.Size() != 0 {
+		t.Errorf("expected saving the history to empty the journal, got %v %v", fi, err)
+	}
+	j.Add("cities", city("manila", "City of Manila"))
+	j.Flush()
+	file, _ := os.OpenFile(wal, os.O_APPEND|os.O_WRONLY, 0644)
+	file.WriteString(`{"op":"add","at":`)
+	file.Close()
+
+	restored, err := LoadHistory(historyFile(dir, "cities"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := NewMutexFenceIndex()
+	idx.Set("cities", restored)
+	j = NewJournal(idx, dir)
+	if err := j.Replay(); err != nil {
+		t.Fatal(err)
+	}
+	if history, _ := j.History("cities", "manila"); len(history) != 2 || history[0].Properties["name"] != "City of Manila" {
+		t.Errorf("expected the add since the history was saved replayed onto it, got %v", history)
+	}
+	j.Add("cities", city("pasig", "Pasig"))
+	j.Flush()
+	j = NewJournal(NewMutexFenceIndex(), dir)
+	restored, _ = LoadHistory(historyFile(dir, "cities"))
+	j.Set("cities", restored)
+	if err := j.Replay(); err != nil {
+		t.Fatal(err)
+	}
+	if matchs, _ := j.Search("cities", cd(0.5, 0.5), 1); len(matchs) != 2 {
+		t.Errorf("expected the writes after a cut short one replayed, got %v", matchs)
+	}
+}
+
+func TestTenantJournal(t *testing.T) {
+	dir := t.TempDir()
+	s := NewServer()
+	if err := s.AddLayer("fence", KindFence, NewJournal(NewMutexFenceIndex(), filepath.Join(dir, "fence"))); err != nil {
+		t.Fatal(err)
+	}
+	tenant, _ := s.AddTenant("acme", TenantLimits{})
+	layer, _ := tenant.Layer("fence")
+	fence, _ := NewFence()
+	layer.Index.Set("cities", fence)
+	if err := s.ReplayJournals(); err != nil {
+		t.Fatal(err)
+	}
+	f := NewPolygonFeature(square(0, 0, 1))
+	f.Properties = map[string]interface{}{"id": "manila"}
+	layer.Index.Add("cities", f)
+	if err := s.SaveHistory(dir); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := LoadHistory(filepath.Join(dir, "t", "acme", "fence", "cities"+historyExt))
+	if err != nil || restored.Len() != 1 {
+		t.Errorf("expected the tenant's index saved under its own directory, got %v", err)
+	}
+}
@@ -0,0 +1,259 @@
+package philifence
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// how far past its expiry, or before it's valid, a token is still taken
+var JWTLeeway = time.Minute
+
+// how long after it was issued a token without an expiry is taken, those with neither being
+// refused
+var JWTMaxAge = time.Hour
+
+// JWTVerifier authenticates bearer JSON Web Tokens signed with RS256, RS384, RS512, ES256,
+// ES384 or ES512 by a key of a JWKS file, https://tools.ietf.org/html/rfc7517. The token's
+// subject is the principal, granted the access of its scope claim, space separated
+// {access}:{index pattern}, e.g. "read:fence/* write:fence/cities".
+type JWTVerifier struct {
+	keys     map[string]crypto.PublicKey // by kid
+	Issuer   string                      // checked when set
+	Audience string                      // checked when set
+}
+
+// LoadJWKS reads the keys of a JWKS file, skipping those of unsupported types
+func LoadJWKS(path string) (*JWTVerifier, error) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(file, &jwks); err != nil {
+		return nil, errorf("Invalid JWKS %s: %v", path, err)
+	}
+	v := &JWTVerifier{keys: make(map[string]crypto.PublicKey)}
+	for _, k := range jwks.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			warn(err, "reading key "+k.Kid+" of "+path)
+			continue
+		}
+		v.keys[k.Kid] = key
+	}
+	if len(v.keys) == 0 {
+		return nil, errorf("No usable keys in JWKS %s", path)
+	}
+	return v, nil
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	if k.Use != "" && k.Use != "sig" {
+		return nil, errorf("Key not for signatures")
+	}
+	switch k.Kty {
+	case "RSA":
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil || len(e) > 4 {
+			return nil, errorf("Invalid RSA key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errorf("Unsupported curve %q", k.Crv)
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(k.X)
+		y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+		if errX != nil || errY != nil {
+			return nil, errorf("Invalid EC key")
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errorf("EC key not on its curve")
+		}
+		return key, nil
+	}
+	return nil, errorf("Unsupported key type %q", k.Kty)
+}
+
+type jwtClaims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"` // a string or an array of them
+	Expires   *float64        `json:"exp"`
+	IssuedAt  *float64        `json:"iat"`
+	NotBefore *float64        `json:"nbf"`
+	Scope     string          `json:"scope"`
+	Tenant    string          `json:"tenant"` // the default tenant when left out
+}
+
+func (v *JWTVerifier) Authenticate(r *http.Request) (*Principal, error) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil, nil
+	}
+	claims, err := v.verify(strings.TrimPrefix(auth, "Bearer "))
+	if err != nil {
+		return nil, err
+	}
+	return claims.principal(), nil
+}
+
+// verify checks the token's signature, times, issuer and audience
+func (v *JWTVerifier) verify(token string) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errorf("Malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	key, ok := v.keys[header.Kid]
+	if !ok {
+		return nil, errorf("Unknown token key %q", header.Kid)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errorf("Malformed token signature")
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	now := float64(time.Now().Unix())
+	leeway := JWTLeeway.Seconds()
+	expires := claims.Expires
+	if expires == nil && claims.IssuedAt != nil {
+		maxAge := *claims.IssuedAt + JWTMaxAge.Seconds()
+		expires = &maxAge
+	}
+	switch {
+	case expires == nil:
+		return nil, errorf("Token without an expiry")
+	case now > *expires+leeway:
+		return nil, errorf("Token expired")
+	case claims.NotBefore != nil && now < *claims.NotBefore-leeway:
+		return nil, errorf("Token not valid yet")
+	case v.Issuer != "" && claims.Issuer != v.Issuer:
+		return nil, errorf("Token issuer %q not trusted", claims.Issuer)
+	case v.Audience != "" && !claims.hasAudience(v.Audience):
+		return nil, errorf("Token not meant for %q", v.Audience)
+	}
+	return &claims, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return errorf("Malformed token")
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return errorf("Malformed token: %v", err)
+	}
+	return nil
+}
+
+// the algorithm has to match the key's type, so an RSA key can't be used as an HMAC secret
+func verifySignature(alg string, key crypto.PublicKey, signed string, sig []byte) error {
+	if len(alg) != 5 {
+		return errorf("Unsupported token algorithm %q", alg)
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	}
+	if hash == 0 {
+		return errorf("Unsupported token algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if alg[:2] != "RS" || rsa.VerifyPKCS1v15(key, hash, digest, sig) != nil {
+			return errorf("Invalid token signature")
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if alg[:2] != "ES" || len(sig) != 2*size {
+			return errorf("Invalid token signature")
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return errorf("Invalid token signature")
+		}
+	default:
+		return errorf("Unsupported token key")
+	}
+	return nil
+}
+
+func (c *jwtClaims) hasAudience(audience string) bool {
+	var one string
+	if json.Unmarshal(c.Audience, &one) == nil {
+		return one == audience
+	}
+	var many []string
+	json.Unmarshal(c.Audience, &many)
+	for _, a := range many {
+		if a == audience {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *jwtClaims) principal() *Principal {
+	p := &Principal{Name: c.Subject, Tenant: c.Tenant}
+	for _, scope := range strings.Fields(c.Scope) {
+		if kv := strings.SplitN(scope, ":", 2); len(kv) == 2 {
+			p.Grants = append(p.Grants, Grant{Index: kv[1], Access: []string{kv[0]}})
+		}
+	}
+	return p
+}
@@ -0,0 +1,141 @@
+package philifence
+
+import (
+	"math"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	KindFence = "fence"
+	KindRoad  = "road"
+)
+
+// Kind decides how the features of a layer match a searched point
+type Kind struct {
+	Name string
+	// Params are the query params Search reads, they are not echoed back in the response
+	Params []string
+	// Search matches a point against the named index of a layer
+	Search func(idx FenceIndex, name string, c Coordinate, tol float64, params url.Values) ([]Properties, error)
+}
+
+var kinds = map[string]*Kind{
+	KindFence: {Name: KindFence, Params: []string{"mode", "distance", "metrics", "at", "as_of"}, Search: searchFence},
+	KindRoad:  {Name: KindRoad, Params: []string{"metrics", "at", "as_of"}, Search: searchRoad},
+}
+
+var kindsMu sync.RWMutex // guards kinds
+
+// RegisterKind makes a kind available to layers and config groups, it is meant to be
+// called at init
+func RegisterKind(kind *Kind) {
+	kindsMu.Lock()
+	defer kindsMu.Unlock()
+	kinds[kind.Name] = kind
+}
+
+func LookupKind(name string) (kind *Kind, ok bool) {
+	kindsMu.RLock()
+	defer kindsMu.RUnlock()
+	kind, ok = kinds[name]
+	return
+}
+
+// the time features have to be valid and recorded at, now unless given as at= or as_of=
+func searchTime(params url.Values) (time.Time, error) {
+	at, param := params.Get("at"), "at"
+	if asOf := params.Get("as_of"); asOf != "" {
+		if at != "" {
+			return time.Time{}, errorf("Query params 'at' and 'as_of' are the same, only one can be given")
+		}
+		at, param = asOf, "as_of"
+	}
+	if at == "" {
+		return time.Now(), nil
+	}
+	t, err := ParseTime(at)
+	if err != nil {
+		return t, errorf("Query param '%s' must be a RFC 3339 time or a date", param)
+	}
+	return t, nil
+}
+
+// features containing the point, or with mode=near also those within tolerance meters of it.
+// Distances to the boundary cost a scan of every segment, so plain searches only get them
+// with distance=true.
+func searchFence(idx FenceIndex, name string, c Coordinate, tol float64, params url.Values) (fences []Properties, err error) {
+	mode := params.Get("mode")
+	if mode != "" && mode != StatusInside && mode != StatusNear {
+		return nil, errorf("Query param 'mode' must be inside or near")
+	}
+	at, err := searchTime(params)
+	if err != nil {
+		return
+	}
+	metrics, _ := strconv.ParseBool(params.Get("metrics"))
+	distance, _ := strconv.ParseBool(params.Get("distance"))
+	var matchs []Match
+	if mode == StatusNear {
+		matchs, err = idx.NearAt(name, c, tol, at)
+		distance = true
+	} else {
+		var features []*Feature
+		features, err = idx.SearchAt(name, c, tol, at)
+		for _, fence := range features {
+			match := Match{Feature: fence, Status: StatusInside}
+			if distance {
+				match.Distance = fence.Distance(c)
+			}
+			matchs = append(matchs, match)
+		}
+	}
+	if err != nil {
+		return
+	}
+	fences = make([]Properties, len(matchs))
+	for i, match := range matchs {
+		extra := Properties{}
+		if distance {
+			extra["distance"], extra["status"] = match.Distance, match.Status
+		}
+		if metrics {
+			extra["metrics"] = match.Feature.Metrics()
+		}
+		if len(extra) == 0 {
+			fences[i] = match.Feature.Properties
+		} else {
+			fences[i] = resultProperties(match.Feature, extra)
+		}
+	}
+	return
+}
+
+// features within tolerance meters of the point, measured to their nearest segment as a
+// line has no inside to contain it
+func searchRoad(idx FenceIndex, name string, c Coordinate, tol float64, params url.Values) (roads []Properties, err error) {
+	at, err := searchTime(params)
+	if err != nil {
+		return
+	}
+	metrics, _ := strconv.ParseBool(params.Get("metrics"))
+	matchs, err := idx.NearAt(name, c, tol, at)
+	if err != nil {
+		return
+	}
+	roads = []Properties{}
+	for _, match := range matchs {
+		road := match.Feature
+		if math.Abs(match.Distance) > tol {
+			continue
+		}
+		if metrics {
+			roads = append(roads, resultProperties(road, Properties{"metrics": road.Metrics()}))
+		} else {
+			roads = append(roads, road.Properties)
+		}
+	}
+	return
+}
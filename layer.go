@@ -0,0 +1,89 @@
+package philifence
+
+import (
+	"context"
+	"net/url"
+)
+
+// size of the slices of a batch that Layer.EachBatch searches at once
+var LayerBatchChunk = 1024
+
+// Layer is a named set of indices, of a kind that decides how searches match its features.
+// A Server serves each of its layers under /{name}, Go programs can also query them in process.
+type Layer struct {
+	Name   string
+	Kind   *Kind
+	Index  FenceIndex
+	tenant *Tenant // nil for the default tenant's
+}
+
+// NewLayer queries the index by a registered kind, e.g. KindFence
+func NewLayer(name, kind string, idx FenceIndex) (*Layer, error) {
+	k, ok := LookupKind(kind)
+	if !ok {
+		return nil, errorf("Unknown kind %q", kind)
+	}
+	if name == "" {
+		return nil, errorf("Layer needs a name")
+	}
+	return &Layer{Name: name, Kind: k, Index: idx}, nil
+}
+
+// Search matches c against the named index by the layer's kind, as the search route does.
+// params are the kind's options, e.g. mode=near for fences.
+func (l *Layer) Search(ctx context.Context, name string, c Coordinate, tol float64, params url.Values) ([]Properties, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return l.Kind.Search(l.Index, name, c, tol, params)
+}
+
+// Each calls fn with every feature of the named index within tol meters of c
+func (l *Layer) Each(ctx context.Context, name string, c Coordinate, tol float64, fn func(*Feature) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	features, err := l.Index.Search(name, c, tol)
+	if err != nil {
+		return err
+	}
+	return eachFeature(ctx, features, fn)
+}
+
+// EachNear calls fn with every feature of the named index containing c, or with a boundary
+// within tol meters of it
+func (l *Layer) EachNear(ctx context.Context, name string, c Coordinate, tol float64, fn func(Match) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	matchs, err := l.Index.Near(name, c, tol)
+	if err != nil {
+		return err
+	}
+	return eachMatch(ctx, matchs, fn)
+}
+
+// EachBatch answers the queries against the named index a chunk at a time, calling fn with
+// the matches of each query, so large batches are streamed and can be cancelled midway
+func (l *Layer) EachBatch(ctx context.Context, name string, queries []BatchQuery, fn func(id string, matchs []*Feature) error) error {
+	for len(queries) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n := LayerBatchChunk
+		if n < 1 || n > len(queries) {
+			n = len(queries)
+		}
+		matchs, err := l.Index.SearchBatch(name, queries[:n])
+		if err != nil {
+			return err
+		}
+		for _, q := range queries[:n] {
+			if err := fn(string(q.Id), matchs[string(q.Id)]); err != nil {
+				return err
+			}
+		}
+		queries = queries[n:]
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package philifence
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// classes of routes, each limited at its own rate
+const (
+	LimitSearch   = "search"   // searches, lists, tiles and event streams
+	LimitMutation = "mutation" // adding and deleting indices, posting locations
+	LimitBatch    = "batch"    // batch searches and joins
+)
+
+// how long a client's bucket is kept once it has filled back up
+var LimitIdle = 10 * time.Minute
+
+// how often the day's usage is saved to the quota file when the config leaves it out
+var QuotaSaveInterval = time.Minute
+
+// Rate refills a client's bucket at per_second up to burst requests
+type Rate struct {
+	PerSecond float64 `json:"per_second"`
+	Burst     int     `json:"burst"`
+}
+
+// LimitConfig limits every client, by its API key or other credentials, or its ip without any
+type LimitConfig struct {
+	Rates        map[string]Rate `json:"rates"`         // by class, a class without one is unlimited
+	DailyQuota   int             `json:"daily_quota"`   // requests a client may make per UTC day, 0 for no quota
+	QuotaFile    string          `json:"quota_file"`    // where the day's usage is kept across restarts
+	SaveInterval Duration        `json:"save_interval"` // how often usage is saved to the quota file, QuotaSaveInterval when left out
+	// proxies in front of the server, the client ip being the entry of X-Forwarded-For the
+	// outermost one added, as those left of it may be forged, 0 for the connection's ip
+	TrustedProxies int `json:"trusted_proxies"`
+}
+
+// Limiter holds a token bucket per class and client, and each client's usage of the day
+type Limiter struct {
+	config  LimitConfig
+	buckets map[string]*bucket // by class and client
+	day     string
+	used    map[string]int // by client
+	swept   time.Time
+	now     func() time.Time
+	mu      sync.Mutex
+	saving  sync.Mutex // one save writes the quota file at a time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewLimiter(config LimitConfig) *Limiter {
+	return &Limiter{config: config, buckets: make(map[string]*bucket), used: make(map[string]int), now: time.Now}
+}
+
+// SetLimiter limits the requests of every route of a class, which a server without doesn't
+func (s *Server) SetLimiter(l *Limiter) {
+	s.limiter = l
+}
+
+// allow takes a token from the client's bucket for the class and counts the request against
+// its quota, or tells how long until it may retry
+func (l *Limiter) allow(class, client string) (code string, retry time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if day := now.UTC().Format("2006-01-02"); day != l.day {
+		l.day, l.used = day, make(map[string]int)
+	}
+	if l.config.DailyQuota > 0 && l.used[client] >= l.config.DailyQuota {
+		midnight := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+		return ErrorQuotaExceeded, midnight.Sub(now)
+	}
+	if rate, ok := l.config.Rates[class]; ok && rate.PerSecond > 0 {
+		l.sweep(now)
+		key := class + " " + client
+		b, ok := l.buckets[key]
+		if !ok {
+			b = &bucket{tokens: float64(rate.Burst), last: now}
+			l.buckets[key] = b
+		}
+		b.tokens = math.Min(float64(rate.Burst), b.tokens+now.Sub(b.last).Seconds()*rate.PerSecond)
+		b.last = now
+		if b.tokens < 1 {
+			return ErrorRateLimited, time.Duration((1 - b.tokens) / rate.PerSecond * float64(time.Second))
+		}
+		b.tokens--
+	}
+	l.used[client]++
+	return "", 0
+}
+
+// drops the buckets of clients idle for LimitIdle, as they have filled back up
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < LimitIdle {
+		return
+	}
+	l.swept = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > LimitIdle {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+type quotaFile struct {
+	Day  string         `json:"day"`
+	Used map[string]int `json:"used"`
+}
+
+// SaveQuotas writes the day's usage to the quota file, if there is one
+func (l *Limiter) SaveQuotas() error {
+	if l.config.QuotaFile == "" {
+		return nil
+	}
+	l.saving.Lock()
+	defer l.saving.Unlock()
+	l.mu.Lock()
+	buf, err := json.Marshal(quotaFile{l.day, l.used})
+	l.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := l.config.QuotaFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.config.QuotaFile)
+}
+
+// SaveQuotasEvery saves the limiter's usage to its quota file every interval in the background,
+// until the server shuts down, so a crash loses at most an interval of it
+func (s *Server) SaveQuotasEvery(interval time.Duration) {
+	if s.limiter == nil || s.limiter.config.QuotaFile == "" || interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stopping.Done():
+				return
+			case <-ticker.C:
+				warn(s.limiter.SaveQuotas(), "saving quotas to "+s.limiter.config.QuotaFile)
+			}
+		}
+	}()
+}
+
+// LoadQuotas reads back the usage SaveQuotas wrote, a missing file being no usage
+func (l *Limiter) LoadQuotas() error {
+	if l.config.QuotaFile == "" {
+		return nil
+	}
+	buf, err := ioutil.ReadFile(l.config.QuotaFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var q quotaFile
+	if err := json.Unmarshal(buf, &q); err != nil {
+		return errorf("Invalid quota file %s: %v", l.config.QuotaFile, err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if q.Day == l.now().UTC().Format("2006-01-02") && q.Used != nil {
+		l.day, l.used = q.Day, q.Used
+	}
+	return nil
+}
+
+// who the request is from, its principal when it has authenticated as one, or its ip
+func (s *Server) client(r *http.Request) string {
+	if p, ok := PrincipalFrom(r.Context()); ok && p != nil && p != s.auth.Anonymous {
+		return "key:" + p.Tenant + "/" + p.Name
+	}
+	if hops := s.limiter.config.TrustedProxies; hops > 0 {
+		var forwarded []string
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			forwarded = append(forwarded, strings.Split(header, ",")...)
+		}
+		if len(forwarded) > 0 {
+			// fewer entries than proxies, every one was added by a proxy
+			i := len(forwarded) - hops
+			if i < 0 {
+				i = 0
+			}
+			return "ip:" + strings.TrimSpace(forwarded[i])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// limit responds 429 with a Retry-After to requests past their client's rate or quota
+func (s *Server) limit(class string, handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		if s.limiter == nil {
+			handle(w, r, params)
+			return
+		}
+		code, retry := s.limiter.allow(class, s.client(r))
+		if code == "" {
+			handle(w, r, params)
+			return
+		}
+		seconds := int(math.Ceil(retry.Seconds()))
+		if seconds < 1 {
+			seconds = 1
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		message := "Too many " + class + " requests"
+		if code == ErrorQuotaExceeded {
+			message = sprintf("Daily quota of %d requests used up", s.limiter.config.DailyQuota)
+		}
+		respondError(w, http.StatusTooManyRequests, code, message)
+	}
+}
@@ -0,0 +1,84 @@
+package philifence
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Date(2019, 3, 4, 23, 59, 0, 0, time.UTC)
+	l := NewLimiter(LimitConfig{Rates: map[string]Rate{LimitSearch: {PerSecond: 1, Burst: 2}}, DailyQuota: 4})
+	l.now = func() time.Time { return now }
+	expect := func(class, client, code string) {
+		t.Helper()
+		if got, _ := l.allow(class, client); got != code {
+			t.Errorf("Expected %q for %s by %s, got %q", code, class, client, got)
+		}
+	}
+	expect(LimitSearch, "a", "")
+	expect(LimitSearch, "a", "")
+	expect(LimitSearch, "a", ErrorRateLimited)
+	expect(LimitSearch, "b", "")
+	expect(LimitMutation, "a", "")
+	if _, retry := l.allow(LimitSearch, "a"); retry != time.Second {
+		t.Errorf("Expected to retry in a second, got %v", retry)
+	}
+	now = now.Add(time.Second)
+	expect(LimitSearch, "a", "")
+	expect(LimitMutation, "a", ErrorQuotaExceeded)
+	if _, retry := l.allow(LimitMutation, "a"); retry != 59*time.Second {
+		t.Errorf("Expected to retry at midnight, got %v", retry)
+	}
+
+	l.config.QuotaFile = filepath.Join(t.TempDir(), "quotas.json")
+	if err := l.SaveQuotas(); err != nil {
+		t.Fatal(err)
+	}
+	restarted := NewLimiter(l.config)
+	restarted.now = l.now
+	if err := restarted.LoadQuotas(); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := restarted.allow(LimitMutation, "a"); code != ErrorQuotaExceeded {
+		t.Errorf("Expected the quota to outlive a restart, got %q", code)
+	}
+	now = now.Add(time.Minute)
+	expect(LimitMutation, "a", "")
+}
+
+func TestServerLimits(t *testing.T) {
+	s := serverWith(t, KindFence, KindFence)
+	s.SetLimiter(NewLimiter(LimitConfig{Rates: map[string]Rate{LimitSearch: {PerSecond: 0.1, Burst: 1}}, TrustedProxies: 1}))
+	path := "/fence/cities/search?lat=1&lon=1"
+	if r := serveAs(s, "GET", path, "", "X-Forwarded-For", "10.0.0.1"); r.Code != 200 {
+		t.Errorf("Expected the first search through, got %d", r.Code)
+	}
+	// the client can't get round its limit by forging entries left of the proxy's
+	r := serveAs(s, "GET", path, "", "X-Forwarded-For", "10.0.0.9, 10.0.0.1")
+	if r.Code != 429 || r.Header().Get("Retry-After") != "10" {
+		t.Errorf("Expected a 429 to retry in 10s, got %d %q", r.Code, r.Header().Get("Retry-After"))
+	}
+	if r := serveAs(s, "GET", path, "", "X-Forwarded-For", "10.0.0.2"); r.Code != 200 {
+		t.Errorf("Expected other clients through, got %d", r.Code)
+	}
+	if r := serveAs(s, "POST", "/fence/cities/search/batch", `[{"lat": 1, "lon": 1}]`, "X-Forwarded-For", "10.0.0.1"); r.Code != 200 {
+		t.Errorf("Expected batches to have a limit of their own, got %d", r.Code)
+	}
+
+	// usage is saved as it goes, not only on shutdown
+	file := filepath.Join(t.TempDir(), "quotas.json")
+	s.SetLimiter(NewLimiter(LimitConfig{DailyQuota: 10, QuotaFile: file}))
+	s.SaveQuotasEvery(5 * time.Millisecond)
+	defer s.stop()
+	serveAs(s, "GET", path, "")
+	for i := 0; i < 100; i++ {
+		if buf, _ := ioutil.ReadFile(file); strings.Contains(string(buf), `":1`) {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Errorf("Expected the usage saved while serving")
+}
@@ -0,0 +1,598 @@
+package philifence
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// defaults of MatchOptions, after Newson & Krumm, Hidden Markov Map Matching Through Noise
+// and Sparseness, ACM SIGSPATIAL 2009
+var (
+	MatchRadius     = 50.0   // meters around a ping within which roads are candidates
+	MatchMaxRadius  = 500.0  // meters a radius may be at most, past which candidates are mostly noise
+	MatchSigma      = 10.0   // meters of gps noise
+	MatchBeta       = 5.0    // meters, how much routes between pings tend to differ from straight lines
+	MatchMaxDetour  = 2000.0 // meters a route may be longer than the straight line between its pings
+	MatchCandidates = 8      // nearest roads a ping may be matched to
+	MaxTrace        = 10000  // pings a trace may have
+)
+
+// MatchOptions tune MatchTrace, the defaults being used for those left out
+type MatchOptions struct {
+	Radius float64
+	Sigma  float64
+	Beta   float64
+}
+
+func (o MatchOptions) withDefaults() MatchOptions {
+	if o.Radius <= 0 {
+		o.Radius = MatchRadius
+	}
+	if o.Radius > MatchMaxRadius {
+		o.Radius = MatchMaxRadius
+	}
+	if o.Sigma <= 0 {
+		o.Sigma = MatchSigma
+	}
+	if o.Beta <= 0 {
+		o.Beta = MatchBeta
+	}
+	return o
+}
+
+// MatchedPoint is a ping snapped onto the road it was matched to
+type MatchedPoint struct {
+	Index    int // of the ping in the trace
+	Point    Coordinate
+	Road     *Feature
+	Distance float64 // meters from the ping
+}
+
+// MatchResult is a trace matched onto roads
+type MatchResult struct {
+	Points    []MatchedPoint
+	Roads     []*Feature     // travelled, in order, once per stretch
+	Paths     [][]Coordinate // the trace snapped onto the roads and routed along them between pings, one per stretch between breaks
+	Unmatched []int          // pings without a road within the radius
+	Breaks    int            // times no route joined consecutive pings and matching started over
+}
+
+// MatchTrace matches a trace of gps pings onto the roads of a tree with a hidden markov model,
+// the roads within the radius of each ping being its states. Pings are likely near their road,
+// by a normal distribution of their distance, and from one ping to the next the likely roads
+// are those between which the route is about as long as the straight line, by an exponential
+// distribution of the difference. Viterbi then gives the likeliest roads for the whole trace,
+// rather than snapping every ping to its nearest road and zig-zagging between parallel ones.
+//
+// Routes follow the lines of the active road features near the trace, joined where they share
+// vertices, in either direction. Only the roads a route between two pings may take are read,
+// a transition at a time, rather than every road around the whole trace. Pings within 2 sigma
+// of the previous one are skipped, as they add nothing but noise. Where no route joins two
+// pings matching starts over, on a path of its own.
+func MatchTrace(tree *Rtree, trace []Coordinate, opts MatchOptions) *MatchResult {
+	opts = opts.withDefaults()
+	result := &MatchResult{}
+	if len(trace) == 0 {
+		return result
+	}
+	g := newRoadGraph(tree, time.Now())
+	var chain []matchStep
+	last := -1
+	for i, c := range trace {
+		if last >= 0 && haversine(trace[last], c) < 2*opts.Sigma {
+			continue
+		}
+		if last >= 0 {
+			g.cover(trace[last], c, opts.Radius)
+		} else {
+			g.cover(c, c, opts.Radius)
+		}
+		candidates := g.candidates(c, opts.Radius)
+		if len(candidates) == 0 {
+			result.Unmatched = append(result.Unmatched, i)
+			continue
+		}
+		last = i
+		step := matchStep{index: i, ping: c, candidates: candidates, score: make([]float64, len(candidates)), back: make([]int, len(candidates))}
+		emissions := make([]float64, len(candidates))
+		for j, cand := range candidates {
+			emissions[j] = logEmission(cand.distance, opts.Sigma)
+		}
+		if len(chain) > 0 && step.transition(g, &chain[len(chain)-1], emissions, opts.Beta) {
+			chain = append(chain, step)
+			continue
+		}
+		if len(chain) > 0 {
+			result.add(g, chain)
+			result.Breaks++
+		}
+		copy(step.score, emissions)
+		chain = []matchStep{step}
+	}
+	result.add(g, chain)
+	return result
+}
+
+// a kept ping with its candidates, the log probability of the likeliest path ending at each
+// and the candidate of the previous ping that path came from
+type matchStep struct {
+	index      int
+	ping       Coordinate
+	candidates []*matchCandidate
+	score      []float64
+	back       []int
+}
+
+// scores the step's candidates from the previous step's, false when no route joins them
+func (step *matchStep) transition(g *roadGraph, prev *matchStep, emissions []float64, beta float64) (ok bool) {
+	straight := haversine(prev.ping, step.ping)
+	limit := straight + MatchMaxDetour
+	for j := range step.score {
+		step.score[j] = math.Inf(-1)
+	}
+	for i, from := range prev.candidates {
+		if math.IsInf(prev.score[i], -1) {
+			continue
+		}
+		dist, _ := g.route(from, limit)
+		for j, to := range step.candidates {
+			d := g.distance(from, to, dist)
+			if d > limit {
+				continue
+			}
+			if score := prev.score[i] + logTransition(d, straight, beta) + emissions[j]; score > step.score[j] {
+				step.score[j], step.back[j], ok = score, i, true
+			}
+		}
+	}
+	return
+}
+
+func logEmission(meters, sigma float64) float64 {
+	return -0.5*(meters/sigma)*(meters/sigma) - math.Log(math.Sqrt(2*math.Pi)*sigma)
+}
+
+func logTransition(route, straight, beta float64) float64 {
+	return -math.Abs(route-straight)/beta - math.Log(beta)
+}
+
+// adds the likeliest path through the chain to the result, as a path of its own
+func (result *MatchResult) add(g *roadGraph, chain []matchStep) {
+	if len(chain) == 0 {
+		return
+	}
+	var line []Coordinate
+	best := 0
+	final := chain[len(chain)-1]
+	for j, score := range final.score {
+		if score > final.score[best] {
+			best = j
+		}
+	}
+	matched := make([]*matchCandidate, len(chain))
+	for i := len(chain) - 1; i >= 0; i-- {
+		matched[i] = chain[i].candidates[best]
+		best = chain[i].back[best]
+	}
+
+	for i, cand := range matched {
+		result.Points = append(result.Points, MatchedPoint{Index: chain[i].index, Point: cand.point, Road: cand.road, Distance: cand.distance})
+		if i == 0 {
+			line = append(line, cand.point)
+			result.travel(cand.road)
+			continue
+		}
+		from := matched[i-1]
+		dist, prev := g.route(from, haversine(chain[i-1].ping, chain[i].ping)+MatchMaxDetour)
+		path, roads := g.path(from, cand, dist, prev)
+		line = append(line, path...)
+		for _, road := range roads {
+			result.travel(road)
+		}
+	}
+	result.Paths = append(result.Paths, line)
+}
+
+func (result *MatchResult) travel(road *Feature) {
+	if n := len(result.Roads); n == 0 || result.Roads[n-1] != road {
+		result.Roads = append(result.Roads, road)
+	}
+}
+
+// the box around the coordinates, widened by meters
+func traceBox(trace []Coordinate, meters float64) Box {
+	box := Box{min: trace[0], max: trace[0]}
+	for _, c := range trace[1:] {
+		box = box.extend(Box{min: c, max: c})
+	}
+	lat := meters / earthRadius * degrees
+	lon := lat / math.Max(0.01, math.Cos(math.Max(math.Abs(box.min.lat), math.Abs(box.max.lat))*radians))
+	box.min = Coordinate{lat: math.Max(-90, box.min.lat-lat), lon: math.Max(-180, box.min.lon-lon)}
+	box.max = Coordinate{lat: math.Min(90, box.max.lat+lat), lon: math.Min(180, box.max.lon+lon)}
+	return box
+}
+
+// roadGraph joins the lines of the roads near a trace where they share vertices, added as the
+// trace is matched
+type roadGraph struct {
+	tree   *Rtree
+	at     time.Time
+	nodes  map[Coordinate]int
+	coords []Coordinate
+	edges  [][]roadEdge
+	parts  map[*Polygon][]int // the node of every vertex of a road's line
+}
+
+type roadEdge struct {
+	to     int
+	meters float64
+	road   *Feature
+}
+
+func newRoadGraph(tree *Rtree, at time.Time) *roadGraph {
+	return &roadGraph{tree: tree, at: at, nodes: make(map[Coordinate]int), parts: make(map[*Polygon][]int)}
+}
+
+// cover adds the roads a route between candidates of pings a and b may take. Such a route is
+// at most the straight line plus MatchMaxDetour long, so it stays within the ellipse of that
+// length around the candidates, which are up to radius off the pings.
+func (g *roadGraph) cover(a, b Coordinate, radius float64) {
+	limit := haversine(a, b) + MatchMaxDetour
+	foci := math.Max(0, haversine(a, b)-2*radius)
+	g.add(traceBox([]Coordinate{a, b}, radius+math.Sqrt(limit*limit-foci*foci)/2))
+}
+
+// add adds the roads in the box not in the graph yet
+func (g *roadGraph) add(box Box) {
+	g.tree.search(box, func(leaf *customRect) {
+		road := leaf.Feature()
+		if !road.Current() || !road.Active(g.at) || g.parts[leaf.polygon] != nil {
+			return
+		}
+		line := leaf.polygon.Exterior.Coordinates
+		ids := make([]int, len(line))
+		for i, c := range line {
+			ids[i] = g.node(c)
+			if i > 0 {
+				meters := haversine(line[i-1], c)
+				g.edges[ids[i-1]] = append(g.edges[ids[i-1]], roadEdge{ids[i], meters, road})
+				g.edges[ids[i]] = append(g.edges[ids[i]], roadEdge{ids[i-1], meters, road})
+			}
+		}
+		g.parts[leaf.polygon] = ids
+	})
+}
+
+func (g *roadGraph) node(c Coordinate) int {
+	id, ok := g.nodes[c]
+	if !ok {
+		id = len(g.coords)
+		g.nodes[c] = id
+		g.coords = append(g.coords, c)
+		g.edges = append(g.edges, nil)
+	}
+	return id
+}
+
+// matchCandidate is the point of a road's line nearest to a ping
+type matchCandidate struct {
+	road     *Feature
+	part     *Polygon
+	segment  int // of the line, between its vertices segment and segment+1
+	t        float64
+	point    Coordinate
+	distance float64
+	from, to int     // nodes of the segment's ends
+	length   float64 // meters of the segment
+}
+
+// the nearest roads within radius meters of c, one candidate for each line
+func (g *roadGraph) candidates(c Coordinate, radius float64) (candidates []*matchCandidate) {
+	seen := make(map[*Polygon]bool)
+	for _, q := range rectsFromCenter(c, radius) {
+		g.tree.search(q.box, func(leaf *customRect) {
+			ids, ok := g.parts[leaf.polygon]
+			if !ok || seen[leaf.polygon] {
+				return
+			}
+			seen[leaf.polygon] = true
+			var nearest *matchCandidate
+			line := leaf.polygon.Exterior.Coordinates
+			for i := 1; i < len(line); i++ {
+				t, d := projectSegment(c, line[i-1], line[i])
+				if d > radius || nearest != nil && d >= nearest.distance {
+					continue
+				}
+				nearest = &matchCandidate{
+					road: leaf.Feature(), part: leaf.polygon, segment: i - 1, t: t,
+					point: lerp(line[i-1], line[i], t), distance: d,
+					from: ids[i-1], to: ids[i], length: haversine(line[i-1], line[i]),
+				}
+			}
+			if nearest != nil {
+				candidates = append(candidates, nearest)
+			}
+		})
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].distance < candidates[j].distance })
+	if len(candidates) > MatchCandidates {
+		candidates = candidates[:MatchCandidates]
+	}
+	return
+}
+
+// roadStep is how a node was reached on the shortest route to it
+type roadStep struct {
+	from int
+	road *Feature
+}
+
+// route finds the meters along the roads from the candidate to every node no further than
+// limit, and the step into each, by Dijkstra
+func (g *roadGraph) route(from *matchCandidate, limit float64) (dist map[int]float64, prev map[int]roadStep) {
+	dist, prev = make(map[int]float64), make(map[int]roadStep)
+	queue := &routeQueue{}
+	reach := func(node int, meters float64, step *roadStep) {
+		if d, ok := dist[node]; (ok && d <= meters) || meters > limit {
+			return
+		}
+		dist[node] = meters
+		if step != nil {
+			prev[node] = *step
+		}
+		heap.Push(queue, routeEntry{node, meters})
+	}
+	reach(from.from, from.t*from.length, nil)
+	reach(from.to, (1-from.t)*from.length, nil)
+	for queue.Len() > 0 {
+		e := heap.Pop(queue).(routeEntry)
+		if e.meters > dist[e.node] {
+			continue
+		}
+		for _, edge := range g.edges[e.node] {
+			reach(edge.to, e.meters+edge.meters, &roadStep{e.node, edge.road})
+		}
+	}
+	return
+}
+
+// meters along the roads between two candidates, infinite when route found none
+func (g *roadGraph) distance(from, to *matchCandidate, dist map[int]float64) float64 {
+	d, _ := g.nearestEnd(from, to, dist)
+	return d
+}
+
+// which end of to's segment the shortest route enters it by, -1 when from is on the same one
+// and it's shorter to stay on it
+func (g *roadGraph) nearestEnd(from, to *matchCandidate, dist map[int]float64) (meters float64, end int) {
+	meters, end = math.Inf(1), -1
+	if from.part == to.part && from.segment == to.segment {
+		meters = math.Abs(to.t-from.t) * to.length
+	}
+	if d, ok := dist[to.from]; ok && d+to.t*to.length < meters {
+		meters, end = d+to.t*to.length, to.from
+	}
+	if d, ok := dist[to.to]; ok && d+(1-to.t)*to.length < meters {
+		meters, end = d+(1-to.t)*to.length, to.to
+	}
+	return
+}
+
+// the coordinates after from's point up to to's along the shortest route, and the roads it takes
+func (g *roadGraph) path(from, to *matchCandidate, dist map[int]float64, prev map[int]roadStep) (path []Coordinate, roads []*Feature) {
+	_, end := g.nearestEnd(from, to, dist)
+	if end >= 0 {
+		var steps []roadStep
+		node := end
+		for {
+			path = append(path, g.coords[node])
+			step, ok := prev[node]
+			if !ok {
+				break
+			}
+			steps = append(steps, step)
+			node = step.from
+		}
+		for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+			path[i], path[j] = path[j], path[i]
+		}
+		roads = append(roads, from.road)
+		for i := len(steps) - 1; i >= 0; i-- {
+			roads = append(roads, steps[i].road)
+		}
+	}
+	return append(path, to.point), append(roads, to.road)
+}
+
+type routeEntry struct {
+	node   int
+	meters float64
+}
+
+type routeQueue []routeEntry
+
+func (q routeQueue) Len() int            { return len(q) }
+func (q routeQueue) Less(i, j int) bool  { return q[i].meters < q[j].meters }
+func (q routeQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *routeQueue) Push(x interface{}) { *q = append(*q, x.(routeEntry)) }
+func (q *routeQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// ReadTrace reads the pings of a gpx file's tracks and routes, or of geojson points and lines
+func ReadTrace(r io.Reader) (trace []Coordinate, err error) {
+	buf := bufio.NewReader(r)
+	first, err := peekNonSpace(buf)
+	if err != nil {
+		if err == io.EOF {
+			err = errorf("Empty trace")
+		}
+		return
+	}
+	if first == '<' {
+		var gpx gpxFile
+		if err = xml.NewDecoder(buf).Decode(&gpx); err != nil {
+			return
+		}
+		trace = gpx.coordinates()
+	} else {
+		var gj traceGeoJson
+		if err = json.NewDecoder(buf).Decode(&gj); err != nil {
+			return
+		}
+		if trace, err = gj.coordinates(); err != nil {
+			return
+		}
+	}
+	if len(trace) == 0 {
+		err = errorf("Empty trace")
+	}
+	return
+}
+
+type gpxFile struct {
+	Tracks []struct {
+		Segments []struct {
+			Points []gpxPoint `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+	Routes []struct {
+		Points []gpxPoint `xml:"rtept"`
+	} `xml:"rte"`
+}
+
+type gpxPoint struct {
+	Lat float64 `xml:"lat,attr"`
+	Lon float64 `xml:"lon,attr"`
+}
+
+func (gpx gpxFile) coordinates() (trace []Coordinate) {
+	for _, track := range gpx.Tracks {
+		for _, segment := range track.Segments {
+			for _, p := range segment.Points {
+				trace = append(trace, Coordinate{lat: p.Lat, lon: p.Lon})
+			}
+		}
+	}
+	for _, route := range gpx.Routes {
+		for _, p := range route.Points {
+			trace = append(trace, Coordinate{lat: p.Lat, lon: p.Lon})
+		}
+	}
+	return
+}
+
+type traceGeoJson struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+	Geometry    *traceGeoJson   `json:"geometry"`
+	Features    []traceGeoJson  `json:"features"`
+}
+
+func (gj traceGeoJson) coordinates() (trace []Coordinate, err error) {
+	var positions [][]float64
+	switch gj.Type {
+	case "FeatureCollection":
+		for _, f := range gj.Features {
+			coords, err := f.coordinates()
+			if err != nil {
+				return nil, err
+			}
+			trace = append(trace, coords...)
+		}
+		return
+	case "Feature":
+		if gj.Geometry == nil {
+			return nil, errorf("Trace feature has no geometry")
+		}
+		return gj.Geometry.coordinates()
+	case "Point":
+		var position []float64
+		err = json.Unmarshal(gj.Coordinates, &position)
+		positions = [][]float64{position}
+	case "LineString", "MultiPoint":
+		err = json.Unmarshal(gj.Coordinates, &positions)
+	case "MultiLineString":
+		var lines [][][]float64
+		err = json.Unmarshal(gj.Coordinates, &lines)
+		for _, line := range lines {
+			positions = append(positions, line...)
+		}
+	default:
+		return nil, errorf("Traces are points or lines, not %q", gj.Type)
+	}
+	if err != nil {
+		return
+	}
+	for _, p := range positions {
+		if len(p) < 2 {
+			return nil, errorf("Invalid position %v", p)
+		}
+		trace = append(trace, Coordinate{lat: p[1], lon: p[0]})
+	}
+	return
+}
+
+// WriteMatchGeoJson writes the snapped trace as a geojson feature
+func WriteMatchGeoJson(w io.Writer, result *MatchResult) error {
+	return json.NewEncoder(w).Encode(newMatchMessage(result))
+}
+
+// matches a gpx or geojson trace onto the roads of the index, e.g. ?sigma=20 for noisy pings
+func (s *Server) postMatch(layer *Layer) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		defer r.Body.Close()
+		trace, err := ReadTrace(limitBody(r.Body))
+		if err != nil {
+			respondBodyError(w, err, "trace")
+			return
+		}
+		if len(trace) > MaxTrace {
+			respondError(w, http.StatusBadRequest, ErrorBadRequest, sprintf("A trace has at most %d pings", MaxTrace))
+			return
+		}
+		for i, c := range trace {
+			if err := c.validate(); err != nil {
+				respondError(w, http.StatusUnprocessableEntity, ErrorInvalidCoordinate, sprintf("Invalid ping %d: %s", i, err))
+				return
+			}
+		}
+		query := r.URL.Query()
+		var opts MatchOptions
+		for _, param := range []struct {
+			name string
+			v    *float64
+		}{{"radius", &opts.Radius}, {"sigma", &opts.Sigma}, {"beta", &opts.Beta}} {
+			if query.Get(param.name) == "" {
+				continue
+			}
+			var ok bool
+			if *param.v, ok = queryMeters(w, query, param.name, 0); !ok {
+				return
+			}
+		}
+		if opts.Radius > MatchMaxRadius {
+			respondError(w, http.StatusUnprocessableEntity, ErrorInvalidTolerance, sprintf("Radius %v out of range [0, %v]", opts.Radius, MatchMaxRadius))
+			return
+		}
+		name := params.ByName("name")
+		tree, err := layer.Index.Tree(name)
+		if err != nil {
+			respondUnknownIndex(w, layer, name)
+			return
+		}
+		respond(w, newMatchMessage(MatchTrace(tree, trace, opts)))
+	}
+}
@@ -0,0 +1,141 @@
+package philifence
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func road(id string, coords ...Coordinate) *Feature {
+	line, _ := NewLine(coords...)
+	f := NewLineFeature(line)
+	f.Properties = map[string]interface{}{"id": id}
+	return f
+}
+
+func TestMatchTrace(t *testing.T) {
+	// two parallel roads 44 m apart, joined at their ends, and a turn north at the east end
+	roads := []*Feature{
+		road("a", cd(0, 0), cd(0, 0.01), cd(0, 0.02)),
+		road("b", cd(0.0004, 0), cd(0.0004, 0.02)),
+		road("x1", cd(0, 0), cd(0.0004, 0)),
+		road("x2", cd(0, 0.02), cd(0.0004, 0.02)),
+		road("north", cd(0, 0.02), cd(-0.01, 0.02)),
+	}
+	fence, _ := newFenceOf(roads)
+	tree := fence.Tree()
+	ids := func(features []*Feature) (ids []string) {
+		for _, f := range features {
+			ids = append(ids, f.Id())
+		}
+		return
+	}
+
+	// every other ping is nearer to b, snapping them one by one would zig-zag
+	var trace []Coordinate
+	for i := 1; i < 19; i++ {
+		lat := -0.0001
+		if i%2 == 0 {
+			lat = 0.00025
+		}
+		trace = append(trace, cd(lat, float64(i)*0.001))
+	}
+	result := MatchTrace(tree, trace, MatchOptions{})
+	if r := ids(result.Roads); len(r) != 1 || r[0] != "a" {
+		t.Errorf("expected the trace along a, got %v", r)
+	}
+	if len(result.Points) != len(trace) || len(result.Unmatched) != 0 || result.Breaks != 0 {
+		t.Errorf("expected every ping matched, got %d of %d %v", len(result.Points), len(trace), result.Unmatched)
+	}
+	if len(result.Paths) != 1 {
+		t.Errorf("expected a single path, got %v", result.Paths)
+	}
+	for _, c := range result.Paths[0] {
+		if c.lat != 0 {
+			t.Errorf("expected the path on a, got %v", c)
+		}
+	}
+
+	trace = []Coordinate{cd(0.0001, 0.016), cd(-0.0001, 0.018), cd(0.00005, 0.0199), cd(-0.004, 0.02005), cd(-0.008, 0.01995), cd(5, 5)}
+	result = MatchTrace(tree, trace, MatchOptions{})
+	if r := ids(result.Roads); strings.Join(r, ",") != "a,north" {
+		t.Errorf("expected the trace to turn from a onto north, got %v", r)
+	}
+	if len(result.Unmatched) != 1 || result.Unmatched[0] != 5 {
+		t.Errorf("expected the last ping unmatched, got %v", result.Unmatched)
+	}
+	corner := false
+	for _, c := range result.Paths[0] {
+		corner = corner || c == cd(0, 0.02)
+	}
+	if !corner {
+		t.Errorf("expected the path to go round the corner, got %v", result.Paths)
+	}
+
+	// roads no route joins break the trace into a path each
+	apart := []*Feature{road("a", cd(0, 0), cd(0, 0.02)), road("b", cd(0.1, 0), cd(0.1, 0.02))}
+	fence, _ = newFenceOf(apart)
+	trace = []Coordinate{cd(0, 0.001), cd(0, 0.005), cd(0.1, 0.01), cd(0.1, 0.015)}
+	result = MatchTrace(fence.Tree(), trace, MatchOptions{})
+	if result.Breaks != 1 || len(result.Paths) != 2 {
+		t.Errorf("expected a path on either side of the break, got %d %v", result.Breaks, result.Paths)
+	}
+	if msg := newMatchMessage(result); msg.Geometry == nil || msg.Geometry.Type != "MultiLineString" {
+		t.Errorf("expected a multilinestring of the broken trace, got %v", msg.Geometry)
+	}
+
+	// an L shaped trace only reads the roads along it, not those in the corner of its box
+	inside := road("inside", cd(0.05, 0.02), cd(0.05, 0.03))
+	fence, _ = newFenceOf(append(roads, inside))
+	g := newRoadGraph(fence.Tree(), time.Now())
+	trace = nil
+	for i := 0; i <= 10; i++ {
+		trace = append(trace, cd(0, float64(i)*0.01))
+	}
+	for i := 1; i <= 10; i++ {
+		trace = append(trace, cd(float64(i)*0.01, 0.1))
+	}
+	for i := 1; i < len(trace); i++ {
+		g.cover(trace[i-1], trace[i], 50)
+	}
+	if g.parts[inside.Geometry[0]] != nil || g.parts[roads[0].Geometry[0]] == nil {
+		t.Errorf("expected only the roads along the trace in the graph, got %d parts", len(g.parts))
+	}
+}
+
+func TestReadTrace(t *testing.T) {
+	gpx := `<?xml version="1.0"?>
+<gpx version="1.1"><trk><trkseg><trkpt lat="14.5" lon="121.0"><time>2024-03-04T08:00:00Z</time></trkpt><trkpt lat="14.6" lon="121.1"></trkpt></trkseg></trk></gpx>`
+	cases := map[string]int{
+		gpx: 2,
+		`{"type": "LineString", "coordinates": [[121.0, 14.5], [121.1, 14.6], [121.2, 14.7]]}`:                                        3,
+		`{"type": "FeatureCollection", "features": [{"type": "Feature", "geometry": {"type": "Point", "coordinates": [121, 14.5]}}]}`: 1,
+	}
+	for body, n := range cases {
+		if trace, err := ReadTrace(strings.NewReader(body)); err != nil || len(trace) != n {
+			t.Errorf("expected %d pings, got %v %v", n, trace, err)
+		}
+	}
+	for _, bad := range []string{"", `{"type": "Polygon", "coordinates": []}`, `{"type": "Feature"}`, "<gpx></gpx>"} {
+		if _, err := ReadTrace(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected %q to fail", bad)
+		}
+	}
+
+	s := serverWith(t, KindRoad, KindRoad, road("a", cd(0, 0), cd(0, 0.02)))
+	r := serveAs(s, "POST", "/road/cities/match?sigma=5", `{"type": "LineString", "coordinates": [[0.001, 0.0001], [0.005, -0.0001], [0.009, 0.0001]]}`)
+	var msg MatchMessage
+	if err := json.Unmarshal(r.Body.Bytes(), &msg); err != nil || r.Code != 200 || msg.Geometry == nil || len(msg.Properties.Roads) != 1 || len(msg.Properties.Points) != 3 {
+		t.Errorf("expected the trace matched onto a, got %d %s", r.Code, r.Body)
+	}
+	if r := serveAs(s, "POST", "/road/cities/match?radius=1000", `{"type": "Point", "coordinates": [0, 0]}`); r.Code != 422 {
+		t.Errorf("expected a radius past MatchMaxRadius to 422, got %d", r.Code)
+	}
+	if r := serveAs(s, "POST", "/road/cities/match", `{"type": "LineString", "coordinates": [[0, 91]]}`); r.Code != 422 {
+		t.Errorf("expected an invalid ping to 422, got %d", r.Code)
+	}
+	if r := serveAs(s, "POST", "/road/cities/match?sigma=x", `{"type": "Point", "coordinates": [0, 0]}`); r.Code != 400 {
+		t.Errorf("expected an invalid sigma to 400, got %d", r.Code)
+	}
+}
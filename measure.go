@@ -0,0 +1,154 @@
+package philifence
+
+import (
+	"math"
+)
+
+// whether Fence.Add stores the metrics of a feature, otherwise they're computed on every call
+var CacheMetrics = true
+
+// Metrics are the geodesic measurements of a feature. Areas are in square meters, lengths in meters.
+type Metrics struct {
+	Area           float64    `json:"area,omitempty"`
+	Perimeter      float64    `json:"perimeter,omitempty"`
+	Length         float64    `json:"length,omitempty"`
+	Centroid       Coordinate `json:"centroid"`
+	PointOnSurface Coordinate `json:"point_on_surface"`
+}
+
+// Metrics of the feature, cached at insert time unless CacheMetrics is off
+func (f *Feature) Metrics() *Metrics {
+	if f.metrics != nil {
+		return f.metrics
+	}
+	return f.computeMetrics()
+}
+
+func (f *Feature) computeMetrics() *Metrics {
+	m := &Metrics{}
+	if len(f.Geometry) == 0 {
+		return m
+	}
+	if f.isAreal() {
+		var largest *Polygon
+		var largestArea, a, mlat, mlon float64
+		for _, poly := range f.Geometry {
+			area := poly.Area()
+			m.Area += area
+			m.Perimeter += poly.Perimeter()
+			if largest == nil || area > largestArea {
+				largest, largestArea = poly, area
+			}
+			pa, plat, plon := poly.moments()
+			a, mlat, mlon = a+pa, mlat+plat, mlon+plon
+		}
+		if a != 0 {
+			m.Centroid = Coordinate{lat: mlat / a, lon: mlon / a}
+		} else {
+			m.Centroid = largest.Exterior.Coordinates[0]
+		}
+		m.PointOnSurface = largest.pointOnSurface(m.Centroid.lat)
+		return m
+	}
+
+	var l, mlat, mlon float64
+	for _, poly := range f.Geometry {
+		m.Length += poly.Length()
+		poly.Exterior.eachSegment(func(a, b Coordinate) bool {
+			d := math.Hypot(b.lat-a.lat, b.lon-a.lon)
+			mid := lerp(a, b, 0.5)
+			l, mlat, mlon = l+d, mlat+mid.lat*d, mlon+mid.lon*d
+			return true
+		})
+	}
+	if l != 0 {
+		m.Centroid = Coordinate{lat: mlat / l, lon: mlon / l}
+	} else {
+		// points
+		n := 0.0
+		for _, poly := range f.Geometry {
+			for _, c := range poly.Exterior.Coordinates {
+				mlat, mlon, n = mlat+c.lat, mlon+c.lon, n+1
+			}
+		}
+		m.Centroid = Coordinate{lat: mlat / n, lon: mlon / n}
+	}
+	m.PointOnSurface = f.nearestVertex(m.Centroid)
+	return m
+}
+
+func (f *Feature) nearestVertex(c Coordinate) (nearest Coordinate) {
+	best := math.Inf(1)
+	for _, poly := range f.Geometry {
+		for _, v := range poly.Exterior.Coordinates {
+			if d := math.Hypot(v.lat-c.lat, v.lon-c.lon); d < best {
+				best, nearest = d, v
+			}
+		}
+	}
+	return
+}
+
+// Area in square meters, excluding holes
+func (poly *Polygon) Area() float64 {
+	area := math.Abs(ringArea(poly.Exterior.Coordinates))
+	for _, hole := range poly.Holes {
+		area -= math.Abs(ringArea(hole.Coordinates))
+	}
+	return math.Max(area, 0)
+}
+
+// Perimeter in meters, including the boundary of holes
+func (poly *Polygon) Perimeter() (perimeter float64) {
+	for _, ring := range poly.rings() {
+		perimeter += pathLength(ring.Coordinates)
+	}
+	return
+}
+
+// Length in meters of the exterior as an open path, i.e. for lines
+func (poly *Polygon) Length() float64 {
+	return pathLength(poly.Exterior.Coordinates)
+}
+
+// planar area and first moments of the polygon, holes subtracted regardless of ring orientation
+//
+// https://en.wikipedia.org/wiki/Centroid#Of_a_polygon
+func (poly *Polygon) moments() (area, mlat, mlon float64) {
+	for i, ring := range poly.rings() {
+		var a, my, mx float64
+		ring.eachSegment(func(p, q Coordinate) bool {
+			cross := p.lon*q.lat - q.lon*p.lat
+			a += cross / 2
+			mx += (p.lon + q.lon) * cross / 6
+			my += (p.lat + q.lat) * cross / 6
+			return true
+		})
+		sign := 1.0
+		if (a < 0) != (i > 0) {
+			sign = -1
+		}
+		area, mlat, mlon = area+sign*a, mlat+sign*my, mlon+sign*mx
+	}
+	return
+}
+
+// a point guaranteed to lie inside the polygon: the middle of the widest
+// interior span along the given latitude, or along the middle of the box
+func (poly *Polygon) pointOnSurface(lat float64) Coordinate {
+	box := poly.computeBox()
+	if lat <= box.min.lat || lat >= box.max.lat {
+		lat = box.center().lat
+	}
+	lons := poly.scanline(lat)
+	if len(lons) < 2 {
+		return poly.Exterior.Coordinates[0]
+	}
+	best := 0
+	for i := 2; i+1 < len(lons); i += 2 {
+		if lons[i+1]-lons[i] > lons[best+1]-lons[best] {
+			best = i
+		}
+	}
+	return Coordinate{lat: lat, lon: (lons[best] + lons[best+1]) / 2}
+}
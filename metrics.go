@@ -0,0 +1,236 @@
+package philifence
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// upper bounds of the latency buckets, in seconds
+var LatencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// process wide counters, the same for every server
+var (
+	searchCandidates = &counter{} // leaves the trees returned for searched points
+	searchMatches    = &counter{} // features among them that matched
+	inserts          = &counter{} // features added to indices after they were loaded
+	loadedFeatures   = &counter{}
+	purgedFeatures   = &counter{} // expired features dropped from their fences
+	loadDurations    = newHistogram([]float64{.01, .1, .5, 1, 2.5, 5, 10, 30, 60, 120, 300})
+)
+
+type counter struct {
+	n uint64
+}
+
+func (c *counter) inc() {
+	atomic.AddUint64(&c.n, 1)
+}
+
+func (c *counter) add(n int) {
+	atomic.AddUint64(&c.n, uint64(n))
+}
+
+func (c *counter) value() uint64 {
+	return atomic.LoadUint64(&c.n)
+}
+
+type histogram struct {
+	bounds []float64
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+	mu     sync.Mutex
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.mu.Lock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+	h.mu.Unlock()
+}
+
+func (h *histogram) since(start time.Time) {
+	h.observe(time.Since(start).Seconds())
+}
+
+// routeStats are the requests of a route, by method and path pattern
+type routeStats struct {
+	method, route string
+	latency       *histogram
+	codes         map[int]uint64
+}
+
+type serverMetrics struct {
+	routes map[string]*routeStats
+	mu     sync.Mutex
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{routes: make(map[string]*routeStats)}
+}
+
+func (m *serverMetrics) observe(method, route string, code int, d time.Duration) {
+	key := method + " " + route
+	m.mu.Lock()
+	r, ok := m.routes[key]
+	if !ok {
+		r = &routeStats{method: method, route: route, latency: newHistogram(LatencyBuckets), codes: make(map[int]uint64)}
+		m.routes[key] = r
+	}
+	r.codes[code]++
+	m.mu.Unlock()
+	r.latency.observe(d.Seconds())
+}
+
+// metricWriter writes the prometheus text format
+//
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+type metricWriter struct {
+	w io.Writer
+}
+
+func (m metricWriter) family(name, typ, help string) {
+	fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (m metricWriter) sample(name string, value float64, labels ...string) {
+	fmt.Fprintf(m.w, "%s%s %s\n", name, formatLabels(labels), formatValue(value))
+}
+
+func (m metricWriter) histogram(name string, h *histogram, labels ...string) {
+	h.mu.Lock()
+	counts, sum, count := append([]uint64(nil), h.counts...), h.sum, h.count
+	h.mu.Unlock()
+	cumulative := uint64(0)
+	for i, bound := range h.bounds {
+		cumulative += counts[i]
+		m.sample(name+"_bucket", float64(cumulative), append(labels, "le", formatValue(bound))...)
+	}
+	m.sample(name+"_bucket", float64(count), append(labels, "le", "+Inf")...)
+	m.sample(name+"_sum", sum, labels...)
+	m.sample(name+"_count", float64(count), labels...)
+}
+
+// labels as name, value pairs
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+`="`+escape.Replace(labels[i+1])+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return fmt.Sprint(v)
+}
+
+// writeMetrics writes the metrics of the server's layers and routes along with the process
+// wide ones
+func (s *Server) writeMetrics(w io.Writer) {
+	m := metricWriter{w}
+	type index struct {
+		layer, name string
+		stats       IndexStats
+	}
+	var indices []index
+	for _, name := range s.names {
+		layer := s.layers[name]
+		keys := layer.Index.Keys()
+		sort.Strings(keys)
+		for _, key := range keys {
+			if stats, err := layer.Index.Stats(key); err == nil {
+				indices = append(indices, index{name, key, stats})
+			}
+		}
+	}
+	m.family("philifence_index_features", "gauge", "Features in an index.")
+	for _, idx := range indices {
+		m.sample("philifence_index_features", float64(idx.stats.Features), "layer", idx.layer, "index", idx.name)
+	}
+	m.family("philifence_index_leaves", "gauge", "Polygons indexed for the features of an index.")
+	for _, idx := range indices {
+		m.sample("philifence_index_leaves", float64(idx.stats.Leaves), "layer", idx.layer, "index", idx.name)
+	}
+	m.family("philifence_index_height", "gauge", "Levels of nodes of the Hilbert R-tree searches of an index go down.")
+	for _, idx := range indices {
+		m.sample("philifence_index_height", float64(idx.stats.Height), "layer", idx.layer, "index", idx.name)
+	}
+
+	objects := make([]string, 0, len(s.objects))
+	for name := range s.objects {
+		objects = append(objects, name)
+	}
+	sort.Strings(objects)
+	m.family("philifence_objects", "gauge", "Positions in an object layer, stale ones included until they expire.")
+	for _, name := range objects {
+		m.sample("philifence_objects", float64(s.objects[name].Len()), "layer", name)
+	}
+
+	s.metrics.mu.Lock()
+	routes := make([]*routeStats, 0, len(s.metrics.routes))
+	for _, r := range s.metrics.routes {
+		routes = append(routes, r)
+	}
+	s.metrics.mu.Unlock()
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].route < routes[j].route || routes[i].route == routes[j].route && routes[i].method < routes[j].method
+	})
+	m.family("philifence_http_requests_total", "counter", "Requests by route and status code.")
+	for _, r := range routes {
+		s.metrics.mu.Lock()
+		codes := make([]int, 0, len(r.codes))
+		for code := range r.codes {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		counts := make([]uint64, len(codes))
+		for i, code := range codes {
+			counts[i] = r.codes[code]
+		}
+		s.metrics.mu.Unlock()
+		for i, code := range codes {
+			m.sample("philifence_http_requests_total", float64(counts[i]), "method", r.method, "route", r.route, "code", fmt.Sprint(code))
+		}
+	}
+	m.family("philifence_http_request_duration_seconds", "histogram", "Request latency by route.")
+	for _, r := range routes {
+		m.histogram("philifence_http_request_duration_seconds", r.latency, "method", r.method, "route", r.route)
+	}
+
+	m.family("philifence_search_candidates_total", "counter", "Leaves the R-trees returned for searched points, before matching them.")
+	m.sample("philifence_search_candidates_total", float64(searchCandidates.value()))
+	m.family("philifence_search_matches_total", "counter", "Features that matched searched points.")
+	m.sample("philifence_search_matches_total", float64(searchMatches.value()))
+	m.family("philifence_inserts_total", "counter", "Features added to indices after loading.")
+	m.sample("philifence_inserts_total", float64(inserts.value()))
+	m.family("philifence_loaded_features_total", "counter", "Features loaded from dataset files.")
+	m.sample("philifence_loaded_features_total", float64(loadedFeatures.value()))
+	m.family("philifence_purged_features_total", "counter", "Expired features purged from indices.")
+	m.sample("philifence_purged_features_total", float64(purgedFeatures.value()))
+	m.family("philifence_load_duration_seconds", "histogram", "Time to load a dataset file into a fence.")
+	m.histogram("philifence_load_duration_seconds", loadDurations)
+}
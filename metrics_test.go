@@ -0,0 +1,46 @@
+package philifence
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServerMetrics(t *testing.T) {
+	a := NewPolygonFeature(square(0, 0, 2))
+	a.Properties = map[string]interface{}{"id": "a"}
+	s := serverWith(t, KindFence, KindFence, a)
+	searchServer(t, s, "/fence/cities/search?lat=1&lon=1")
+	searchServer(t, s, "/fence/cities/search?lat=1&lon=1")
+
+	r := httptest.NewRecorder()
+	s.ServeHTTP(r, httptest.NewRequest("GET", "/metrics", nil))
+	body := r.Body.String()
+	for _, line := range []string{
+		`philifence_index_features{layer="fence",index="cities"} 1`,
+		`philifence_index_height{layer="fence",index="cities"} 1`,
+		`philifence_http_requests_total{method="GET",route="/fence/:name/search",code="200"} 2`,
+		`philifence_http_request_duration_seconds_count{method="GET",route="/fence/:name/search"} 2`,
+		`philifence_http_request_duration_seconds_bucket{method="GET",route="/fence/:name/search",le="+Inf"} 2`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected %s in\n%s", line, body)
+		}
+	}
+	if !strings.Contains(body, "philifence_search_matches_total ") {
+		t.Errorf("Expected search counters in\n%s", body)
+	}
+
+	s.SetReady(false)
+	r = httptest.NewRecorder()
+	s.ServeHTTP(r, httptest.NewRequest("GET", "/readyz", nil))
+	if r.Code != 503 {
+		t.Errorf("Expected not ready while loading, got %d", r.Code)
+	}
+	s.SetReady(true)
+	r = httptest.NewRecorder()
+	s.ServeHTTP(r, httptest.NewRequest("GET", "/readyz", nil))
+	if r.Code != 200 {
+		t.Errorf("Expected ready once loaded, got %d", r.Code)
+	}
+}
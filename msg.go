@@ -18,6 +18,17 @@ type ResponseMessage struct {
 	Result []Properties `json:"result"`
 }
 
+type BatchResponseMessage struct {
+	Result map[string][]Properties `json:"result"`
+}
+
+type JoinMessage struct {
+	Left   string   `json:"left"`
+	Right  string   `json:"right"`
+	Length *float64 `json:"length,omitempty"`
+	Area   *float64 `json:"area,omitempty"`
+}
+
 func newPointMessage(c Coordinate, props Properties) *PointMessage {
 	return &PointMessage{
 		Type:       "Feature",
@@ -35,3 +46,123 @@ func newResponseMessage(c Coordinate, props map[string]interface{}, fences []Pro
 		Result: fences,
 	}
 }
+
+func newBatchResponseMessage(matchs map[string][]*Feature) *BatchResponseMessage {
+	result := make(map[string][]Properties, len(matchs))
+	for id, features := range matchs {
+		props := make([]Properties, len(features))
+		for i, feature := range features {
+			props[i] = feature.Properties
+		}
+		result[id] = props
+	}
+	return &BatchResponseMessage{Result: result}
+}
+
+func newJoinMessage(pair JoinPair, measure bool) *JoinMessage {
+	msg := &JoinMessage{Left: pair.Left.Id(), Right: pair.Right.Id()}
+	if measure {
+		if pair.Left.isAreal() && pair.Right.isAreal() {
+			msg.Area = &pair.Area
+		} else {
+			msg.Length = &pair.Length
+		}
+	}
+	return msg
+}
+
+// the properties of every version, with its number and when it was current
+func newHistoryMessage(versions []*Feature) []Properties {
+	history := make([]Properties, len(versions))
+	for i, f := range versions {
+		v := f.Version()
+		extra := Properties{"version": v.Number}
+		if !v.Since.IsZero() {
+			extra["since"] = v.Since
+		}
+		if !v.Until.IsZero() {
+			extra["until"] = v.Until
+		}
+		history[i] = resultProperties(f, extra)
+	}
+	return history
+}
+
+// copies the feature's properties along with any extra per-result fields
+func resultProperties(f *Feature, extra Properties) Properties {
+	props := make(Properties, len(f.Properties)+len(extra))
+	for k, v := range f.Properties {
+		props[k] = v
+	}
+	for k, v := range extra {
+		props[k] = v
+	}
+	return props
+}
+
+type MatchMessage struct {
+	Type       string          `json:"type"`
+	Geometry   *LineGeometry   `json:"geometry"`
+	Properties MatchProperties `json:"properties"`
+}
+
+type LineGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"` // [][]float64 for a LineString, [][][]float64 for a MultiLineString
+}
+
+type MatchProperties struct {
+	Roads     []string              `json:"roads"`
+	Points    []MatchedPointMessage `json:"points"`
+	Unmatched []int                 `json:"unmatched"`
+	Breaks    int                   `json:"breaks"`
+}
+
+type MatchedPointMessage struct {
+	Index    int     `json:"index"`
+	Lat      float64 `json:"lat"`
+	Lon      float64 `json:"lon"`
+	Road     string  `json:"road"`
+	Distance float64 `json:"distance"`
+}
+
+// the snapped trace as a linestring feature, a multilinestring of a line per stretch when it broke,
+// without a geometry when no stretch has two pings matched
+func newMatchMessage(result *MatchResult) *MatchMessage {
+	msg := &MatchMessage{
+		Type: "Feature",
+		Properties: MatchProperties{
+			Roads:     make([]string, len(result.Roads)),
+			Points:    make([]MatchedPointMessage, len(result.Points)),
+			Unmatched: result.Unmatched,
+			Breaks:    result.Breaks,
+		},
+	}
+	if msg.Properties.Unmatched == nil {
+		msg.Properties.Unmatched = []int{}
+	}
+	for i, road := range result.Roads {
+		msg.Properties.Roads[i] = road.Id()
+	}
+	for i, p := range result.Points {
+		msg.Properties.Points[i] = MatchedPointMessage{Index: p.Index, Lat: p.Point.lat, Lon: p.Point.lon, Road: p.Road.Id(), Distance: p.Distance}
+	}
+	var lines [][][]float64
+	for _, path := range result.Paths {
+		if len(path) < 2 {
+			continue
+		}
+		line := make([][]float64, len(path))
+		for i, c := range path {
+			line[i] = []float64{c.lon, c.lat}
+		}
+		lines = append(lines, line)
+	}
+	switch {
+	case len(lines) == 1:
+		msg.Geometry = &LineGeometry{Type: "LineString", Coordinates: lines[0]}
+	case len(lines) > 1:
+		msg.Geometry = &LineGeometry{Type: "MultiLineString", Coordinates: lines}
+	}
+	return msg
+}
@@ -0,0 +1,548 @@
+package philifence
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// defaults of object indices
+var (
+	ObjectTTL      = 2 * time.Minute // how long a position is kept without an update
+	ObjectCellSize = 0.01            // degrees, ~1.1 km at the equator
+)
+
+// Object is the latest known position of something moving, e.g. a vehicle
+type Object struct {
+	Id         string     `json:"id"`
+	Point      Coordinate `json:"point"`
+	Properties Properties `json:"properties,omitempty"`
+	Updated    time.Time  `json:"updated"`
+}
+
+// ObjectMatch is an object found around a point
+type ObjectMatch struct {
+	Object
+	Distance float64 `json:"distance"` // meters
+}
+
+type objectCell struct {
+	row, col int
+}
+
+// ObjectIndex keeps the positions of moving objects in a grid of ObjectCellSize degree cells,
+// so an update is a couple of map writes rather than a tree insert, and positions can move
+// every few seconds. Positions not updated within the TTL are stale: searches skip them and
+// Expire, which Start runs in the background, removes them.
+//
+// It holds the default tenant's objects, every other tenant getting an index of its own
+// from Tenant.
+type ObjectIndex struct {
+	TTL      time.Duration
+	cellSize float64
+	objects  map[string]*Object
+	cells    map[objectCell]map[string]*Object
+	tenants  map[string]*ObjectIndex
+	now      func() time.Time
+	stop     chan struct{}
+	stopped  sync.Once
+	mu       sync.RWMutex
+	tenantMu sync.Mutex // guards tenants
+}
+
+// NewObjectIndex keeps positions for ttl, in cells of cellSize degrees, 0 for the defaults
+func NewObjectIndex(ttl time.Duration, cellSize float64) *ObjectIndex {
+	if ttl <= 0 {
+		ttl = ObjectTTL
+	}
+	if cellSize <= 0 {
+		cellSize = ObjectCellSize
+	}
+	return &ObjectIndex{
+		TTL:      ttl,
+		cellSize: cellSize,
+		objects:  make(map[string]*Object),
+		cells:    make(map[objectCell]map[string]*Object),
+		tenants:  make(map[string]*ObjectIndex),
+		now:      time.Now,
+		stop:     make(chan struct{}),
+	}
+}
+
+// Tenant is the index of the tenant's objects, which no other tenant sees, alike and expired
+// along with idx
+func (idx *ObjectIndex) Tenant(name string) *ObjectIndex {
+	if name == DefaultTenant {
+		return idx
+	}
+	idx.tenantMu.Lock()
+	defer idx.tenantMu.Unlock()
+	t, ok := idx.tenants[name]
+	if !ok {
+		t = NewObjectIndex(idx.TTL, idx.cellSize)
+		t.now = idx.now
+		idx.tenants[name] = t
+	}
+	return t
+}
+
+func (idx *ObjectIndex) cell(c Coordinate) objectCell {
+	cols := int(math.Ceil(360 / idx.cellSize))
+	return objectCell{int(math.Floor((c.lat + 90) / idx.cellSize)), int(math.Floor((c.lon+180)/idx.cellSize)) % cols}
+}
+
+func (idx *ObjectIndex) stale(o *Object, now time.Time) bool {
+	return now.Sub(o.Updated) >= idx.TTL
+}
+
+// Upsert moves the object to c, adding it when it is new. Properties are replaced unless nil.
+func (idx *ObjectIndex) Upsert(id string, c Coordinate, properties Properties) {
+	now := idx.now()
+	cell := idx.cell(c)
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	o, ok := idx.objects[id]
+	if ok {
+		if old := idx.cell(o.Point); old != cell {
+			idx.unlink(old, id)
+		}
+		// a copy, as searches hand out the objects they find
+		o = &Object{Id: id, Point: c, Properties: o.Properties, Updated: now}
+	} else {
+		o = &Object{Id: id, Point: c, Updated: now}
+	}
+	if properties != nil {
+		o.Properties = properties
+	}
+	idx.objects[id] = o
+	if idx.cells[cell] == nil {
+		idx.cells[cell] = make(map[string]*Object)
+	}
+	idx.cells[cell][id] = o
+}
+
+func (idx *ObjectIndex) unlink(cell objectCell, id string) {
+	delete(idx.cells[cell], id)
+	if len(idx.cells[cell]) == 0 {
+		delete(idx.cells, cell)
+	}
+}
+
+// Remove forgets the object, returning whether there was one
+func (idx *ObjectIndex) Remove(id string) bool {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	o, ok := idx.objects[id]
+	if ok {
+		idx.unlink(idx.cell(o.Point), id)
+		delete(idx.objects, id)
+	}
+	return ok
+}
+
+// Get the object's position unless it is stale
+func (idx *ObjectIndex) Get(id string) (Object, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	o, ok := idx.objects[id]
+	if !ok || idx.stale(o, idx.now()) {
+		return Object{}, false
+	}
+	return *o, true
+}
+
+// Len is the number of objects, stale ones included until they expire
+func (idx *ObjectIndex) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.objects)
+}
+
+// each calls fn with the fresh objects in the cells overlapping the box
+func (idx *ObjectIndex) each(box Box, now time.Time, fn func(*Object)) {
+	lo, hi := idx.cell(box.min), idx.cell(box.max)
+	cols := int(math.Ceil(360 / idx.cellSize))
+	if box.max.lon >= 180 {
+		hi.col = cols - 1
+	}
+	for row := lo.row; row <= hi.row; row++ {
+		for col := lo.col; col <= hi.col; col++ {
+			for _, o := range idx.cells[objectCell{row, col}] {
+				if !idx.stale(o, now) {
+					fn(o)
+				}
+			}
+		}
+	}
+}
+
+// Within returns the objects within radius meters of c, the nearest first. keep, when not
+// nil, picks the objects to return, e.g. those available.
+func (idx *ObjectIndex) Within(c Coordinate, radius float64, keep func(Object) bool) (matchs []ObjectMatch) {
+	now := idx.now()
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	for _, q := range rectsFromCenter(c, radius) {
+		idx.each(q.box, now, func(o *Object) {
+			if d := haversine(c, o.Point); d <= radius && (keep == nil || keep(*o)) {
+				matchs = append(matchs, ObjectMatch{*o, d})
+			}
+		})
+	}
+	sort.Slice(matchs, func(i, j int) bool { return matchs[i].Distance < matchs[j].Distance })
+	return
+}
+
+// Nearest returns the k objects nearest to c, no further than radius meters. It searches
+// within a cell's width first, doubling the radius until k objects are found.
+func (idx *ObjectIndex) Nearest(c Coordinate, k int, radius float64, keep func(Object) bool) []ObjectMatch {
+	if k <= 0 {
+		return nil
+	}
+	r := math.Min(radius, idx.cellSize*radians*earthRadius)
+	for {
+		matchs := idx.Within(c, r, keep)
+		if len(matchs) >= k || r >= radius {
+			if len(matchs) > k {
+				matchs = matchs[:k]
+			}
+			return matchs
+		}
+		r = math.Min(radius, 2*r)
+	}
+}
+
+// InFeature returns the objects inside the feature, e.g. a fence
+func (idx *ObjectIndex) InFeature(f *Feature, keep func(Object) bool) (objects []Object) {
+	now := idx.now()
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	seen := make(map[*Object]bool)
+	for _, poly := range f.Geometry {
+		idx.each(poly.computeBox(), now, func(o *Object) {
+			if !seen[o] && poly.Contains(o.Point) && (keep == nil || keep(*o)) {
+				seen[o] = true
+				objects = append(objects, *o)
+			}
+		})
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Id < objects[j].Id })
+	return
+}
+
+// Expire removes the objects stale at now, the tenants' included, returning how many there were
+func (idx *ObjectIndex) Expire(now time.Time) (n int) {
+	idx.tenantMu.Lock()
+	for _, t := range idx.tenants {
+		n += t.Expire(now)
+	}
+	idx.tenantMu.Unlock()
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for id, o := range idx.objects {
+		if idx.stale(o, now) {
+			idx.unlink(idx.cell(o.Point), id)
+			delete(idx.objects, id)
+			n++
+		}
+	}
+	return
+}
+
+// Start expires stale objects in the background, every half TTL
+func (idx *ObjectIndex) Start() {
+	go func() {
+		ticker := time.NewTicker(idx.TTL / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-idx.stop:
+				return
+			case t := <-ticker.C:
+				idx.Expire(t)
+			}
+		}
+	}()
+}
+
+// Stop ends what Start runs, it can be called more than once
+func (idx *ObjectIndex) Stop() error {
+	idx.stopped.Do(func() { close(idx.stop) })
+	return nil
+}
+
+// most objects a nearest search returns
+var MaxNearest = 100
+
+// ObjectUpdate is the position of an object as posted
+type ObjectUpdate struct {
+	Id         string     `json:"id"`
+	Lat        float64    `json:"lat"`
+	Lon        float64    `json:"lon"`
+	Properties Properties `json:"properties"` // left as they were when left out
+}
+
+// AddObjects serves the object index under /{name}, and each tenant's index of it under
+// /t/{tenant}/{name}
+func (s *Server) AddObjects(name string, idx *ObjectIndex) error {
+	if reservedLayers[name] || strings.Contains(name, "/") {
+		return errorf("Invalid layer name %q", name)
+	}
+	if _, ok := s.layers[name]; ok {
+		return errorf("Layer %q already exists", name)
+	}
+	if _, ok := s.objects[name]; ok {
+		return errorf("Layer %q already exists", name)
+	}
+	s.objects[name] = idx
+	for _, prefix := range []string{"/" + name, "/t/:tenant/" + name} {
+		s.handle("POST", prefix+"/objects", s.guard(AccessWrite, resource(name), s.limit(LimitMutation, s.objectsOf(idx, s.postObjects))))
+		s.handle("GET", prefix+"/objects/:id", s.guard(AccessRead, resource(name), s.limit(LimitSearch, s.objectsOf(idx, s.getObject))))
+		s.handle("DELETE", prefix+"/objects/:id", s.guard(AccessWrite, resource(name), s.limit(LimitMutation, s.objectsOf(idx, s.deleteObject))))
+		s.handle("GET", prefix+"/within", s.guard(AccessRead, objectsIn(name), s.limit(LimitSearch, s.objectsOf(idx, s.getWithin))))
+		s.handle("GET", prefix+"/nearest", s.guard(AccessRead, resource(name), s.limit(LimitSearch, s.objectsOf(idx, s.getNearest))))
+	}
+	return nil
+}
+
+// objectsOf serves the route with the index of the :tenant's objects
+func (s *Server) objectsOf(idx *ObjectIndex, handle func(*ObjectIndex) httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		tenant := params.ByName("tenant")
+		if _, ok := s.tenants[tenant]; !ok && tenant != DefaultTenant {
+			respondError(w, http.StatusNotFound, ErrorUnknownTenant, "No tenant "+tenant)
+			return
+		}
+		handle(idx.Tenant(tenant))(w, r, params)
+	}
+}
+
+func (s *Server) Objects(name string) (idx *ObjectIndex, ok bool) {
+	idx, ok = s.objects[name]
+	return
+}
+
+// the objects, and the index of the fence they are searched in
+func objectsIn(name string) func(*http.Request, httprouter.Params) []string {
+	return func(r *http.Request, params httprouter.Params) []string {
+		resources := []string{name}
+		if layer, index, _, ok := fenceParam(r.URL.Query()); ok {
+			resources = append(resources, layer+"/"+index)
+		}
+		return resources
+	}
+}
+
+// fence={layer}/{index}/{feature id}
+func fenceParam(query url.Values) (layer, index, feature string, ok bool) {
+	parts := strings.SplitN(query.Get("fence"), "/", 3)
+	if len(parts) != 3 {
+		return
+	}
+	return parts[0], parts[1], parts[2], true
+}
+
+// accepts a json array or newline delimited json of {id, lat, lon, properties}
+func (s *Server) postObjects(idx *ObjectIndex) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		defer r.Body.Close()
+		updates, err := decodeObjectUpdates(limitBody(r.Body))
+		if err != nil {
+			respondBodyError(w, err, "objects")
+			return
+		}
+		for _, u := range updates {
+			if u.Id == "" {
+				respondError(w, http.StatusBadRequest, ErrorBadRequest, "Objects need an id")
+				return
+			}
+			if err := (Coordinate{lat: u.Lat, lon: u.Lon}).validate(); err != nil {
+				respondError(w, http.StatusUnprocessableEntity, ErrorInvalidCoordinate, "Object "+u.Id+": "+err.Error())
+				return
+			}
+		}
+		for _, u := range updates {
+			idx.Upsert(u.Id, Coordinate{lat: u.Lat, lon: u.Lon}, u.Properties)
+		}
+		respond(w, "success")
+	}
+}
+
+func decodeObjectUpdates(r io.Reader) (updates []ObjectUpdate, err error) {
+	buf := bufio.NewReader(r)
+	first, err := peekNonSpace(buf)
+	if err != nil {
+		if err == io.EOF {
+			err = errorf("No objects")
+		}
+		return
+	}
+	dec := json.NewDecoder(buf)
+	if first == '[' {
+		err = dec.Decode(&updates)
+		return
+	}
+	for {
+		var u ObjectUpdate
+		if err = dec.Decode(&u); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+		updates = append(updates, u)
+	}
+}
+
+func (s *Server) getObject(idx *ObjectIndex) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		o, ok := idx.Get(params.ByName("id"))
+		if !ok {
+			respondError(w, http.StatusNotFound, ErrorUnknownObject, "No object "+params.ByName("id"))
+			return
+		}
+		respond(w, o)
+	}
+}
+
+func (s *Server) deleteObject(idx *ObjectIndex) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		if !idx.Remove(params.ByName("id")) {
+			respondError(w, http.StatusNotFound, ErrorUnknownObject, "No object "+params.ByName("id"))
+			return
+		}
+		respond(w, "success")
+	}
+}
+
+// objects within radius meters of lat, lon, or inside fence={layer}/{index}/{feature id} of
+// the same tenant
+func (s *Server) getWithin(idx *ObjectIndex) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		query := r.URL.Query()
+		keep := objectFilter(query)
+		if layerName, index, id, ok := fenceParam(query); ok {
+			layers, _, ok := s.scope(w, params)
+			if !ok {
+				return
+			}
+			layer, ok := layers[layerName]
+			if !ok {
+				respondError(w, http.StatusNotFound, ErrorUnknownLayer, "No layer "+layerName)
+				return
+			}
+			if !hasIndex(layer.Index, index) {
+				respondUnknownIndex(w, layer, index)
+				return
+			}
+			feature, err := layer.Index.Feature(index, id)
+			if err != nil {
+				respondError(w, http.StatusNotFound, ErrorUnknownFeature, err.Error())
+				return
+			}
+			respond(w, idx.InFeature(feature, keep))
+			return
+		} else if query.Get("fence") != "" {
+			respondError(w, http.StatusBadRequest, ErrorBadRequest, "Query param 'fence' must be {layer}/{index}/{feature id}")
+			return
+		}
+		c, ok := queryCoordinate(w, query)
+		if !ok {
+			return
+		}
+		radius, ok := queryMeters(w, query, "radius", 0)
+		if !ok {
+			return
+		}
+		respond(w, idx.Within(c, radius, keep))
+	}
+}
+
+// the k objects nearest to lat, lon, within radius meters
+func (s *Server) getNearest(idx *ObjectIndex) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		query := r.URL.Query()
+		c, ok := queryCoordinate(w, query)
+		if !ok {
+			return
+		}
+		radius, ok := queryMeters(w, query, "radius", MaxTolerance)
+		if !ok {
+			return
+		}
+		k := 1
+		if v := query.Get("k"); v != "" {
+			var err error
+			if k, err = strconv.Atoi(v); err != nil || k < 1 || k > MaxNearest {
+				respondError(w, http.StatusBadRequest, ErrorBadRequest, sprintf("Query param 'k' must be 1 to %d", MaxNearest))
+				return
+			}
+		}
+		respond(w, idx.Nearest(c, k, radius, objectFilter(query)))
+	}
+}
+
+// where={property}:{value}, every one of them has to match
+func objectFilter(query url.Values) func(Object) bool {
+	where := query["where"]
+	if len(where) == 0 {
+		return nil
+	}
+	return func(o Object) bool {
+		for _, kv := range where {
+			k, v, _ := strings.Cut(kv, ":")
+			if sprintf("%v", o.Properties[k]) != v {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// lat and lon, responding when they are missing or invalid
+func queryCoordinate(w http.ResponseWriter, query url.Values) (c Coordinate, ok bool) {
+	lat, err := strconv.ParseFloat(query.Get("lat"), 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, ErrorBadRequest, "Query param 'lat' required as float")
+		return
+	}
+	lon, err := strconv.ParseFloat(query.Get("lon"), 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, ErrorBadRequest, "Query param 'lon' required as float")
+		return
+	}
+	c = Coordinate{lat: lat, lon: lon}
+	if err := c.validate(); err != nil {
+		respondError(w, http.StatusUnprocessableEntity, ErrorInvalidCoordinate, err.Error())
+		return
+	}
+	return c, true
+}
+
+// a distance in meters up to MaxTolerance, required unless there is a default
+func queryMeters(w http.ResponseWriter, query url.Values, param string, def float64) (float64, bool) {
+	v := query.Get(param)
+	if v == "" && def > 0 {
+		return def, true
+	}
+	meters, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, ErrorBadRequest, "Query param '"+param+"' required as float")
+		return 0, false
+	}
+	if err := validateTolerance(meters); err != nil {
+		respondError(w, http.StatusUnprocessableEntity, ErrorInvalidTolerance, err.Error())
+		return 0, false
+	}
+	return meters, true
+}
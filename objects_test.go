@@ -0,0 +1,116 @@
+package philifence
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestObjectIndex(t *testing.T) {
+	idx := NewObjectIndex(time.Minute, 0)
+	now := time.Date(2024, 3, 4, 8, 0, 0, 0, time.UTC)
+	idx.now = func() time.Time { return now }
+	idx.Upsert("a", cd(14.60, 121.00), Properties{"status": "available"})
+	idx.Upsert("b", cd(14.61, 121.00), Properties{"status": "busy"})
+	idx.Upsert("c", cd(14.65, 121.00), Properties{"status": "available"})
+	idx.Upsert("d", cd(0, 179.999), nil)
+	idx.Upsert("e", cd(0, -179.999), nil)
+	idx.Upsert("a", cd(14.70, 121.00), nil) // moves to another cell, keeping its properties
+
+	ids := func(matchs []ObjectMatch) (ids []string) {
+		for _, m := range matchs {
+			ids = append(ids, m.Id)
+		}
+		return
+	}
+	if m := idx.Within(cd(14.60, 121.00), 6000, nil); len(m) != 2 || m[0].Id != "b" || m[1].Id != "c" {
+		t.Errorf("expected b then c within 6 km, got %v", ids(m))
+	}
+	if m := idx.Within(cd(0, 180), 1000, nil); len(m) != 2 {
+		t.Errorf("expected d and e across the antimeridian, got %v", ids(m))
+	}
+	available := func(o Object) bool { return o.Properties["status"] == "available" }
+	if m := idx.Nearest(cd(14.60, 121.00), 2, MaxTolerance, available); len(m) != 2 || m[0].Id != "c" || m[1].Id != "a" {
+		t.Errorf("expected c then a as the nearest available, got %v", ids(m))
+	}
+	if m := idx.Nearest(cd(14.60, 121.00), 5, 20000, nil); len(m) != 3 {
+		t.Errorf("expected the 3 objects within 20 km, got %v", ids(m))
+	}
+	fence := NewPolygonFeature(square(14.55, 120.95, 0.12))
+	if objects := idx.InFeature(fence, nil); len(objects) != 2 || objects[0].Id != "b" || objects[1].Id != "c" {
+		t.Errorf("expected b and c in the fence, got %v", objects)
+	}
+
+	now = now.Add(30 * time.Second)
+	idx.Upsert("b", cd(14.61, 121.00), nil)
+	now = now.Add(45 * time.Second)
+	if _, ok := idx.Get("a"); ok {
+		t.Error("expected a to be stale")
+	}
+	if m := idx.Within(cd(14.60, 121.00), 20000, nil); len(m) != 1 || m[0].Id != "b" {
+		t.Errorf("expected only b to be fresh, got %v", ids(m))
+	}
+	if n := idx.Expire(now); n != 4 || idx.Len() != 1 {
+		t.Errorf("expected 4 stale objects expired leaving 1, got %d and %d", n, idx.Len())
+	}
+	if !idx.Remove("b") || idx.Remove("b") || idx.Len() != 0 {
+		t.Error("expected b to be removed once")
+	}
+	idx.Stop()
+	idx.Stop()
+}
+
+func TestServerObjects(t *testing.T) {
+	zone := NewPolygonFeature(square(14.55, 120.95, 0.1))
+	zone.Properties = map[string]interface{}{"id": "zone"}
+	s := serverWith(t, KindFence, KindFence, zone)
+	if err := s.AddObjects("drivers", NewObjectIndex(0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddObjects(KindFence, NewObjectIndex(0, 0)); err == nil {
+		t.Error("expected objects named after a layer to fail")
+	}
+	if _, err := s.AddTenant("acme", TenantLimits{}); err != nil {
+		t.Fatal(err)
+	}
+	updates := `{"id": "a", "lat": 14.60, "lon": 121.00, "properties": {"status": "available"}}
+{"id": "b", "lat": 14.61, "lon": 121.00, "properties": {"status": "busy"}}
+{"id": "c", "lat": 15.00, "lon": 121.00, "properties": {"status": "available"}}`
+	cases := []struct {
+		method, path, body string
+		status             int
+	}{
+		{"POST", "/drivers/objects", updates, 200},
+		{"POST", "/drivers/objects", `[{"id": "x", "lat": 91, "lon": 0}]`, 422},
+		{"POST", "/drivers/objects", `[{"lat": 1, "lon": 0}]`, 400},
+		{"GET", "/drivers/objects/a", "", 200},
+		{"GET", "/drivers/objects/x", "", 404},
+		{"GET", "/drivers/within?lat=14.6&lon=121", "", 400},
+		{"GET", "/drivers/within?fence=fence/cities", "", 400},
+		{"GET", "/drivers/within?fence=fence/cities/nope", "", 404},
+		{"GET", "/drivers/nearest?lat=14.6&lon=121&k=0", "", 400},
+		{"DELETE", "/drivers/objects/b", "", 200},
+		{"DELETE", "/drivers/objects/b", "", 404},
+		{"GET", "/t/acme/drivers/objects/a", "", 404},
+		{"GET", "/t/nope/drivers/objects/a", "", 404},
+		{"POST", "/t/acme/drivers/objects", `{"id": "z", "lat": 14.60, "lon": 121.00}`, 200},
+		{"GET", "/t/acme/drivers/objects/z", "", 200},
+		{"GET", "/drivers/objects/z", "", 404},
+	}
+	for _, c := range cases {
+		if r := serveAs(s, c.method, c.path, c.body); r.Code != c.status {
+			t.Errorf("%s %s: expected %d, got %d %s", c.method, c.path, c.status, r.Code, r.Body)
+		}
+	}
+
+	var matchs []ObjectMatch
+	json.Unmarshal(serveAs(s, "GET", "/drivers/nearest?lat=14.6&lon=121&k=2&where=status:available", "").Body.Bytes(), &matchs)
+	if len(matchs) != 2 || matchs[0].Id != "a" || matchs[1].Id != "c" || matchs[1].Distance < 40000 {
+		t.Errorf("expected a then c as the nearest available, got %+v", matchs)
+	}
+	var objects []Object
+	json.Unmarshal(serveAs(s, "GET", "/drivers/within?fence=fence/cities/zone", "").Body.Bytes(), &objects)
+	if len(objects) != 1 || objects[0].Id != "a" {
+		t.Errorf("expected only a in the zone, got %+v", objects)
+	}
+}
@@ -0,0 +1,4 @@
+// Package philifencepb holds the protobuf messages and gRPC service of philifence.
+package philifencepb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative philifence.proto
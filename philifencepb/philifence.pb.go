@@ -0,0 +1,1428 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        (unknown)
+// source: philifence.proto
+
+package philifencepb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	structpb "google.golang.org/protobuf/types/known/structpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Coordinate struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Lat           float64                `protobuf:"fixed64,1,opt,name=lat,proto3" json:"lat,omitempty"`
+	Lon           float64                `protobuf:"fixed64,2,opt,name=lon,proto3" json:"lon,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Coordinate) Reset() {
+	*x = Coordinate{}
+	mi := &file_philifence_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Coordinate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Coordinate) ProtoMessage() {}
+
+func (x *Coordinate) ProtoReflect() protoreflect.Message {
+	mi := &file_philifence_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Coordinate.ProtoReflect.Descriptor instead.
+func (*Coordinate) Descriptor() ([]byte, []int) {
+	return file_philifence_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Coordinate) GetLat() float64 {
+	if x != nil {
+		return x.Lat
+	}
+	return 0
+}
+
+func (x *Coordinate) GetLon() float64 {
+	if x != nil {
+		return x.Lon
+	}
+	return 0
+}
+
+type Ring struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Coordinates   []*Coordinate          `protobuf:"bytes,1,rep,name=coordinates,proto3" json:"coordinates,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Ring) Reset() {
+	*x = Ring{}
+	mi := &file_philifence_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Ring) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Ring) ProtoMessage() {}
+
+func (x *Ring) ProtoReflect() protoreflect.Message {
+	mi := &file_philifence_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Ring.ProtoReflect.Descriptor instead.
+func (*Ring) Descriptor() ([]byte, []int) {
+	return file_philifence_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Ring) GetCoordinates() []*Coordinate {
+	if x != nil {
+		return x.Coordinates
+	}
+	return nil
+}
+
+type Geometry struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Exterior      *Ring                  `protobuf:"bytes,1,opt,name=exterior,proto3" json:"exterior,omitempty"`
+	Holes         []*Ring                `protobuf:"bytes,2,rep,name=holes,proto3" json:"holes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Geometry) Reset() {
+	*x = Geometry{}
+	mi := &file_philifence_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Geometry) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Geometry) ProtoMessage() {}
+
+func (x *Geometry) ProtoReflect() protoreflect.Message {
+	mi := &file_philifence_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Geometry.ProtoReflect.Descriptor instead.
+func (*Geometry) Descriptor() ([]byte, []int) {
+	return file_philifence_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Geometry) GetExterior() *Ring {
+	if x != nil {
+		return x.Exterior
+	}
+	return nil
+}
+
+func (x *Geometry) GetHoles() []*Ring {
+	if x != nil {
+		return x.Holes
+	}
+	return nil
+}
+
+type Feature struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Type          string                 `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	Geometry      []*Geometry            `protobuf:"bytes,3,rep,name=geometry,proto3" json:"geometry,omitempty"`
+	Properties    *structpb.Struct       `protobuf:"bytes,4,opt,name=properties,proto3" json:"properties,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Feature) Reset() {
+	*x = Feature{}
+	mi := &file_philifence_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Feature) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Feature) ProtoMessage() {}
+
+func (x *Feature) ProtoReflect() protoreflect.Message {
+	mi := &file_philifence_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Feature.ProtoReflect.Descriptor instead.
+func (*Feature) Descriptor() ([]byte, []int) {
+	return file_philifence_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Feature) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Feature) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Feature) GetGeometry() []*Geometry {
+	if x != nil {
+		return x.Geometry
+	}
+	return nil
+}
+
+func (x *Feature) GetProperties() *structpb.Struct {
+	if x != nil {
+		return x.Properties
+	}
+	return nil
+}
+
+type Layer struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Kind          string                 `protobuf:"bytes,2,opt,name=kind,proto3" json:"kind,omitempty"`
+	Indices       []string               `protobuf:"bytes,3,rep,name=indices,proto3" json:"indices,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Layer) Reset() {
+	*x = Layer{}
+	mi := &file_philifence_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Layer) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Layer) ProtoMessage() {}
+
+func (x *Layer) ProtoReflect() protoreflect.Message {
+	mi := &file_philifence_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Layer.ProtoReflect.Descriptor instead.
+func (*Layer) Descriptor() ([]byte, []int) {
+	return file_philifence_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Layer) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Layer) GetKind() string {
+	if x != nil {
+		return x.Kind
+	}
+	return ""
+}
+
+func (x *Layer) GetIndices() []string {
+	if x != nil {
+		return x.Indices
+	}
+	return nil
+}
+
+type ListLayersRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListLayersRequest) Reset() {
+	*x = ListLayersRequest{}
+	mi := &file_philifence_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListLayersRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListLayersRequest) ProtoMessage() {}
+
+func (x *ListLayersRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_philifence_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListLayersRequest.ProtoReflect.Descriptor instead.
+func (*ListLayersRequest) Descriptor() ([]byte, []int) {
+	return file_philifence_proto_rawDescGZIP(), []int{5}
+}
+
+type ListLayersResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Layers        []*Layer               `protobuf:"bytes,1,rep,name=layers,proto3" json:"layers,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListLayersResponse) Reset() {
+	*x = ListLayersResponse{}
+	mi := &file_philifence_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListLayersResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListLayersResponse) ProtoMessage() {}
+
+func (x *ListLayersResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_philifence_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListLayersResponse.ProtoReflect.Descriptor instead.
+func (*ListLayersResponse) Descriptor() ([]byte, []int) {
+	return file_philifence_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ListLayersResponse) GetLayers() []*Layer {
+	if x != nil {
+		return x.Layers
+	}
+	return nil
+}
+
+type SearchRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Layer         string                 `protobuf:"bytes,1,opt,name=layer,proto3" json:"layer,omitempty"`
+	Index         string                 `protobuf:"bytes,2,opt,name=index,proto3" json:"index,omitempty"`
+	Point         *Coordinate            `protobuf:"bytes,3,opt,name=point,proto3" json:"point,omitempty"`
+	Tolerance     float64                `protobuf:"fixed64,4,opt,name=tolerance,proto3" json:"tolerance,omitempty"`
+	Params        map[string]string      `protobuf:"bytes,5,rep,name=params,proto3" json:"params,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SearchRequest) Reset() {
+	*x = SearchRequest{}
+	mi := &file_philifence_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SearchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchRequest) ProtoMessage() {}
+
+func (x *SearchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_philifence_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchRequest.ProtoReflect.Descriptor instead.
+func (*SearchRequest) Descriptor() ([]byte, []int) {
+	return file_philifence_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *SearchRequest) GetLayer() string {
+	if x != nil {
+		return x.Layer
+	}
+	return ""
+}
+
+func (x *SearchRequest) GetIndex() string {
+	if x != nil {
+		return x.Index
+	}
+	return ""
+}
+
+func (x *SearchRequest) GetPoint() *Coordinate {
+	if x != nil {
+		return x.Point
+	}
+	return nil
+}
+
+func (x *SearchRequest) GetTolerance() float64 {
+	if x != nil {
+		return x.Tolerance
+	}
+	return 0
+}
+
+func (x *SearchRequest) GetParams() map[string]string {
+	if x != nil {
+		return x.Params
+	}
+	return nil
+}
+
+type SearchResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Query         *Coordinate            `protobuf:"bytes,1,opt,name=query,proto3" json:"query,omitempty"`
+	Result        []*structpb.Struct     `protobuf:"bytes,2,rep,name=result,proto3" json:"result,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SearchResponse) Reset() {
+	*x = SearchResponse{}
+	mi := &file_philifence_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SearchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchResponse) ProtoMessage() {}
+
+func (x *SearchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_philifence_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchResponse.ProtoReflect.Descriptor instead.
+func (*SearchResponse) Descriptor() ([]byte, []int) {
+	return file_philifence_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *SearchResponse) GetQuery() *Coordinate {
+	if x != nil {
+		return x.Query
+	}
+	return nil
+}
+
+func (x *SearchResponse) GetResult() []*structpb.Struct {
+	if x != nil {
+		return x.Result
+	}
+	return nil
+}
+
+type Match struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Feature       *Feature               `protobuf:"bytes,1,opt,name=feature,proto3" json:"feature,omitempty"`
+	Distance      float64                `protobuf:"fixed64,2,opt,name=distance,proto3" json:"distance,omitempty"`
+	Status        string                 `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Match) Reset() {
+	*x = Match{}
+	mi := &file_philifence_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Match) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Match) ProtoMessage() {}
+
+func (x *Match) ProtoReflect() protoreflect.Message {
+	mi := &file_philifence_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Match.ProtoReflect.Descriptor instead.
+func (*Match) Descriptor() ([]byte, []int) {
+	return file_philifence_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *Match) GetFeature() *Feature {
+	if x != nil {
+		return x.Feature
+	}
+	return nil
+}
+
+func (x *Match) GetDistance() float64 {
+	if x != nil {
+		return x.Distance
+	}
+	return 0
+}
+
+func (x *Match) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+type NearResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Query         *Coordinate            `protobuf:"bytes,1,opt,name=query,proto3" json:"query,omitempty"`
+	Matches       []*Match               `protobuf:"bytes,2,rep,name=matches,proto3" json:"matches,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *NearResponse) Reset() {
+	*x = NearResponse{}
+	mi := &file_philifence_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NearResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NearResponse) ProtoMessage() {}
+
+func (x *NearResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_philifence_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NearResponse.ProtoReflect.Descriptor instead.
+func (*NearResponse) Descriptor() ([]byte, []int) {
+	return file_philifence_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *NearResponse) GetQuery() *Coordinate {
+	if x != nil {
+		return x.Query
+	}
+	return nil
+}
+
+func (x *NearResponse) GetMatches() []*Match {
+	if x != nil {
+		return x.Matches
+	}
+	return nil
+}
+
+type AddRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Layer         string                 `protobuf:"bytes,1,opt,name=layer,proto3" json:"layer,omitempty"`
+	Index         string                 `protobuf:"bytes,2,opt,name=index,proto3" json:"index,omitempty"`
+	Feature       *Feature               `protobuf:"bytes,3,opt,name=feature,proto3" json:"feature,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AddRequest) Reset() {
+	*x = AddRequest{}
+	mi := &file_philifence_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddRequest) ProtoMessage() {}
+
+func (x *AddRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_philifence_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddRequest.ProtoReflect.Descriptor instead.
+func (*AddRequest) Descriptor() ([]byte, []int) {
+	return file_philifence_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *AddRequest) GetLayer() string {
+	if x != nil {
+		return x.Layer
+	}
+	return ""
+}
+
+func (x *AddRequest) GetIndex() string {
+	if x != nil {
+		return x.Index
+	}
+	return ""
+}
+
+func (x *AddRequest) GetFeature() *Feature {
+	if x != nil {
+		return x.Feature
+	}
+	return nil
+}
+
+type AddResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AddResponse) Reset() {
+	*x = AddResponse{}
+	mi := &file_philifence_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddResponse) ProtoMessage() {}
+
+func (x *AddResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_philifence_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddResponse.ProtoReflect.Descriptor instead.
+func (*AddResponse) Descriptor() ([]byte, []int) {
+	return file_philifence_proto_rawDescGZIP(), []int{12}
+}
+
+type DeleteRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Layer         string                 `protobuf:"bytes,1,opt,name=layer,proto3" json:"layer,omitempty"`
+	Index         string                 `protobuf:"bytes,2,opt,name=index,proto3" json:"index,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteRequest) Reset() {
+	*x = DeleteRequest{}
+	mi := &file_philifence_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteRequest) ProtoMessage() {}
+
+func (x *DeleteRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_philifence_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteRequest.ProtoReflect.Descriptor instead.
+func (*DeleteRequest) Descriptor() ([]byte, []int) {
+	return file_philifence_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *DeleteRequest) GetLayer() string {
+	if x != nil {
+		return x.Layer
+	}
+	return ""
+}
+
+func (x *DeleteRequest) GetIndex() string {
+	if x != nil {
+		return x.Index
+	}
+	return ""
+}
+
+type DeleteResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteResponse) Reset() {
+	*x = DeleteResponse{}
+	mi := &file_philifence_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteResponse) ProtoMessage() {}
+
+func (x *DeleteResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_philifence_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteResponse.ProtoReflect.Descriptor instead.
+func (*DeleteResponse) Descriptor() ([]byte, []int) {
+	return file_philifence_proto_rawDescGZIP(), []int{14}
+}
+
+type BatchQuery struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Point         *Coordinate            `protobuf:"bytes,2,opt,name=point,proto3" json:"point,omitempty"`
+	Tolerance     float64                `protobuf:"fixed64,3,opt,name=tolerance,proto3" json:"tolerance,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchQuery) Reset() {
+	*x = BatchQuery{}
+	mi := &file_philifence_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchQuery) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchQuery) ProtoMessage() {}
+
+func (x *BatchQuery) ProtoReflect() protoreflect.Message {
+	mi := &file_philifence_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchQuery.ProtoReflect.Descriptor instead.
+func (*BatchQuery) Descriptor() ([]byte, []int) {
+	return file_philifence_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *BatchQuery) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *BatchQuery) GetPoint() *Coordinate {
+	if x != nil {
+		return x.Point
+	}
+	return nil
+}
+
+func (x *BatchQuery) GetTolerance() float64 {
+	if x != nil {
+		return x.Tolerance
+	}
+	return 0
+}
+
+type BatchRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Layer         string                 `protobuf:"bytes,1,opt,name=layer,proto3" json:"layer,omitempty"`
+	Index         string                 `protobuf:"bytes,2,opt,name=index,proto3" json:"index,omitempty"`
+	Queries       []*BatchQuery          `protobuf:"bytes,3,rep,name=queries,proto3" json:"queries,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchRequest) Reset() {
+	*x = BatchRequest{}
+	mi := &file_philifence_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchRequest) ProtoMessage() {}
+
+func (x *BatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_philifence_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchRequest.ProtoReflect.Descriptor instead.
+func (*BatchRequest) Descriptor() ([]byte, []int) {
+	return file_philifence_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *BatchRequest) GetLayer() string {
+	if x != nil {
+		return x.Layer
+	}
+	return ""
+}
+
+func (x *BatchRequest) GetIndex() string {
+	if x != nil {
+		return x.Index
+	}
+	return ""
+}
+
+func (x *BatchRequest) GetQueries() []*BatchQuery {
+	if x != nil {
+		return x.Queries
+	}
+	return nil
+}
+
+type BatchResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Result        []*structpb.Struct     `protobuf:"bytes,2,rep,name=result,proto3" json:"result,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchResult) Reset() {
+	*x = BatchResult{}
+	mi := &file_philifence_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchResult) ProtoMessage() {}
+
+func (x *BatchResult) ProtoReflect() protoreflect.Message {
+	mi := &file_philifence_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchResult.ProtoReflect.Descriptor instead.
+func (*BatchResult) Descriptor() ([]byte, []int) {
+	return file_philifence_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *BatchResult) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *BatchResult) GetResult() []*structpb.Struct {
+	if x != nil {
+		return x.Result
+	}
+	return nil
+}
+
+type LocationUpdate struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Layer         string                 `protobuf:"bytes,2,opt,name=layer,proto3" json:"layer,omitempty"`
+	Index         string                 `protobuf:"bytes,3,opt,name=index,proto3" json:"index,omitempty"`
+	Point         *Coordinate            `protobuf:"bytes,4,opt,name=point,proto3" json:"point,omitempty"`
+	Tolerance     float64                `protobuf:"fixed64,5,opt,name=tolerance,proto3" json:"tolerance,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LocationUpdate) Reset() {
+	*x = LocationUpdate{}
+	mi := &file_philifence_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LocationUpdate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LocationUpdate) ProtoMessage() {}
+
+func (x *LocationUpdate) ProtoReflect() protoreflect.Message {
+	mi := &file_philifence_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LocationUpdate.ProtoReflect.Descriptor instead.
+func (*LocationUpdate) Descriptor() ([]byte, []int) {
+	return file_philifence_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *LocationUpdate) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *LocationUpdate) GetLayer() string {
+	if x != nil {
+		return x.Layer
+	}
+	return ""
+}
+
+func (x *LocationUpdate) GetIndex() string {
+	if x != nil {
+		return x.Index
+	}
+	return ""
+}
+
+func (x *LocationUpdate) GetPoint() *Coordinate {
+	if x != nil {
+		return x.Point
+	}
+	return nil
+}
+
+func (x *LocationUpdate) GetTolerance() float64 {
+	if x != nil {
+		return x.Tolerance
+	}
+	return 0
+}
+
+type LocationResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Point         *Coordinate            `protobuf:"bytes,2,opt,name=point,proto3" json:"point,omitempty"`
+	Result        []*structpb.Struct     `protobuf:"bytes,3,rep,name=result,proto3" json:"result,omitempty"`
+	Error         string                 `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+	Events        []*Event               `protobuf:"bytes,5,rep,name=events,proto3" json:"events,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LocationResult) Reset() {
+	*x = LocationResult{}
+	mi := &file_philifence_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LocationResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LocationResult) ProtoMessage() {}
+
+func (x *LocationResult) ProtoReflect() protoreflect.Message {
+	mi := &file_philifence_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LocationResult.ProtoReflect.Descriptor instead.
+func (*LocationResult) Descriptor() ([]byte, []int) {
+	return file_philifence_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *LocationResult) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *LocationResult) GetPoint() *Coordinate {
+	if x != nil {
+		return x.Point
+	}
+	return nil
+}
+
+func (x *LocationResult) GetResult() []*structpb.Struct {
+	if x != nil {
+		return x.Result
+	}
+	return nil
+}
+
+func (x *LocationResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *LocationResult) GetEvents() []*Event {
+	if x != nil {
+		return x.Events
+	}
+	return nil
+}
+
+type Event struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Type          string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Layer         string                 `protobuf:"bytes,2,opt,name=layer,proto3" json:"layer,omitempty"`
+	Index         string                 `protobuf:"bytes,3,opt,name=index,proto3" json:"index,omitempty"`
+	Feature       string                 `protobuf:"bytes,4,opt,name=feature,proto3" json:"feature,omitempty"`
+	Device        string                 `protobuf:"bytes,5,opt,name=device,proto3" json:"device,omitempty"`
+	Point         *Coordinate            `protobuf:"bytes,6,opt,name=point,proto3" json:"point,omitempty"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=time,proto3" json:"time,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Event) Reset() {
+	*x = Event{}
+	mi := &file_philifence_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Event) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Event) ProtoMessage() {}
+
+func (x *Event) ProtoReflect() protoreflect.Message {
+	mi := &file_philifence_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Event.ProtoReflect.Descriptor instead.
+func (*Event) Descriptor() ([]byte, []int) {
+	return file_philifence_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *Event) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Event) GetLayer() string {
+	if x != nil {
+		return x.Layer
+	}
+	return ""
+}
+
+func (x *Event) GetIndex() string {
+	if x != nil {
+		return x.Index
+	}
+	return ""
+}
+
+func (x *Event) GetFeature() string {
+	if x != nil {
+		return x.Feature
+	}
+	return ""
+}
+
+func (x *Event) GetDevice() string {
+	if x != nil {
+		return x.Device
+	}
+	return ""
+}
+
+func (x *Event) GetPoint() *Coordinate {
+	if x != nil {
+		return x.Point
+	}
+	return nil
+}
+
+func (x *Event) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+var File_philifence_proto protoreflect.FileDescriptor
+
+const file_philifence_proto_rawDesc = "" +
+	"\n" +
+	"\x10philifence.proto\x12\rphilifence.v1\x1a\x1cgoogle/protobuf/struct.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"0\n" +
+	"\n" +
+	"Coordinate\x12\x10\n" +
+	"\x03lat\x18\x01 \x01(\x01R\x03lat\x12\x10\n" +
+	"\x03lon\x18\x02 \x01(\x01R\x03lon\"C\n" +
+	"\x04Ring\x12;\n" +
+	"\vcoordinates\x18\x01 \x03(\v2\x19.philifence.v1.CoordinateR\vcoordinates\"f\n" +
+	"\bGeometry\x12/\n" +
+	"\bexterior\x18\x01 \x01(\v2\x13.philifence.v1.RingR\bexterior\x12)\n" +
+	"\x05holes\x18\x02 \x03(\v2\x13.philifence.v1.RingR\x05holes\"\x9b\x01\n" +
+	"\aFeature\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04type\x18\x02 \x01(\tR\x04type\x123\n" +
+	"\bgeometry\x18\x03 \x03(\v2\x17.philifence.v1.GeometryR\bgeometry\x127\n" +
+	"\n" +
+	"properties\x18\x04 \x01(\v2\x17.google.protobuf.StructR\n" +
+	"properties\"I\n" +
+	"\x05Layer\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x12\n" +
+	"\x04kind\x18\x02 \x01(\tR\x04kind\x12\x18\n" +
+	"\aindices\x18\x03 \x03(\tR\aindices\"\x13\n" +
+	"\x11ListLayersRequest\"B\n" +
+	"\x12ListLayersResponse\x12,\n" +
+	"\x06layers\x18\x01 \x03(\v2\x14.philifence.v1.LayerR\x06layers\"\x87\x02\n" +
+	"\rSearchRequest\x12\x14\n" +
+	"\x05layer\x18\x01 \x01(\tR\x05layer\x12\x14\n" +
+	"\x05index\x18\x02 \x01(\tR\x05index\x12/\n" +
+	"\x05point\x18\x03 \x01(\v2\x19.philifence.v1.CoordinateR\x05point\x12\x1c\n" +
+	"\ttolerance\x18\x04 \x01(\x01R\ttolerance\x12@\n" +
+	"\x06params\x18\x05 \x03(\v2(.philifence.v1.SearchRequest.ParamsEntryR\x06params\x1a9\n" +
+	"\vParamsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"r\n" +
+	"\x0eSearchResponse\x12/\n" +
+	"\x05query\x18\x01 \x01(\v2\x19.philifence.v1.CoordinateR\x05query\x12/\n" +
+	"\x06result\x18\x02 \x03(\v2\x17.google.protobuf.StructR\x06result\"m\n" +
+	"\x05Match\x120\n" +
+	"\afeature\x18\x01 \x01(\v2\x16.philifence.v1.FeatureR\afeature\x12\x1a\n" +
+	"\bdistance\x18\x02 \x01(\x01R\bdistance\x12\x16\n" +
+	"\x06status\x18\x03 \x01(\tR\x06status\"o\n" +
+	"\fNearResponse\x12/\n" +
+	"\x05query\x18\x01 \x01(\v2\x19.philifence.v1.CoordinateR\x05query\x12.\n" +
+	"\amatches\x18\x02 \x03(\v2\x14.philifence.v1.MatchR\amatches\"j\n" +
+	"\n" +
+	"AddRequest\x12\x14\n" +
+	"\x05layer\x18\x01 \x01(\tR\x05layer\x12\x14\n" +
+	"\x05index\x18\x02 \x01(\tR\x05index\x120\n" +
+	"\afeature\x18\x03 \x01(\v2\x16.philifence.v1.FeatureR\afeature\"\r\n" +
+	"\vAddResponse\";\n" +
+	"\rDeleteRequest\x12\x14\n" +
+	"\x05layer\x18\x01 \x01(\tR\x05layer\x12\x14\n" +
+	"\x05index\x18\x02 \x01(\tR\x05index\"\x10\n" +
+	"\x0eDeleteResponse\"k\n" +
+	"\n" +
+	"BatchQuery\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12/\n" +
+	"\x05point\x18\x02 \x01(\v2\x19.philifence.v1.CoordinateR\x05point\x12\x1c\n" +
+	"\ttolerance\x18\x03 \x01(\x01R\ttolerance\"o\n" +
+	"\fBatchRequest\x12\x14\n" +
+	"\x05layer\x18\x01 \x01(\tR\x05layer\x12\x14\n" +
+	"\x05index\x18\x02 \x01(\tR\x05index\x123\n" +
+	"\aqueries\x18\x03 \x03(\v2\x19.philifence.v1.BatchQueryR\aqueries\"N\n" +
+	"\vBatchResult\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12/\n" +
+	"\x06result\x18\x02 \x03(\v2\x17.google.protobuf.StructR\x06result\"\x9b\x01\n" +
+	"\x0eLocationUpdate\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x14\n" +
+	"\x05layer\x18\x02 \x01(\tR\x05layer\x12\x14\n" +
+	"\x05index\x18\x03 \x01(\tR\x05index\x12/\n" +
+	"\x05point\x18\x04 \x01(\v2\x19.philifence.v1.CoordinateR\x05point\x12\x1c\n" +
+	"\ttolerance\x18\x05 \x01(\x01R\ttolerance\"\xc6\x01\n" +
+	"\x0eLocationResult\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12/\n" +
+	"\x05point\x18\x02 \x01(\v2\x19.philifence.v1.CoordinateR\x05point\x12/\n" +
+	"\x06result\x18\x03 \x03(\v2\x17.google.protobuf.StructR\x06result\x12\x14\n" +
+	"\x05error\x18\x04 \x01(\tR\x05error\x12,\n" +
+	"\x06events\x18\x05 \x03(\v2\x14.philifence.v1.EventR\x06events\"\xda\x01\n" +
+	"\x05Event\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12\x14\n" +
+	"\x05layer\x18\x02 \x01(\tR\x05layer\x12\x14\n" +
+	"\x05index\x18\x03 \x01(\tR\x05index\x12\x18\n" +
+	"\afeature\x18\x04 \x01(\tR\afeature\x12\x16\n" +
+	"\x06device\x18\x05 \x01(\tR\x06device\x12/\n" +
+	"\x05point\x18\x06 \x01(\v2\x19.philifence.v1.CoordinateR\x05point\x12.\n" +
+	"\x04time\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\x04time2\x83\x04\n" +
+	"\n" +
+	"PhiliFence\x12Q\n" +
+	"\n" +
+	"ListLayers\x12 .philifence.v1.ListLayersRequest\x1a!.philifence.v1.ListLayersResponse\x12E\n" +
+	"\x06Search\x12\x1c.philifence.v1.SearchRequest\x1a\x1d.philifence.v1.SearchResponse\x12A\n" +
+	"\x04Near\x12\x1c.philifence.v1.SearchRequest\x1a\x1b.philifence.v1.NearResponse\x12<\n" +
+	"\x03Add\x12\x19.philifence.v1.AddRequest\x1a\x1a.philifence.v1.AddResponse\x12E\n" +
+	"\x06Delete\x12\x1c.philifence.v1.DeleteRequest\x1a\x1d.philifence.v1.DeleteResponse\x12H\n" +
+	"\vSearchBatch\x12\x1b.philifence.v1.BatchRequest\x1a\x1a.philifence.v1.BatchResult0\x01\x12I\n" +
+	"\x05Track\x12\x1d.philifence.v1.LocationUpdate\x1a\x1d.philifence.v1.LocationResult(\x010\x01B,Z*github.com/jtejido/philifence/philifencepbb\x06proto3"
+
+var (
+	file_philifence_proto_rawDescOnce sync.Once
+	file_philifence_proto_rawDescData []byte
+)
+
+func file_philifence_proto_rawDescGZIP() []byte {
+	file_philifence_proto_rawDescOnce.Do(func() {
+		file_philifence_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_philifence_proto_rawDesc), len(file_philifence_proto_rawDesc)))
+	})
+	return file_philifence_proto_rawDescData
+}
+
+var file_philifence_proto_msgTypes = make([]protoimpl.MessageInfo, 22)
+var file_philifence_proto_goTypes = []any{
+	(*Coordinate)(nil),            // 0: philifence.v1.Coordinate
+	(*Ring)(nil),                  // 1: philifence.v1.Ring
+	(*Geometry)(nil),              // 2: philifence.v1.Geometry
+	(*Feature)(nil),               // 3: philifence.v1.Feature
+	(*Layer)(nil),                 // 4: philifence.v1.Layer
+	(*ListLayersRequest)(nil),     // 5: philifence.v1.ListLayersRequest
+	(*ListLayersResponse)(nil),    // 6: philifence.v1.ListLayersResponse
+	(*SearchRequest)(nil),         // 7: philifence.v1.SearchRequest
+	(*SearchResponse)(nil),        // 8: philifence.v1.SearchResponse
+	(*Match)(nil),                 // 9: philifence.v1.Match
+	(*NearResponse)(nil),          // 10: philifence.v1.NearResponse
+	(*AddRequest)(nil),            // 11: philifence.v1.AddRequest
+	(*AddResponse)(nil),           // 12: philifence.v1.AddResponse
+	(*DeleteRequest)(nil),         // 13: philifence.v1.DeleteRequest
+	(*DeleteResponse)(nil),        // 14: philifence.v1.DeleteResponse
+	(*BatchQuery)(nil),            // 15: philifence.v1.BatchQuery
+	(*BatchRequest)(nil),          // 16: philifence.v1.BatchRequest
+	(*BatchResult)(nil),           // 17: philifence.v1.BatchResult
+	(*LocationUpdate)(nil),        // 18: philifence.v1.LocationUpdate
+	(*LocationResult)(nil),        // 19: philifence.v1.LocationResult
+	(*Event)(nil),                 // 20: philifence.v1.Event
+	nil,                           // 21: philifence.v1.SearchRequest.ParamsEntry
+	(*structpb.Struct)(nil),       // 22: google.protobuf.Struct
+	(*timestamppb.Timestamp)(nil), // 23: google.protobuf.Timestamp
+}
+var file_philifence_proto_depIdxs = []int32{
+	0,  // 0: philifence.v1.Ring.coordinates:type_name -> philifence.v1.Coordinate
+	1,  // 1: philifence.v1.Geometry.exterior:type_name -> philifence.v1.Ring
+	1,  // 2: philifence.v1.Geometry.holes:type_name -> philifence.v1.Ring
+	2,  // 3: philifence.v1.Feature.geometry:type_name -> philifence.v1.Geometry
+	22, // 4: philifence.v1.Feature.properties:type_name -> google.protobuf.Struct
+	4,  // 5: philifence.v1.ListLayersResponse.layers:type_name -> philifence.v1.Layer
+	0,  // 6: philifence.v1.SearchRequest.point:type_name -> philifence.v1.Coordinate
+	21, // 7: philifence.v1.SearchRequest.params:type_name -> philifence.v1.SearchRequest.ParamsEntry
+	0,  // 8: philifence.v1.SearchResponse.query:type_name -> philifence.v1.Coordinate
+	22, // 9: philifence.v1.SearchResponse.result:type_name -> google.protobuf.Struct
+	3,  // 10: philifence.v1.Match.feature:type_name -> philifence.v1.Feature
+	0,  // 11: philifence.v1.NearResponse.query:type_name -> philifence.v1.Coordinate
+	9,  // 12: philifence.v1.NearResponse.matches:type_name -> philifence.v1.Match
+	3,  // 13: philifence.v1.AddRequest.feature:type_name -> philifence.v1.Feature
+	0,  // 14: philifence.v1.BatchQuery.point:type_name -> philifence.v1.Coordinate
+	15, // 15: philifence.v1.BatchRequest.queries:type_name -> philifence.v1.BatchQuery
+	22, // 16: philifence.v1.BatchResult.result:type_name -> google.protobuf.Struct
+	0,  // 17: philifence.v1.LocationUpdate.point:type_name -> philifence.v1.Coordinate
+	0,  // 18: philifence.v1.LocationResult.point:type_name -> philifence.v1.Coordinate
+	22, // 19: philifence.v1.LocationResult.result:type_name -> google.protobuf.Struct
+	20, // 20: philifence.v1.LocationResult.events:type_name -> philifence.v1.Event
+	0,  // 21: philifence.v1.Event.point:type_name -> philifence.v1.Coordinate
+	23, // 22: philifence.v1.Event.time:type_name -> google.protobuf.Timestamp
+	5,  // 23: philifence.v1.PhiliFence.ListLayers:input_type -> philifence.v1.ListLayersRequest
+	7,  // 24: philifence.v1.PhiliFence.Search:input_type -> philifence.v1.SearchRequest
+	7,  // 25: philifence.v1.PhiliFence.Near:input_type -> philifence.v1.SearchRequest
+	11, // 26: philifence.v1.PhiliFence.Add:input_type -> philifence.v1.AddRequest
+	13, // 27: philifence.v1.PhiliFence.Delete:input_type -> philifence.v1.DeleteRequest
+	16, // 28: philifence.v1.PhiliFence.SearchBatch:input_type -> philifence.v1.BatchRequest
+	18, // 29: philifence.v1.PhiliFence.Track:input_type -> philifence.v1.LocationUpdate
+	6,  // 30: philifence.v1.PhiliFence.ListLayers:output_type -> philifence.v1.ListLayersResponse
+	8,  // 31: philifence.v1.PhiliFence.Search:output_type -> philifence.v1.SearchResponse
+	10, // 32: philifence.v1.PhiliFence.Near:output_type -> philifence.v1.NearResponse
+	12, // 33: philifence.v1.PhiliFence.Add:output_type -> philifence.v1.AddResponse
+	14, // 34: philifence.v1.PhiliFence.Delete:output_type -> philifence.v1.DeleteResponse
+	17, // 35: philifence.v1.PhiliFence.SearchBatch:output_type -> philifence.v1.BatchResult
+	19, // 36: philifence.v1.PhiliFence.Track:output_type -> philifence.v1.LocationResult
+	30, // [30:37] is the sub-list for method output_type
+	23, // [23:30] is the sub-list for method input_type
+	23, // [23:23] is the sub-list for extension type_name
+	23, // [23:23] is the sub-list for extension extendee
+	0,  // [0:23] is the sub-list for field type_name
+}
+
+func init() { file_philifence_proto_init() }
+func file_philifence_proto_init() {
+	if File_philifence_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_philifence_proto_rawDesc), len(file_philifence_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   22,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_philifence_proto_goTypes,
+		DependencyIndexes: file_philifence_proto_depIdxs,
+		MessageInfos:      file_philifence_proto_msgTypes,
+	}.Build()
+	File_philifence_proto = out.File
+	file_philifence_proto_goTypes = nil
+	file_philifence_proto_depIdxs = nil
+}
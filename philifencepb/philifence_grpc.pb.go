@@ -0,0 +1,347 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: philifence.proto
+
+package philifencepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	PhiliFence_ListLayers_FullMethodName  = "/philifence.v1.PhiliFence/ListLayers"
+	PhiliFence_Search_FullMethodName      = "/philifence.v1.PhiliFence/Search"
+	PhiliFence_Near_FullMethodName        = "/philifence.v1.PhiliFence/Near"
+	PhiliFence_Add_FullMethodName         = "/philifence.v1.PhiliFence/Add"
+	PhiliFence_Delete_FullMethodName      = "/philifence.v1.PhiliFence/Delete"
+	PhiliFence_SearchBatch_FullMethodName = "/philifence.v1.PhiliFence/SearchBatch"
+	PhiliFence_Track_FullMethodName       = "/philifence.v1.PhiliFence/Track"
+)
+
+// PhiliFenceClient is the client API for PhiliFence service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type PhiliFenceClient interface {
+	ListLayers(ctx context.Context, in *ListLayersRequest, opts ...grpc.CallOption) (*ListLayersResponse, error)
+	Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error)
+	Near(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*NearResponse, error)
+	Add(ctx context.Context, in *AddRequest, opts ...grpc.CallOption) (*AddResponse, error)
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+	SearchBatch(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[BatchResult], error)
+	Track(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[LocationUpdate, LocationResult], error)
+}
+
+type philiFenceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPhiliFenceClient(cc grpc.ClientConnInterface) PhiliFenceClient {
+	return &philiFenceClient{cc}
+}
+
+func (c *philiFenceClient) ListLayers(ctx context.Context, in *ListLayersRequest, opts ...grpc.CallOption) (*ListLayersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListLayersResponse)
+	err := c.cc.Invoke(ctx, PhiliFence_ListLayers_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *philiFenceClient) Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SearchResponse)
+	err := c.cc.Invoke(ctx, PhiliFence_Search_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *philiFenceClient) Near(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*NearResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(NearResponse)
+	err := c.cc.Invoke(ctx, PhiliFence_Near_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *philiFenceClient) Add(ctx context.Context, in *AddRequest, opts ...grpc.CallOption) (*AddResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AddResponse)
+	err := c.cc.Invoke(ctx, PhiliFence_Add_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *philiFenceClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, PhiliFence_Delete_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *philiFenceClient) SearchBatch(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[BatchResult], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &PhiliFence_ServiceDesc.Streams[0], PhiliFence_SearchBatch_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[BatchRequest, BatchResult]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PhiliFence_SearchBatchClient = grpc.ServerStreamingClient[BatchResult]
+
+func (c *philiFenceClient) Track(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[LocationUpdate, LocationResult], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &PhiliFence_ServiceDesc.Streams[1], PhiliFence_Track_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[LocationUpdate, LocationResult]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PhiliFence_TrackClient = grpc.BidiStreamingClient[LocationUpdate, LocationResult]
+
+// PhiliFenceServer is the server API for PhiliFence service.
+// All implementations must embed UnimplementedPhiliFenceServer
+// for forward compatibility.
+type PhiliFenceServer interface {
+	ListLayers(context.Context, *ListLayersRequest) (*ListLayersResponse, error)
+	Search(context.Context, *SearchRequest) (*SearchResponse, error)
+	Near(context.Context, *SearchRequest) (*NearResponse, error)
+	Add(context.Context, *AddRequest) (*AddResponse, error)
+	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	SearchBatch(*BatchRequest, grpc.ServerStreamingServer[BatchResult]) error
+	Track(grpc.BidiStreamingServer[LocationUpdate, LocationResult]) error
+	mustEmbedUnimplementedPhiliFenceServer()
+}
+
+// UnimplementedPhiliFenceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedPhiliFenceServer struct{}
+
+func (UnimplementedPhiliFenceServer) ListLayers(context.Context, *ListLayersRequest) (*ListLayersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListLayers not implemented")
+}
+func (UnimplementedPhiliFenceServer) Search(context.Context, *SearchRequest) (*SearchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Search not implemented")
+}
+func (UnimplementedPhiliFenceServer) Near(context.Context, *SearchRequest) (*NearResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Near not implemented")
+}
+func (UnimplementedPhiliFenceServer) Add(context.Context, *AddRequest) (*AddResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Add not implemented")
+}
+func (UnimplementedPhiliFenceServer) Delete(context.Context, *DeleteRequest) (*DeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedPhiliFenceServer) SearchBatch(*BatchRequest, grpc.ServerStreamingServer[BatchResult]) error {
+	return status.Errorf(codes.Unimplemented, "method SearchBatch not implemented")
+}
+func (UnimplementedPhiliFenceServer) Track(grpc.BidiStreamingServer[LocationUpdate, LocationResult]) error {
+	return status.Errorf(codes.Unimplemented, "method Track not implemented")
+}
+func (UnimplementedPhiliFenceServer) mustEmbedUnimplementedPhiliFenceServer() {}
+func (UnimplementedPhiliFenceServer) testEmbeddedByValue()                    {}
+
+// UnsafePhiliFenceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PhiliFenceServer will
+// result in compilation errors.
+type UnsafePhiliFenceServer interface {
+	mustEmbedUnimplementedPhiliFenceServer()
+}
+
+func RegisterPhiliFenceServer(s grpc.ServiceRegistrar, srv PhiliFenceServer) {
+	// If the following call pancis, it indicates UnimplementedPhiliFenceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&PhiliFence_ServiceDesc, srv)
+}
+
+func _PhiliFence_ListLayers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListLayersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PhiliFenceServer).ListLayers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PhiliFence_ListLayers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PhiliFenceServer).ListLayers(ctx, req.(*ListLayersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PhiliFence_Search_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PhiliFenceServer).Search(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PhiliFence_Search_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PhiliFenceServer).Search(ctx, req.(*SearchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PhiliFence_Near_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PhiliFenceServer).Near(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PhiliFence_Near_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PhiliFenceServer).Near(ctx, req.(*SearchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PhiliFence_Add_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PhiliFenceServer).Add(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PhiliFence_Add_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PhiliFenceServer).Add(ctx, req.(*AddRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PhiliFence_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PhiliFenceServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PhiliFence_Delete_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PhiliFenceServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PhiliFence_SearchBatch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(BatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(PhiliFenceServer).SearchBatch(m, &grpc.GenericServerStream[BatchRequest, BatchResult]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PhiliFence_SearchBatchServer = grpc.ServerStreamingServer[BatchResult]
+
+func _PhiliFence_Track_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PhiliFenceServer).Track(&grpc.GenericServerStream[LocationUpdate, LocationResult]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PhiliFence_TrackServer = grpc.BidiStreamingServer[LocationUpdate, LocationResult]
+
+// PhiliFence_ServiceDesc is the grpc.ServiceDesc for PhiliFence service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var PhiliFence_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "philifence.v1.PhiliFence",
+	HandlerType: (*PhiliFenceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListLayers",
+			Handler:    _PhiliFence_ListLayers_Handler,
+		},
+		{
+			MethodName: "Search",
+			Handler:    _PhiliFence_Search_Handler,
+		},
+		{
+			MethodName: "Near",
+			Handler:    _PhiliFence_Near_Handler,
+		},
+		{
+			MethodName: "Add",
+			Handler:    _PhiliFence_Add_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _PhiliFence_Delete_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SearchBatch",
+			Handler:       _PhiliFence_SearchBatch_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Track",
+			Handler:       _PhiliFence_Track_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "philifence.proto",
+}
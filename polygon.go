@@ -1,5 +1,9 @@
 package philifence
 
+import (
+	"math"
+)
+
 type PolyRing struct {
 	Coordinates []Coordinate
 	Box 		Box
@@ -130,6 +134,7 @@ func (pr *PolyRing) isClockwise() bool {
 type Polygon struct {
 	Exterior *PolyRing
 	Holes []*PolyRing
+	prepared *preparedPolygon
 }
 
 func MakePoly(length int) *Polygon {
@@ -140,11 +145,61 @@ func NewPoly(coords ...Coordinate) *Polygon {
 	return &Polygon{Exterior: NewPolyRing(coords...)}
 }
 
+// NewPolygon builds a polygon from an exterior ring and any holes, closing rings that are
+// left open. Every ring needs at least three distinct coordinates.
+func NewPolygon(exterior []Coordinate, holes ...[]Coordinate) (poly *Polygon, err error) {
+	ring, err := newClosedRing(exterior)
+	if err != nil {
+		return nil, err
+	}
+	poly = &Polygon{Exterior: ring}
+	for _, hole := range holes {
+		if ring, err = newClosedRing(hole); err != nil {
+			return nil, err
+		}
+		poly.Holes = append(poly.Holes, ring)
+	}
+	return
+}
+
+// NewLine builds a line through at least two coordinates
+func NewLine(coords ...Coordinate) (*Polygon, error) {
+	if len(coords) < 2 {
+		return nil, errorf("Line needs at least 2 coordinates, got %d", len(coords))
+	}
+	for _, c := range coords {
+		if err := c.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return NewPoly(coords...), nil
+}
+
+func newClosedRing(coords []Coordinate) (*PolyRing, error) {
+	for _, c := range coords {
+		if err := c.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if n := len(coords); n > 0 && coords[0] != coords[n-1] {
+		coords = append(coords[:n:n], coords[0])
+	}
+	if len(coords) < 4 {
+		return nil, errorf("Ring needs at least 3 distinct coordinates, got %d", len(coords)-1)
+	}
+	return NewPolyRing(coords...), nil
+}
+
 func (poly *Polygon) Add(c ...Coordinate) {
 	poly.Exterior.Add(c...)
+	poly.prepared = nil
 }
 
 func (poly *Polygon) Contains(c Coordinate) (ok bool) {
+	if poly.prepared != nil {
+		return poly.prepared.contains(c)
+	}
+
 	ok = poly.Exterior.computeWindingNumber(c) != 0
 
 	if ok {
@@ -181,4 +236,20 @@ func NewBox(min, max Coordinate) (box Box, err error) {
 	box = Box{min: min, max: max}
 
 	return
-}
\ No newline at end of file
+}
+
+func (b Box) intersects(o Box) bool {
+	return b.min.lat <= o.max.lat && o.min.lat <= b.max.lat &&
+		b.min.lon <= o.max.lon && o.min.lon <= b.max.lon
+}
+
+func (b Box) extend(o Box) Box {
+	return Box{
+		min: Coordinate{lat: math.Min(b.min.lat, o.min.lat), lon: math.Min(b.min.lon, o.min.lon)},
+		max: Coordinate{lat: math.Max(b.max.lat, o.max.lat), lon: math.Max(b.max.lon, o.max.lon)},
+	}
+}
+
+func (b Box) center() Coordinate {
+	return Coordinate{lat: (b.min.lat + b.max.lat) / 2, lon: (b.min.lon + b.max.lon) / 2}
+}
@@ -1,6 +1,10 @@
 package philifence
 
-import "testing"
+import (
+	"math"
+	"net/url"
+	"testing"
+)
 
 func TestReversePolygon(t *testing.T) {
 	vals := []float64{5, 4, 3, 2, 1, 0}
@@ -72,3 +76,93 @@ func TestContains(t *testing.T) {
 func cd(x, y float64) Coordinate {
 	return Coordinate{x, y}
 }
+
+func TestMetrics(t *testing.T) {
+	poly := square(0, 0, 2)
+	poly.Holes = []*PolyRing{square(0.5, 0.5, 1).Exterior}
+	feature := NewPolygonFeature(poly)
+	m := feature.Metrics()
+
+	band := func(lat1, lat2, dlon float64) float64 {
+		return earthRadius * earthRadius * dlon * radians * (math.Sin(lat2*radians) - math.Sin(lat1*radians))
+	}
+	want := band(0, 2, 2) - band(0.5, 1.5, 1)
+	if math.Abs(m.Area-want)/want > 1e-3 {
+		t.Errorf("Wrong area %f, expected %f", m.Area, want)
+	}
+	if m.Perimeter < 12*111000 || m.Perimeter > 12*111400 {
+		t.Errorf("Wrong perimeter %f", m.Perimeter)
+	}
+	if math.Abs(m.Centroid.lat-1) > 1e-9 || math.Abs(m.Centroid.lon-1) > 1e-9 {
+		t.Errorf("Wrong centroid %v", m.Centroid)
+	}
+	// the centroid lies in the hole
+	if !poly.Contains(m.PointOnSurface) {
+		t.Errorf("Point on surface %v is outside %v", m.PointOnSurface, poly)
+	}
+
+	line := NewLineFeature(NewPoly(cd(0, 0), cd(0, 1), cd(0, 3)))
+	m = line.Metrics()
+	if want := haversine(cd(0, 0), cd(0, 3)); math.Abs(m.Length-want) > 1e-6 {
+		t.Errorf("Wrong length %f, expected %f", m.Length, want)
+	}
+	if m.Centroid != cd(0, 1.5) || m.PointOnSurface != cd(0, 1) {
+		t.Errorf("Wrong line centroid %v or point on surface %v", m.Centroid, m.PointOnSurface)
+	}
+}
+
+func TestDistance(t *testing.T) {
+	poly := square(0, 0, 2)
+	poly.Holes = []*PolyRing{square(0.5, 0.5, 1).Exterior}
+	feature := NewPolygonFeature(poly)
+	deg := radians * earthRadius
+
+	tests := []struct {
+		c    Coordinate
+		want float64
+	}{
+		{cd(0.25, 1), 0.25 * deg},  // inside, nearest the southern edge
+		{cd(1, 1), -0.5 * deg},     // in the hole
+		{cd(1, 0.4), 0.1 * deg},    // inside, nearest the hole
+		{cd(1, 2.01), -0.01 * deg}, // east of the fence
+		{cd(-0.5, 1), -0.5 * deg},  // south of the fence
+	}
+	for _, test := range tests {
+		got := feature.Distance(test.c)
+		if math.Abs(got-test.want) > math.Abs(test.want)*0.01 {
+			t.Errorf("Distance from %v got %f, expected %f", test.c, got, test.want)
+		}
+	}
+}
+
+func TestFenceNear(t *testing.T) {
+	fence, _ := NewFence()
+	fence.Add(NewPolygonFeature(square(0, 0, 0.01)))
+	// ~111m east of the fence
+	c := cd(0.005, 0.011)
+	if matchs := fence.Near(c, 50); len(matchs) != 0 {
+		t.Errorf("Expected no fence within 50m, got %v", matchs)
+	}
+	matchs := fence.Near(c, 200)
+	if len(matchs) != 1 || matchs[0].Status != StatusNear || matchs[0].Distance > 0 {
+		t.Errorf("Expected a near fence within 200m, got %v", matchs)
+	}
+	matchs = fence.Near(cd(0.005, 0.005), 1)
+	if len(matchs) != 1 || matchs[0].Status != StatusInside {
+		t.Errorf("Expected to be inside the fence, got %v", matchs)
+	}
+}
+
+func TestSearchFenceDistance(t *testing.T) {
+	idx := NewFenceIndex()
+	fence, _ := newFenceOf([]*Feature{NewPolygonFeature(square(0, 0, 2))})
+	idx.Set("cities", fence)
+	fences, err := searchFence(idx, "cities", cd(1, 1), 0, url.Values{})
+	if err != nil || len(fences) != 1 || fences[0]["distance"] != nil {
+		t.Errorf("Expected no distance unless asked for, got %v %v", fences, err)
+	}
+	fences, err = searchFence(idx, "cities", cd(1, 1), 0, url.Values{"distance": {"true"}})
+	if err != nil || len(fences) != 1 || fences[0]["distance"] == nil || fences[0]["status"] != StatusInside {
+		t.Errorf("Expected the distance with distance=true, got %v %v", fences, err)
+	}
+}
@@ -0,0 +1,157 @@
+package philifence
+
+import (
+	"math"
+)
+
+var (
+	PrepareThreshold  = 64 // polygons with at least this many vertices are prepared by Fence.Add
+	PreparedGridSize  = 32 // cells per side of the interior grid
+	PreparedBandEdges = 4  // average edges per band of the edge index
+)
+
+const (
+	cellOutside uint8 = iota
+	cellInside
+	cellBoundary
+)
+
+// preparedPolygon answers point-in-polygon tests without walking every vertex. A grid
+// over the box tells which cells are known to be inside or outside, and points in cells
+// the boundary runs through are tested against the few edges in their latitude band.
+type preparedPolygon struct {
+	box          Box
+	rings        []*preparedRing // exterior first
+	cells        []uint8
+	cellW, cellH float64
+}
+
+// edges bucketed into horizontal bands, any edge crossing a latitude is in its band
+type preparedRing struct {
+	minLat, bandH float64
+	bands         [][][2]Coordinate
+}
+
+// prepare builds the prepared representation that Contains will use from now on
+func (poly *Polygon) prepare() {
+	p := &preparedPolygon{box: poly.computeBox()}
+	for _, ring := range poly.rings() {
+		p.rings = append(p.rings, newPreparedRing(ring, p.box))
+	}
+
+	n := PreparedGridSize
+	p.cells = make([]uint8, n*n)
+	p.cellW = (p.box.max.lon - p.box.min.lon) / float64(n)
+	p.cellH = (p.box.max.lat - p.box.min.lat) / float64(n)
+	poly.eachSegment(func(a, b Coordinate) bool {
+		box := segmentBox(a, b)
+		x0, y0 := p.cell(box.min)
+		x1, y1 := p.cell(box.max)
+		for y := y0; y <= y1; y++ {
+			for x := x0; x <= x1; x++ {
+				p.cells[y*n+x] = cellBoundary
+			}
+		}
+		return true
+	})
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			if p.cells[y*n+x] == cellBoundary {
+				continue
+			}
+			center := Coordinate{
+				lat: p.box.min.lat + (float64(y)+0.5)*p.cellH,
+				lon: p.box.min.lon + (float64(x)+0.5)*p.cellW,
+			}
+			if p.exactContains(center) {
+				p.cells[y*n+x] = cellInside
+			}
+		}
+	}
+	poly.prepared = p
+}
+
+func newPreparedRing(ring *PolyRing, box Box) *preparedRing {
+	n := ring.Len()/PreparedBandEdges + 1
+	r := &preparedRing{
+		minLat: box.min.lat,
+		bandH:  (box.max.lat - box.min.lat) / float64(n),
+		bands:  make([][][2]Coordinate, n),
+	}
+	ring.eachSegment(func(a, b Coordinate) bool {
+		lo, hi := r.band(math.Min(a.lat, b.lat)), r.band(math.Max(a.lat, b.lat))
+		for i := lo; i <= hi; i++ {
+			r.bands[i] = append(r.bands[i], [2]Coordinate{a, b})
+		}
+		return true
+	})
+	return r
+}
+
+func (r *preparedRing) band(lat float64) int {
+	if r.bandH <= 0 {
+		return 0
+	}
+	return clampIndex(int((lat-r.minLat)/r.bandH), len(r.bands))
+}
+
+// same as PolyRing.computeWindingNumber, over the edges of a single band
+func (r *preparedRing) computeWindingNumber(q Coordinate) (wn int) {
+	for _, e := range r.bands[r.band(q.lat)] {
+		if e[0].lat <= q.lat {
+			if e[1].lat > q.lat && isLeft(e[0], e[1], q) > 0 {
+				wn++
+			}
+		} else if e[1].lat <= q.lat && isLeft(e[0], e[1], q) < 0 {
+			wn--
+		}
+	}
+	return
+}
+
+func (p *preparedPolygon) cell(c Coordinate) (x, y int) {
+	n := PreparedGridSize
+	if p.cellW > 0 {
+		x = clampIndex(int((c.lon-p.box.min.lon)/p.cellW), n)
+	}
+	if p.cellH > 0 {
+		y = clampIndex(int((c.lat-p.box.min.lat)/p.cellH), n)
+	}
+	return
+}
+
+func (p *preparedPolygon) contains(c Coordinate) bool {
+	if c.lat < p.box.min.lat || c.lat > p.box.max.lat || c.lon < p.box.min.lon || c.lon > p.box.max.lon {
+		return false
+	}
+	x, y := p.cell(c)
+	switch p.cells[y*PreparedGridSize+x] {
+	case cellInside:
+		return true
+	case cellOutside:
+		return false
+	}
+	return p.exactContains(c)
+}
+
+func (p *preparedPolygon) exactContains(c Coordinate) bool {
+	if p.rings[0].computeWindingNumber(c) == 0 {
+		return false
+	}
+	for _, hole := range p.rings[1:] {
+		if hole.computeWindingNumber(c) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func clampIndex(i, n int) int {
+	if i < 0 {
+		return 0
+	}
+	if i >= n {
+		return n - 1
+	}
+	return i
+}
@@ -0,0 +1,79 @@
+package philifence
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// a jagged, concave boundary with a hole, shaped like a GADM city of n vertices
+func jaggedPolygon(n int, rnd *rand.Rand) *Polygon {
+	ring := func(n int, lat, lon, radius float64) *PolyRing {
+		ring := MakePolyRing(n + 1)
+		for i := 0; i < n; i++ {
+			θ := 2 * math.Pi * float64(i) / float64(n)
+			r := radius * (1 + 0.3*math.Sin(7*θ) + 0.05*rnd.Float64())
+			ring.Coordinates[i] = cd(lat+r*math.Sin(θ), lon+r*math.Cos(θ))
+		}
+		ring.Coordinates[n] = ring.Coordinates[0]
+		return ring
+	}
+	poly := &Polygon{Exterior: ring(n, 10.3, 123.9, 0.1)}
+	hole := ring(n/10, 10.3, 123.9, 0.02)
+	hole.reverse()
+	poly.Holes = []*PolyRing{hole}
+	return poly
+}
+
+func randomPoints(n int, box Box, rnd *rand.Rand) []Coordinate {
+	points := make([]Coordinate, n)
+	for i := range points {
+		points[i] = cd(
+			box.min.lat+rnd.Float64()*(box.max.lat-box.min.lat),
+			box.min.lon+rnd.Float64()*(box.max.lon-box.min.lon),
+		)
+	}
+	return points
+}
+
+func TestPreparedContains(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	plain := jaggedPolygon(5000, rnd)
+	prepared := &Polygon{Exterior: plain.Exterior, Holes: plain.Holes}
+	prepared.prepare()
+
+	inside := 0
+	for _, c := range randomPoints(20000, plain.computeBox(), rnd) {
+		want := plain.Contains(c)
+		if got := prepared.Contains(c); got != want {
+			t.Fatalf("Prepared polygon contains %v: %v, expected %v", c, got, want)
+		}
+		if want {
+			inside++
+		}
+	}
+	if inside == 0 {
+		t.Errorf("No points were inside the polygon")
+	}
+}
+
+func benchmarkContains(b *testing.B, prepare bool) {
+	rnd := rand.New(rand.NewSource(1))
+	poly := jaggedPolygon(20000, rnd)
+	if prepare {
+		poly.prepare()
+	}
+	points := randomPoints(1024, poly.computeBox(), rnd)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		poly.Contains(points[i%len(points)])
+	}
+}
+
+func BenchmarkContainsPlain(b *testing.B) {
+	benchmarkContains(b, false)
+}
+
+func BenchmarkContainsPrepared(b *testing.B) {
+	benchmarkContains(b, true)
+}
@@ -0,0 +1,270 @@
+package philifence
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	ReloadQueued  = "queued"
+	ReloadLoading = "loading"
+	ReloadLoaded  = "loaded"
+	ReloadFailed  = "failed"
+	ReloadRemoved = "removed"
+)
+
+// ReloadStatus is the state of the last reload of an index from its file
+type ReloadStatus struct {
+	Index    string    `json:"index"`
+	Path     string    `json:"path"`
+	State    string    `json:"state"`
+	Features int       `json:"features"`
+	Error    string    `json:"error,omitempty"`
+	Started  time.Time `json:"started,omitempty"`
+	Finished time.Time `json:"finished,omitempty"`
+}
+
+// reloads a watcher holds before turning more away with ErrReloadBusy
+var ReloadQueueSize = 64
+
+var ErrReloadBusy = errorf("Reload queue is full")
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// Watcher polls a dataset directory and rebuilds the fence of every geojson file that is
+// added or changed, one at a time in the background, swapping it into the index with Update
+// once built. The old fence keeps serving searches meanwhile. A file is only picked up
+// once it has been left alone for a whole interval, so half-written files are skipped.
+//
+// The file is the whole of the index: a reload deletes the features added through the api
+// since, as it does any other feature missing from the file, keeping them in the history.
+type Watcher struct {
+	pattern  string
+	idx      FenceIndex
+	interval time.Duration
+	load     func(path string) (*Fence, int, error)
+	key      func(path string) string // index name of a file
+	seen     map[string]fileStamp     // by path, at the last scan
+	loaded   map[string]fileStamp     // by path, at the last reload
+	queued   map[string]bool
+	statuses map[string]*ReloadStatus // by index
+	queue    chan string
+	stop     chan struct{}
+	mu       sync.Mutex
+}
+
+// NewWatcher watches the directory an index was loaded from with LoadIndex, taking the
+// files as they are now as already loaded
+func NewWatcher(dir string, idx FenceIndex, interval time.Duration) *Watcher {
+	return newWatcher(filepath.Join(dir, "*json"), idx, interval, LoadFence, sluggify)
+}
+
+func newWatcher(pattern string, idx FenceIndex, interval time.Duration, load func(string) (*Fence, int, error), key func(string) string) *Watcher {
+	w := &Watcher{
+		pattern:  pattern,
+		idx:      idx,
+		interval: interval,
+		load:     load,
+		key:      key,
+		seen:     make(map[string]fileStamp),
+		loaded:   make(map[string]fileStamp),
+		queued:   make(map[string]bool),
+		statuses: make(map[string]*ReloadStatus),
+		queue:    make(chan string, ReloadQueueSize),
+		stop:     make(chan struct{}),
+	}
+	paths, _ := w.paths()
+	for _, path := range paths {
+		if stamp, err := statFile(path); err == nil {
+			w.seen[path], w.loaded[path] = stamp, stamp
+			w.statuses[w.key(path)] = &ReloadStatus{Index: w.key(path), Path: path, State: ReloadLoaded}
+		}
+	}
+	return w
+}
+
+// Start polls the directory every interval, an interval of 0 only serves manual reloads
+func (w *Watcher) Start() {
+	go w.work()
+	if w.interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+				w.scan()
+			}
+		}
+	}()
+}
+
+func (w *Watcher) Stop() {
+	close(w.stop)
+}
+
+// Reload queues a rebuild of the named index, or of every index when name is empty. It
+// returns ErrReloadBusy when the queue is too full to take them all, queueing what it can.
+func (w *Watcher) Reload(name string) error {
+	paths, err := w.paths()
+	if err != nil {
+		return err
+	}
+	found, busy := false, false
+	for _, path := range paths {
+		if name == "" || w.key(path) == name {
+			found = true
+			busy = !w.enqueue(path) || busy
+		}
+	}
+	if !found {
+		return errorf("No geojson file for index %q at %s", name, w.pattern)
+	}
+	if busy {
+		return ErrReloadBusy
+	}
+	return nil
+}
+
+// Statuses of every index the watcher knows of, sorted by name
+func (w *Watcher) Statuses() []ReloadStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	statuses := make([]ReloadStatus, 0, len(w.statuses))
+	for _, status := range w.statuses {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Index < statuses[j].Index })
+	return statuses
+}
+
+func (w *Watcher) paths() ([]string, error) {
+	return filepath.Glob(w.pattern)
+}
+
+func statFile(path string) (stamp fileStamp, err error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	return fileStamp{fi.ModTime(), fi.Size()}, nil
+}
+
+// queues every file that changed since it was loaded and hasn't changed since the last
+// scan, and removes the indices of deleted files
+func (w *Watcher) scan() {
+	paths, err := w.paths()
+	if err != nil {
+		warn(err, "watching "+w.pattern)
+		return
+	}
+	exists := make(map[string]bool, len(paths))
+	var changed []string
+	w.mu.Lock()
+	for _, path := range paths {
+		exists[path] = true
+		stamp, err := statFile(path)
+		if err != nil {
+			continue
+		}
+		last, ok := w.seen[path]
+		w.seen[path] = stamp
+		if ok && last == stamp && w.loaded[path] != stamp {
+			changed = append(changed, path)
+		}
+	}
+	var removed []string
+	for path := range w.seen {
+		if !exists[path] {
+			removed = append(removed, path)
+			delete(w.seen, path)
+			delete(w.loaded, path)
+		}
+	}
+	w.mu.Unlock()
+
+	// a file the queue has no room for is still changed at the next scan
+	for _, path := range changed {
+		w.enqueue(path)
+	}
+	for _, path := range removed {
+		key := w.key(path)
+		info("Removing %q as %s is gone\n", key, path)
+		w.idx.Remove(key)
+		w.setStatus(&ReloadStatus{Index: key, Path: path, State: ReloadRemoved, Finished: time.Now()})
+	}
+}
+
+// enqueue queues the file unless it already is, returning false when the queue is full
+func (w *Watcher) enqueue(path string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.queued[path] {
+		return true
+	}
+	select {
+	case w.queue <- path:
+	default:
+		return false
+	}
+	// under the lock, so the worker can't report it loading first
+	w.queued[path] = true
+	w.statuses[w.key(path)] = &ReloadStatus{Index: w.key(path), Path: path, State: ReloadQueued}
+	return true
+}
+
+func (w *Watcher) work() {
+	for {
+		select {
+		case <-w.stop:
+			return
+		case path := <-w.queue:
+			w.rebuild(path)
+		}
+	}
+}
+
+func (w *Watcher) rebuild(path string) {
+	key := w.key(path)
+	status := &ReloadStatus{Index: key, Path: path, State: ReloadLoading, Started: time.Now()}
+	w.setStatus(status)
+	stamp, _ := statFile(path)
+	w.mu.Lock()
+	delete(w.queued, path)
+	w.mu.Unlock()
+
+	info("Reloading %q from %s\n", key, path)
+	fence, n, err := w.load(path)
+	if err == nil {
+		err = w.idx.Update(key, func(old *Fence) (*Fence, error) {
+			return reloaded(old, fence, time.Now())
+		})
+	}
+	status = &ReloadStatus{Index: key, Path: path, State: ReloadLoaded, Features: n, Started: status.Started, Finished: time.Now()}
+	if err != nil {
+		warn(err, "reloading "+path)
+		status.State, status.Error = ReloadFailed, err.Error()
+	} else {
+		info("Reloaded %d features for %q\n", n, key)
+	}
+	w.mu.Lock()
+	w.loaded[path] = stamp
+	w.mu.Unlock()
+	w.setStatus(status)
+}
+
+func (w *Watcher) setStatus(status *ReloadStatus) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.statuses[status.Index] = status
+}
@@ -0,0 +1,93 @@
+package philifence
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const squareGeoJson = `{"type": "FeatureCollection", "features": [
+	{"type": "Feature", "id": "%s", "properties": {},
+	 "geometry": {"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [1, 1], [0, 1], [0, 0]]]}}
+]}`
+
+func waitForStatus(t *testing.T, w *Watcher, state string) ReloadStatus {
+	for i := 0; i < 100; i++ {
+		if statuses := w.Statuses(); len(statuses) == 1 && statuses[0].State == state {
+			return statuses[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Reload never got %s, %v", state, w.Statuses())
+	return ReloadStatus{}
+}
+
+func TestWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "philifence")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "Test Cities.json")
+	if err := ioutil.WriteFile(path, []byte(sprintf(squareGeoJson, "old")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := LoadIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := time.Now()
+	w := NewWatcher(dir, idx, 0)
+	w.Start()
+	defer w.Stop()
+
+	ioutil.WriteFile(path, []byte(sprintf(squareGeoJson, "new")), 0644)
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Minute))
+	// changed files are only picked up once they settle
+	w.scan()
+	w.scan()
+	status := waitForStatus(t, w, ReloadLoaded)
+	if status.Index != "test-cities" || status.Features != 1 {
+		t.Errorf("Wrong reload status %v", status)
+	}
+	if _, err := idx.Feature("test-cities", "new"); err != nil {
+		t.Errorf("Index was not reloaded, %v", err)
+	}
+	// the reload deleted old, which is still there as of before it
+	if versions, err := idx.History("test-cities", "old"); err != nil || len(versions) != 1 || versions[0].Current() {
+		t.Errorf("Expected old kept as deleted, got %v %v", versions, err)
+	}
+	if matchs, _ := idx.SearchAt("test-cities", cd(0.5, 0.5), 1, loaded); len(matchs) != 1 || matchs[0].Id() != "old" {
+		t.Errorf("Expected old as of before the reload, got %v", matchs)
+	}
+
+	if err := w.Reload("unknown"); err == nil {
+		t.Errorf("Expected an error reloading an unknown index")
+	}
+	if err := w.Reload("test-cities"); err != nil {
+		t.Errorf("Manual reload failed %v", err)
+	}
+	waitForStatus(t, w, ReloadLoaded)
+	if versions, _ := idx.History("test-cities", "new"); len(versions) != 1 || !versions[0].Current() {
+		t.Errorf("Expected reloading an unchanged feature to keep it as it was, got %v", versions)
+	}
+
+	defer func(size int) { ReloadQueueSize = size }(ReloadQueueSize)
+	ReloadQueueSize = 0
+	busy := NewWatcher(dir, idx, 0)
+	if err := busy.Reload("test-cities"); err != ErrReloadBusy {
+		t.Errorf("Expected a full queue to be busy, got %v", err)
+	}
+	if status := waitForStatus(t, busy, ReloadLoaded); !status.Started.IsZero() {
+		t.Errorf("Expected the busy reload to leave the status alone, got %v", status)
+	}
+
+	os.Remove(path)
+	w.scan()
+	waitForStatus(t, w, ReloadRemoved)
+	if len(idx.Keys()) != 0 {
+		t.Errorf("Index was not removed %v", idx.Keys())
+	}
+}
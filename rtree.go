@@ -1,8 +1,9 @@
 package philifence
 
 import (
-	"github.com/jtejido/hrtree"
 	"math"
+	"sort"
+	"sync/atomic"
 )
 
 var (
@@ -18,48 +19,215 @@ const (
 	degrees     = 180 / math.Pi
 )
 
+// Rtree is a Hilbert R-tree. Leaves are kept in the order of the hilbert value of their
+// centers and every node knows the largest value under it, so an insert goes down to where
+// its value falls, splitting the nodes it overfills in half on its way back up.
+//
+// Trees are persistent: a tree handed out by share is never written to again, as the inserts
+// after it copy the nodes on their path rather than write them, leaving the rest shared.
+// Until a tree is shared its nodes are written in place, so loading a dataset copies nothing.
 type Rtree struct {
-	rtree *hrtree.HRtree
+	root     *rtreeNode
+	size     int    // leaves
+	height   int    // levels of nodes, 0 when empty
+	min, max int    // fan-out
+	edit     uint64 // of the nodes the tree may write in place, 0 once shared
 }
 
+type rtreeNode struct {
+	box      Box
+	lhv      uint64       // largest hilbert value under the node
+	children []*rtreeNode // nil for a leaf
+	leaf     *customRect
+	edit     uint64 // of the tree that made the node
+}
+
+// stamps of the trees, and id maps, writing in place
+var rtreeEdits uint64
+
 func NewRtree() (*Rtree, error) {
-	rt, err := hrtree.NewTree(MinimumNodeChildren, MaximumNodeChildren, Resolution)
+	return NewRtreeWith(MinimumNodeChildren, MaximumNodeChildren)
+}
 
-	return &Rtree{
-		rtree: rt,
-	}, err
+// NewRtreeWith builds a tree with the given fan-out. Nodes are split in half, so they hold
+// at least max/2 children whatever min is, but for the root.
+func NewRtreeWith(min, max int) (*Rtree, error) {
+	if min < 1 || max < 2 || min > max {
+		return nil, errorf("Invalid fan-out %d..%d", min, max)
+	}
+	return &Rtree{min: min, max: max}, nil
 }
 
+// Size is the number of leaves, Height the number of levels of nodes above them
 func (r *Rtree) Size() int {
-	return r.rtree.Size()
+	return r.size
+}
+
+func (r *Rtree) Height() int {
+	return r.height
 }
 
 func (r *Rtree) Insert(s *Polygon, data interface{}) {
-	node := &customRect{s, s.computeBox(), data}
-	r.rtree.Insert(node)
+	r.insert(&customRect{polygon: s, box: s.computeBox(), data: data})
 }
 
-func (r *Rtree) intersections(q hrtree.Rectangle) []*customRect {
-	inodes := r.rtree.SearchIntersect(q)
+func (r *Rtree) insert(leaf *customRect) {
+	if r.edit == 0 {
+		r.edit = atomic.AddUint64(&rtreeEdits, 1)
+	}
+	n := &rtreeNode{box: leaf.box, lhv: hilbertKey(leaf.box.center()), leaf: leaf}
+	r.size++
+	if r.root == nil {
+		r.root, r.height = r.node([]*rtreeNode{n}), 1
+		return
+	}
+	root, split := r.insertInto(r.root, n)
+	if split != nil {
+		root, r.height = r.node([]*rtreeNode{root, split}), r.height+1
+	}
+	r.root = root
+}
 
-	nodes := make([]*customRect, len(inodes))
-	for i, inode := range inodes {
-		nodes[i] = inode.(*customRect)
+// insertInto puts the leaf under n, returning n or the copy of it the tree may write, along
+// with the node split from it when it overflowed
+func (r *Rtree) insertInto(n, leaf *rtreeNode) (*rtreeNode, *rtreeNode) {
+	n = r.writable(n)
+	if n.children[0].leaf != nil {
+		// after the leaves of the same value, in the order they came
+		i := sort.Search(len(n.children), func(i int) bool { return n.children[i].lhv > leaf.lhv })
+		n.children = append(n.children, nil)
+		copy(n.children[i+1:], n.children[i:])
+		n.children[i] = leaf
+	} else {
+		i := sort.Search(len(n.children), func(i int) bool { return n.children[i].lhv >= leaf.lhv })
+		if i == len(n.children) {
+			i--
+		}
+		child, split := r.insertInto(n.children[i], leaf)
+		n.children[i] = child
+		if split != nil {
+			n.children = append(n.children, nil)
+			copy(n.children[i+2:], n.children[i+1:])
+			n.children[i+1] = split
+		}
+	}
+	if len(n.children) <= r.max {
+		n.box = n.box.extend(leaf.box)
+		if leaf.lhv > n.lhv {
+			n.lhv = leaf.lhv
+		}
+		return n, nil
 	}
+	half := len(n.children) / 2
+	split := r.node(append([]*rtreeNode(nil), n.children[half:]...))
+	for i := half; i < len(n.children); i++ {
+		n.children[i] = nil
+	}
+	n.children = n.children[:half]
+	n.fit()
+	return n, split
+}
 
-	return nodes
+// writable is n when the tree may write it, otherwise a copy it may
+func (r *Rtree) writable(n *rtreeNode) *rtreeNode {
+	if n.edit == r.edit {
+		return n
+	}
+	children := make([]*rtreeNode, len(n.children), len(n.children)+1)
+	copy(children, n.children)
+	return &rtreeNode{box: n.box, lhv: n.lhv, children: children, edit: r.edit}
+}
+
+// a node of the tree's over the children
+func (r *Rtree) node(children []*rtreeNode) *rtreeNode {
+	n := &rtreeNode{children: children, edit: r.edit}
+	n.fit()
+	return n
+}
+
+func (n *rtreeNode) fit() {
+	n.box = n.children[0].box
+	for _, child := range n.children[1:] {
+		n.box = n.box.extend(child.box)
+	}
+	n.lhv = n.children[len(n.children)-1].lhv
+}
+
+// share returns the tree as it is, which neither it nor the tree go on to write
+func (r *Rtree) share() *Rtree {
+	if r.edit != 0 {
+		r.edit = 0
+	}
+	shared := *r
+	return &shared
+}
+
+// search calls fn with every leaf whose box intersects box
+func (r *Rtree) search(box Box, fn func(*customRect)) {
+	if r.root == nil {
+		return
+	}
+	stack := []*rtreeNode{r.root}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if !n.box.intersects(box) {
+			continue
+		}
+		if n.leaf != nil {
+			fn(n.leaf)
+			continue
+		}
+		stack = append(stack, n.children...)
+	}
+}
+
+// each calls fn with every leaf in hilbert order
+func (r *Rtree) each(fn func(*customRect)) {
+	var walk func(n *rtreeNode)
+	walk = func(n *rtreeNode) {
+		if n.leaf != nil {
+			fn(n.leaf)
+			return
+		}
+		for _, child := range n.children {
+			walk(child)
+		}
+	}
+	if r.root != nil {
+		walk(r.root)
+	}
 }
 
-func (r *Rtree) Contains(c Coordinate, tol float64) []*customRect {
-	q := rectFromCenter(c, tol)
-	return r.intersections(q)
+// Contains returns the leaves whose boxes are within tol meters of c, but for parts of a
+// single coordinate, which contain nothing
+func (r *Rtree) Contains(c Coordinate, tol float64) (nodes []*customRect) {
+	qs := rectsFromCenter(c, tol)
+	var seen map[*customRect]bool
+	if len(qs) > 1 {
+		// a box across the antimeridian, a node may intersect both sides
+		seen = make(map[*customRect]bool)
+	}
+	for _, q := range qs {
+		r.search(q.box, func(n *customRect) {
+			if n.polygon.Len() < 2 || seen[n] {
+				return
+			}
+			if seen != nil {
+				seen[n] = true
+			}
+			nodes = append(nodes, n)
+		})
+	}
+	return nodes
 }
 
-// implements Spatial
+// a leaf of the tree
 type customRect struct {
 	polygon *Polygon
 	box     Box // precomputed box
 	data    interface{}
+	seq     int // features added to the fence before the leaf's
 }
 
 func (n *customRect) Feature() *Feature {
@@ -70,27 +238,21 @@ func (n *customRect) Value() interface{} {
 	return n.data
 }
 
-// implements Rectangle
-func (n *customRect) LowerLeft() hrtree.Point {
-	return hrtree.Point{lonToUint32(n.box.min.lon), latToUint32(n.box.min.lat)}
-}
-
-func (n *customRect) UpperRight() hrtree.Point {
-	return hrtree.Point{lonToUint32(n.box.max.lon), latToUint32(n.box.max.lat)}
-}
-
-// ensure the limit is within -180, 180
+// clamped within -180, 180
 func lonToUint32(c float64) uint64 {
-	return uint64(float64(dim) * ((c + 180.0) / 360.0))
+	return uint64(float64(dim) * ((math.Max(-180, math.Min(180, c)) + 180.0) / 360.0))
 }
 
-// ensure the limit is within -90.0, 90.0
+// clamped within -90.0, 90.0
 func latToUint32(c float64) uint64 {
-	return uint64(float64(dim) * ((c + 90.0) / 180.0))
+	return uint64(float64(dim) * ((math.Max(-90, math.Min(90, c)) + 90.0) / 180.0))
 }
 
 // from http://janmatuschek.de/LatitudeLongitudeBoundingCoordinates#Latitude
-func rectFromCenter(c Coordinate, meters float64) *customRect {
+//
+// A box reaching a pole covers every longitude. One crossing the antimeridian is
+// returned as two, one on either side of it.
+func rectsFromCenter(c Coordinate, meters float64) []*customRect {
 
 	c.lat *= radians
 	c.lon *= radians
@@ -99,40 +261,46 @@ func rectFromCenter(c Coordinate, meters float64) *customRect {
 
 	minLat := c.lat - r
 	maxLat := c.lat + r
+	minLon := -math.Pi
+	maxLon := math.Pi
 
-	latT := math.Asin(math.Sin(c.lat) / math.Cos(r))
-	lonΔ := math.Acos((math.Cos(r) - math.Sin(latT)*math.Sin(c.lat)) / (math.Cos(latT) * math.Cos(c.lat)))
+	if maxLat > math.Pi/2 || minLat < -math.Pi/2 {
+		minLat = math.Max(minLat, -math.Pi/2)
+		maxLat = math.Min(maxLat, math.Pi/2)
+		return []*customRect{newRect(minLat, minLon, maxLat, maxLon)}
+	}
 
-	minLon := c.lon - lonΔ
-	maxLon := c.lon + lonΔ
+	latT := math.Asin(math.Sin(c.lat) / math.Cos(r))
+	cosΔ := (math.Cos(r) - math.Sin(latT)*math.Sin(c.lat)) / (math.Cos(latT) * math.Cos(c.lat))
+	lonΔ := math.Acos(math.Max(-1, math.Min(1, cosΔ)))
 
-	if maxLat > math.Pi/2 {
-		minLon = -math.Pi
-		maxLat = math.Pi / 2
-		maxLon = math.Pi
+	if lonΔ >= math.Pi {
+		return []*customRect{newRect(minLat, minLon, maxLat, maxLon)}
 	}
 
-	if minLat < -math.Pi/2 {
-		minLat = -math.Pi / 2
-		minLon = -math.Pi
-		maxLon = math.Pi
-	}
+	minLon = c.lon - lonΔ
+	maxLon = c.lon + lonΔ
 
-	if minLon < -math.Pi || maxLon > math.Pi {
-		minLon = -math.Pi
-		maxLon = math.Pi
+	if minLon < -math.Pi {
+		return []*customRect{
+			newRect(minLat, minLon+2*math.Pi, maxLat, math.Pi),
+			newRect(minLat, -math.Pi, maxLat, maxLon),
+		}
+	}
+	if maxLon > math.Pi {
+		return []*customRect{
+			newRect(minLat, minLon, maxLat, math.Pi),
+			newRect(minLat, -math.Pi, maxLat, maxLon-2*math.Pi),
+		}
 	}
 
-	minLon = math.Mod(minLon+3*math.Pi, 2*math.Pi) - math.Pi // normalise to -180..+180°
-	maxLon = math.Mod(maxLon+3*math.Pi, 2*math.Pi) - math.Pi
+	return []*customRect{newRect(minLat, minLon, maxLat, maxLon)}
+}
 
-	minLat *= degrees
-	minLon *= degrees
-	maxLat *= degrees
-	maxLon *= degrees
-	lower := Coordinate{lon: minLon, lat: minLat}
-	upper := Coordinate{lon: maxLon, lat: maxLat}
+// a query box from radian bounds
+func newRect(minLat, minLon, maxLat, maxLon float64) *customRect {
+	lower := Coordinate{lon: minLon * degrees, lat: minLat * degrees}
+	upper := Coordinate{lon: maxLon * degrees, lat: maxLat * degrees}
 	poly := NewPoly(lower, upper)
 	return &customRect{polygon: poly, box: poly.computeBox()}
-
 }
@@ -0,0 +1,195 @@
+package philifence
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// TLSConfig serves https, and gRPC over TLS, from a certificate and key file. With a client
+// CA, clients presenting a certificate must have it signed by the CA.
+type TLSConfig struct {
+	Cert              string `json:"cert"`
+	Key               string `json:"key"`
+	ClientCA          string `json:"client_ca"`
+	RequireClientCert bool   `json:"require_client_cert"` // refuse clients without a certificate
+}
+
+func (t TLSConfig) enabled() bool {
+	return t.Cert != "" || t.Key != ""
+}
+
+func (t TLSConfig) config() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+	if err != nil {
+		return nil, errorf("Invalid TLS certificate: %v", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if t.ClientCA != "" {
+		pem, err := ioutil.ReadFile(t.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errorf("No certificates in client CA %s", t.ClientCA)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	if t.RequireClientCert {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// OnShutdown adds fn to what Serve runs once the requests in flight are done, e.g. to stop
+// watchers or flush writes, in the order added
+func (s *Server) OnShutdown(fn func() error) {
+	s.shutdown = append(s.shutdown, fn)
+}
+
+// Serve serves http, and gRPC when the config has a port for it, until ctx is done. It then
+// stops accepting connections, ends the event streams, waits up to the shutdown timeout for
+// the requests in flight and runs the shutdown hooks.
+func (s *Server) Serve(ctx context.Context, config ServerConfig) error {
+	hs, err := s.httpServer(config)
+	if err != nil {
+		return err
+	}
+	lis, err := net.Listen("tcp", ":"+config.Port)
+	if err != nil {
+		return err
+	}
+	return s.serve(ctx, hs, lis, config)
+}
+
+func (s *Server) httpServer(config ServerConfig) (*http.Server, error) {
+	var tlsConfig *tls.Config
+	if config.TLS.enabled() {
+		var err error
+		if tlsConfig, err = config.TLS.config(); err != nil {
+			return nil, err
+		}
+	}
+	if config.Profiler {
+		s.Profile()
+		info("Profiling available at /debug/pprof/")
+	}
+	return &http.Server{
+		Handler:           s,
+		TLSConfig:         tlsConfig,
+		ReadTimeout:       config.ReadTimeout.Duration,
+		ReadHeaderTimeout: config.ReadHeaderTimeout.Duration,
+		WriteTimeout:      config.WriteTimeout.Duration,
+		IdleTimeout:       config.IdleTimeout.Duration,
+		MaxHeaderBytes:    config.MaxHeaderBytes,
+	}, nil
+}
+
+// grpcServer is a gRPC server for the layers of s, over TLS when tlsConfig is set
+func (s *Server) grpcServer(tlsConfig *tls.Config) *grpc.Server {
+	opts := s.GRPCServerOptions()
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	g := grpc.NewServer(opts...)
+	s.RegisterGRPC(g)
+	return g
+}
+
+func (s *Server) serve(ctx context.Context, hs *http.Server, lis net.Listener, config ServerConfig) (err error) {
+	hs.RegisterOnShutdown(s.stop)
+	tlsConfig := hs.TLSConfig
+	errs := make(chan error, 2)
+	go func() {
+		info("Listening on %s, tls %v\n", lis.Addr(), tlsConfig != nil)
+		if tlsConfig != nil {
+			lis = tls.NewListener(lis, tlsConfig)
+		}
+		errs <- hs.Serve(lis)
+	}()
+	var g *grpc.Server
+	if config.GRPCPort != "" {
+		g = s.grpcServer(tlsConfig)
+		glis, err := net.Listen("tcp", ":"+config.GRPCPort)
+		if err != nil {
+			hs.Close()
+			return err
+		}
+		go func() {
+			info("Serving gRPC on %s\n", glis.Addr())
+			errs <- g.Serve(glis)
+		}()
+	}
+
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+	}
+	info("Shutting down, waiting up to %v for requests in flight\n", config.ShutdownTimeout.Duration)
+	if err := s.drain(hs, g, config.ShutdownTimeout.Duration); err != nil {
+		warn(err, "shutting down")
+	}
+	for _, fn := range s.shutdown {
+		warn(fn(), "shutdown hook")
+	}
+	info("Done Fencing\n")
+	if err == http.ErrServerClosed || err == grpc.ErrServerStopped {
+		err = nil
+	}
+	return err
+}
+
+// drain shuts both servers down gracefully, closing them outright past the timeout
+func (s *Server) drain(hs *http.Server, g *grpc.Server, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if g != nil {
+		stopped := make(chan struct{})
+		go func() {
+			g.GracefulStop()
+			close(stopped)
+		}()
+		defer func() {
+			select {
+			case <-stopped:
+			case <-ctx.Done():
+				g.Stop()
+			}
+		}()
+	}
+	if err := hs.Shutdown(ctx); err != nil {
+		hs.Close()
+		return err
+	}
+	return nil
+}
+
+// streamContext is done with the request or once the server shuts down, so long lived
+// streams don't hold up draining
+func (s *Server) streamContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(s.stopping, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// extends the write deadline of a streamed response, which outlives the write timeout, by d
+// or indefinitely for 0
+func extendWrite(w http.ResponseWriter, d time.Duration) {
+	deadline := time.Time{}
+	if d > 0 {
+		deadline = time.Now().Add(d)
+	}
+	http.NewResponseController(w).SetWriteDeadline(deadline)
+}
@@ -0,0 +1,69 @@
+package philifence
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestServerShutdown(t *testing.T) {
+	s := serverWith(t, KindFence, KindFence)
+	started := make(chan struct{})
+	s.router.GET("/slow", func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		respond(w, "done")
+	})
+	hooked := false
+	s.OnShutdown(func() error {
+		hooked = true
+		return nil
+	})
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	config := NewServerConfig("0")
+	served := make(chan error)
+	go func() {
+		served <- s.serve(ctx, &http.Server{Handler: s}, lis, config)
+	}()
+	url := "http://" + lis.Addr().String()
+
+	events, err := http.Get(url + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer events.Body.Close()
+	slow := make(chan int)
+	go func() {
+		res, err := http.Get(url + "/slow")
+		if err != nil {
+			slow <- 0
+			return
+		}
+		res.Body.Close()
+		slow <- res.StatusCode
+	}()
+	<-started
+	cancel()
+	if code := <-slow; code != 200 {
+		t.Errorf("Expected the request in flight to finish, got %d", code)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the event stream to end on shutdown")
+	}
+	if !hooked {
+		t.Errorf("Expected the shutdown hooks to run")
+	}
+}
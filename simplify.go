@@ -0,0 +1,54 @@
+package philifence
+
+// simplify drops vertices within tol meters of the line through their neighbours using
+// Douglas-Peucker, keeping rings closed and every ring and line valid
+//
+// https://en.wikipedia.org/wiki/Ramer%E2%80%93Douglas%E2%80%93Peucker_algorithm
+func (f *Feature) simplify(tol float64) {
+	if tol <= 0 || f.isPoint() {
+		return
+	}
+	min := 2
+	if f.isAreal() {
+		min = 4
+	}
+	for _, poly := range f.Geometry {
+		for _, ring := range poly.rings() {
+			if simplified := douglasPeucker(ring.Coordinates, tol); len(simplified) >= min {
+				ring.Coordinates = simplified
+			}
+		}
+		poly.prepared = nil
+	}
+}
+
+func douglasPeucker(coords []Coordinate, tol float64) []Coordinate {
+	if len(coords) < 3 {
+		return coords
+	}
+	keep := make([]bool, len(coords))
+	keep[0], keep[len(coords)-1] = true, true
+	stack := [][2]int{{0, len(coords) - 1}}
+	for len(stack) > 0 {
+		span := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		far, farthest := -1, tol
+		for i := span[0] + 1; i < span[1]; i++ {
+			if d := segmentDistance(coords[i], coords[span[0]], coords[span[1]]); d > farthest {
+				far, farthest = i, d
+			}
+		}
+		if far < 0 {
+			continue
+		}
+		keep[far] = true
+		stack = append(stack, [2]int{span[0], far}, [2]int{far, span[1]})
+	}
+	simplified := make([]Coordinate, 0, len(coords))
+	for i, c := range coords {
+		if keep[i] {
+			simplified = append(simplified, c)
+		}
+	}
+	return simplified
+}
@@ -0,0 +1,267 @@
+package philifence
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SnapshotFenceIndex never blocks readers. Every fence is held as an immutable snapshot
+// which writers replace atomically, so a search only ever loads a pointer.
+//
+// A write forks the fence readers are on, whose tree and ids the fork shares until it is
+// written to: an add copies the nodes on the path to the new leaf and id, O(log n) of them,
+// leaving those readers are on as they are. The fork is then published in its place.
+type SnapshotFenceIndex struct {
+	fences atomic.Value // map[string]*snapshotFence, copied on Set
+	mu     sync.Mutex   // serialises Set and Remove
+}
+
+type snapshotFence struct {
+	current atomic.Value // *Fence, read-only
+	mu      sync.Mutex   // serialises writers
+}
+
+func NewSnapshotFenceIndex() *SnapshotFenceIndex {
+	idx := &SnapshotFenceIndex{}
+	idx.fences.Store(make(map[string]*snapshotFence))
+	return idx
+}
+
+func (idx *SnapshotFenceIndex) held(name string) (*snapshotFence, error) {
+	fence, ok := idx.fences.Load().(map[string]*snapshotFence)[name]
+	if !ok {
+		return nil, fmt.Errorf("FenceIndex does not contain fence %q", name)
+	}
+	return fence, nil
+}
+
+func (idx *SnapshotFenceIndex) snapshot(name string) (*Fence, error) {
+	fence, err := idx.held(name)
+	if err != nil {
+		return nil, err
+	}
+	return fence.current.Load().(*Fence), nil
+}
+
+// publish makes next the fence readers are on, then retires what it replaced
+func (f *snapshotFence) publish(next *Fence) {
+	if !next.readonly {
+		next.readonly = true
+	}
+	f.current.Store(next)
+	next.published()
+}
+
+// write publishes the fence fn returns for the current one, which it must not write to
+func (idx *SnapshotFenceIndex) write(name string, fn func(current *Fence) (*Fence, error)) error {
+	fence, err := idx.held(name)
+	if err != nil {
+		return err
+	}
+	fence.mu.Lock()
+	defer fence.mu.Unlock()
+	next, err := fn(fence.current.Load().(*Fence))
+	if err != nil {
+		return err
+	}
+	fence.publish(next)
+	return nil
+}
+
+// Set publishes a fork of the fence, which is the caller's to go on writing to. A fence
+// already held is replaced in place, after any write to it in progress.
+func (idx *SnapshotFenceIndex) Set(name string, fence *Fence) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.set(name, fence)
+}
+
+func (idx *SnapshotFenceIndex) set(name string, fence *Fence) {
+	defer fence.published()
+	fences := idx.fences.Load().(map[string]*snapshotFence)
+	if held, ok := fences[name]; ok {
+		held.mu.Lock()
+		defer held.mu.Unlock()
+		held.publish(fence.fork())
+		return
+	}
+	next := make(map[string]*snapshotFence, len(fences)+1)
+	for k, v := range fences {
+		next[k] = v
+	}
+	f := &snapshotFence{}
+	f.publish(fence.fork())
+	next[name] = f
+	idx.fences.Store(next)
+}
+
+// Update publishes the fence fn makes of the one readers are on, holding off other writers
+// meanwhile
+func (idx *SnapshotFenceIndex) Update(name string, fn func(old *Fence) (*Fence, error)) error {
+	if _, err := idx.held(name); err != nil {
+		idx.mu.Lock()
+		if _, err := idx.held(name); err != nil {
+			defer idx.mu.Unlock()
+			fence, err := fn(nil)
+			if err != nil {
+				return err
+			}
+			idx.set(name, fence)
+			return nil
+		}
+		idx.mu.Unlock()
+	}
+	return idx.write(name, fn)
+}
+
+func (idx *SnapshotFenceIndex) Remove(name string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	fences := idx.fences.Load().(map[string]*snapshotFence)
+	next := make(map[string]*snapshotFence, len(fences))
+	for k, v := range fences {
+		if k != name {
+			next[k] = v
+		}
+	}
+	idx.fences.Store(next)
+}
+
+// Get returns the fence readers are on, which is read-only
+func (idx *SnapshotFenceIndex) Get(name string) *Fence {
+	fence, err := idx.snapshot(name)
+	if err != nil {
+		return nil
+	}
+	return fence
+}
+
+func (idx *SnapshotFenceIndex) Add(name string, feature *Feature) error {
+	err := idx.write(name, func(current *Fence) (*Fence, error) {
+		next := current.fork()
+		next.unpublished = true
+		next.Add(feature)
+		return next, nil
+	})
+	if err == nil {
+		inserts.inc()
+	}
+	return err
+}
+
+func (idx *SnapshotFenceIndex) Search(name string, c Coordinate, tol float64) ([]*Feature, error) {
+	return idx.SearchAt(name, c, tol, time.Now())
+}
+
+func (idx *SnapshotFenceIndex) SearchAt(name string, c Coordinate, tol float64, t time.Time) ([]*Feature, error) {
+	fence, err := idx.snapshot(name)
+	if err != nil {
+		return nil, err
+	}
+	info("Searching fence for latitude : %.5f, longitude : %.5f in %q", c.lat, c.lon, name)
+	return fence.GetAt(c, tol, t), nil
+}
+
+func (idx *SnapshotFenceIndex) Near(name string, c Coordinate, tol float64) ([]Match, error) {
+	return idx.NearAt(name, c, tol, time.Now())
+}
+
+func (idx *SnapshotFenceIndex) NearAt(name string, c Coordinate, tol float64, t time.Time) ([]Match, error) {
+	fence, err := idx.snapshot(name)
+	if err != nil {
+		return nil, err
+	}
+	info("Searching fence near latitude : %.5f, longitude : %.5f in %q", c.lat, c.lon, name)
+	return fence.NearAt(c, tol, t), nil
+}
+
+func (idx *SnapshotFenceIndex) SearchBatch(name string, queries []BatchQuery) (map[string][]*Feature, error) {
+	fence, err := idx.snapshot(name)
+	if err != nil {
+		return nil, err
+	}
+	info("Searching fence for %d points in %q", len(queries), name)
+	return fence.GetBatch(queries), nil
+}
+
+func (idx *SnapshotFenceIndex) Tree(name string) (*Rtree, error) {
+	fence, err := idx.snapshot(name)
+	if err != nil {
+		return nil, err
+	}
+	return fence.Tree(), nil
+}
+
+func (idx *SnapshotFenceIndex) Stats(name string) (IndexStats, error) {
+	fence, err := idx.snapshot(name)
+	if err != nil {
+		return IndexStats{}, err
+	}
+	return fence.stats(), nil
+}
+
+func (idx *SnapshotFenceIndex) Feature(name, id string) (*Feature, error) {
+	fence, err := idx.snapshot(name)
+	if err != nil {
+		return nil, err
+	}
+	feature, ok := fence.Feature(id)
+	if !ok {
+		return nil, fmt.Errorf("Fence %q does not contain feature %q", name, id)
+	}
+	return feature, nil
+}
+
+func (idx *SnapshotFenceIndex) History(name, id string) ([]*Feature, error) {
+	fence, err := idx.snapshot(name)
+	if err != nil {
+		return nil, err
+	}
+	feature, ok := fence.latest(id)
+	if !ok {
+		return nil, fmt.Errorf("Fence %q does not contain feature %q", name, id)
+	}
+	return feature.History(), nil
+}
+
+// DeleteFeature publishes a fork of the fence, for those keeping what they made of its tree
+// to tell it changed
+func (idx *SnapshotFenceIndex) DeleteFeature(name, id string) error {
+	return idx.write(name, func(current *Fence) (*Fence, error) {
+		next := current.fork()
+		if !next.Delete(id, time.Now()) {
+			return nil, fmt.Errorf("Fence %q does not contain feature %q", name, id)
+		}
+		return next, nil
+	})
+}
+
+// Purge publishes a fence rebuilt without the expired features, readers keep searching the
+// old one meanwhile
+func (idx *SnapshotFenceIndex) Purge(name string, t time.Time) (expired []*Feature, err error) {
+	err = idx.write(name, func(current *Fence) (next *Fence, err error) {
+		next, expired, err = current.purge(t)
+		return
+	})
+	return
+}
+
+func (idx *SnapshotFenceIndex) Keys() (keys []string) {
+	for k := range idx.fences.Load().(map[string]*snapshotFence) {
+		keys = append(keys, k)
+	}
+	return
+}
+
+func newFenceOf(features []*Feature) (*Fence, error) {
+	fence, err := NewFence()
+	if err != nil {
+		return nil, err
+	}
+	for _, feature := range features {
+		fence.insert(feature)
+	}
+	return fence, nil
+}
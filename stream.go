@@ -0,0 +1,203 @@
+package philifence
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/julienschmidt/httprouter"
+)
+
+var (
+	// how long an idle event stream waits before a keep-alive, so proxies don't close it
+	EventKeepAlive = 15 * time.Second
+	// how long a websocket write may take before the subscriber is dropped
+	EventWriteTimeout = 10 * time.Second
+)
+
+// SetOrigins lets the event websockets of pages from other origins than the server's own,
+// such as "https://app.example.com", "*" allowing any
+func (s *Server) SetOrigins(origins ...string) {
+	s.origins = origins
+}
+
+// websockets are opened by clients without an origin, pages of the server's own or the
+// allowed origins, not pages of any site its users visit
+func (s *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range s.origins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+type droppedMessage struct {
+	Type    string `json:"type"`
+	Dropped int    `json:"dropped"`
+}
+
+// moves devices, taking a batch of {id, lat, lon, tolerance} where the id is the device's, and
+// responds with the enter and exit events. A device may move more than once, in order.
+func (s *Server) postLocations(layer *Layer) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		defer r.Body.Close()
+		updates, err := decodeQueries(limitBody(r.Body))
+		if err != nil {
+			respondBodyError(w, err, "locations")
+			return
+		}
+		if !validQueries(w, updates, "location") {
+			return
+		}
+		name := params.ByName("name")
+		if !hasIndex(layer.Index, name) {
+			respondUnknownIndex(w, layer, name)
+			return
+		}
+		events := []Event{}
+		for _, u := range updates {
+			moved, err := s.hub(layer).Move(r.Context(), layer, name, string(u.Id), u.Coordinate(), u.Tolerance)
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, ErrorInternal, "Error moving "+string(u.Id)+": "+err.Error())
+				return
+			}
+			events = append(events, moved...)
+		}
+		respond(w, events)
+	}
+}
+
+// streams events as server-sent events, or over a websocket when asked to upgrade, filtered by
+// the comma separated index ({layer}/{index}), feature, device and type params
+func (s *Server) getEvents(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	_, hub, ok := s.scope(w, params)
+	if !ok {
+		return
+	}
+	filter := eventFilter(r.URL.Query())
+	if websocket.IsWebSocketUpgrade(r) {
+		s.streamWebSocket(w, r, hub, filter)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondError(w, http.StatusInternalServerError, ErrorInternal, "Streaming unsupported")
+		return
+	}
+	sub := hub.Subscribe(filter)
+	defer sub.Close()
+	w.Header().Set("Server", "philifence")
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ctx, cancel := s.streamContext(r.Context())
+	defer cancel()
+	for {
+		extendWrite(w, EventKeepAlive+EventWriteTimeout)
+		events, dropped, err := nextEvents(ctx, sub)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			io.WriteString(w, ": keep-alive\n\n")
+		case dropped > 0:
+			writeServerEvent(w, EventDropped, droppedMessage{EventDropped, dropped})
+		}
+		for _, e := range events {
+			writeServerEvent(w, e.Type, e)
+		}
+		flusher.Flush()
+	}
+}
+
+func writeServerEvent(w io.Writer, event string, msg interface{}) {
+	fmt.Fprintf(w, "event: %s\ndata: ", event)
+	writeJson(w, msg)
+	io.WriteString(w, "\n\n")
+}
+
+func (s *Server) streamWebSocket(w http.ResponseWriter, r *http.Request, hub *EventHub, filter EventFilter) {
+	sub := hub.Subscribe(filter) // before the client knows it is subscribed
+	defer sub.Close()
+	upgrader := websocket.Upgrader{CheckOrigin: s.checkOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // the upgrader has responded
+	}
+	defer conn.Close()
+
+	ctx, cancel := s.streamContext(context.Background())
+	defer cancel()
+	go func() {
+		// reads until the client goes away, which also answers its pings and close
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	for {
+		events, dropped, err := nextEvents(ctx, sub)
+		if ctx.Err() != nil {
+			conn.SetWriteDeadline(time.Now().Add(EventWriteTimeout))
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+			return
+		}
+		conn.SetWriteDeadline(time.Now().Add(EventWriteTimeout))
+		if err != nil {
+			err = conn.WriteMessage(websocket.PingMessage, nil)
+		} else if dropped > 0 {
+			err = conn.WriteJSON(droppedMessage{EventDropped, dropped})
+		}
+		for i := 0; err == nil && i < len(events); i++ {
+			err = conn.WriteJSON(events[i])
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// pending events, or a deadline error after EventKeepAlive without any
+func nextEvents(ctx context.Context, sub *Subscription) ([]Event, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, EventKeepAlive)
+	defer cancel()
+	return sub.Next(ctx)
+}
+
+func eventFilter(query url.Values) EventFilter {
+	return EventFilter{
+		Indices:  splitParam(query, "index"),
+		Features: splitParam(query, "feature"),
+		Devices:  splitParam(query, "device"),
+		Types:    splitParam(query, "type"),
+	}
+}
+
+// values of a repeated or comma separated param
+func splitParam(query url.Values, key string) (values []string) {
+	for _, v := range query[key] {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				values = append(values, s)
+			}
+		}
+	}
+	return
+}
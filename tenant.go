@@ -0,0 +1,253 @@
+package philifence
+
+import (
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// DefaultTenant owns the layers served without a /t/{tenant} prefix
+const DefaultTenant = ""
+
+var tenantName = regexp.MustCompile("^[a-z0-9][a-z0-9_-]*$")
+
+// TenantLimits bound what a tenant may add, 0 being no limit
+type TenantLimits struct {
+	MaxFeatures int   `json:"max_features"`
+	MaxMemory   int64 `json:"max_memory"`  // estimated bytes of the features' geometries
+	MaxIndices  int   `json:"max_indices"` // across every layer
+}
+
+// Tenant is a namespace of its own layers, of the same names and kinds as the server's, with
+// indices and events no other tenant sees. It is served under /t/{name}.
+type Tenant struct {
+	Name   string
+	Limits TenantLimits
+	layers map[string]*Layer
+	events *EventHub
+	usage  map[string]tenantUsage // by {layer}/{index}
+	total  tenantUsage
+	mu     sync.Mutex
+}
+
+type tenantUsage struct {
+	features int
+	memory   int64
+}
+
+func (t *Tenant) Layer(name string) (layer *Layer, ok bool) {
+	layer, ok = t.layers[name]
+	return
+}
+
+func (t *Tenant) Events() *EventHub {
+	return t.events
+}
+
+// Usage is how many features the tenant has added, and their estimated size in bytes
+func (t *Tenant) Usage() (features int, memory int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.total.features, t.total.memory
+}
+
+// AddTenant adds a tenant with an empty index-less layer for each of the server's
+func (s *Server) AddTenant(name string, limits TenantLimits) (*Tenant, error) {
+	if !tenantName.MatchString(name) {
+		return nil, errorf("Invalid tenant name %q", name)
+	}
+	if _, ok := s.tenants[name]; ok {
+		return nil, errorf("Tenant %q already exists", name)
+	}
+	t := &Tenant{Name: name, Limits: limits, layers: make(map[string]*Layer), events: NewEventHub(), usage: make(map[string]tenantUsage)}
+	for _, layer := range s.layers {
+		t.addLayer(layer)
+	}
+	s.tenants[name] = t
+	return t, nil
+}
+
+func (s *Server) Tenant(name string) (t *Tenant, ok bool) {
+	t, ok = s.tenants[name]
+	return
+}
+
+// everyLayer is every layer of the server, followed by those of its tenants
+func (s *Server) everyLayer() []*Layer {
+	layers := make([]*Layer, 0, len(s.layers))
+	for _, layer := range s.layers {
+		layers = append(layers, layer)
+	}
+	for _, tenant := range s.tenants {
+		for _, layer := range tenant.layers {
+			layers = append(layers, layer)
+		}
+	}
+	return layers
+}
+
+// addLayer mirrors a layer of the server with an index of the tenant's own, of the same type
+func (t *Tenant) addLayer(layer *Layer) {
+	var idx FenceIndex = NewFenceIndexWith(IndexOptions{SnapshotReads: snapshotReads(layer.Index)})
+	if j, ok := layer.Index.(*Journal); ok {
+		idx = NewJournal(idx, filepath.Join(filepath.Dir(j.dir), "t", t.Name, layer.Name))
+	}
+	t.layers[layer.Name] = &Layer{Name: layer.Name, Kind: layer.Kind, Index: idx, tenant: t}
+}
+
+// addIndex sets an empty index of the name, unless the tenant already has as many indices
+// as it may
+func (l *Layer) addIndex(name string, fence *Fence) error {
+	t := l.tenant
+	if t == nil {
+		l.Index.Set(name, fence)
+		return nil
+	}
+	// held while setting it, so concurrent puts can't both take the last one
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if max := t.Limits.MaxIndices; max > 0 {
+		n := 0
+		for _, layer := range t.layers {
+			n += len(layer.Index.Keys())
+		}
+		if n+1 > max {
+			return errorf("Tenant %q is at its limit of %d indices", t.Name, max)
+		}
+	}
+	l.Index.Set(name, fence)
+	return nil
+}
+
+// reserve counts the feature against the tenant's limits before it is added to the index
+func (l *Layer) reserve(name string, f *Feature) error {
+	t := l.tenant
+	if t == nil {
+		return nil
+	}
+	memory := f.footprint()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if max := t.Limits.MaxFeatures; max > 0 && t.total.features+1 > max {
+		return errorf("Tenant %q is at its limit of %d features", t.Name, max)
+	}
+	if max := t.Limits.MaxMemory; max > 0 && t.total.memory+memory > max {
+		return errorf("Tenant %q would be past its limit of %d bytes", t.Name, max)
+	}
+	key := l.Name + "/" + name
+	usage := t.usage[key]
+	usage.features++
+	usage.memory += memory
+	t.usage[key] = usage
+	t.total.features++
+	t.total.memory += memory
+	return nil
+}
+
+// recount counts the features of the index against the limits afresh, e.g. once restored,
+// as they were within them when added
+func (l *Layer) recount(name string) {
+	t := l.tenant
+	fence := l.Index.Get(name)
+	if t == nil || fence == nil {
+		return
+	}
+	var usage tenantUsage
+	for _, f := range everyFeature(fence) {
+		usage.features++
+		usage.memory += f.footprint()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := l.Name + "/" + name
+	t.total.features += usage.features - t.usage[key].features
+	t.total.memory += usage.memory - t.usage[key].memory
+	t.usage[key] = usage
+}
+
+// releaseFeatures gives back what the features removed from an index counted against the limits
+func (l *Layer) releaseFeatures(name string, features []*Feature) {
+	t := l.tenant
+	if t == nil || len(features) == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := l.Name + "/" + name
+	usage := t.usage[key]
+	for _, f := range features {
+		memory := f.footprint()
+		usage.features--
+		usage.memory -= memory
+		t.total.features--
+		t.total.memory -= memory
+	}
+	t.usage[key] = usage
+}
+
+// release gives back what the features of a removed index counted against the limits
+func (l *Layer) release(name string) {
+	t := l.tenant
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := l.Name + "/" + name
+	t.total.features -= t.usage[key].features
+	t.total.memory -= t.usage[key].memory
+	delete(t.usage, key)
+}
+
+// inTenant serves the route with the tenant's layer of the name
+func (s *Server) inTenant(name string, handle func(*Layer) httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		t, ok := s.tenants[params.ByName("tenant")]
+		if !ok {
+			respondError(w, http.StatusNotFound, ErrorUnknownTenant, "No tenant "+params.ByName("tenant"))
+			return
+		}
+		handle(t.layers[name])(w, r, params)
+	}
+}
+
+// scope is the layers and events of the request's tenant, the default tenant's for routes
+// without one, or responds that there is no such tenant
+func (s *Server) scope(w http.ResponseWriter, params httprouter.Params) (map[string]*Layer, *EventHub, bool) {
+	name := params.ByName("tenant")
+	layers, events, ok := s.tenantScope(name)
+	if !ok {
+		respondError(w, http.StatusNotFound, ErrorUnknownTenant, "No tenant "+name)
+	}
+	return layers, events, ok
+}
+
+// the layers and events of the named tenant
+func (s *Server) tenantScope(name string) (map[string]*Layer, *EventHub, bool) {
+	if name == DefaultTenant {
+		return s.layers, s.events, true
+	}
+	t, ok := s.tenants[name]
+	if !ok {
+		return nil, nil, false
+	}
+	return t.layers, t.events, true
+}
+
+// the events of the layer's tenant
+func (s *Server) hub(layer *Layer) *EventHub {
+	if layer.tenant != nil {
+		return layer.tenant.events
+	}
+	return s.events
+}
+
+func tenantOf(layer *Layer) string {
+	if layer.tenant != nil {
+		return layer.tenant.Name
+	}
+	return DefaultTenant
+}
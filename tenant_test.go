@@ -0,0 +1,104 @@
+package philifence
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestTenants(t *testing.T) {
+	const feature = `{"type": "Feature", "id": "b", "properties": {"id": "b"}, "geometry": {"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [1, 1], [0, 0]]]}}`
+	a := NewPolygonFeature(square(0, 0, 2))
+	a.Properties = map[string]interface{}{"id": "a"}
+	s := serverWith(t, KindFence, KindFence, a)
+	if _, err := s.AddTenant("acme", TenantLimits{MaxFeatures: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddTenant("other", TenantLimits{MaxIndices: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddTenant("acme", TenantLimits{}); err == nil {
+		t.Error("expected adding a tenant twice to fail")
+	}
+	cases := []struct {
+		method, path string
+		status       int
+	}{
+		{"GET", "/t/acme/fence/cities/search?lat=1&lon=1", 404},
+		{"GET", "/t/nope/fence/cities/search?lat=1&lon=1", 404},
+		{"PUT", "/t/acme/fence/cities", 200},
+		{"POST", "/t/acme/fence/cities/add", 200},
+		{"POST", "/t/acme/fence/cities/add", 507},
+		{"PUT", "/t/other/fence/cities", 200},
+		{"PUT", "/t/other/fence/towns", 507},
+		{"POST", "/t/other/fence/cities/add", 200},
+		{"DELETE", "/t/acme/fence/cities", 200},
+		{"PUT", "/t/acme/fence/cities", 200},
+		{"POST", "/t/acme/fence/cities/add", 200},
+		{"GET", "/fence/cities/search?lat=1.5&lon=1.5", 200},
+		{"GET", "/t/acme/layers", 200},
+		{"GET", "/t/nope/layers", 404},
+		{"GET", "/t/acme/tiles/fence/0/0/0.mvt", 200},
+		{"GET", "/t/nope/tiles/fence/0/0/0.mvt", 404},
+		{"GET", "/t/acme/join?left=fence/cities&right=fence/cities", 200},
+		{"GET", "/t/nope/join?left=fence/cities&right=fence/cities", 404},
+	}
+	for _, c := range cases {
+		if r := serveAs(s, c.method, c.path, feature); r.Code != c.status {
+			t.Errorf("%s %s: expected %d, got %d %s", c.method, c.path, c.status, r.Code, r.Body)
+		}
+	}
+
+	// the default tenant's index is left as it was, and the tenants' hold only their own
+	for path, id := range map[string]string{"/fence/cities/search?lat=0.2&lon=0.8": "a", "/t/acme/fence/cities/search?lat=0.2&lon=0.8": "b"} {
+		var res ResponseMessage
+		json.Unmarshal(serveAs(s, "GET", path, "").Body.Bytes(), &res)
+		if len(res.Result) != 1 || res.Result[0]["id"] != id {
+			t.Errorf("%s: expected only %s, got %v", path, id, res.Result)
+		}
+	}
+	acme, _ := s.Tenant("acme")
+	if n, memory := acme.Usage(); n != 1 || memory <= 0 {
+		t.Errorf("expected acme to use 1 feature, got %d of %d bytes", n, memory)
+	}
+}
+
+func TestTenantKeys(t *testing.T) {
+	s := authServer(t)
+	acme, err := s.AddTenant("acme", TenantLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddTenant("other", TenantLimits{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"acme", "other"} {
+		tenant, _ := s.Tenant(name)
+		layer, _ := tenant.Layer(KindFence)
+		layer.Index.Set("cities", s.layers[KindFence].Index.Get("cities"))
+	}
+	// everything of the default tenant, not the others'
+	s.auth.Anonymous = &Principal{Name: "anonymous", Grants: []Grant{{Index: "*", Access: []string{AccessRead}}}}
+	keys := s.auth.Authenticators[0].(*APIKeys)
+	keys.Add("acme-key", &Principal{Name: "acme", Tenant: acme.Name, Grants: []Grant{{Index: "fence/*", Access: []string{AccessRead}}}})
+	keys.Add("operator-key", &Principal{Name: "operator", Grants: []Grant{{Index: "t/*/fence/*", Access: []string{AccessRead}}}})
+	cases := []struct {
+		key, path string
+		status    int
+	}{
+		{"acme-key", "/t/acme/fence/cities/search?lat=1&lon=1", 200},
+		{"acme-key", "/t/other/fence/cities/search?lat=1&lon=1", 403},
+		{"acme-key", "/fence/cities/search?lat=1&lon=1", 403},
+		{"reader-key", "/t/acme/fence/cities/search?lat=1&lon=1", 403},
+		{"operator-key", "/t/acme/fence/cities/search?lat=1&lon=1", 200},
+		{"operator-key", "/t/other/fence/cities/search?lat=1&lon=1", 200},
+		{"operator-key", "/fence/cities/search?lat=1&lon=1", 403},
+		{"admin-key", "/fence/cities/search?lat=1&lon=1", 200},
+		{"admin-key", "/t/acme/fence/cities/search?lat=1&lon=1", 403},
+		{"", "/t/acme/fence/cities/search?lat=1&lon=1", 401},
+	}
+	for _, c := range cases {
+		if r := serveAs(s, "GET", c.path, "", "X-API-Key", c.key); r.Code != c.status {
+			t.Errorf("GET %s as %q: expected %d, got %d %s", c.path, c.key, c.status, r.Code, r.Body)
+		}
+	}
+}
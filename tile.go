@@ -0,0 +1,485 @@
+package philifence
+
+import (
+	"container/list"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var (
+	// size of a tile in its own coordinates
+	TileExtent = 4096
+	// how far features reach past the edges of a tile, in tile coordinates, so lines
+	// and polygon outlines join up across tiles
+	TileBuffer = 64
+	// Douglas-Peucker tolerance in tile coordinates
+	TileSimplify = 1.0
+	// encoded tiles kept in memory, least recently used dropped first
+	TileCacheSize = 1024
+)
+
+// Mapbox Vector Tile geometry types and commands
+//
+// https://github.com/mapbox/vector-tile-spec/tree/master/2.1
+const (
+	mvtPoint      = 1
+	mvtLineString = 2
+	mvtPolygon    = 3
+
+	mvtMoveTo    = 1
+	mvtLineTo    = 2
+	mvtClosePath = 7
+)
+
+// tileBox is the lon/lat box of tile x, y at zoom z of the web mercator grid
+func tileBox(z, x, y int) Box {
+	n := math.Exp2(float64(z))
+	lat := func(y float64) float64 {
+		return math.Atan(math.Sinh(math.Pi*(1-2*y/n))) * degrees
+	}
+	return Box{
+		min: Coordinate{lat: lat(float64(y + 1)), lon: float64(x)/n*360 - 180},
+		max: Coordinate{lat: lat(float64(y)), lon: float64(x+1)/n*360 - 180},
+	}
+}
+
+// projects lon/lat to the coordinates of a tile, y growing downwards
+type tileProjection struct {
+	n, x, y float64
+}
+
+func (p tileProjection) project(c Coordinate) (float64, float64) {
+	lat := math.Max(-85.0511, math.Min(85.0511, c.lat))
+	x := (c.lon + 180) / 360 * p.n
+	y := (1 - math.Log(math.Tan(lat*radians)+1/math.Cos(lat*radians))/math.Pi) / 2 * p.n
+	extent := float64(TileExtent)
+	return (x - p.x) * extent, (y - p.y) * extent
+}
+
+type tilePoint struct {
+	x, y float64
+}
+
+// EncodeTile renders the current features of the trees, by name, intersecting tile x, y at
+// zoom z as a Mapbox Vector Tile with a layer per tree. Geometry is simplified, clipped to
+// the tile and its buffer, and features keep their properties.
+func EncodeTile(z, x, y int, trees map[string]*Rtree) []byte {
+	box := tileBox(z, x, y)
+	// the buffer, in degrees, at the edges of the tile
+	pad := float64(TileBuffer) / float64(TileExtent)
+	search := Box{
+		min: Coordinate{lat: box.min.lat - (box.max.lat-box.min.lat)*pad, lon: box.min.lon - (box.max.lon-box.min.lon)*pad},
+		max: Coordinate{lat: box.max.lat + (box.max.lat-box.min.lat)*pad, lon: box.max.lon + (box.max.lon-box.min.lon)*pad},
+	}
+	proj := tileProjection{n: math.Exp2(float64(z)), x: float64(x), y: float64(y)}
+	// meters per tile coordinate at the tile's center
+	tol := TileSimplify * 2 * math.Pi * earthRadius * math.Cos(box.center().lat*radians) / proj.n / float64(TileExtent)
+
+	names := make([]string, 0, len(trees))
+	for name := range trees {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var tile []byte
+	for _, name := range names {
+		parts := make(map[*Feature][]*Polygon)
+		var order []*Feature
+		trees[name].search(search, func(leaf *customRect) {
+			f := leaf.Feature()
+			if !f.Current() {
+				return
+			}
+			if _, ok := parts[f]; !ok {
+				order = append(order, f)
+			}
+			parts[f] = append(parts[f], leaf.polygon)
+		})
+		if layer := encodeTileLayer(name, order, parts, proj, tol); layer != nil {
+			tile = protowire.AppendTag(tile, 3, protowire.BytesType)
+			tile = protowire.AppendBytes(tile, layer)
+		}
+	}
+	return tile
+}
+
+// keys and values of a layer, each kept once
+type tileTags struct {
+	keys   map[string]uint32
+	values map[interface{}]uint32
+	layer  []byte
+}
+
+func (t *tileTags) key(k string) uint32 {
+	i, ok := t.keys[k]
+	if !ok {
+		i = uint32(len(t.keys))
+		t.keys[k] = i
+		t.layer = protowire.AppendTag(t.layer, 3, protowire.BytesType)
+		t.layer = protowire.AppendString(t.layer, k)
+	}
+	return i
+}
+
+// strings, numbers and booleans are kept as they are, anything else as its json
+func (t *tileTags) value(v interface{}) (uint32, bool) {
+	switch v.(type) {
+	case string, float64, bool:
+	case nil:
+		return 0, false
+	case int:
+		v = float64(v.(int))
+	default:
+		buf, err := json.Marshal(v)
+		if err != nil {
+			return 0, false
+		}
+		v = string(buf)
+	}
+	i, ok := t.values[v]
+	if ok {
+		return i, true
+	}
+	i = uint32(len(t.values))
+	t.values[v] = i
+	var value []byte
+	switch v := v.(type) {
+	case string:
+		value = protowire.AppendTag(value, 1, protowire.BytesType)
+		value = protowire.AppendString(value, v)
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			value = protowire.AppendTag(value, 6, protowire.VarintType)
+			value = protowire.AppendVarint(value, protowire.EncodeZigZag(int64(v)))
+		} else {
+			value = protowire.AppendTag(value, 3, protowire.Fixed64Type)
+			value = protowire.AppendFixed64(value, math.Float64bits(v))
+		}
+	case bool:
+		value = protowire.AppendTag(value, 7, protowire.VarintType)
+		value = protowire.AppendVarint(value, protowire.EncodeBool(v))
+	}
+	t.layer = protowire.AppendTag(t.layer, 4, protowire.BytesType)
+	t.layer = protowire.AppendBytes(t.layer, value)
+	return i, true
+}
+
+func encodeTileLayer(name string, features []*Feature, parts map[*Feature][]*Polygon, proj tileProjection, tol float64) []byte {
+	var encoded [][]byte
+	tags := &tileTags{keys: make(map[string]uint32), values: make(map[interface{}]uint32)}
+	for _, f := range features {
+		typ, geometry := encodeTileGeometry(f, parts[f], proj, tol)
+		if len(geometry) == 0 {
+			continue
+		}
+		var feature []byte
+		if id, err := strconv.ParseUint(f.Id(), 10, 64); err == nil {
+			feature = protowire.AppendTag(feature, 1, protowire.VarintType)
+			feature = protowire.AppendVarint(feature, id)
+		}
+		keys := make([]string, 0, len(f.Properties))
+		for k := range f.Properties {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var packed []byte
+		for _, k := range keys {
+			if v, ok := tags.value(f.Properties[k]); ok {
+				packed = protowire.AppendVarint(packed, uint64(tags.key(k)))
+				packed = protowire.AppendVarint(packed, uint64(v))
+			}
+		}
+		if len(packed) > 0 {
+			feature = protowire.AppendTag(feature, 2, protowire.BytesType)
+			feature = protowire.AppendBytes(feature, packed)
+		}
+		feature = protowire.AppendTag(feature, 3, protowire.VarintType)
+		feature = protowire.AppendVarint(feature, uint64(typ))
+		feature = protowire.AppendTag(feature, 4, protowire.BytesType)
+		feature = protowire.AppendBytes(feature, geometry)
+		encoded = append(encoded, feature)
+	}
+	if len(encoded) == 0 {
+		return nil
+	}
+	layer := protowire.AppendTag(nil, 15, protowire.VarintType)
+	layer = protowire.AppendVarint(layer, 2)
+	layer = protowire.AppendTag(layer, 1, protowire.BytesType)
+	layer = protowire.AppendString(layer, name)
+	for _, feature := range encoded {
+		layer = protowire.AppendTag(layer, 2, protowire.BytesType)
+		layer = protowire.AppendBytes(layer, feature)
+	}
+	layer = append(layer, tags.layer...)
+	layer = protowire.AppendTag(layer, 5, protowire.VarintType)
+	layer = protowire.AppendVarint(layer, uint64(TileExtent))
+	return layer
+}
+
+// the packed geometry commands of the parts of a feature within the tile
+func encodeTileGeometry(f *Feature, polys []*Polygon, proj tileProjection, tol float64) (typ int, geometry []byte) {
+	lo, hi := -float64(TileBuffer), float64(TileExtent+TileBuffer)
+	enc := &commandEncoder{}
+	switch {
+	case f.isPoint():
+		typ = mvtPoint
+		var points []tilePoint
+		for _, poly := range polys {
+			for _, c := range poly.Exterior.Coordinates {
+				x, y := proj.project(c)
+				if x >= lo && x <= hi && y >= lo && y <= hi {
+					points = append(points, tilePoint{math.Round(x), math.Round(y)})
+				}
+			}
+		}
+		if len(points) > 0 {
+			enc.moveTo(points)
+		}
+	case f.isAreal():
+		typ = mvtPolygon
+		for _, poly := range polys {
+			for i, ring := range poly.rings() {
+				coords := douglasPeucker(ring.Coordinates, tol)
+				if len(coords) < 4 {
+					coords = ring.Coordinates
+				}
+				points := roundPoints(clipRing(projectPoints(coords, proj), lo, hi))
+				if len(points) < 3 {
+					if i == 0 {
+						break // without an exterior the holes don't matter
+					}
+					continue
+				}
+				// exteriors wind clockwise on screen, with a positive area as y grows downwards
+				if area := ringSignedArea(points); area == 0 {
+					continue
+				} else if (area > 0) != (i == 0) {
+					for l, r := 0, len(points)-1; l < r; l, r = l+1, r-1 {
+						points[l], points[r] = points[r], points[l]
+					}
+				}
+				enc.moveTo(points[:1])
+				enc.lineTo(points[1:])
+				enc.closePath()
+			}
+		}
+	default:
+		typ = mvtLineString
+		for _, poly := range polys {
+			for _, line := range clipLine(projectPoints(douglasPeucker(poly.Exterior.Coordinates, tol), proj), lo, hi) {
+				if points := roundPoints(line); len(points) >= 2 {
+					enc.moveTo(points[:1])
+					enc.lineTo(points[1:])
+				}
+			}
+		}
+	}
+	return typ, enc.buf
+}
+
+func projectPoints(coords []Coordinate, proj tileProjection) []tilePoint {
+	points := make([]tilePoint, len(coords))
+	for i, c := range coords {
+		points[i].x, points[i].y = proj.project(c)
+	}
+	return points
+}
+
+// rounds to whole tile coordinates, dropping repeated points and the closing one of rings
+func roundPoints(points []tilePoint) (rounded []tilePoint) {
+	for _, p := range points {
+		p = tilePoint{math.Round(p.x), math.Round(p.y)}
+		if n := len(rounded); n == 0 || rounded[n-1] != p {
+			rounded = append(rounded, p)
+		}
+	}
+	if n := len(rounded); n > 1 && rounded[0] == rounded[n-1] {
+		rounded = rounded[:n-1]
+	}
+	return
+}
+
+// twice the area of the ring by the shoelace formula
+func ringSignedArea(points []tilePoint) (area float64) {
+	for i, p := range points {
+		q := points[(i+1)%len(points)]
+		area += p.x*q.y - q.x*p.y
+	}
+	return
+}
+
+// clipRing clips a ring to the square lo..hi with Sutherland-Hodgman, one edge at a time
+//
+// https://en.wikipedia.org/wiki/Sutherland%E2%80%93Hodgman_algorithm
+func clipRing(points []tilePoint, lo, hi float64) []tilePoint {
+	edges := []struct {
+		inside func(p tilePoint) bool
+		cross  func(a, b tilePoint) tilePoint
+	}{
+		{func(p tilePoint) bool { return p.x >= lo }, func(a, b tilePoint) tilePoint { return crossX(a, b, lo) }},
+		{func(p tilePoint) bool { return p.x <= hi }, func(a, b tilePoint) tilePoint { return crossX(a, b, hi) }},
+		{func(p tilePoint) bool { return p.y >= lo }, func(a, b tilePoint) tilePoint { return crossY(a, b, lo) }},
+		{func(p tilePoint) bool { return p.y <= hi }, func(a, b tilePoint) tilePoint { return crossY(a, b, hi) }},
+	}
+	for _, edge := range edges {
+		if len(points) == 0 {
+			break
+		}
+		input := points
+		points = nil
+		prev := input[len(input)-1]
+		for _, p := range input {
+			switch {
+			case edge.inside(p) && !edge.inside(prev):
+				points = append(points, edge.cross(prev, p), p)
+			case edge.inside(p):
+				points = append(points, p)
+			case edge.inside(prev):
+				points = append(points, edge.cross(prev, p))
+			}
+			prev = p
+		}
+	}
+	return points
+}
+
+// clipLine splits a line into the parts within the square lo..hi, clipping each segment
+// with Liang-Barsky
+//
+// https://en.wikipedia.org/wiki/Liang%E2%80%93Barsky_algorithm
+func clipLine(points []tilePoint, lo, hi float64) (lines [][]tilePoint) {
+	var line []tilePoint
+	for i := 1; i < len(points); i++ {
+		a, b := points[i-1], points[i]
+		t0, t1 := 0.0, 1.0
+		dx, dy := b.x-a.x, b.y-a.y
+		visible := true
+		for _, edge := range [][2]float64{{-dx, a.x - lo}, {dx, hi - a.x}, {-dy, a.y - lo}, {dy, hi - a.y}} {
+			p, q := edge[0], edge[1]
+			switch {
+			case p == 0 && q < 0:
+				visible = false
+			case p < 0:
+				t0 = math.Max(t0, q/p)
+			case p > 0:
+				t1 = math.Min(t1, q/p)
+			}
+		}
+		if !visible || t0 > t1 {
+			if len(line) > 0 {
+				lines, line = append(lines, line), nil
+			}
+			continue
+		}
+		start := tilePoint{a.x + t0*dx, a.y + t0*dy}
+		end := tilePoint{a.x + t1*dx, a.y + t1*dy}
+		if len(line) == 0 {
+			line = append(line, start)
+		}
+		line = append(line, end)
+		if t1 < 1 {
+			lines, line = append(lines, line), nil
+		}
+	}
+	if len(line) > 0 {
+		lines = append(lines, line)
+	}
+	return
+}
+
+func crossX(a, b tilePoint, x float64) tilePoint {
+	return tilePoint{x, a.y + (b.y-a.y)*(x-a.x)/(b.x-a.x)}
+}
+
+func crossY(a, b tilePoint, y float64) tilePoint {
+	return tilePoint{a.x + (b.x-a.x)*(y-a.y)/(b.y-a.y), y}
+}
+
+// commandEncoder writes geometry commands with their zigzag encoded deltas
+type commandEncoder struct {
+	buf  []byte
+	x, y int64
+}
+
+func (e *commandEncoder) command(id, count int) {
+	e.buf = protowire.AppendVarint(e.buf, uint64(id&0x7|count<<3))
+}
+
+func (e *commandEncoder) points(points []tilePoint) {
+	for _, p := range points {
+		x, y := int64(p.x), int64(p.y)
+		e.buf = protowire.AppendVarint(e.buf, protowire.EncodeZigZag(x-e.x))
+		e.buf = protowire.AppendVarint(e.buf, protowire.EncodeZigZag(y-e.y))
+		e.x, e.y = x, y
+	}
+}
+
+func (e *commandEncoder) moveTo(points []tilePoint) {
+	e.command(mvtMoveTo, len(points))
+	e.points(points)
+}
+
+func (e *commandEncoder) lineTo(points []tilePoint) {
+	e.command(mvtLineTo, len(points))
+	e.points(points)
+}
+
+func (e *commandEncoder) closePath() {
+	e.command(mvtClosePath, 1)
+}
+
+// tileCache keeps encoded tiles along with the trees they were rendered from, a tile being
+// stale once any of its indices has changed
+type tileCache struct {
+	entries map[string]*list.Element
+	order   *list.List // most recently used first
+	mu      sync.Mutex
+}
+
+type tileEntry struct {
+	key   string
+	trees map[string]*Rtree
+	tile  []byte
+}
+
+func newTileCache() *tileCache {
+	return &tileCache{entries: make(map[string]*list.Element), order: list.New()}
+}
+
+func (c *tileCache) get(key string, trees map[string]*Rtree) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*tileEntry)
+	if len(entry.trees) != len(trees) {
+		return nil, false
+	}
+	for name, tree := range trees {
+		if entry.trees[name] != tree {
+			return nil, false
+		}
+	}
+	c.order.MoveToFront(e)
+	return entry.tile, true
+}
+
+func (c *tileCache) put(key string, trees map[string]*Rtree, tile []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.order.Remove(e)
+	}
+	c.entries[key] = c.order.PushFront(&tileEntry{key, trees, tile})
+	for c.order.Len() > TileCacheSize {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.entries, last.Value.(*tileEntry).key)
+	}
+}
@@ -0,0 +1,90 @@
+package philifence
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// fields of a protobuf message by number, bytes fields only
+func protoFields(t *testing.T, b []byte) map[protowire.Number][][]byte {
+	fields := make(map[protowire.Number][][]byte)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		b = b[n:]
+		value := b
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		fields[num] = append(fields[num], value[:n])
+		b = b[n:]
+	}
+	return fields
+}
+
+func packedVarints(t *testing.T, field []byte) (values []uint64) {
+	b, n := protowire.ConsumeBytes(field)
+	if n < 0 {
+		t.Fatal(protowire.ParseError(n))
+	}
+	for len(b) > 0 {
+		v, n := protowire.ConsumeVarint(b)
+		values, b = append(values, v), b[n:]
+	}
+	return
+}
+
+func TestEncodeTile(t *testing.T) {
+	inside := NewPolygonFeature(square(10, 10, 10))
+	inside.Properties = map[string]interface{}{"id": "7", "name": "inside"}
+	// covers the whole of tile 1/1/0, the north east quarter of the world
+	covering := NewPolygonFeature(square(-10, -10, 100))
+	covering.Properties = map[string]interface{}{"id": "8"}
+	fence, _ := newFenceOf([]*Feature{inside, covering})
+
+	tile := EncodeTile(1, 1, 0, map[string]*Rtree{"cities": fence.Tree()})
+	layers := protoFields(t, tile)[3]
+	if len(layers) != 1 {
+		t.Fatalf("Expected a single layer, got %d", len(layers))
+	}
+	layerBytes, _ := protowire.ConsumeBytes(layers[0])
+	layer := protoFields(t, layerBytes)
+	if name, _ := protowire.ConsumeBytes(layer[1][0]); string(name) != "cities" {
+		t.Errorf("Wrong layer name %q", name)
+	}
+	if len(layer[2]) != 2 {
+		t.Fatalf("Expected both features, got %d", len(layer[2]))
+	}
+	for _, f := range layer[2] {
+		featureBytes, _ := protowire.ConsumeBytes(f)
+		geometry := packedVarints(t, protoFields(t, featureBytes)[4][0])
+		// MoveTo 1, 2 params, LineTo 3, 6 params, ClosePath
+		if len(geometry) != 11 || geometry[0] != 1<<3|mvtMoveTo || geometry[3] != 3<<3|mvtLineTo || geometry[10] != 1<<3|mvtClosePath {
+			t.Errorf("Expected a clipped square, got %v", geometry)
+		}
+	}
+
+	// the covering square is clipped to the tile's buffer
+	ring := clipRing([]tilePoint{{-500, -500}, {5000, -500}, {5000, 5000}, {-500, 5000}}, -64, 4160)
+	if len(ring) != 4 || ringSignedArea(ring) != 2*4224*4224 {
+		t.Errorf("Wrong clipped ring %v", ring)
+	}
+	if lines := clipLine([]tilePoint{{-100, 10}, {100, 10}, {100, -100}, {200, 10}}, 0, 4096); len(lines) != 2 {
+		t.Errorf("Expected the line to leave and come back into the tile, got %v", lines)
+	}
+
+	cache := newTileCache()
+	trees := map[string]*Rtree{"cities": fence.Tree()}
+	cache.put("t", trees, tile)
+	if _, ok := cache.get("t", trees); !ok {
+		t.Errorf("Expected a cached tile")
+	}
+	fence.Add(NewPolygonFeature(square(20, 20, 1)))
+	if _, ok := cache.get("t", map[string]*Rtree{"cities": fence.Tree()}); ok {
+		t.Errorf("Expected the tile to be stale once the fence changed")
+	}
+}
@@ -0,0 +1,242 @@
+package philifence
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// properties a feature's validity is read from
+const (
+	PropertyValidFrom  = "valid_from"  // RFC 3339 or 2006-01-02
+	PropertyValidUntil = "valid_until" // exclusive
+	PropertySchedule   = "schedule"    // one or more schedules separated by ;
+)
+
+// Validity bounds when a feature is matched. A feature without one always is, and once past
+// Until it is expired, and purged from its fence after the server's retention.
+type Validity struct {
+	From, Until time.Time // zero for unbounded
+	Schedules   []Schedule
+}
+
+// Active is whether the feature is matched at t: within From and Until, and within any of
+// the schedules when there are some
+func (v *Validity) Active(t time.Time) bool {
+	if v == nil {
+		return true
+	}
+	if (!v.From.IsZero() && t.Before(v.From)) || v.Expired(t) {
+		return false
+	}
+	if len(v.Schedules) == 0 {
+		return true
+	}
+	for _, s := range v.Schedules {
+		if s.Active(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired is whether the feature will never be matched again after t
+func (v *Validity) Expired(t time.Time) bool {
+	return v != nil && !v.Until.IsZero() && !t.Before(v.Until)
+}
+
+// Schedule is a daily window on some days of the week, e.g. "weekdays 07:00-10:00 Asia/Manila".
+// A window ending before it starts runs past midnight into the next day.
+type Schedule struct {
+	Days       [7]bool // by time.Weekday
+	Start, End time.Duration
+	Location   *time.Location
+}
+
+var scheduleDays = map[string][]time.Weekday{
+	"daily":    {time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday},
+	"weekdays": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekends": {time.Saturday, time.Sunday},
+}
+
+var weekdays = map[string]time.Weekday{"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday,
+	"wed": time.Wednesday, "thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday}
+
+// ParseSchedule reads "{days} {HH:MM}-{HH:MM} [{IANA zone}]", the days being daily, weekdays,
+// weekends or a comma separated list of days and ranges, e.g. "mon,wed-fri". The zone is UTC
+// when left out.
+func ParseSchedule(s string) (schedule Schedule, err error) {
+	fields := strings.Fields(strings.Replace(s, "–", "-", -1))
+	if len(fields) < 2 || len(fields) > 3 {
+		return schedule, errorf("Schedule %q must be {days} {HH:MM}-{HH:MM} [{zone}]", s)
+	}
+	if err = schedule.parseDays(strings.ToLower(fields[0])); err != nil {
+		return
+	}
+	window := strings.SplitN(fields[1], "-", 2)
+	if len(window) != 2 {
+		return schedule, errorf("Schedule %q has no {HH:MM}-{HH:MM} window", s)
+	}
+	if schedule.Start, err = parseClock(window[0]); err != nil {
+		return
+	}
+	if schedule.End, err = parseClock(window[1]); err != nil {
+		return
+	}
+	if schedule.Start == schedule.End {
+		return schedule, errorf("Schedule %q has an empty window", s)
+	}
+	schedule.Location = time.UTC
+	if len(fields) == 3 {
+		if schedule.Location, err = time.LoadLocation(fields[2]); err != nil {
+			return schedule, errorf("Schedule %q has an unknown zone: %v", s, err)
+		}
+	}
+	return
+}
+
+func (s *Schedule) parseDays(days string) error {
+	if all, ok := scheduleDays[days]; ok {
+		for _, d := range all {
+			s.Days[d] = true
+		}
+		return nil
+	}
+	for _, part := range strings.Split(days, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		from, ok := weekdays[bounds[0]]
+		to, toOk := from, ok
+		if len(bounds) == 2 {
+			to, toOk = weekdays[bounds[1]]
+		}
+		if !ok || !toOk {
+			return errorf("Unknown days %q in schedule", part)
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			s.Days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// HH:MM, up to 24:00
+func parseClock(s string) (time.Duration, error) {
+	hm := strings.SplitN(s, ":", 2)
+	if len(hm) == 2 {
+		h, herr := strconv.Atoi(hm[0])
+		m, merr := strconv.Atoi(hm[1])
+		if herr == nil && merr == nil && h >= 0 && m >= 0 && m < 60 && (h < 24 || h == 24 && m == 0) {
+			return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+		}
+	}
+	return 0, errorf("Invalid time of day %q, expected HH:MM", s)
+}
+
+// Active is whether t is within the window, in the schedule's zone
+func (s Schedule) Active(t time.Time) bool {
+	t = t.In(s.Location)
+	clock := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	day := t.Weekday()
+	if s.Start < s.End {
+		return s.Days[day] && clock >= s.Start && clock < s.End
+	}
+	return (s.Days[day] && clock >= s.Start) || (s.Days[(day+6)%7] && clock < s.End)
+}
+
+// parseValidity reads the validity properties, nil when there are none
+func parseValidity(properties map[string]interface{}) (v *Validity, err error) {
+	from, until, schedule := properties[PropertyValidFrom], properties[PropertyValidUntil], properties[PropertySchedule]
+	if from == nil && until == nil && schedule == nil {
+		return nil, nil
+	}
+	v = &Validity{}
+	if v.From, err = parseTimeProperty(PropertyValidFrom, from); err != nil {
+		return nil, err
+	}
+	if v.Until, err = parseTimeProperty(PropertyValidUntil, until); err != nil {
+		return nil, err
+	}
+	if !v.From.IsZero() && !v.Until.IsZero() && !v.From.Before(v.Until) {
+		return nil, errorf("Feature is valid from %v, not before it is valid until %v", v.From, v.Until)
+	}
+	if schedule == nil {
+		return
+	}
+	s, ok := schedule.(string)
+	if !ok {
+		return nil, errorf("Property %q must be a string", PropertySchedule)
+	}
+	for _, part := range strings.Split(s, ";") {
+		sched, err := ParseSchedule(part)
+		if err != nil {
+			return nil, err
+		}
+		v.Schedules = append(v.Schedules, sched)
+	}
+	return
+}
+
+func parseTimeProperty(name string, value interface{}) (time.Time, error) {
+	if value == nil {
+		return time.Time{}, nil
+	}
+	s, ok := value.(string)
+	if ok {
+		if t, err := ParseTime(s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errorf("Property %q must be a RFC 3339 time or a date, got %v", name, value)
+}
+
+// ParseTime reads RFC 3339 times, or dates as their midnight UTC
+func ParseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+// Purge drops the features expired at t from every index of every layer, tenants' included,
+// returning how many there were
+func (s *Server) Purge(t time.Time) (n int) {
+	for _, layer := range s.everyLayer() {
+		for _, name := range layer.Index.Keys() {
+			expired, err := layer.Index.Purge(name, t)
+			if err != nil {
+				warn(err, "purging "+layer.Name+"/"+name)
+				continue
+			}
+			layer.releaseFeatures(name, expired)
+			n += len(expired)
+		}
+	}
+	if n > 0 {
+		info("Purged %d expired features", n)
+	}
+	return
+}
+
+// PurgeEvery purges the features expired for longer than retention every interval in the
+// background, until the server shuts down, searches at= a time before keep matching them
+// for the retention. An interval of 0 never purges.
+func (s *Server) PurgeEvery(interval, retention time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stopping.Done():
+				return
+			case t := <-ticker.C:
+				s.Purge(t.Add(-retention))
+			}
+		}
+	}()
+}
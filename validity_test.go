@@ -0,0 +1,116 @@
+package philifence
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedule(t *testing.T) {
+	manila, err := time.LoadLocation("Asia/Manila")
+	if err != nil {
+		t.Skip(err)
+	}
+	cases := []struct {
+		schedule string
+		at       time.Time
+		active   bool
+	}{
+		{"weekdays 07:00–10:00 Asia/Manila", time.Date(2024, 3, 4, 7, 0, 0, 0, manila), true}, // monday
+		{"weekdays 07:00-10:00 Asia/Manila", time.Date(2024, 3, 4, 10, 0, 0, 0, manila), false},
+		{"weekdays 07:00-10:00 Asia/Manila", time.Date(2024, 3, 3, 8, 0, 0, 0, manila), false}, // sunday
+		{"weekdays 07:00-10:00 Asia/Manila", time.Date(2024, 3, 4, 0, 30, 0, 0, time.UTC), true},
+		{"weekdays 07:00-10:00", time.Date(2024, 3, 4, 0, 30, 0, 0, time.UTC), false},
+		{"fri-sun 22:00-02:00", time.Date(2024, 3, 4, 1, 0, 0, 0, time.UTC), true}, // monday morning, from sunday
+		{"fri-sun 22:00-02:00", time.Date(2024, 3, 5, 1, 0, 0, 0, time.UTC), false},
+		{"mon,wed 12:00-24:00", time.Date(2024, 3, 6, 23, 59, 0, 0, time.UTC), true},
+	}
+	for _, c := range cases {
+		s, err := ParseSchedule(c.schedule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if active := s.Active(c.at); active != c.active {
+			t.Errorf("%q at %v: expected active %v", c.schedule, c.at, c.active)
+		}
+	}
+	for _, bad := range []string{"weekdays", "someday 07:00-10:00", "daily 07:00-25:00", "daily 07:00-07:00", "daily 07:00-10:00 Nowhere/Town"} {
+		if _, err := ParseSchedule(bad); err == nil {
+			t.Errorf("expected %q to fail", bad)
+		}
+	}
+}
+
+func TestValidity(t *testing.T) {
+	promo := NewPolygonFeature(square(0, 0, 1))
+	promo.Properties = map[string]interface{}{"id": "promo", PropertyValidFrom: "2024-03-01", PropertyValidUntil: "2024-04-01T00:00:00Z"}
+	rush := NewPolygonFeature(square(0, 0, 1))
+	rush.Properties = map[string]interface{}{"id": "rush", PropertySchedule: "weekdays 07:00-10:00; sat 12:00-13:00"}
+	always := NewPolygonFeature(square(0, 0, 1))
+	always.Properties = map[string]interface{}{"id": "always"}
+	if err := promo.validate(); err != nil {
+		t.Fatal(err)
+	}
+	bad := NewPolygonFeature(square(0, 0, 1))
+	bad.Properties = map[string]interface{}{PropertyValidUntil: "soon"}
+	if err := bad.validate(); err == nil {
+		t.Error("expected an invalid valid_until to fail")
+	}
+
+	ids := func(features []*Feature) (ids []string) {
+		for _, f := range features {
+			ids = append(ids, f.Id())
+		}
+		return
+	}
+	for _, idx := range []FenceIndex{NewMutexFenceIndex(), NewSnapshotFenceIndex()} {
+		fence, _ := newFenceOf([]*Feature{promo, rush, always})
+		idx.Set("offers", fence)
+		for at, expected := range map[string]int{
+			"2024-02-29T08:00:00Z": 2, // thursday, rush and always
+			"2024-03-02T08:00:00Z": 2, // saturday, promo and always
+			"2024-03-02T12:30:00Z": 3,
+			"2024-04-01T08:00:00Z": 2, // monday, promo is over
+		} {
+			when, _ := ParseTime(at)
+			matchs, _ := idx.SearchAt("offers", cd(0.5, 0.5), 1, when)
+			if len(matchs) != expected {
+				t.Errorf("%T at %s: expected %d matchs, got %v", idx, at, expected, ids(matchs))
+			}
+		}
+
+		expired, err := idx.Purge("offers", time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC))
+		if err != nil || len(expired) != 0 {
+			t.Errorf("%T: expected nothing expired midway, got %v %v", idx, ids(expired), err)
+		}
+		expired, err = idx.Purge("offers", time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC))
+		if err != nil || len(expired) != 1 || expired[0] != promo {
+			t.Errorf("%T: expected promo expired, got %v %v", idx, ids(expired), err)
+		}
+		if stats, _ := idx.Stats("offers"); stats.Features != 2 {
+			t.Errorf("%T: expected 2 features left, got %d", idx, stats.Features)
+		}
+		if matchs, _ := idx.SearchAt("offers", cd(0.5, 0.5), 1, time.Date(2024, 3, 2, 12, 30, 0, 0, time.UTC)); len(matchs) != 2 {
+			t.Errorf("%T: expected promo to be gone after the purge, got %v", idx, ids(matchs))
+		}
+	}
+
+	s := serverWith(t, KindFence, KindFence, promo, always)
+	if msg := searchServer(t, s, "/fence/cities/search?lat=0.5&lon=0.5&at=2024-03-10"); len(msg.Result) != 2 {
+		t.Errorf("expected promo and always at=2024-03-10, got %v", msg.Result)
+	}
+	if msg := searchServer(t, s, "/fence/cities/search?lat=0.5&lon=0.5&at=2024-05-01"); len(msg.Result) != 1 {
+		t.Errorf("expected only always at=2024-05-01, got %v", msg.Result)
+	}
+	if n := s.Purge(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)); n != 1 {
+		t.Errorf("expected the server to purge promo, got %d", n)
+	}
+
+	// purged in the background, promo is kept for searches before its expiry for the retention
+	s = serverWith(t, KindFence, KindFence, promo, always)
+	defer s.stop()
+	s.PurgeEvery(time.Millisecond, time.Since(time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)))
+	time.Sleep(20 * time.Millisecond)
+	if msg := searchServer(t, s, "/fence/cities/search?lat=0.5&lon=0.5&at=2024-03-10"); len(msg.Result) != 2 {
+		t.Errorf("expected promo at=2024-03-10 within the retention, got %v", msg.Result)
+	}
+}
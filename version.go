@@ -0,0 +1,148 @@
+package philifence
+
+import (
+	"reflect"
+	"sync/atomic"
+	"time"
+)
+
+// Version tells apart the features added to a fence with the same id. Adding a feature
+// replaces the current one of its id, which is kept for searches as of a time before it was
+// replaced, as are deleted features. History costs nothing but the retired versions
+// themselves: they stay where they are in the fence's tree, or a snapshot's, stamped with
+// when they were retired and linked from the version that replaced them.
+type Version struct {
+	Number int       `json:"version"`
+	Since  time.Time `json:"since"` // zero for features loaded from datasets
+	Until  time.Time `json:"until"` // zero while current
+}
+
+func (f *Feature) Version() Version {
+	return Version{Number: f.replaces + 1, Since: f.since, Until: f.retired()}
+}
+
+// History of the feature's id, its latest version first
+func (f *Feature) History() (versions []*Feature) {
+	for ; f != nil; f = f.previous {
+		versions = append(versions, f)
+	}
+	return
+}
+
+// Current is whether the feature hasn't been replaced or deleted
+func (f *Feature) Current() bool {
+	return atomic.LoadInt64(&f.until) == 0
+}
+
+func (f *Feature) retired() time.Time {
+	if until := atomic.LoadInt64(&f.until); until != 0 {
+		return time.Unix(0, until).UTC()
+	}
+	return time.Time{}
+}
+
+func (f *Feature) retire(t time.Time) {
+	atomic.StoreInt64(&f.until, t.UnixNano())
+}
+
+// recorded is whether the feature was the version of its id at t
+func (f *Feature) recorded(t time.Time) bool {
+	if !f.since.IsZero() && t.Before(f.since) {
+		return false
+	}
+	until := atomic.LoadInt64(&f.until)
+	return until == 0 || t.UnixNano() < until
+}
+
+// supersede makes the feature, added at t, the version after latest, the latest of its id
+// so far, which is left for Fence.retire. It has to be called before the feature is visible
+// to searches.
+func (f *Feature) supersede(latest *Feature, t time.Time) {
+	f.since = t
+	if latest == nil || latest == f {
+		return
+	}
+	f.previous, f.replaces = latest, latest.replaces+1
+}
+
+// reloaded rebuilds the fence loaded anew from a dataset as the versions after those of the
+// fence it replaces, at t: new features are added, changed ones supersede theirs, those no
+// longer in the dataset are deleted and unchanged ones are kept as they were, along with
+// every retired version, so a reload is in the history as the api's adds and deletes are.
+// The versions it replaces are retired once the fence is published in place of the old one.
+func reloaded(old, loaded *Fence, t time.Time) (*Fence, error) {
+	if old == nil || old.features.size == 0 {
+		return loaded, nil
+	}
+	fence, err := NewFenceWith(loaded.rtree.min, loaded.rtree.max)
+	if err != nil {
+		return nil, err
+	}
+	fence.unpublished = true
+	var versions []*Feature
+	for _, f := range everyFeature(loaded) {
+		latest, _ := old.latest(f.Id())
+		if latest != nil && latest.Current() && sameFeature(latest, f) {
+			f = latest
+		} else {
+			f.supersede(latest, t)
+			fence.retire(latest, t)
+		}
+		fence.insert(f)
+	}
+	for _, f := range everyFeature(old) {
+		if f.Id() == "" {
+			continue
+		}
+		latest, ok := fence.latest(f.Id())
+		if latest == f {
+			continue
+		}
+		if !ok {
+			fence.retire(f, t)
+		}
+		versions = append(versions, f)
+	}
+	for _, f := range versions {
+		fence.insert(f)
+	}
+	return fence, nil
+}
+
+// the features of the fence, those without a part in its tree included
+func everyFeature(r *Fence) []*Feature {
+	features := r.Features()
+	seen := make(map[*Feature]bool, len(features))
+	for _, f := range features {
+		seen[f] = true
+	}
+	r.features.each(func(f *Feature) {
+		if !seen[f] {
+			features = append(features, f)
+		}
+	})
+	return features
+}
+
+// whether the features have the same geometry and properties
+func sameFeature(a, b *Feature) bool {
+	return a.Type == b.Type && reflect.DeepEqual(a.Properties, b.Properties) && sameGeometry(a, b)
+}
+
+func sameGeometry(a, b *Feature) bool {
+	if len(a.Geometry) != len(b.Geometry) {
+		return false
+	}
+	for i, poly := range a.Geometry {
+		other := b.Geometry[i]
+		if !reflect.DeepEqual(poly.Exterior.Coordinates, other.Exterior.Coordinates) || len(poly.Holes) != len(other.Holes) {
+			return false
+		}
+		for j, hole := range poly.Holes {
+			if !reflect.DeepEqual(hole.Coordinates, other.Holes[j].Coordinates) {
+				return false
+			}
+		}
+	}
+	return true
+}
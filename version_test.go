@@ -0,0 +1,168 @@
+package philifence
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestVersions(t *testing.T) {
+	city := func(name string) *Feature {
+		f := NewPolygonFeature(square(0, 0, 1))
+		f.Properties = map[string]interface{}{"id": "manila", "name": name}
+		return f
+	}
+	names := func(features []*Feature) (names []interface{}) {
+		for _, f := range features {
+			names = append(names, f.Properties["name"])
+		}
+		return
+	}
+	for _, idx := range []FenceIndex{NewMutexFenceIndex(), NewSnapshotFenceIndex()} {
+		loaded := city("Manila")
+		fence, _ := newFenceOf([]*Feature{loaded})
+		idx.Set("cities", fence)
+		beforeAdd := time.Now()
+		if err := idx.Add("cities", city("City of Manila")); err != nil {
+			t.Fatal(err)
+		}
+		beforeDelete := time.Now()
+
+		if matchs, _ := idx.Search("cities", cd(0.5, 0.5), 1); len(matchs) != 1 || matchs[0].Properties["name"] != "City of Manila" {
+			t.Errorf("%T: expected only the latest version, got %v", idx, names(matchs))
+		}
+		if matchs, _ := idx.SearchAt("cities", cd(0.5, 0.5), 1, beforeAdd); len(matchs) != 1 || matchs[0] != loaded {
+			t.Errorf("%T: expected the loaded version as of before the add, got %v", idx, names(matchs))
+		}
+		if err := idx.DeleteFeature("cities", "manila"); err != nil {
+			t.Fatal(err)
+		}
+		if err := idx.DeleteFeature("cities", "manila"); err == nil {
+			t.Errorf("%T: expected deleting twice to fail", idx)
+		}
+		if matchs, _ := idx.Search("cities", cd(0.5, 0.5), 1); len(matchs) != 0 {
+			t.Errorf("%T: expected nothing after the delete, got %v", idx, names(matchs))
+		}
+		if matchs, _ := idx.NearAt("cities", cd(0.5, 0.5), 1, beforeDelete); len(matchs) != 1 || matchs[0].Feature.Properties["name"] != "City of Manila" {
+			t.Errorf("%T: expected the latest version as of before the delete, got %v", idx, matchs)
+		}
+		if _, err := idx.Feature("cities", "manila"); err == nil {
+			t.Errorf("%T: expected a deleted feature to be gone", idx)
+		}
+		if tree, _ := idx.Tree("cities"); len(tree.GroupNearest(GroupQuery{Points: []Coordinate{cd(0.5, 0.5)}, K: 1, At: time.Now()})) != 0 {
+			t.Errorf("%T: expected group queries to leave out replaced and deleted versions", idx)
+		}
+
+		history, err := idx.History("cities", "manila")
+		if err != nil || len(history) != 2 {
+			t.Fatalf("%T: expected 2 versions, got %v %v", idx, names(history), err)
+		}
+		latest, first := history[0].Version(), history[1].Version()
+		if latest.Number != 2 || first.Number != 1 || !first.Since.IsZero() || !first.Until.Equal(latest.Since) || latest.Until.IsZero() {
+			t.Errorf("%T: unexpected versions %+v and %+v", idx, latest, first)
+		}
+	}
+
+	s := serverWith(t, KindFence, KindFence, city("Manila"))
+	asOf := time.Now().UTC().Format(time.RFC3339Nano)
+	if r := serveAs(s, "DELETE", "/fence/cities/features/manila", ""); r.Code != 200 {
+		t.Fatalf("expected the delete to succeed, got %d %s", r.Code, r.Body)
+	}
+	if msg := searchServer(t, s, "/fence/cities/search?lat=0.5&lon=0.5"); len(msg.Result) != 0 {
+		t.Errorf("expected nothing after the delete, got %v", msg.Result)
+	}
+	if msg := searchServer(t, s, "/fence/cities/search?lat=0.5&lon=0.5&as_of="+asOf); len(msg.Result) != 1 {
+		t.Errorf("expected manila as of %s, got %v", asOf, msg.Result)
+	}
+	if r := serveAs(s, "GET", "/fence/cities/search?lat=0.5&lon=0.5&at=2020-01-01&as_of=2020-01-01", ""); r.Code != 400 {
+		t.Errorf("expected at and as_of together to fail, got %d", r.Code)
+	}
+	r := serveAs(s, "GET", "/fence/cities/features/manila/history", "")
+	var history []Properties
+	if err := json.Unmarshal(r.Body.Bytes(), &history); err != nil || len(history) != 1 || history[0]["until"] == nil {
+		t.Errorf("expected the deleted version in the history, got %d %s", r.Code, r.Body)
+	}
+	if r := serveAs(s, "DELETE", "/fence/cities/features/manila", ""); r.Code != 404 {
+		t.Errorf("expected deleting twice to 404, got %d", r.Code)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	city := func(id, name string) *Feature {
+		f := NewPolygonFeature(square(0, 0, 1))
+		f.Properties = map[string]interface{}{"id": id, "name": name}
+		return f
+	}
+	idx := NewMutexFenceIndex()
+	fence, _ := newFenceOf([]*Feature{city("manila", "Manila")})
+	idx.Set("cities", fence)
+	beforeAdd := time.Now()
+	idx.Add("cities", city("manila", "City of Manila"))
+	idx.Add("cities", city("pasig", "Pasig"))
+	idx.DeleteFeature("cities", "pasig")
+
+	path := filepath.Join(t.TempDir(), "cities"+historyExt)
+	if err := SaveHistory(path, idx.Get("cities")); err != nil {
+		t.Fatal(err)
+	}
+	file, _ := os.Open(path)
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bufio.NewScanner(gz)
+	var records []historyRecord
+	for lines.Scan() {
+		var rec historyRecord
+		json.Unmarshal(lines.Bytes(), &rec)
+		records = append(records, rec)
+	}
+	if len(records) != 4 || records[2].Previous != 1 || records[2].Parts != nil || records[2].Type != "" {
+		t.Errorf("expected the second version of manila to leave out its geometry, got %+v", records)
+	}
+
+	restored, err := LoadHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx.Set("cities", restored)
+	if matchs, _ := idx.Search("cities", cd(0.5, 0.5), 1); len(matchs) != 1 || matchs[0].Properties["name"] != "City of Manila" {
+		t.Errorf("expected only the latest version of manila, got %v", matchs)
+	}
+	if matchs, _ := idx.SearchAt("cities", cd(0.5, 0.5), 1, beforeAdd); len(matchs) != 1 || matchs[0].Properties["name"] != "Manila" {
+		t.Errorf("expected the loaded version as of before the add, got %v", matchs)
+	}
+	if history, _ := idx.History("cities", "manila"); len(history) != 2 || history[0].Version().Number != 2 || !history[1].Version().Until.Equal(history[0].Version().Since) {
+		t.Errorf("expected 2 versions of manila, got %v", history)
+	}
+	if history, _ := idx.History("cities", "pasig"); len(history) != 1 || history[0].Current() {
+		t.Errorf("expected pasig deleted, got %v", history)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	for _, idx := range []FenceIndex{NewMutexFenceIndex(), NewSnapshotFenceIndex()} {
+		fence, _ := newFenceOf([]*Feature{NewPolygonFeature(square(0, 0, 1))})
+		idx.Set("cities", fence)
+		added := make(chan error)
+		err := idx.Update("cities", func(old *Fence) (*Fence, error) {
+			go func() { added <- idx.Add("cities", NewPolygonFeature(square(2, 2, 1))) }()
+			time.Sleep(10 * time.Millisecond)
+			return newFenceOf(old.Features())
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = <-added; err != nil {
+			t.Fatal(err)
+		}
+		if matchs, _ := idx.Search("cities", cd(2.5, 2.5), 1); len(matchs) != 1 {
+			t.Errorf("%T: expected the add made during the update to land on the new fence, got %v", idx, matchs)
+		}
+	}
+}